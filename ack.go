@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"go.etcd.io/bbolt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// Acknowledgement marks the alert of a device as seen. The device keeps its status in the status
+// outputs, but its records rate as info for min_severity, so notifications routed by severity
+// stop until it recovers. The acknowledgement is cleared by the first collection that finds the
+// device ok again.
+type Acknowledgement struct {
+	Device  string    `json:"device" db:"partition_name"`
+	Ts      time.Time `json:"ts" db:"ts"`
+	Comment string    `json:"comment,omitempty" db:"comment"`
+}
+
+// ackStore keeps acknowledgements in the local store or the database, whichever history is read
+// from.
+type ackStore interface {
+	acknowledgements() (map[string]Acknowledgement, error)
+	acknowledge(ack Acknowledgement) error
+	// clearAcknowledgement returns false if the device was not acknowledged
+	clearAcknowledgement(device string) (bool, error)
+	Close() error
+}
+
+// localAcksBucket holds acknowledgements in the local store, next to the buckets of partitions.
+const localAcksBucket = "gosmart:acknowledgements"
+
+func openAckStore(conf Config) (ackStore, error) {
+	if conf.Local != nil {
+		db, err := openLocalStore(*conf.Local, false)
+		if err != nil {
+			return nil, err
+		}
+		return localAcks{db: db}, nil
+	}
+	if conf.Db != nil {
+		db, err := connectPostgres(*conf.Db)
+		if err != nil {
+			return nil, err
+		}
+		return postgresAcks{db: db, conf: *conf.Db}, nil
+	}
+	return nil, errNoHistory
+}
+
+type localAcks struct {
+	db *bbolt.DB
+}
+
+func (s localAcks) acknowledgements() (map[string]Acknowledgement, error) {
+	acks := make(map[string]Acknowledgement)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(localAcksBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var ack Acknowledgement
+			if err := json.Unmarshal(v, &ack); err != nil {
+				return fmt.Errorf("local store read error for the acknowledgement of %s: %w", k, err)
+			}
+			acks[string(k)] = ack
+			return nil
+		})
+	})
+	return acks, err
+}
+
+func (s localAcks) acknowledge(ack Acknowledgement) error {
+	value, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(localAcksBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(ack.Device), value)
+	})
+}
+
+func (s localAcks) clearAcknowledgement(device string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(localAcksBucket))
+		if bucket == nil || bucket.Get([]byte(device)) == nil {
+			return nil
+		}
+		found = true
+		return bucket.Delete([]byte(device))
+	})
+	return found, err
+}
+
+func (s localAcks) Close() error {
+	return s.db.Close()
+}
+
+// postgresAcks keeps acknowledgements in the <table>_acks table, created with initialize.
+type postgresAcks struct {
+	db   *sqlx.DB
+	conf DBConfig
+}
+
+func (s postgresAcks) acknowledgements() (map[string]Acknowledgement, error) {
+	var rows []Acknowledgement
+	ctx, cancel := dbContext(s.conf)
+	defer cancel()
+	if err := s.db.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT partition_name, ts, comment FROM %s.%s_acks;`, s.conf.Schema, s.conf.Table)); err != nil {
+		return nil, err
+	}
+	acks := make(map[string]Acknowledgement, len(rows))
+	for _, ack := range rows {
+		acks[ack.Device] = ack
+	}
+	return acks, nil
+}
+
+func (s postgresAcks) acknowledge(ack Acknowledgement) error {
+	ctx, cancel := dbContext(s.conf)
+	defer cancel()
+	_, err := s.db.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s.%s_acks (partition_name, ts, comment) VALUES (:partition_name, :ts, :comment) ON CONFLICT (partition_name) DO UPDATE SET ts = EXCLUDED.ts, comment = EXCLUDED.comment;`, s.conf.Schema, s.conf.Table), ack)
+	return err
+}
+
+func (s postgresAcks) clearAcknowledgement(device string) (bool, error) {
+	ctx, cancel := dbContext(s.conf)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s.%s_acks WHERE partition_name = $1;`, s.conf.Schema, s.conf.Table), device)
+	if err != nil {
+		return false, err
+	}
+	rows, _ := res.RowsAffected()
+	return rows > 0, nil
+}
+
+func (s postgresAcks) Close() error {
+	return s.db.Close()
+}
+
+// applyAcknowledgements marks the records of acknowledged devices and clears the
+// acknowledgements of devices that are ok again. Without a local store or database nothing can be
+// acknowledged.
+func applyAcknowledgements(records []PartitionLine, conf Config) []PartitionLine {
+	if conf.Local == nil && conf.Db == nil {
+		return records
+	}
+	store, err := openAckStore(conf)
+	if err != nil {
+		log.Printf("Could not load acknowledgements: %s\n", err)
+		return records
+	}
+	defer store.Close()
+	acks, err := store.acknowledgements()
+	if err != nil {
+		log.Printf("Could not load acknowledgements: %s\n", err)
+		return records
+	}
+
+	for i := range records {
+		record := &records[i]
+		ack, ok := acks[record.PartitionName]
+		if !ok {
+			continue
+		}
+		if status, _ := deviceStatus(*record); status != StatusOk {
+			record.Acknowledged = &ack
+			continue
+		}
+		if _, err := store.clearAcknowledgement(record.PartitionName); err != nil {
+			log.Printf("Could not clear the acknowledgement of %s: %s\n", record.PartitionName, err)
+		} else {
+			log.Printf("%s recovered, cleared its acknowledgement\n", record.PartitionName)
+		}
+	}
+	return records
+}
+
+// handleAcknowledgements lists the acknowledgements, acknowledges the alert of the configured
+// device given as device with an optional comment, or clears the acknowledgement of device.
+// Only devices that are alerting in the last collection can be acknowledged.
+func (d *daemon) handleAcknowledgements(w http.ResponseWriter, r *http.Request) {
+	if d.conf.Local == nil && d.conf.Db == nil {
+		http.Error(w, "acknowledgements need a local store or database", http.StatusConflict)
+		return
+	}
+
+	code := http.StatusOK
+	var device PartitionConfig
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+		var ok bool
+		if device, ok = d.configuredDevice(r.URL.Query().Get("device")); !ok {
+			http.Error(w, "device must be a configured device", http.StatusNotFound)
+			return
+		}
+	}
+	if r.Method == http.MethodPost {
+		alerting := false
+		d.mu.Lock()
+		for _, record := range d.lastRecords {
+			if status, _ := deviceStatus(record); record.PartitionName == device.Path && alertingStatus(status) {
+				alerting = true
+			}
+		}
+		d.mu.Unlock()
+		if !alerting {
+			http.Error(w, "device is not alerting", http.StatusConflict)
+			return
+		}
+	}
+
+	store, err := openAckStore(d.conf)
+	if err != nil {
+		d.recordError(err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer store.Close()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		ack := Acknowledgement{Device: device.Path, Ts: time.Now(), Comment: r.URL.Query().Get("comment")}
+		if err := store.acknowledge(ack); err != nil {
+			d.recordError(err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		log.Printf("Acknowledged the alert of %s: %s\n", ack.Device, ack.Comment)
+		code = http.StatusCreated
+	case http.MethodDelete:
+		found, err := store.clearAcknowledgement(device.Path)
+		if err != nil {
+			d.recordError(err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		} else if !found {
+			http.Error(w, "device is not acknowledged", http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	acks, err := store.acknowledgements()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(acks)
+}
+
+// runAckCommand implements `gosmart ack`, which acknowledges, clears or lists acknowledgements in
+// the local store or database of the config.
+func runAckCommand(args []string) {
+	fs := flag.NewFlagSet("ack", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	comment := fs.String("comment", "", "Why the alert is acknowledged, e.g. a ticket")
+	clear := fs.Bool("clear", false, "Clear the acknowledgement instead")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ack [-f config] [-comment text | -clear] [device]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Lists the acknowledgements without a device.")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	store, err := openAckStore(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open acknowledgements: %s\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	device := fs.Arg(0)
+	switch {
+	case device == "":
+		acks, err := store.acknowledgements()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read acknowledgements: %s\n", err)
+			os.Exit(1)
+		}
+		devices := make([]string, 0, len(acks))
+		for device := range acks {
+			devices = append(devices, device)
+		}
+		slices.Sort(devices)
+		for _, device := range devices {
+			ack := acks[device]
+			fmt.Printf("%s acknowledged %s %s\n", ack.Device, ack.Ts.Format(time.RFC3339), ack.Comment)
+		}
+	case *clear:
+		found, err := store.clearAcknowledgement(device)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not clear the acknowledgement: %s\n", err)
+			os.Exit(1)
+		} else if !found {
+			fmt.Fprintf(os.Stderr, "%s is not acknowledged\n", device)
+			os.Exit(1)
+		}
+	default:
+		if err := store.acknowledge(Acknowledgement{Device: device, Ts: time.Now(), Comment: *comment}); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not acknowledge: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// Advisory is a known issue of a drive model, optionally limited to some firmware versions.
+type Advisory struct {
+	ModelPrefixes []string `json:"model_prefixes"`
+	// Firmware lists the affected versions, all versions are affected if empty
+	Firmware  []string `json:"firmware,omitempty"`
+	Summary   string   `json:"summary"`
+	Reference string   `json:"reference,omitempty"`
+}
+
+//go:embed advisories.json
+var builtinAdvisoriesJson []byte
+
+// builtinAdvisories are the known issues shipped with gosmart, Config.Advisories extends them.
+var builtinAdvisories = func() []Advisory {
+	var advisories []Advisory
+	if err := json.Unmarshal(builtinAdvisoriesJson, &advisories); err != nil {
+		panic("invalid advisories.json: " + err.Error())
+	}
+	return advisories
+}()
+
+func (a Advisory) matches(model, firmware string) bool {
+	if len(a.Firmware) > 0 && !slices.Contains(a.Firmware, firmware) {
+		return false
+	}
+	return slices.ContainsFunc(a.ModelPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(model, prefix)
+	})
+}
+
+// applyAdvisories records every built-in or configured advisory matching the drive.
+func applyAdvisories(line PartitionLine, extra []Advisory) PartitionLine {
+	if line.Model == "" {
+		return line
+	}
+	for _, advisory := range append(slices.Clone(builtinAdvisories), extra...) {
+		if advisory.matches(line.Model, line.Firmware) {
+			msg := advisory.Summary
+			if advisory.Reference != "" {
+				msg += " (" + advisory.Reference + ")"
+			}
+			line.Advisories = append(line.Advisories, msg)
+		}
+	}
+	return line
+}
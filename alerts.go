@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// alertTransition is an alert firing or resolving between two collections. The rule is the health
+// status that alerts: warning or error.
+type alertTransition struct {
+	Device string
+	Rule   string
+	Firing bool
+	Text   string
+	Ts     time.Time
+}
+
+func alertingStatus(status string) bool {
+	return status == StatusWarning || status == StatusError
+}
+
+// statusSilenced is the alert status of silenced devices, it never alerts.
+const statusSilenced = "silenced"
+
+// alertStatus is the device status unless the device is silenced. A device still failing when
+// its silence ends fires again.
+func alertStatus(line PartitionLine) (string, []string) {
+	if line.SilencedUntil != nil {
+		return statusSilenced, nil
+	}
+	return deviceStatus(line)
+}
+
+// alertTransitions compares the health of each device in cur with the same device in prev.
+// Devices that are new or gone do not cause transitions.
+func alertTransitions(prev, cur []PartitionLine) []alertTransition {
+	before := make(map[string]string, len(prev))
+	for _, line := range prev {
+		before[line.PartitionName], _ = alertStatus(line)
+	}
+
+	var transitions []alertTransition
+	for _, line := range cur {
+		was, ok := before[line.PartitionName]
+		status, reasons := alertStatus(line)
+		if !ok || was == status {
+			continue
+		}
+		if alertingStatus(was) {
+			transitions = append(transitions, alertTransition{
+				Device: line.PartitionName, Rule: was, Ts: line.Ts,
+				Text: fmt.Sprintf("SMART %s resolved on %s, now %s", was, deviceLocated(line), status),
+			})
+		}
+		if alertingStatus(status) {
+			if guests := guestsAtRisk(line); guests != "" {
+				reasons = append(reasons, guests)
+			}
+			transitions = append(transitions, alertTransition{
+				Device: line.PartitionName, Rule: status, Firing: true, Ts: line.Ts,
+				Text: fmt.Sprintf("SMART %s on %s: %s", status, deviceLocated(line), strings.Join(reasons, "; ")),
+			})
+		}
+	}
+	return transitions
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+)
+
+// AnonymizeConfig replaces serial numbers and WWNs with a keyed hash before records leave the
+// host, for data sent to shared aggregation endpoints. The hash is stable for a key, so the same
+// drive keeps the same identifier across runs and hosts sharing the key. Device paths are kept as
+// configured, /dev/disk/by-id links contain the serial number.
+type AnonymizeConfig struct {
+	Key string `json:"key,omitempty"`
+	// KeyFile is read instead of Key, so the key can be kept out of the config
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+// anonymizer hashes identifiers with the configured key. Without a key they are dropped, so a
+// broken config never leaks them.
+type anonymizer struct {
+	key []byte
+}
+
+func newAnonymizer(conf AnonymizeConfig) anonymizer {
+	key := conf.Key
+	if conf.KeyFile != "" {
+		b, err := os.ReadFile(conf.KeyFile)
+		if err != nil {
+			log.Printf("Could not read the anonymization key, dropping serial numbers: %s\n", err)
+			return anonymizer{}
+		}
+		key = strings.TrimSpace(string(b))
+	}
+	if key == "" {
+		log.Println("No anonymization key, dropping serial numbers")
+	}
+	return anonymizer{key: []byte(key)}
+}
+
+// hash returns the identifier hashed with HMAC-SHA256, shortened to 16 bytes.
+func (a anonymizer) hash(id string) string {
+	if id == "" || len(a.key) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(id))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// anonymizeRecords hashes the serial numbers of records.
+func anonymizeRecords(records []PartitionLine, conf AnonymizeConfig) []PartitionLine {
+	a := newAnonymizer(conf)
+	for i := range records {
+		records[i].Serial = a.hash(records[i].Serial)
+	}
+	return records
+}
+
+// anonymizeSmartctl hashes the serial number and WWN in raw smartctl JSON output.
+func anonymizeSmartctl(out json.RawMessage, conf AnonymizeConfig) (json.RawMessage, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return nil, err
+	}
+	a := newAnonymizer(conf)
+	if serial, ok := doc["serial_number"].(string); ok {
+		doc["serial_number"] = a.hash(serial)
+	}
+	if wwn, ok := doc["wwn"]; ok {
+		b, _ := json.Marshal(wwn)
+		doc["wwn"] = a.hash(string(b))
+	}
+	return json.Marshal(doc)
+}
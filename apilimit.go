@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per API client, refilled at a per minute rate with bursts of up to
+// a minute's worth of requests.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*rateBucket)}
+}
+
+// allow takes a request from the client's bucket. If it is empty it returns false and how long
+// until the next request is allowed.
+func (l *rateLimiter) allow(client string, perMinute int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(perMinute) / 60
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &rateBucket{tokens: float64(perMinute), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(perMinute), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// statusRecorder remembers the status and size of a response for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// accessLogEntry is one line of the access log, written as JSON to stderr.
+type accessLogEntry struct {
+	Ts         time.Time `json:"ts"`
+	Remote     string    `json:"remote"`
+	Token      string    `json:"token,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// limitAndLog rate limits requests per token, or per remote address for requests without a known
+// token, and logs every request if conf.AccessLog is set.
+func limitAndLog(h http.Handler, conf ServeConfig, auth apiAuth) http.Handler {
+	limiter := newRateLimiter()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		remote, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remote = r.RemoteAddr
+		}
+		client, perMinute := "remote:"+remote, conf.RequestsPerMinute
+		token, known := auth.token(r)
+		if known {
+			client = "token:" + token.Name
+			if token.RequestsPerMinute > 0 {
+				perMinute = token.RequestsPerMinute
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		allowed := true
+		if perMinute > 0 {
+			var wait time.Duration
+			if allowed, wait = limiter.allow(client, perMinute); !allowed {
+				rec.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(rec, "rate limit exceeded", http.StatusTooManyRequests)
+			}
+		}
+		if allowed {
+			h.ServeHTTP(rec, r)
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if conf.AccessLog {
+			j, _ := json.Marshal(accessLogEntry{
+				Ts: start, Remote: remote, Token: token.Name, Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery,
+				Status: rec.status, Bytes: rec.bytes, DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			})
+			fmt.Fprintln(os.Stderr, string(j))
+		}
+	})
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultArchiveSegmentRecords is when a new segment is started if SegmentRecords is zero.
+const defaultArchiveSegmentRecords = 10000
+
+// ArchiveConfig is a tamper evident, append-only archive of records for proving the SMART history
+// was not altered later. Records are appended to JSON lines segments in Dir, each line holding the
+// SHA-256 of the line before it, so changing, removing or reordering a record breaks the chain
+// from there on. `gosmart archive verify` checks the chain. Nothing chains to the last line, so
+// changing it or cutting records off the end is only detected against a head hash printed by an
+// earlier verify and kept elsewhere.
+type ArchiveConfig struct {
+	Dir string `json:"dir"`
+	// SegmentRecords starts a new segment after this many records, 10000 if zero. Full segments
+	// are made read-only.
+	SegmentRecords int `json:"segment_records,omitempty"`
+}
+
+// archiveEntry is one line of an archive segment.
+type archiveEntry struct {
+	// Seq numbers the records of the archive from 1, across segments
+	Seq uint64 `json:"seq"`
+	// Prev is the hex SHA-256 of the previous line without its newline, empty on the first line
+	Prev   string          `json:"prev"`
+	Record json.RawMessage `json:"record"`
+}
+
+// archiveMu serializes appends within the process, the outputs of serve and collect may share a
+// directory.
+var archiveMu sync.Mutex
+
+func archiveHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// archiveSegments lists the segment files of dir in order. Segments are named after the sequence
+// number of their first record.
+func archiveSegments(dir string) ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(dir, "segment-*.jsonl"))
+	sort.Strings(segments)
+	return segments, err
+}
+
+func archiveSegmentPath(dir string, firstSeq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("segment-%012d.jsonl", firstSeq))
+}
+
+func archiveSegmentFirstSeq(path string) (uint64, error) {
+	var seq uint64
+	_, err := fmt.Sscanf(filepath.Base(path), "segment-%d.jsonl", &seq)
+	return seq, err
+}
+
+// lastArchiveLine returns the last line of a segment without its newline, reading backwards from
+// the end so appending does not read whole segments. It fails if the segment does not end in a
+// newline, an append was cut short.
+func lastArchiveLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	const block = 4096
+	var tail []byte
+	for offset := size; offset > 0; {
+		n := min(int64(block), offset)
+		offset -= n
+		buf := make([]byte, n)
+		if _, err := f.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		tail = append(buf, tail...)
+		if len(tail) > 0 && tail[len(tail)-1] != '\n' {
+			return nil, fmt.Errorf("%s ends in an incomplete line, check it with `gosmart archive verify`", path)
+		}
+		if i := bytes.LastIndexByte(tail[:len(tail)-1], '\n'); i >= 0 {
+			return tail[i+1 : len(tail)-1], nil
+		}
+	}
+	return tail[:len(tail)-1], nil
+}
+
+// saveToArchive appends records to the archive, chained to its last line.
+func saveToArchive(records []PartitionLine, conf ArchiveConfig, tsFormat string) error {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	segmentRecords := conf.SegmentRecords
+	if segmentRecords <= 0 {
+		segmentRecords = defaultArchiveSegmentRecords
+	}
+	if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+		return fmt.Errorf("archive output error: %w", err)
+	}
+	segments, err := archiveSegments(conf.Dir)
+	if err != nil {
+		return fmt.Errorf("archive output error: %w", err)
+	}
+
+	// The head of the chain is the last line of the last segment
+	var prev archiveEntry
+	var prevHash string
+	segment, firstSeq := archiveSegmentPath(conf.Dir, 1), uint64(1)
+	if len(segments) > 0 {
+		segment = segments[len(segments)-1]
+		if firstSeq, err = archiveSegmentFirstSeq(segment); err != nil {
+			return fmt.Errorf("archive output error: unexpected segment name %s", segment)
+		}
+		last, err := lastArchiveLine(segment)
+		if err != nil {
+			return fmt.Errorf("archive output error: %w", err)
+		}
+		if last != nil {
+			if err := json.Unmarshal(last, &prev); err != nil {
+				return fmt.Errorf("archive output error: last line of %s: %w", segment, err)
+			}
+			prevHash = archiveHash(last)
+		}
+	}
+
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for _, record := range records {
+		v, err := renderRecord(record, tsFormat)
+		if err != nil {
+			return fmt.Errorf("archive output error for %s: %w", record.PartitionName, err)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("archive output error for %s: %w", record.PartitionName, err)
+		}
+		entry := archiveEntry{Seq: prev.Seq + 1, Prev: prevHash, Record: data}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("archive output error for %s: %w", record.PartitionName, err)
+		}
+
+		if entry.Seq-firstSeq >= uint64(segmentRecords) {
+			if f != nil {
+				f.Close()
+				f = nil
+			}
+			_ = os.Chmod(segment, 0o444)
+			segment, firstSeq = archiveSegmentPath(conf.Dir, entry.Seq), entry.Seq
+		}
+		if f == nil {
+			if f, err = os.OpenFile(segment, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644); err != nil {
+				return fmt.Errorf("archive output error: %w", err)
+			}
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("archive write error for %s: %w", record.PartitionName, err)
+		}
+		prev, prevHash = entry, archiveHash(line)
+	}
+	if f != nil {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("archive write error: %w", err)
+		}
+	}
+	return nil
+}
+
+// archiveVerification is the outcome of verifyArchive.
+type archiveVerification struct {
+	Segments int
+	Records  uint64
+	// Head is the hash of the last line, what the next record is chained to
+	Head string
+	// Seen holds the hashes of all lines, to check an expected head is part of the chain
+	Seen map[string]bool
+}
+
+// verifyArchive checks the chain of the archive in dir, returning where it first breaks.
+func verifyArchive(dir string) (archiveVerification, error) {
+	result := archiveVerification{Seen: make(map[string]bool)}
+	segments, err := archiveSegments(dir)
+	if err != nil {
+		return result, err
+	}
+	if len(segments) == 0 {
+		return result, fmt.Errorf("no archive segments in %s", dir)
+	}
+	for _, segment := range segments {
+		if firstSeq, err := archiveSegmentFirstSeq(segment); err != nil || firstSeq != result.Records+1 {
+			return result, fmt.Errorf("%s follows record %d, segments are missing or renamed", segment, result.Records)
+		}
+		if err := verifyArchiveSegment(segment, &result); err != nil {
+			return result, err
+		}
+		result.Segments++
+	}
+	return result, nil
+}
+
+func verifyArchiveSegment(segment string, result *archiveVerification) error {
+	f, err := os.Open(segment)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		} else if err == io.EOF {
+			return fmt.Errorf("%s line %d: incomplete line, an append was cut short", segment, lineNo)
+		} else if err != nil {
+			return err
+		}
+		line = line[:len(line)-1]
+		var entry archiveEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("%s line %d: %w", segment, lineNo, err)
+		}
+		if entry.Seq != result.Records+1 {
+			return fmt.Errorf("%s line %d: record %d follows record %d, records are missing or out of order", segment, lineNo, entry.Seq, result.Records)
+		}
+		if entry.Prev != result.Head {
+			return fmt.Errorf("%s line %d: record %d does not chain to the record before it, the archive was altered", segment, lineNo, entry.Seq)
+		}
+		result.Records = entry.Seq
+		result.Head = archiveHash(line)
+		result.Seen[result.Head] = true
+	}
+}
+
+// runArchiveCommand implements `gosmart archive verify`, which checks the hash chain of the
+// archive output and prints its head hash.
+func runArchiveCommand(args []string) {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: gosmart archive verify [-f config | -dir dir] [-expect hash]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("archive verify", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	dir := fs.String("dir", "", "Archive directory, instead of the one in the config")
+	expect := fs.String("expect", "", "Head hash printed by an earlier verify, which must still be part of the chain")
+	_ = fs.Parse(args[1:])
+
+	if *dir == "" {
+		conf, err := loadConfig(*confFiPath)
+		if err != nil {
+			panic(fmt.Sprintf("Could not read config: %s\n", err))
+		}
+		if conf.Archive == nil {
+			fmt.Fprintln(os.Stderr, "archive verify needs an archive in the config or -dir")
+			os.Exit(2)
+		}
+		*dir = conf.Archive.Dir
+	}
+
+	result, err := verifyArchive(*dir)
+	if err != nil {
+		fmt.Printf("FAIL after %d records: %s\n", result.Records, err)
+		os.Exit(1)
+	}
+	if *expect != "" && !result.Seen[strings.ToLower(*expect)] {
+		fmt.Printf("FAIL: %s is not part of the chain, records were cut off or replaced\n", *expect)
+		os.Exit(1)
+	}
+	fmt.Printf("ok %d records in %d segments, head %s\n", result.Records, result.Segments, result.Head)
+}
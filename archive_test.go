@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archiveRecords returns n records, numbered in their partition names.
+func archiveRecords(from, n int) []PartitionLine {
+	var records []PartitionLine
+	for i := from; i < from+n; i++ {
+		records = append(records, PartitionLine{PartitionName: fmt.Sprintf("/dev/sd%d", i), Ts: time.Date(2024, 5, 1, 12, i, 0, 0, time.UTC)})
+	}
+	return records
+}
+
+// Appends in several batches roll over segments and keep one chain.
+func TestArchiveChain(t *testing.T) {
+	conf := ArchiveConfig{Dir: t.TempDir(), SegmentRecords: 3}
+	if err := saveToArchive(archiveRecords(0, 2), conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	first, err := verifyArchive(conf.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{3, 2} {
+		if err := saveToArchive(archiveRecords(int(first.Records), n), conf, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := verifyArchive(conf.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Records != 7 || result.Segments != 3 {
+		t.Errorf("verified %d records in %d segments, want 7 in 3", result.Records, result.Segments)
+	}
+	if !result.Seen[first.Head] || result.Head == first.Head {
+		t.Errorf("head %s of the first batch is not followed in the chain", first.Head)
+	}
+	segments, _ := archiveSegments(conf.Dir)
+	for i, want := range []string{"segment-000000000001.jsonl", "segment-000000000004.jsonl", "segment-000000000007.jsonl"} {
+		if filepath.Base(segments[i]) != want {
+			t.Errorf("segment %d is %s, want %s", i, filepath.Base(segments[i]), want)
+		}
+	}
+	// Full segments are made read-only
+	if info, err := os.Stat(segments[0]); err != nil || info.Mode().Perm()&0o222 != 0 {
+		t.Errorf("full segment is writable: %v %v", info.Mode(), err)
+	}
+	last, err := lastArchiveLine(segments[2])
+	if err != nil || archiveHash(last) != result.Head {
+		t.Errorf("last line %s does not hash to the head: %v", last, err)
+	}
+}
+
+func TestArchiveTampering(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the lines of the first of three segments, or the segments of dir
+		tamper func(t *testing.T, dir string, lines [][]byte) [][]byte
+		want   string
+	}{{
+		name: "record changed",
+		tamper: func(t *testing.T, dir string, lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte("/dev/sd1"), []byte("/dev/sd9"), 1)
+			return lines
+		},
+		want: "record 3 does not chain to the record before it",
+	}, {
+		name: "record removed",
+		tamper: func(t *testing.T, dir string, lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		},
+		want: "record 3 follows record 1",
+	}, {
+		name: "records swapped",
+		tamper: func(t *testing.T, dir string, lines [][]byte) [][]byte {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		},
+		want: "record 2 follows record 0",
+	}, {
+		name: "append cut short",
+		tamper: func(t *testing.T, dir string, lines [][]byte) [][]byte {
+			lines[2] = lines[2][:len(lines[2])-10]
+			return lines
+		},
+		want: "incomplete line",
+	}, {
+		name: "segment removed",
+		tamper: func(t *testing.T, dir string, lines [][]byte) [][]byte {
+			if err := os.Remove(archiveSegmentPath(dir, 4)); err != nil {
+				t.Fatal(err)
+			}
+			return lines
+		},
+		want: "segments are missing or renamed",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := ArchiveConfig{Dir: t.TempDir(), SegmentRecords: 3}
+			if err := saveToArchive(archiveRecords(0, 7), conf, ""); err != nil {
+				t.Fatal(err)
+			}
+			segment := archiveSegmentPath(conf.Dir, 1)
+			data, err := os.ReadFile(segment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := bytes.SplitAfter(data, []byte("\n"))
+			lines = tt.tamper(t, conf.Dir, lines[:len(lines)-1])
+			if err := os.Chmod(segment, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(segment, bytes.Join(lines, nil), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = verifyArchive(conf.Dir)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("verify returned %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// Appending to a segment whose last line was cut short fails instead of chaining to it.
+func TestArchiveAppendAfterCut(t *testing.T) {
+	conf := ArchiveConfig{Dir: t.TempDir()}
+	if err := saveToArchive(archiveRecords(0, 2), conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	segment := archiveSegmentPath(conf.Dir, 1)
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(segment, data[:len(data)-5], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveToArchive(archiveRecords(2, 1), conf, ""); err == nil || !strings.Contains(err.Error(), "incomplete line") {
+		t.Errorf("append returned %v", err)
+	}
+}
+
+func TestArchiveEmpty(t *testing.T) {
+	if _, err := verifyArchive(t.TempDir()); err == nil {
+		t.Error("verified an archive without segments")
+	}
+}
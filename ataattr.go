@@ -0,0 +1,93 @@
+package main
+
+import "fmt"
+
+// AtaSmartAttr is an ATA SMART attribute as a backend read it. It has the fields of smart.go's
+// attribute, which converts to it directly, so records look the same on platforms without smart.go.
+type AtaSmartAttr struct {
+	Id          uint8
+	Flags       uint16
+	Current     uint8
+	Worst       uint8
+	VendorBytes [6]byte
+	// Name and Type come from smart.go's drive database, or from smartctl for Name
+	Name     string
+	Type     int
+	ValueRaw uint64
+}
+
+// Raw value formats of AtaSmartAttr.Type used here, numbered as in smart.go.
+const (
+	ataAttrTypeTempMinMax = 18
+	ataAttrTypeTemp10X    = 19
+)
+
+// parseTemperature splits the raw value of a temperature attribute into the current, lowest and
+// highest temperature, following smartmontools' ata_format_attr_raw_value as smart.go does.
+func (a AtaSmartAttr) parseTemperature() (current, low, high int, err error) {
+	switch a.Type {
+	case ataAttrTypeTemp10X:
+		return int(a.ValueRaw&0xffff) / 10, 0, 0, nil
+	case ataAttrTypeTempMinMax:
+		word0 := uint16(a.ValueRaw)
+		word1 := uint16(a.ValueRaw >> 16)
+		word2 := uint16(a.ValueRaw >> 32)
+		raw0 := int8(a.ValueRaw)
+		raw1 := int8(a.ValueRaw >> 8)
+		raw2 := int8(a.ValueRaw >> 16)
+		raw3 := int8(a.ValueRaw >> 24)
+		raw4 := int8(a.ValueRaw >> 32)
+
+		ctw0 := checkTempWord(word0)
+		if word2 == 0 {
+			if word1 == 0 && ctw0 != 0 {
+				// 00 00 00 00 xx TT
+				return int(raw0), 0, 0, nil
+			} else if lo, hi, ok := checkTempRange(raw0, raw2, raw3); ctw0 != 0 && ok {
+				// 00 00 HL LH xx TT
+				return int(raw0), int(lo), int(hi), nil
+			} else if lo, hi, ok := checkTempRange(raw0, raw1, raw2); raw3 == 0 && ok {
+				// 00 00 00 HL LH TT
+				return int(raw0), int(lo), int(hi), nil
+			}
+		} else if ctw0 != 0 {
+			if lo, hi, ok := checkTempRange(raw0, raw2, raw4); ctw0&checkTempWord(word1)&checkTempWord(word2) != 0 && ok {
+				// xx HL xx LH xx TT
+				return int(raw0), int(lo), int(hi), nil
+			} else if lo, hi, ok := checkTempRange(raw0, raw2, raw3); word2 < 0x7fff && ok && hi >= 40 {
+				// CC CC HL LH xx TT
+				return int(raw0), int(lo), int(hi), nil
+			}
+		}
+		return int(raw0), 0, 0, nil
+	}
+	return 0, 0, 0, fmt.Errorf("attribute %d is not a temperature, raw format %d", a.Id, a.Type)
+}
+
+// checkTempWord classifies a 16 bit word of a temperature raw value by the sign it can hold.
+func checkTempWord(word uint16) int {
+	switch {
+	case word <= 0x7f:
+		// >= 0, signed byte or word
+		return 0x11
+	case word <= 0xff:
+		// < 0, signed byte
+		return 0x01
+	case word >= 0xff80:
+		// < 0, signed word
+		return 0x10
+	}
+	return 0x00
+}
+
+// checkTempRange returns t1 and t2 as the lowest and highest temperature if t lies between them
+// and they are plausible temperatures.
+func checkTempRange(t, t1, t2 int8) (int8, int8, bool) {
+	if t1 > t2 {
+		t1, t2 = t2, t1
+	}
+	if -60 <= t1 && t1 <= t && t <= t2 && t2 <= 120 && !(t1 == -1 && t2 <= 0) {
+		return t1, t2, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// sgIoHdr is the kernel's struct sg_io_hdr.
+type sgIoHdr struct {
+	interfaceId    int32
+	dxferDirection int32
+	cmdLen         uint8
+	mxSbLen        uint8
+	iovecCount     uint16
+	dxferLen       uint32
+	dxferp         uintptr
+	cmdp           uintptr
+	sbp            uintptr
+	timeout        uint32
+	flags          uint32
+	packId         int32
+	usrPtr         uintptr
+	status         uint8
+	maskedStatus   uint8
+	msgStatus      uint8
+	sbLenWr        uint8
+	hostStatus     uint16
+	driverStatus   uint16
+	resid          int32
+	duration       uint32
+	info           uint32
+}
+
+const (
+	sgIo           = 0x2285
+	sgDxferFromDev = -3
+	// ataPassThrough16 carries ATA commands to SATA drives behind libata or a SAT bridge
+	ataPassThrough16   = 0x85
+	ataIdentifyDevice  = 0xEC
+	ataIdentifyTimeout = 5000
+)
+
+// readAtaIdentify returns the 512 bytes of IDENTIFY DEVICE data. smart.go skips the words it does
+// not name, like the security status, so the command is sent again with an ATA passthrough.
+func readAtaIdentify(devName string) ([]byte, error) {
+	f, err := os.Open(devName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	sense := make([]byte, 32)
+	// PIO data-in, transfer from the device, length in the sector count field counted in blocks
+	cdb := []byte{ataPassThrough16, 4 << 1, 0x0E, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, ataIdentifyDevice, 0}
+	hdr := sgIoHdr{
+		interfaceId:    'S',
+		dxferDirection: sgDxferFromDev,
+		cmdLen:         uint8(len(cdb)),
+		mxSbLen:        uint8(len(sense)),
+		dxferLen:       uint32(len(buf)),
+		dxferp:         uintptr(unsafe.Pointer(&buf[0])),
+		cmdp:           uintptr(unsafe.Pointer(&cdb[0])),
+		sbp:            uintptr(unsafe.Pointer(&sense[0])),
+		timeout:        ataIdentifyTimeout,
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), sgIo, uintptr(unsafe.Pointer(&hdr)))
+	runtime.KeepAlive(buf)
+	runtime.KeepAlive(cdb)
+	runtime.KeepAlive(sense)
+	if errno != 0 {
+		return nil, errno
+	}
+	if hdr.status != 0 || hdr.hostStatus != 0 || hdr.driverStatus&^0x08 != 0 {
+		return nil, fmt.Errorf("IDENTIFY DEVICE failed with status %#x, host status %#x, driver status %#x", hdr.status, hdr.hostStatus, hdr.driverStatus)
+	}
+	return buf, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func readAtaIdentify(devName string) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
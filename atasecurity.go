@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// AtaSecurity is the ATA security feature set state from IDENTIFY DEVICE word 128, with the erase
+// time estimates of words 89 and 90, for planning secure erases before retiring drives.
+type AtaSecurity struct {
+	Supported bool `json:"supported"`
+	// Enabled is set when a user password is set
+	Enabled bool `json:"enabled"`
+	Locked  bool `json:"locked"`
+	// Frozen drives refuse security commands until their next power cycle. Most BIOSes freeze
+	// drives at boot, suspending and resuming the host usually unfreezes them.
+	Frozen bool `json:"frozen"`
+	// CountExpired is set after five wrong passwords, unlock attempts then fail until a power cycle
+	CountExpired           bool `json:"count_expired"`
+	EnhancedEraseSupported bool `json:"enhanced_erase_supported"`
+	// EraseMinutes and EnhancedEraseMinutes are the estimated durations of SECURITY ERASE UNIT, nil
+	// if the drive does not report them. The highest value of the field means at least that long.
+	EraseMinutes         *int `json:"erase_minutes,omitempty"`
+	EnhancedEraseMinutes *int `json:"enhanced_erase_minutes,omitempty"`
+	// EraseReady is set when a secure erase can be started right away
+	EraseReady bool `json:"erase_ready"`
+}
+
+func (s AtaSecurity) String() string {
+	if !s.Supported {
+		return "not supported"
+	}
+	parts := []string{enabledString(s.Enabled)}
+	for _, state := range []struct {
+		set  bool
+		name string
+	}{{s.Locked, "locked"}, {s.Frozen, "frozen"}, {s.CountExpired, "password attempts exceeded"}} {
+		if state.set {
+			parts = append(parts, state.name)
+		}
+	}
+	if s.EraseMinutes != nil {
+		parts = append(parts, fmt.Sprintf("erase %d min", *s.EraseMinutes))
+	}
+	if s.EnhancedEraseMinutes != nil {
+		parts = append(parts, fmt.Sprintf("enhanced erase %d min", *s.EnhancedEraseMinutes))
+	}
+	if s.EraseReady {
+		parts = append(parts, "ready to erase")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseAtaSecurity decodes the security words of IDENTIFY DEVICE data.
+func parseAtaSecurity(identify []byte) (*AtaSecurity, error) {
+	if len(identify) < 512 {
+		return nil, fmt.Errorf("IDENTIFY DEVICE data is %d bytes, want 512", len(identify))
+	}
+	word := func(n int) uint16 { return binary.LittleEndian.Uint16(identify[2*n:]) }
+	status := word(128)
+	s := &AtaSecurity{Supported: status&0x1 != 0}
+	if !s.Supported {
+		return s, nil
+	}
+	s.Enabled = status&0x2 != 0
+	s.Locked = status&0x4 != 0
+	s.Frozen = status&0x8 != 0
+	s.CountExpired = status&0x10 != 0
+	s.EnhancedEraseSupported = status&0x20 != 0
+	s.EraseMinutes = ataEraseMinutes(word(89))
+	if s.EnhancedEraseSupported {
+		s.EnhancedEraseMinutes = ataEraseMinutes(word(90))
+	}
+	s.EraseReady = !s.Locked && !s.Frozen && !s.CountExpired
+	return s, nil
+}
+
+// ataEraseMinutes decodes an erase time word, counted in 2 minute units in bits 14:0 if bit 15 is
+// set and in bits 7:0 otherwise. Zero means the time is not reported.
+func ataEraseMinutes(w uint16) *int {
+	units := int(w & 0xFF)
+	if w&0x8000 != 0 {
+		units = int(w & 0x7FFF)
+	}
+	if units == 0 {
+		return nil
+	}
+	minutes := units * 2
+	return &minutes
+}
+
+// collectAtaSecurity reads the security state of an ATA drive and warns when it is locked, its
+// data is then unreadable until the password is given.
+func collectAtaSecurity(devName string, line PartitionLine) PartitionLine {
+	identify, err := readAtaIdentify(devName)
+	if err != nil {
+		fmt.Printf("Could not read security state of %s: %s\n", devName, err)
+		return line
+	}
+	security, err := parseAtaSecurity(identify)
+	if err != nil {
+		fmt.Printf("Could not read security state of %s: %s\n", devName, err)
+		return line
+	}
+	line.AtaSecurity = security
+	if security.Locked {
+		line.warn("locked by an ATA security password")
+	}
+	return line
+}
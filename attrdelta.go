@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultDeltaKeyframeRows is how often a device's attributes are stored in full with
+// DeltaAttributes, if DeltaKeyframeRows is zero.
+const defaultDeltaKeyframeRows = 100
+
+// attributeDelta is stored in the attributes column of delta encoded rows instead of the array of
+// all attributes. Changed are the attributes that differ from the previous row of the device, or
+// are new.
+type attributeDelta struct {
+	Changed []json.RawMessage `json:"changed"`
+}
+
+// rowChainKey identifies the rows of one device that deltas are chained over, like the fleet
+// overview.
+type rowChainKey struct {
+	uuid          string
+	partitionName string
+}
+
+// isAttributeDelta reports whether a stored attributes column is delta encoded, full rows hold an
+// array.
+func isAttributeDelta(attributes string) bool {
+	return strings.HasPrefix(strings.TrimSpace(attributes), "{")
+}
+
+// attributeState is the full attribute set of a device as of its last applied row.
+type attributeState struct {
+	// full is the last full row as stored, parsed into attrs only once a delta follows it
+	full  string
+	attrs map[uint8]json.RawMessage
+	// deltas is the number of delta rows since the last full row
+	deltas int
+}
+
+func (s *attributeState) empty() bool {
+	return s.full == "" && s.attrs == nil
+}
+
+// parsed returns the attributes by ID, parsing the last full row if needed.
+func (s *attributeState) parsed() (map[uint8]json.RawMessage, error) {
+	if s.attrs == nil {
+		s.attrs = make(map[uint8]json.RawMessage)
+		if s.full != "" {
+			var attrs []json.RawMessage
+			if err := json.Unmarshal([]byte(s.full), &attrs); err != nil {
+				return nil, err
+			}
+			for _, attr := range attrs {
+				id, err := attributeId(attr)
+				if err != nil {
+					return nil, err
+				}
+				s.attrs[id] = attr
+			}
+		}
+	}
+	return s.attrs, nil
+}
+
+// apply moves the state on by a stored row.
+func (s *attributeState) apply(attributes string) error {
+	if !isAttributeDelta(attributes) {
+		*s = attributeState{full: attributes}
+		return nil
+	}
+	var delta attributeDelta
+	if err := json.Unmarshal([]byte(attributes), &delta); err != nil {
+		return err
+	}
+	attrs, err := s.parsed()
+	if err != nil {
+		return err
+	}
+	for _, attr := range delta.Changed {
+		id, err := attributeId(attr)
+		if err != nil {
+			return err
+		}
+		attrs[id] = attr
+	}
+	s.full = ""
+	s.deltas++
+	return nil
+}
+
+// text returns the full attributes as stored in full rows, sorted by ID.
+func (s *attributeState) text() (string, error) {
+	if s.full != "" {
+		return s.full, nil
+	}
+	attrs, err := s.parsed()
+	if err != nil {
+		return "", err
+	}
+	ids := make([]uint8, 0, len(attrs))
+	for id := range attrs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	list := make([]json.RawMessage, len(ids))
+	for i, id := range ids {
+		list[i] = attrs[id]
+	}
+	b, err := json.Marshal(list)
+	return string(b), err
+}
+
+// encode returns what to store for a row with the full attributes given, and applies it. It is a
+// delta unless the state is empty, an attribute went missing or keyframeRows rows were stored since
+// the last full row.
+func (s *attributeState) encode(full string, keyframeRows int) (string, error) {
+	if s.empty() || s.deltas >= keyframeRows-1 {
+		*s = attributeState{full: full}
+		return full, nil
+	}
+	var attrs []json.RawMessage
+	if err := json.Unmarshal([]byte(full), &attrs); err != nil {
+		return "", err
+	}
+	previous, err := s.parsed()
+	if err != nil {
+		return "", err
+	}
+	delta := attributeDelta{Changed: make([]json.RawMessage, 0)}
+	seen := make(map[uint8]bool, len(attrs))
+	for _, attr := range attrs {
+		id, err := attributeId(attr)
+		if err != nil {
+			return "", err
+		}
+		seen[id] = true
+		if old, ok := previous[id]; !ok || !sameJson(old, attr) {
+			delta.Changed = append(delta.Changed, attr)
+		}
+	}
+	for id := range previous {
+		if !seen[id] {
+			*s = attributeState{full: full}
+			return full, nil
+		}
+	}
+	b, err := json.Marshal(delta)
+	if err != nil {
+		return "", err
+	}
+	return string(b), s.apply(string(b))
+}
+
+func attributeId(attr json.RawMessage) (uint8, error) {
+	var id struct{ Id uint8 }
+	err := json.Unmarshal(attr, &id)
+	return id.Id, err
+}
+
+// sameJson compares JSON values regardless of formatting and key order, which JSONB does not keep.
+func sameJson(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// loadAttributeState reads the attributes of a device as of its last row before ts, from its last
+// full row on.
+func loadAttributeState(db *sqlx.DB, conf DBConfig, key rowChainKey, ts time.Time) (*attributeState, error) {
+	var rows []string
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	err := db.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT attributes::text FROM %[1]s.%[2]s WHERE uuid = $1 AND partition_name = $2 AND ts < $3 AND ts >= (SELECT max(ts) FROM %[1]s.%[2]s WHERE uuid = $1 AND partition_name = $2 AND ts < $3 AND jsonb_typeof(attributes) = 'array') ORDER BY ts;`, conf.Schema, conf.Table), key.uuid, key.partitionName, ts)
+	if err != nil {
+		return nil, err
+	}
+	state := &attributeState{}
+	for _, row := range rows {
+		if err := state.apply(row); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// stateLoader reads the attributes of a device as of its last stored row before ts.
+type stateLoader func(key rowChainKey, ts time.Time) (*attributeState, error)
+
+// dbStateLoader loads attribute states with loadAttributeState.
+func dbStateLoader(db *sqlx.DB, conf DBConfig) stateLoader {
+	return func(key rowChainKey, ts time.Time) (*attributeState, error) {
+		return loadAttributeState(db, conf, key, ts)
+	}
+}
+
+// encodeAttributeDeltas replaces the attributes of rows by deltas against the previous row of
+// their device, stored or earlier in rows.
+func encodeAttributeDeltas(db *sqlx.DB, conf DBConfig, rows []PartitionLineDb) error {
+	keyframeRows := conf.DeltaKeyframeRows
+	if keyframeRows <= 0 {
+		keyframeRows = defaultDeltaKeyframeRows
+	}
+	return encodeRowDeltas(rows, keyframeRows, dbStateLoader(db, conf))
+}
+
+// encodeRowDeltas is encodeAttributeDeltas with the stored rows read by load.
+func encodeRowDeltas(rows []PartitionLineDb, keyframeRows int, load stateLoader) error {
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return rows[order[i]].Ts.Before(rows[order[j]].Ts) })
+
+	states := make(map[rowChainKey]*attributeState)
+	encoded := make([]string, len(rows))
+	for _, i := range order {
+		key := rowChainKey{uuid: rows[i].Uuid, partitionName: rows[i].PartitionName}
+		state, ok := states[key]
+		if !ok {
+			var err error
+			if state, err = load(key, rows[i].Ts); err != nil {
+				return err
+			}
+			states[key] = state
+		}
+		full, _ := rows[i].Attributes.(string)
+		var err error
+		if encoded[i], err = state.encode(full, keyframeRows); err != nil {
+			return fmt.Errorf("%s: %w", key.partitionName, err)
+		}
+	}
+	// Rows are only changed once all of them are encoded, so on error they are stored in full
+	for i := range rows {
+		rows[i].Attributes = encoded[i]
+	}
+	return nil
+}
+
+// attributeResolver turns stored rows back into full attributes, reading the rows a device's
+// first delta builds on from the database.
+type attributeResolver struct {
+	load   stateLoader
+	states map[rowChainKey]*attributeState
+}
+
+func newAttributeResolver(db *sqlx.DB, conf DBConfig) *attributeResolver {
+	return &attributeResolver{load: dbStateLoader(db, conf), states: make(map[rowChainKey]*attributeState)}
+}
+
+// resolve returns the full attributes of a stored row. The rows of a device must be resolved
+// oldest first and without gaps, only the base of its first delta is read from the database.
+func (r *attributeResolver) resolve(uuid, partitionName string, ts time.Time, attributes string) (string, error) {
+	key := rowChainKey{uuid: uuid, partitionName: partitionName}
+	state, ok := r.states[key]
+	if !isAttributeDelta(attributes) {
+		if !ok {
+			state = &attributeState{}
+			r.states[key] = state
+		}
+		return attributes, state.apply(attributes)
+	}
+	if !ok {
+		var err error
+		if state, err = r.load(key, ts); err != nil {
+			return "", err
+		}
+		r.states[key] = state
+	}
+	if err := state.apply(attributes); err != nil {
+		return "", err
+	}
+	return state.text()
+}
+
+// resolveHistoryRows replaces delta encoded attributes of rows, in the order given, by the full
+// attributes.
+func resolveHistoryRows(db *sqlx.DB, conf DBConfig, rows []historyRow) error {
+	resolver := newAttributeResolver(db, conf)
+	for i, row := range rows {
+		attributes, err := resolver.resolve(row.Uuid, row.PartitionName, row.Ts, row.Attributes)
+		if err != nil {
+			return fmt.Errorf("%s at %s: %w", row.PartitionName, row.Ts.Format(time.RFC3339), err)
+		}
+		rows[i].Attributes = attributes
+	}
+	return nil
+}
+
+// rebaseAttributeDeltas stores the first row at or after the cutoff of rule of every device in full
+// if it is a delta, and the delta rows before the cutoff the rule keeps, so deleting the rows it
+// matches keeps the remaining deltas readable.
+func rebaseAttributeDeltas(db *sqlx.DB, conf DBConfig, rule retentionRule) error {
+	var rows []historyRow
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	err := db.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT DISTINCT ON (uuid, partition_name) uuid, partition_name, ts, attributes::text AS attributes FROM %s.%s WHERE ts >= $1 ORDER BY uuid, partition_name, ts;`, conf.Schema, conf.Table), rule.cutoff)
+	if err != nil {
+		return err
+	}
+	if rule.keepsOlder {
+		var kept []historyRow
+		err := db.SelectContext(ctx, &kept, fmt.Sprintf(`SELECT uuid, partition_name, ts, attributes::text AS attributes FROM %s.%s WHERE ts < $1 AND NOT (%s) AND jsonb_typeof(attributes) = 'object';`, conf.Schema, conf.Table, rule.filter), append([]any{rule.cutoff}, rule.args...)...)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, kept...)
+	}
+	for _, row := range rows {
+		if !isAttributeDelta(row.Attributes) {
+			continue
+		}
+		full, err := newAttributeResolver(db, conf).resolve(row.Uuid, row.PartitionName, row.Ts, row.Attributes)
+		if err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s.%s SET attributes = $1 WHERE uuid = $2 AND partition_name = $3 AND ts = $4;`, conf.Schema, conf.Table), full, row.Uuid, row.PartitionName, row.Ts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
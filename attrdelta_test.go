@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAttributeStateEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		// rows are the full attributes of consecutive rows of a device
+		rows         []string
+		keyframeRows int
+		// want is what is stored for each row
+		want []string
+	}{{
+		name:         "first row is full",
+		rows:         []string{`[{"Id":5,"ValueRaw":0}]`},
+		keyframeRows: 100,
+		want:         []string{`[{"Id":5,"ValueRaw":0}]`},
+	}, {
+		name:         "only changes are stored",
+		rows:         []string{`[{"Id":5,"ValueRaw":0},{"Id":9,"ValueRaw":100}]`, `[{"Id":5,"ValueRaw":0},{"Id":9,"ValueRaw":101}]`, `[{"Id":5,"ValueRaw":0},{"Id":9,"ValueRaw":101}]`},
+		keyframeRows: 100,
+		want:         []string{`[{"Id":5,"ValueRaw":0},{"Id":9,"ValueRaw":100}]`, `{"changed":[{"Id":9,"ValueRaw":101}]}`, `{"changed":[]}`},
+	}, {
+		name:         "key order is not a change",
+		rows:         []string{`[{"Id":5,"ValueRaw":0,"Current":100}]`, `[{"Current":100,"Id":5,"ValueRaw":0}]`},
+		keyframeRows: 100,
+		want:         []string{`[{"Id":5,"ValueRaw":0,"Current":100}]`, `{"changed":[]}`},
+	}, {
+		name:         "new attribute",
+		rows:         []string{`[{"Id":5,"ValueRaw":0}]`, `[{"Id":5,"ValueRaw":0},{"Id":197,"ValueRaw":1}]`},
+		keyframeRows: 100,
+		want:         []string{`[{"Id":5,"ValueRaw":0}]`, `{"changed":[{"Id":197,"ValueRaw":1}]}`},
+	}, {
+		name:         "missing attribute stores a full row",
+		rows:         []string{`[{"Id":5,"ValueRaw":0},{"Id":197,"ValueRaw":1}]`, `[{"Id":5,"ValueRaw":0}]`},
+		keyframeRows: 100,
+		want:         []string{`[{"Id":5,"ValueRaw":0},{"Id":197,"ValueRaw":1}]`, `[{"Id":5,"ValueRaw":0}]`},
+	}, {
+		name:         "keyframes",
+		rows:         []string{`[{"Id":9,"ValueRaw":1}]`, `[{"Id":9,"ValueRaw":2}]`, `[{"Id":9,"ValueRaw":3}]`, `[{"Id":9,"ValueRaw":4}]`, `[{"Id":9,"ValueRaw":5}]`},
+		keyframeRows: 3,
+		want:         []string{`[{"Id":9,"ValueRaw":1}]`, `{"changed":[{"Id":9,"ValueRaw":2}]}`, `{"changed":[{"Id":9,"ValueRaw":3}]}`, `[{"Id":9,"ValueRaw":4}]`, `{"changed":[{"Id":9,"ValueRaw":5}]}`},
+	}, {
+		name:         "every row full",
+		rows:         []string{`[{"Id":9,"ValueRaw":1}]`, `[{"Id":9,"ValueRaw":2}]`},
+		keyframeRows: 1,
+		want:         []string{`[{"Id":9,"ValueRaw":1}]`, `[{"Id":9,"ValueRaw":2}]`},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state attributeState
+			for i, row := range tt.rows {
+				stored, err := state.encode(row, tt.keyframeRows)
+				if err != nil {
+					t.Fatalf("row %d: %s", i, err)
+				}
+				if stored != tt.want[i] {
+					t.Errorf("row %d stored as %s, want %s", i, stored, tt.want[i])
+				}
+				if isAttributeDelta(stored) != isAttributeDelta(tt.want[i]) {
+					t.Errorf("row %d: isAttributeDelta(%s) is %v", i, stored, isAttributeDelta(stored))
+				}
+				text, err := state.text()
+				if err != nil {
+					t.Fatalf("row %d: %s", i, err)
+				}
+				if !sameJson([]byte(text), []byte(row)) {
+					t.Errorf("row %d: state is %s after encoding, want %s", i, text, row)
+				}
+			}
+		})
+	}
+}
+
+// Applying the stored rows in order gives back the full attributes, sorted by ID.
+func TestAttributeStateApply(t *testing.T) {
+	var state attributeState
+	if !state.empty() {
+		t.Fatal("new state is not empty")
+	}
+	steps := []struct {
+		stored string
+		want   string
+	}{
+		{`[{"Id":197,"ValueRaw":0},{"Id":5,"ValueRaw":0}]`, `[{"Id":197,"ValueRaw":0},{"Id":5,"ValueRaw":0}]`},
+		{`{"changed":[{"Id":5,"ValueRaw":3}]}`, `[{"Id":5,"ValueRaw":3},{"Id":197,"ValueRaw":0}]`},
+		{`{"changed":[{"Id":9,"ValueRaw":10},{"Id":197,"ValueRaw":1}]}`, `[{"Id":5,"ValueRaw":3},{"Id":9,"ValueRaw":10},{"Id":197,"ValueRaw":1}]`},
+		{`{"changed":[]}`, `[{"Id":5,"ValueRaw":3},{"Id":9,"ValueRaw":10},{"Id":197,"ValueRaw":1}]`},
+		{`[{"Id":5,"ValueRaw":4}]`, `[{"Id":5,"ValueRaw":4}]`},
+	}
+	for i, step := range steps {
+		if err := state.apply(step.stored); err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+		text, err := state.text()
+		if err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+		if text != step.want {
+			t.Errorf("step %d: %s, want %s", i, text, step.want)
+		}
+	}
+	if state.deltas != 0 {
+		t.Errorf("%d deltas counted after a full row", state.deltas)
+	}
+}
+
+func TestAttributeStateInvalid(t *testing.T) {
+	for _, stored := range []string{`{"changed":[{"Id":"five"}]}`, `{"changed":`} {
+		state := attributeState{full: `[{"Id":5,"ValueRaw":0}]`}
+		if err := state.apply(stored); err == nil {
+			t.Errorf("applying %s succeeded", stored)
+		}
+	}
+	state := attributeState{full: `[{"Id":5,"ValueRaw":0}]`}
+	if _, err := state.encode(`not json`, 100); err == nil {
+		t.Error("encoding invalid attributes succeeded")
+	}
+}
+
+// deltaTable stands in for the readings table, holding attributes as JSONB gives them back.
+type deltaTable []PartitionLineDb
+
+// insert stores rows like Postgres, which reorders the keys of JSONB objects.
+func (table *deltaTable) insert(t *testing.T, rows []PartitionLineDb) {
+	t.Helper()
+	for _, row := range rows {
+		var v any
+		if err := json.Unmarshal([]byte(row.Attributes.(string)), &v); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(v)
+		row.Attributes = string(b)
+		*table = append(*table, row)
+	}
+}
+
+// load is loadAttributeState on the table: the rows of the device before ts, from its last full
+// row on.
+func (table deltaTable) load(key rowChainKey, ts time.Time) (*attributeState, error) {
+	var rows []PartitionLineDb
+	for _, row := range table {
+		if row.Uuid != key.uuid || row.PartitionName != key.partitionName || !row.Ts.Before(ts) {
+			continue
+		}
+		if !isAttributeDelta(row.Attributes.(string)) {
+			rows = nil
+		}
+		rows = append(rows, row)
+	}
+	state := &attributeState{}
+	for _, row := range rows {
+		if err := state.apply(row.Attributes.(string)); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// query reads the rows of a device at or after since, resolved like the history of trends and
+// the fleet overview.
+func (table deltaTable) query(t *testing.T, partitionName string, since time.Time) []historyRow {
+	t.Helper()
+	resolver := &attributeResolver{load: table.load, states: make(map[rowChainKey]*attributeState)}
+	var rows []historyRow
+	for _, row := range table {
+		if row.PartitionName != partitionName || row.Ts.Before(since) {
+			continue
+		}
+		attributes, err := resolver.resolve(row.Uuid, row.PartitionName, row.Ts, row.Attributes.(string))
+		if err != nil {
+			t.Fatalf("%s at %s: %s", row.PartitionName, row.Ts, err)
+		}
+		rows = append(rows, historyRow{Uuid: row.Uuid, PartitionName: row.PartitionName, Ts: row.Ts, Attributes: attributes})
+	}
+	return rows
+}
+
+// Records stored delta encoded in batches read back as they were collected, from the first row of
+// a device or from a delta in the middle of its chain.
+func TestAttributeDeltaRoundTrip(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	attr := func(id uint8, raw uint64) Attr {
+		return Attr{AtaSmartAttr: AtaSmartAttr{Id: id, Current: 100, Worst: 100, ValueRaw: raw}, ValueDecoded: raw, Unit: "count"}
+	}
+	var records []PartitionLine
+	for run := 0; run < 12; run++ {
+		ts := start.Add(time.Duration(run) * time.Hour)
+		sda := []Attr{attr(5, uint64(run/4)), attr(9, uint64(1000+run))}
+		// Pending sectors show up for a few runs, their disappearance stores a full row
+		if run >= 5 && run < 8 {
+			sda = append(sda, attr(197, uint64(run)))
+		}
+		records = append(records,
+			PartitionLine{Uuid: "u1", Ts: ts, PartitionName: "/dev/sda", Attributes: sda},
+			PartitionLine{Uuid: "u1", Ts: ts, PartitionName: "/dev/sdb", Attributes: []Attr{attr(5, 0), attr(9, 500)}},
+		)
+	}
+
+	var table deltaTable
+	for batch := 0; batch < len(records); batch += 6 {
+		var rows []PartitionLineDb
+		for _, record := range records[batch:min(batch+6, len(records))] {
+			rows = append(rows, record.partitionLineToDb())
+		}
+		if err := encodeRowDeltas(rows, 5, table.load); err != nil {
+			t.Fatal(err)
+		}
+		table.insert(t, rows)
+	}
+	deltas := 0
+	for _, row := range table {
+		if isAttributeDelta(row.Attributes.(string)) {
+			deltas++
+		}
+	}
+	if deltas == 0 || deltas == len(table) {
+		t.Fatalf("%d of %d rows stored as deltas", deltas, len(table))
+	}
+
+	for _, since := range []time.Time{start, start.Add(6 * time.Hour)} {
+		for _, name := range []string{"/dev/sda", "/dev/sdb"} {
+			var want []historyRow
+			for _, record := range records {
+				if record.PartitionName == name && !record.Ts.Before(since) {
+					attrs, _ := json.Marshal(record.Attributes)
+					want = append(want, historyRow{Attributes: string(attrs)})
+				}
+			}
+			got := table.query(t, name, since)
+			if len(got) != len(want) {
+				t.Fatalf("%s since %s: %d rows, want %d", name, since, len(got), len(want))
+			}
+			for i := range got {
+				if !sameJson([]byte(got[i].Attributes), []byte(want[i].Attributes)) {
+					t.Errorf("%s row %d since %s read back as %s, want %s", name, i, since, got[i].Attributes, want[i].Attributes)
+				}
+			}
+			gotHistory, err := attributeHistory(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantHistory, _ := attributeHistory(want)
+			if !reflect.DeepEqual(gotHistory, wantHistory) {
+				t.Errorf("%s since %s has history %v, want %v", name, since, gotHistory, wantHistory)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"strings"
+)
+
+// RawDecodeRule extracts the meaningful part of a packed raw value for drives whose model starts
+// with ModelPrefix, as (raw >> Shift) masked to Bits bits. Bits of 0 keeps all remaining bits.
+type RawDecodeRule struct {
+	ModelPrefix string  `json:"model_prefix"`
+	Attributes  []uint8 `json:"attributes"`
+	Shift       uint8   `json:"shift"`
+	Bits        uint8   `json:"bits"`
+}
+
+// builtinRawDecodeRules cover vendors known to pack several counters into one raw value.
+var builtinRawDecodeRules = []RawDecodeRule{
+	// Seagate reports Raw_Read_Error_Rate, Seek_Error_Rate and Hardware_ECC_Recovered as a 16 bit
+	// error count on top of a 32 bit operation count
+	{ModelPrefix: "ST", Attributes: []uint8{1, 7, 195}, Shift: 32, Bits: 16},
+}
+
+// buildAttributes turns a device's attribute table into the attributes reported in line.
+func buildAttributes(line PartitionLine, attrs map[uint8]AtaSmartAttr, conf Config) PartitionLine {
+	results := selectAttributes(attrs, conf.Attributes, conf.SkipZeroAttributes)
+	results = applyAttributeNames(results, line.Model, conf)
+	results = decodeRawValues(results, line.Model, conf)
+	results = decodeTemperatures(results)
+	results = normalizeUnits(results, conf.AttributeUnits)
+	line.Attributes = classifyMetricTypes(results, conf.AttributeMetricTypes)
+	line.rawAttributes = attrs
+
+	line.UnsupportedAttributes = unsupportedAttributes(attrs, conf.Attributes)
+	if len(line.UnsupportedAttributes) > 0 {
+		log.Printf("%s does not report requested attributes %v\n", line.PartitionName, line.UnsupportedAttributes)
+	}
+	return line
+}
+
+// AttributeFilter narrows the attributes one output receives to those in Allow, if set, without
+// those in Deny. It cannot add attributes that are not collected.
+type AttributeFilter struct {
+	Allow []uint8 `json:"allow,omitempty"`
+	Deny  []uint8 `json:"deny,omitempty"`
+}
+
+// filterAttributes returns record with only the attributes filter lets through.
+func filterAttributes(record PartitionLine, filter AttributeFilter) PartitionLine {
+	if len(filter.Allow) == 0 && len(filter.Deny) == 0 {
+		return record
+	}
+	attrs := make([]Attr, 0, len(record.Attributes))
+	for _, attr := range record.Attributes {
+		if (len(filter.Allow) == 0 || slices.Contains(filter.Allow, attr.Id)) && !slices.Contains(filter.Deny, attr.Id) {
+			attrs = append(attrs, attr)
+		}
+	}
+	record.Attributes = attrs
+	return record
+}
+
+// selectAttributes picks the requested attribute IDs from a device's attribute table, sorted by ID.
+// Attributes the device does not report are left out, as are zero raw values if skipZero is set.
+func selectAttributes(attrs map[uint8]AtaSmartAttr, attrListToRead []uint8, skipZero bool) []Attr {
+	attrResults := make([]Attr, 0)
+
+	for _, attrNum := range attrListToRead {
+		attr, ok := attrs[attrNum]
+		if !ok || (skipZero && attr.ValueRaw == 0) {
+			continue
+		}
+		attrResults = append(attrResults, Attr{AtaSmartAttr: attr})
+	}
+	slices.SortFunc(attrResults, func(a, b Attr) int { return cmp.Compare(a.Id, b.Id) })
+	return attrResults
+}
+
+// unsupportedAttributes lists the requested attribute IDs missing from a device's attribute table.
+func unsupportedAttributes(attrs map[uint8]AtaSmartAttr, attrListToRead []uint8) []int {
+	var missing []int
+	for _, attrNum := range attrListToRead {
+		if _, ok := attrs[attrNum]; !ok {
+			missing = append(missing, int(attrNum))
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+// applyAttributeNames replaces vendor attribute names with the configured overrides for the drive model.
+func applyAttributeNames(attrs []Attr, model string, conf Config) []Attr {
+	modelNames := conf.ModelAttributeNames[model]
+	for i, attr := range attrs {
+		if name, ok := modelNames[attr.Id]; ok {
+			attrs[i].Name = name
+		} else if name, ok := conf.AttributeNames[attr.Id]; ok {
+			attrs[i].Name = name
+		}
+	}
+	return attrs
+}
+
+// decodeRawValues fills ValueDecoded using the first decode rule matching the drive model and
+// attribute, falling back to the raw value.
+func decodeRawValues(attrs []Attr, model string, conf Config) []Attr {
+	rules := append(append([]RawDecodeRule{}, conf.RawDecodeRules...), builtinRawDecodeRules...)
+	for i, attr := range attrs {
+		attrs[i].ValueDecoded = attr.ValueRaw
+		if rule, ok := findRawDecodeRule(rules, model, attr.Id); ok {
+			attrs[i].ValueDecoded = attr.ValueRaw >> rule.Shift
+			if rule.Bits > 0 {
+				attrs[i].ValueDecoded &= 1<<rule.Bits - 1
+			}
+		}
+	}
+	return attrs
+}
+
+func findRawDecodeRule(rules []RawDecodeRule, model string, attrNum uint8) (RawDecodeRule, bool) {
+	for _, rule := range rules {
+		if !strings.HasPrefix(model, rule.ModelPrefix) {
+			continue
+		}
+		for _, id := range rule.Attributes {
+			if id == attrNum {
+				return rule, true
+			}
+		}
+	}
+	return RawDecodeRule{}, false
+}
+
+// decodeTemperatures splits the packed current/min/max raw value of the temperature attributes
+// (190 Airflow_Temperature_Cel and 194 Temperature_Celsius) into separate fields.
+func decodeTemperatures(attrs []Attr) []Attr {
+	for i, attr := range attrs {
+		if attr.Id != 190 && attr.Id != 194 {
+			continue
+		}
+
+		parsed := attr.AtaSmartAttr
+		if parsed.Type == 0 {
+			// The raw format is unknown when the attribute did not come from smart.go's drive
+			// database (e.g. the smartctl backend), assume the common min/max layout
+			parsed.Type = ataAttrTypeTempMinMax
+		}
+		current, low, high, err := parsed.parseTemperature()
+		if err != nil {
+			continue
+		}
+
+		temp := &Temperature{Current: current}
+		if low != 0 || high != 0 {
+			temp.Min = &low
+			temp.Max = &high
+		}
+		attrs[i].Temperature = temp
+		if current >= 0 {
+			attrs[i].ValueDecoded = uint64(current)
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Roles of API tokens. Operators may do everything read-only tokens may.
+const (
+	RoleReadOnly = "read_only"
+	RoleOperator = "operator"
+)
+
+// ApiToken grants a role to requests with the header "Authorization: Bearer <token>".
+type ApiToken struct {
+	// Name identifies the token holder in logs
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// Role is read_only or operator
+	Role string `json:"role"`
+	// RequestsPerMinute overrides ServeConfig.RequestsPerMinute for this token
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+}
+
+// apiAuth checks requests against the configured tokens. Without tokens every request is allowed,
+// as before tokens could be configured.
+type apiAuth struct {
+	tokens []ApiToken
+}
+
+func newApiAuth(tokens []ApiToken) (apiAuth, error) {
+	for _, t := range tokens {
+		if t.Token == "" {
+			return apiAuth{}, fmt.Errorf("API token %s has no token", t.Name)
+		}
+		if t.Role != RoleReadOnly && t.Role != RoleOperator {
+			return apiAuth{}, fmt.Errorf("API token %s has unknown role %q", t.Name, t.Role)
+		}
+	}
+	return apiAuth{tokens: tokens}, nil
+}
+
+// token returns the configured token a request presents.
+func (a apiAuth) token(r *http.Request) (ApiToken, bool) {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ApiToken{}, false
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	return ApiToken{}, false
+}
+
+// require allows requests with a token of role, or an operator token.
+func (a apiAuth) require(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(a.tokens) == 0 {
+			h(w, r)
+			return
+		}
+		t, ok := a.token(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gosmart"`)
+			http.Error(w, "missing or unknown API token", http.StatusUnauthorized)
+			return
+		}
+		if t.Role != role && t.Role != RoleOperator {
+			http.Error(w, fmt.Sprintf("%s needs the %s role", r.URL.Path, role), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// byMethod allows reads (GET and HEAD) to read-only tokens and everything else only to operators.
+func (a apiAuth) byMethod(h http.HandlerFunc) http.HandlerFunc {
+	read, write := a.require(RoleReadOnly, h), a.require(RoleOperator, h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			read(w, r)
+		} else {
+			write(w, r)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"os"
+)
+
+// avroSchema is the Avro schema of the records written by the avro output. Unsigned counters are
+// stored as long, values above 2^63 wrap.
+const avroSchema = `{
+  "type": "record",
+  "name": "PartitionRecord",
+  "namespace": "gosmart",
+  "fields": [
+    {"name": "uuid", "type": "string"},
+    {"name": "ts", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "partition_name", "type": "string"},
+    {"name": "label", "type": "string"},
+    {"name": "mount_path", "type": "string"},
+    {"name": "size_bytes", "type": "long"},
+    {"name": "model", "type": "string", "default": ""},
+    {"name": "firmware", "type": "string", "default": ""},
+    {"name": "smart_supported", "type": "boolean"},
+    {"name": "skip_reason", "type": "string", "default": ""},
+    {"name": "attributes", "type": {"type": "array", "items": {
+      "type": "record",
+      "name": "Attribute",
+      "fields": [
+        {"name": "id", "type": "int"},
+        {"name": "name", "type": "string"},
+        {"name": "current", "type": "int"},
+        {"name": "worst", "type": "int"},
+        {"name": "raw", "type": "long"},
+        {"name": "value", "type": "long"}
+      ]
+    }}},
+    {"name": "nvme_health", "default": null, "type": ["null", {
+      "type": "record",
+      "name": "NvmeHealth",
+      "fields": [
+        {"name": "critical_warning", "type": "int"},
+        {"name": "temperature_c", "type": "int"},
+        {"name": "available_spare", "type": "int"},
+        {"name": "percentage_used", "type": "int"},
+        {"name": "data_units_read", "type": "long"},
+        {"name": "data_units_written", "type": "long"},
+        {"name": "power_cycles", "type": "long"},
+        {"name": "power_on_hours", "type": "long"},
+        {"name": "unsafe_shutdowns", "type": "long"},
+        {"name": "media_errors", "type": "long"},
+        {"name": "error_log_entries", "type": "long"}
+      ]
+    }]},
+    {"name": "warnings", "type": {"type": "array", "items": "string"}, "default": []}
+  ]
+}`
+
+type AvroConfig struct {
+	// Path of the Avro container file, records are appended if it exists
+	Path string `json:"path"`
+	// Codec is null, deflate or snappy, defaults to deflate
+	Codec string `json:"codec,omitempty"`
+}
+
+// avroRecord converts a record to the generic form goavro encodes.
+func avroRecord(record PartitionLine) map[string]any {
+	attrs := make([]any, 0, len(record.Attributes))
+	for _, attr := range record.Attributes {
+		attrs = append(attrs, map[string]any{
+			"id":      int32(attr.Id),
+			"name":    attr.Name,
+			"current": int32(attr.Current),
+			"worst":   int32(attr.Worst),
+			"raw":     int64(attr.ValueRaw),
+			"value":   int64(attr.ValueDecoded),
+		})
+	}
+	warnings := make([]any, 0, len(record.Warnings))
+	for _, w := range record.Warnings {
+		warnings = append(warnings, w)
+	}
+
+	var nvme any
+	if h := record.NvmeHealth; h != nil {
+		nvme = goavro.Union("gosmart.NvmeHealth", map[string]any{
+			"critical_warning":   int32(h.CriticalWarning),
+			"temperature_c":      int32(h.TemperatureC),
+			"available_spare":    int32(h.AvailableSpare),
+			"percentage_used":    int32(h.PercentageUsed),
+			"data_units_read":    int64(h.DataUnitsRead),
+			"data_units_written": int64(h.DataUnitsWritten),
+			"power_cycles":       int64(h.PowerCycles),
+			"power_on_hours":     int64(h.PowerOnHours),
+			"unsafe_shutdowns":   int64(h.UnsafeShutdowns),
+			"media_errors":       int64(h.MediaErrors),
+			"error_log_entries":  int64(h.ErrorLogEntries),
+		})
+	}
+
+	return map[string]any{
+		"uuid":            record.Uuid,
+		"ts":              record.Ts,
+		"partition_name":  record.PartitionName,
+		"label":           record.Label,
+		"mount_path":      record.MountPath,
+		"size_bytes":      int64(record.SizeBytes),
+		"model":           record.Model,
+		"firmware":        record.Firmware,
+		"smart_supported": record.SmartSupported,
+		"skip_reason":     record.SkipReason,
+		"attributes":      attrs,
+		"nvme_health":     nvme,
+		"warnings":        warnings,
+	}
+}
+
+// saveToAvro appends a record to the configured Avro container file.
+func saveToAvro(record PartitionLine, conf AvroConfig) error {
+	codec := conf.Codec
+	if codec == "" {
+		codec = goavro.CompressionDeflateLabel
+	}
+
+	// goavro reads the header of an existing file to append with its schema and sync marker
+	f, err := os.OpenFile(conf.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("avro output error: %w", err)
+	}
+	defer f.Close()
+
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: f, Schema: avroSchema, CompressionName: codec})
+	if err != nil {
+		return fmt.Errorf("avro output error: %w", err)
+	}
+	if err := w.Append([]any{avroRecord(record)}); err != nil {
+		return fmt.Errorf("avro write error for %s: %w", record.PartitionName, err)
+	}
+	return nil
+}
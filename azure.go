@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AzureConfig struct {
+	// WorkspaceId and SharedKey are the Log Analytics workspace ID and its primary or secondary key
+	WorkspaceId string `json:"workspace_id"`
+	SharedKey   string `json:"shared_key"`
+	// LogType names the custom log table, Azure appends _CL. Defaults to GoSmart.
+	LogType string `json:"log_type,omitempty"`
+}
+
+// azureSignature signs a Data Collector API request as described in
+// https://learn.microsoft.com/azure/azure-monitor/logs/data-collector-api
+func azureSignature(conf AzureConfig, date string, contentLength int) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(conf.SharedKey)
+	if err != nil {
+		return "", fmt.Errorf("shared key is not base64: %w", err)
+	}
+	toSign := "POST\n" + strconv.Itoa(contentLength) + "\napplication/json\nx-ms-date:" + date + "\n/api/logs"
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(toSign))
+	return "SharedKey " + conf.WorkspaceId + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// saveToAzure posts a record's samples to a Log Analytics workspace, one row per sample with its
+// labels as columns.
+func saveToAzure(record PartitionLine, conf AzureConfig, dropLabels []string) error {
+	logType := conf.LogType
+	if logType == "" {
+		logType = "GoSmart"
+	}
+
+	var rows []map[string]any
+	for _, sample := range recordMetrics(record, dropLabels) {
+		row := map[string]any{"ts": record.Ts.UTC().Format(time.RFC3339Nano), "metric": sample.Name, "value": sample.Value}
+		for k, v := range sample.Labels {
+			row[k] = v
+		}
+		rows = append(rows, row)
+	}
+	body, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+	}
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+	signature, err := azureSignature(conf, date, len(body))
+	if err != nil {
+		return fmt.Errorf("azure config error: %w", err)
+	}
+	url := fmt.Sprintf("https://%s.ods.opinsights.azure.com/api/logs?api-version=2016-04-01", conf.WorkspaceId)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("azure request error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", signature)
+	req.Header.Set("Log-Type", logType)
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("time-generated-field", "ts")
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("azure write error for %s: %w", record.PartitionName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("azure write error for %s: %s %s", record.PartitionName, resp.Status, msg)
+	}
+	return nil
+}
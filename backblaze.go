@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// BackblazeConfig compares drives with the failure rates of their models in the Backblaze drive
+// stats, https://www.backblaze.com/cloud-storage/resources/hard-drive-test-data. The CSV is either
+// a table of failure rates by model, with model, drive count, drive days and failures or AFR
+// columns, or the daily data with one row per drive and day, which is summed up by model.
+type BackblazeConfig struct {
+	Path string `json:"path"`
+	// WarnAfr warns about drives of models with an annualized failure rate of at least this many
+	// percent, off if 0
+	WarnAfr float64 `json:"warn_afr,omitempty"`
+	// MinDriveDays ignores models observed for fewer drive days, whose rates mean little
+	MinDriveDays uint64 `json:"min_drive_days,omitempty"`
+}
+
+// BackblazeModel is how a drive model performed in the Backblaze drive stats.
+type BackblazeModel struct {
+	Model     string  `json:"model"`
+	Drives    uint64  `json:"drives,omitempty"`
+	DriveDays uint64  `json:"drive_days,omitempty"`
+	Failures  uint64  `json:"failures"`
+	Afr       float64 `json:"afr"`
+	// FleetAfr is the failure rate of all drives in the data, for comparison
+	FleetAfr float64 `json:"fleet_afr,omitempty"`
+}
+
+func (m BackblazeModel) String() string {
+	s := fmt.Sprintf("%s has a %.2f%% annualized failure rate", m.Model, m.Afr)
+	if m.Drives > 0 {
+		s += fmt.Sprintf(" over %d drives", m.Drives)
+	}
+	if m.FleetAfr > 0 {
+		s += fmt.Sprintf(", %.2f%% for all drives", m.FleetAfr)
+	}
+	return s
+}
+
+// backblazeCriticalAttributes are the attributes Backblaze found most often nonzero on drives
+// that failed.
+var backblazeCriticalAttributes = []uint8{5, 187, 188, 197, 198}
+
+// normalizeCsvHeader turns "Drive Days" and drive_days into the same name.
+func normalizeCsvHeader(header string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(header)), " ", "_")
+}
+
+// loadBackblazeStats reads the failure rates of the models in the CSV, keyed by upper case model.
+func loadBackblazeStats(conf BackblazeConfig) (map[string]BackblazeModel, error) {
+	f, err := os.Open(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the header of %s: %w", conf.Path, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[normalizeCsvHeader(name)] = i
+	}
+	column := func(row []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+		}
+		return ""
+	}
+	number := func(s string) uint64 {
+		n, _ := strconv.ParseUint(strings.ReplaceAll(s, ",", ""), 10, 64)
+		return n
+	}
+	if _, ok := columns["model"]; !ok {
+		return nil, fmt.Errorf("%s has no model column", conf.Path)
+	}
+	_, daily := columns["serial_number"]
+
+	models := make(map[string]BackblazeModel)
+	serials := make(map[string]map[string]bool)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", conf.Path, err)
+		}
+		model := column(row, "model")
+		if model == "" {
+			continue
+		}
+		key := strings.ToUpper(model)
+		m := models[key]
+		m.Model = model
+		if daily {
+			// One row is one drive on one day
+			if serials[key] == nil {
+				serials[key] = make(map[string]bool)
+			}
+			serials[key][column(row, "serial_number")] = true
+			m.Drives = uint64(len(serials[key]))
+			m.DriveDays++
+			m.Failures += number(column(row, "failure"))
+		} else {
+			m.Drives += number(column(row, "drive_count", "drives", "count"))
+			m.DriveDays += number(column(row, "drive_days"))
+			m.Failures += number(column(row, "drive_failures", "failures"))
+			m.Afr, _ = strconv.ParseFloat(strings.TrimSuffix(column(row, "afr", "annualized_failure_rate"), "%"), 64)
+		}
+		models[key] = m
+	}
+
+	var fleetDays, fleetFailures uint64
+	for key, m := range models {
+		if m.DriveDays < conf.MinDriveDays {
+			delete(models, key)
+			continue
+		}
+		fleetDays += m.DriveDays
+		fleetFailures += m.Failures
+		if m.DriveDays > 0 {
+			m.Afr = annualizedFailureRate(m.Failures, m.DriveDays)
+			models[key] = m
+		}
+	}
+	if fleetDays > 0 {
+		for key, m := range models {
+			m.FleetAfr = annualizedFailureRate(fleetFailures, fleetDays)
+			models[key] = m
+		}
+	}
+	return models, nil
+}
+
+// annualizedFailureRate is the percentage of drives failing per year, as Backblaze computes it.
+func annualizedFailureRate(failures, driveDays uint64) float64 {
+	return float64(failures) / (float64(driveDays) / 365) * 100
+}
+
+// backblazeModel finds the stats of a drive model. Seagate drives report their model with a
+// suffix after a dash that the stats leave out, e.g. ST4000DM000-1F2168.
+func backblazeModel(models map[string]BackblazeModel, model string) (BackblazeModel, bool) {
+	key := strings.ToUpper(strings.TrimSpace(model))
+	if m, ok := models[key]; ok {
+		return m, true
+	}
+	if i := strings.Index(key, "-"); i > 0 {
+		m, ok := models[key[:i]]
+		return m, ok
+	}
+	return BackblazeModel{}, false
+}
+
+// applyBackblazeStats sets how the model of each record performed in the Backblaze drive stats,
+// and warns about models with high failure rates and drives with nonzero critical attributes.
+func applyBackblazeStats(records []PartitionLine, conf BackblazeConfig) []PartitionLine {
+	models, err := loadBackblazeStats(conf)
+	if err != nil {
+		log.Printf("Could not load Backblaze drive stats: %s\n", err)
+		return records
+	}
+	for i := range records {
+		record := &records[i]
+		m, ok := backblazeModel(models, record.Model)
+		if !ok {
+			continue
+		}
+		record.Backblaze = &m
+		if conf.WarnAfr > 0 && m.Afr >= conf.WarnAfr {
+			record.warn("Backblaze drive stats: %s", m)
+		}
+		for _, attr := range record.Attributes {
+			if attr.ValueDecoded > 0 && slices.Contains(backblazeCriticalAttributes, attr.Id) {
+				record.warn("%d %s is %d, one of the attributes Backblaze found nonzero on most drives that failed", attr.Id, attr.Name, attr.ValueDecoded)
+			}
+		}
+	}
+	return records
+}
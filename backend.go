@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DeviceBackend reads the SMART data of a device into its record. Backends are selected by name
+// with the backend setting of the config or a partition.
+type DeviceBackend interface {
+	// ReadDevice fills the SMART data of devName into line. It returns false if the device could
+	// not be read, with line.Error set, or has no supported SMART data.
+	ReadDevice(devName string, line PartitionLine, conf Config) (PartitionLine, bool)
+}
+
+// deviceBackends are the backends by name. Embedders and tests can add their own.
+var deviceBackends = map[string]DeviceBackend{
+	BackendSmartctl: smartctlBackend{},
+	BackendFake:     fakeBackend{},
+}
+
+type smartctlBackend struct{}
+
+func (smartctlBackend) ReadDevice(devName string, line PartitionLine, conf Config) (PartitionLine, bool) {
+	return collectSmartctlDevice(devName, line, conf)
+}
+
+// FakeDevice is a canned SMART payload served by the fake backend. A device with NvmeHealth is
+// an NVMe drive, one with Attributes an ATA drive, and one with neither has no SMART support.
+type FakeDevice struct {
+	// Label, MountPath, Uuid and SizeBytes override what discovery found for the device
+	Label      string          `json:"label,omitempty"`
+	MountPath  string          `json:"mount_path,omitempty"`
+	Uuid       string          `json:"uuid,omitempty"`
+	SizeBytes  uint64          `json:"size_bytes,omitempty"`
+	Model      string          `json:"model,omitempty"`
+	Serial     string          `json:"serial,omitempty"`
+	Firmware   string          `json:"firmware,omitempty"`
+	MediaType  string          `json:"media_type,omitempty"`
+	Attributes []FakeAttribute `json:"attributes,omitempty"`
+	NvmeHealth *NvmeHealth     `json:"nvme_health,omitempty"`
+	// Error fails reading the device with this message, in ErrorClass if set
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	// SkipReason reports the device without SMART data for this reason, e.g. SMART disabled
+	SkipReason string `json:"skip_reason,omitempty"`
+}
+
+// FakeAttribute is an ATA SMART attribute as the drive reports it, before decoding.
+type FakeAttribute struct {
+	Id uint8 `json:"id"`
+	// Name is left empty by drives, smart.go names attributes from its drive database
+	Name    string `json:"name,omitempty"`
+	Current uint8  `json:"current"`
+	Worst   uint8  `json:"worst"`
+	Raw     uint64 `json:"raw"`
+}
+
+// fakeBackend serves Config.FakeDevices. Their attributes go through the same selection, naming
+// and decoding as those read from hardware.
+type fakeBackend struct{}
+
+func (fakeBackend) ReadDevice(devName string, line PartitionLine, conf Config) (PartitionLine, bool) {
+	device, ok := conf.FakeDevices[devName]
+	if !ok {
+		line.fail(ErrorClassOpen, os.ErrNotExist, "could not open fake device %s", devName)
+		return line, false
+	}
+	for _, field := range []struct {
+		value  string
+		target *string
+	}{{device.Label, &line.Label}, {device.MountPath, &line.MountPath}, {device.Uuid, &line.Uuid}} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+	if device.SizeBytes > 0 {
+		line.SizeBytes = device.SizeBytes
+	}
+	if device.Error != "" && device.ErrorClass != "" {
+		// A recorded error is replayed as it was
+		fmt.Println(device.Error)
+		line.Error = &CollectionError{Class: device.ErrorClass, Message: device.Error}
+		line.SkipReason = "collection failed: " + device.Error
+		return line, false
+	} else if device.Error != "" {
+		line.fail(ErrorClassRead, errors.New(device.Error), "Could not read fake device %s", devName)
+		return line, false
+	}
+
+	line.Model = device.Model
+	line.Serial = device.Serial
+	line.Firmware = device.Firmware
+	line.MediaType = device.MediaType
+	if device.NvmeHealth != nil {
+		health := *device.NvmeHealth
+		line.Attributes = make([]Attr, 0)
+		line.NvmeHealth = &health
+		line.MediaType = MediaSsd
+		line.SmartSupported = true
+		return line, true
+	}
+	if device.SkipReason != "" {
+		line.SkipReason = device.SkipReason
+		return line, true
+	}
+	if device.Attributes == nil {
+		return line, false
+	}
+
+	attrs := make(map[uint8]AtaSmartAttr)
+	for _, a := range device.Attributes {
+		if _, ok := attrs[a.Id]; ok {
+			fmt.Printf("fake device %s has attribute %d twice, using the last\n", devName, a.Id)
+		}
+		attrs[a.Id] = AtaSmartAttr{Id: a.Id, Name: a.Name, Current: a.Current, Worst: a.Worst, ValueRaw: a.Raw}
+	}
+	line = buildAttributes(line, attrs, conf)
+	line.SmartSupported = true
+	return line, true
+}
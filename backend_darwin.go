@@ -0,0 +1,6 @@
+//go:build darwin
+
+package main
+
+// smart.go's ioctls are not available on macOS, so SMART data is read through smartctl by default.
+const defaultBackend = BackendSmartctl
@@ -0,0 +1,6 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+// smart.go only implements its ioctls on Linux, so SMART data is read through smartctl by default.
+const defaultBackend = BackendSmartctl
@@ -0,0 +1,5 @@
+package main
+
+// smart.go returns ErrOSUnsupported for every device on Windows, so SMART data is read through
+// smartctl by default.
+const defaultBackend = BackendSmartctl
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchResult is the timing of one device and backend over all iterations.
+type benchResult struct {
+	device   string
+	backend  string
+	failures int
+	min      time.Duration
+	max      time.Duration
+	total    time.Duration
+}
+
+func (r *benchResult) add(d time.Duration, ok bool) {
+	if !ok {
+		r.failures++
+		return
+	}
+	if r.min == 0 || d < r.min {
+		r.min = d
+	}
+	r.max = max(r.max, d)
+	r.total += d
+}
+
+func (r *benchResult) avg(iterations int) time.Duration {
+	if n := iterations - r.failures; n > 0 {
+		return r.total / time.Duration(n)
+	}
+	return 0
+}
+
+// runBench implements `gosmart bench`, which times opening and reading every configured device
+// with each backend, to find the drive or controller slowing down collection.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	iterations := fs.Int("n", 5, "Iterations per device and backend")
+	backends := fs.String("backends", BackendSmartGo+","+BackendSmartctl, "Comma separated backends to time")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+
+	var results []*benchResult
+	for _, t := range discoverTargets(conf, time.Now()) {
+		for _, backend := range strings.Split(*backends, ",") {
+			device := t.device
+			device.Backend = strings.TrimSpace(backend)
+			// readDevice falls back to the default backend, which would be timed under this name
+			if _, ok := deviceBackends[device.Backend]; !ok {
+				fmt.Printf("skipping backend %s, it is not available on this platform\n", device.Backend)
+				continue
+			}
+			result := &benchResult{device: t.smartPath, backend: device.Backend}
+			for i := 0; i < *iterations; i++ {
+				start := time.Now()
+				line, ok := readDevice(t.smartPath, t.line, device, conf)
+				result.add(time.Since(start), ok && line.SmartSupported)
+			}
+			results = append(results, result)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tBACKEND\tOK\tFAILED\tMIN\tAVG\tMAX")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", r.device, r.backend, *iterations-r.failures, r.failures,
+			r.min.Round(time.Microsecond), r.avg(*iterations).Round(time.Microsecond), r.max.Round(time.Microsecond))
+	}
+	_ = w.Flush()
+}
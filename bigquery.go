@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type BigQueryConfig struct {
+	// ProjectId defaults to the project of the credentials
+	ProjectId string `json:"project_id,omitempty"`
+	// Dataset must exist, the table is created in it on the first write
+	Dataset string `json:"dataset"`
+	// Table defaults to smart_readings
+	Table string `json:"table,omitempty"`
+	// CredentialsFile is a service account key, the Application Default Credentials are used if empty
+	CredentialsFile string `json:"credentials_file,omitempty"`
+}
+
+const bigQueryScope = "https://www.googleapis.com/auth/bigquery"
+
+// bigQueryMaxRows is the most rows sent in one insertAll call, as recommended for streaming.
+const bigQueryMaxRows = 500
+
+// bigQuerySchema is the schema of the table created for records. Partitioning by day on ts keeps
+// queries over recent readings cheap.
+var bigQuerySchema = []map[string]any{
+	{"name": "uuid", "type": "STRING"},
+	{"name": "ts", "type": "TIMESTAMP", "mode": "REQUIRED"},
+	{"name": "partition_name", "type": "STRING", "mode": "REQUIRED"},
+	{"name": "label", "type": "STRING"},
+	{"name": "mount_path", "type": "STRING"},
+	{"name": "size_bytes", "type": "INT64"},
+	{"name": "model", "type": "STRING"},
+	{"name": "firmware", "type": "STRING"},
+	{"name": "smart_supported", "type": "BOOL"},
+	{"name": "skip_reason", "type": "STRING"},
+	{"name": "run_id", "type": "STRING"},
+	{"name": "attributes", "type": "RECORD", "mode": "REPEATED", "fields": []map[string]any{
+		{"name": "id", "type": "INT64"},
+		{"name": "name", "type": "STRING"},
+		{"name": "current", "type": "INT64"},
+		{"name": "worst", "type": "INT64"},
+		{"name": "raw", "type": "INT64"},
+		{"name": "value", "type": "INT64"},
+		{"name": "unit", "type": "STRING"},
+	}},
+	{"name": "failed_lbas", "type": "INT64", "mode": "REPEATED"},
+}
+
+type bigQueryAttribute struct {
+	Id      uint8  `json:"id"`
+	Name    string `json:"name"`
+	Current uint8  `json:"current"`
+	Worst   uint8  `json:"worst"`
+	Raw     uint64 `json:"raw"`
+	Value   uint64 `json:"value"`
+	Unit    string `json:"unit,omitempty"`
+}
+
+type bigQueryRow struct {
+	Uuid           string              `json:"uuid"`
+	Ts             string              `json:"ts"`
+	PartitionName  string              `json:"partition_name"`
+	Label          string              `json:"label"`
+	MountPath      string              `json:"mount_path"`
+	SizeBytes      uint64              `json:"size_bytes"`
+	Model          string              `json:"model,omitempty"`
+	Firmware       string              `json:"firmware,omitempty"`
+	SmartSupported bool                `json:"smart_supported"`
+	SkipReason     string              `json:"skip_reason,omitempty"`
+	RunId          string              `json:"run_id,omitempty"`
+	Attributes     []bigQueryAttribute `json:"attributes"`
+	FailedLbas     []uint64            `json:"failed_lbas,omitempty"`
+}
+
+func newBigQueryRow(record PartitionLine) bigQueryRow {
+	row := bigQueryRow{
+		Uuid:           record.Uuid,
+		Ts:             record.Ts.UTC().Format("2006-01-02 15:04:05.000000") + " UTC",
+		PartitionName:  record.PartitionName,
+		Label:          record.Label,
+		MountPath:      record.MountPath,
+		SizeBytes:      record.SizeBytes,
+		Model:          record.Model,
+		Firmware:       record.Firmware,
+		SmartSupported: record.SmartSupported,
+		SkipReason:     record.SkipReason,
+		Attributes:     []bigQueryAttribute{},
+		FailedLbas:     record.FailedLbas,
+	}
+	if record.Run != nil {
+		row.RunId = record.Run.Id
+	}
+	for _, attr := range record.Attributes {
+		row.Attributes = append(row.Attributes, bigQueryAttribute{Id: attr.Id, Name: attr.Name, Current: attr.Current, Worst: attr.Worst, Raw: attr.ValueRaw, Value: attr.ValueDecoded, Unit: attr.Unit})
+	}
+	return row
+}
+
+// bigQueryCredentials reads the service account key if configured, the Application Default
+// Credentials otherwise.
+func bigQueryCredentials(ctx context.Context, conf BigQueryConfig) (*google.Credentials, error) {
+	if conf.CredentialsFile == "" {
+		return google.FindDefaultCredentials(ctx, bigQueryScope)
+	}
+	key, err := os.ReadFile(conf.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	return google.CredentialsFromJSON(ctx, key, bigQueryScope)
+}
+
+// saveToBigQuery streams records into the table with insertAll, creating the table if it does
+// not exist. Rows of a run are deduplicated by BigQuery on their run id and device.
+func saveToBigQuery(records []PartitionLine, conf BigQueryConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	creds, err := bigQueryCredentials(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("google credentials error: %w", err)
+	}
+	projectId := conf.ProjectId
+	if projectId == "" {
+		projectId = creds.ProjectID
+	}
+	if projectId == "" {
+		return fmt.Errorf("no Google Cloud project configured or found in the credentials")
+	}
+	table := conf.Table
+	if table == "" {
+		table = "smart_readings"
+	}
+	client := oauth2.NewClient(ctx, creds.TokenSource)
+	tablesUrl := fmt.Sprintf("https://bigquery.googleapis.com/bigquery/v2/projects/%s/datasets/%s/tables", projectId, conf.Dataset)
+
+	for start := 0; start < len(records); start += bigQueryMaxRows {
+		batch := records[start:min(start+bigQueryMaxRows, len(records))]
+		var rows []map[string]any
+		for _, record := range batch {
+			row := map[string]any{"json": newBigQueryRow(record)}
+			if record.Run != nil {
+				row["insertId"] = record.Run.Id + "/" + record.PartitionName
+			}
+			rows = append(rows, row)
+		}
+		body, err := json.Marshal(map[string]any{"rows": rows})
+		if err != nil {
+			return fmt.Errorf("json output error: %w", err)
+		}
+
+		status, msg, err := bigQueryPost(client, tablesUrl+"/"+table+"/insertAll", body)
+		if err == nil && status == http.StatusNotFound && strings.Contains(string(msg), "Not found: Table") {
+			if err := createBigQueryTable(client, tablesUrl, projectId, conf.Dataset, table); err != nil {
+				return err
+			}
+			status, msg, err = bigQueryPost(client, tablesUrl+"/"+table+"/insertAll", body)
+		}
+		if err != nil {
+			return fmt.Errorf("bigquery write error: %w", err)
+		}
+		if status/100 != 2 {
+			return fmt.Errorf("bigquery write error: %d %s", status, msg)
+		}
+		// Rows can be rejected individually with a successful status
+		var result struct {
+			InsertErrors []struct {
+				Index  int `json:"index"`
+				Errors []struct {
+					Message string `json:"message"`
+				} `json:"errors"`
+			} `json:"insertErrors"`
+		}
+		if err := json.Unmarshal(msg, &result); err == nil && len(result.InsertErrors) > 0 {
+			e := result.InsertErrors[0]
+			reason := ""
+			if len(e.Errors) > 0 {
+				reason = e.Errors[0].Message
+			}
+			device := ""
+			if e.Index >= 0 && e.Index < len(batch) {
+				device = batch[e.Index].PartitionName
+			}
+			return fmt.Errorf("bigquery rejected %d rows, %s: %s", len(result.InsertErrors), device, reason)
+		}
+	}
+	return nil
+}
+
+func createBigQueryTable(client *http.Client, tablesUrl, projectId, dataset, table string) error {
+	body, err := json.Marshal(map[string]any{
+		"tableReference":   map[string]string{"projectId": projectId, "datasetId": dataset, "tableId": table},
+		"schema":           map[string]any{"fields": bigQuerySchema},
+		"timePartitioning": map[string]string{"type": "DAY", "field": "ts"},
+	})
+	if err != nil {
+		return fmt.Errorf("json output error: %w", err)
+	}
+	status, msg, err := bigQueryPost(client, tablesUrl, body)
+	if err != nil {
+		return fmt.Errorf("bigquery table creation error: %w", err)
+	}
+	// Another writer may have created it in the meantime
+	if status/100 != 2 && status != http.StatusConflict {
+		return fmt.Errorf("bigquery table creation error: %d %s", status, msg)
+	}
+	fmt.Printf("Created BigQuery table %s.%s.%s\n", projectId, dataset, table)
+	return nil
+}
+
+func bigQueryPost(client *http.Client, url string, body []byte) (int, []byte, error) {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, msg, err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// runDeadline is when a run started at started must stop collecting, zero without a budget.
+func runDeadline(conf Config, started time.Time) time.Time {
+	if conf.RunBudgetSeconds <= 0 {
+		return time.Time{}
+	}
+	return started.Add(time.Duration(conf.RunBudgetSeconds) * time.Second)
+}
+
+// pastDeadline reports whether a run with the deadline given is out of time.
+func pastDeadline(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// collectDeviceBefore collects a device like collectDevice, but gives up at deadline and returns
+// false for done. The device's goroutine is left behind, a drive hanging in an ioctl cannot be
+// interrupted, and its result is dropped if it ever returns.
+func collectDeviceBefore(deadline time.Time, t target, conf Config) (results PartitionLine, ok bool, done bool) {
+	if deadline.IsZero() {
+		results, ok = collectDevice(t.smartPath, t.line, t.device, conf)
+		return results, ok, true
+	}
+	type outcome struct {
+		results PartitionLine
+		ok      bool
+	}
+	// Buffered so an abandoned collection can still send and exit
+	ch := make(chan outcome, 1)
+	go func() {
+		results, ok := collectDevice(t.smartPath, t.line, t.device, conf)
+		ch <- outcome{results, ok}
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case o := <-ch:
+		return o.results, o.ok, true
+	case <-timer.C:
+		log.Printf("%s was still collecting when the run budget of %ds ran out\n", t.smartPath, conf.RunBudgetSeconds)
+		return t.line, false, false
+	}
+}
+
+// deferredLine reports a device skipped because the run budget ran out.
+func deferredLine(line PartitionLine, conf Config) PartitionLine {
+	line.Deferred = true
+	line.SkipReason = fmt.Sprintf("deferred, the run budget of %ds ran out", conf.RunBudgetSeconds)
+	return line
+}
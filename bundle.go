@@ -0,0 +1,251 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// evidenceBundle is everything known about one drive, for a warranty claim or support ticket.
+type evidenceBundle struct {
+	Device   string    `json:"device"`
+	Created  time.Time `json:"created"`
+	Host     string    `json:"host,omitempty"`
+	Version  string    `json:"version"`
+	Warnings []string  `json:"warnings,omitempty"`
+	// Record is the collected record with all attributes the drive reports, its logs and status
+	Record *PartitionLine `json:"record,omitempty"`
+	Status string         `json:"status,omitempty"`
+	// Smartctl is the full output of smartctl -x, with the identify data, logs and tables gosmart
+	// does not decode
+	Smartctl json.RawMessage `json:"smartctl,omitempty"`
+	History  []bundleReading `json:"history,omitempty"`
+}
+
+// bundleReading is a stored reading of the device.
+type bundleReading struct {
+	Ts         time.Time       `json:"ts"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+// runBundle implements `gosmart bundle`, which writes the evidence of one device to a zip file, or
+// a single JSON document if the output ends in .json.
+func runBundle(args []string) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	outPath := fs.String("o", "", "Output file, .zip or .json (default gosmart-bundle-<device>-<date>.zip), - for JSON on stdout")
+	historyDays := fs.Int("history-days", 90, "Days of stored readings to include, if a local store or database is configured")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bundle [-f config] [-o file] device\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	device := fs.Arg(0)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+	warnStatefulThresholds(conf, "bundle")
+
+	bundle := collectBundle(device, conf, *historyDays)
+	for _, warning := range bundle.Warnings {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	path := *outPath
+	if path == "" {
+		path = fmt.Sprintf("gosmart-bundle-%s-%s.zip", strings.ReplaceAll(strings.TrimPrefix(device, "/dev/"), "/", "_"), bundle.Created.Format("20060102-150405"))
+	}
+	switch {
+	case path == "-":
+		err = writeBundleJson(os.Stdout, bundle)
+	case strings.HasSuffix(path, ".json"):
+		err = writeBundleFile(path, bundle, writeBundleJson)
+	default:
+		err = writeBundleFile(path, bundle, writeBundleZip)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write bundle: %s\n", err)
+		os.Exit(1)
+	}
+	if path != "-" {
+		fmt.Printf("Wrote %s\n", path)
+	}
+}
+
+// collectBundle reads everything it can about device. Parts that cannot be read are left out and
+// explained in the warnings.
+func collectBundle(device string, conf Config, historyDays int) evidenceBundle {
+	bundle := evidenceBundle{Device: device, Created: time.Now(), Version: collectorVersion()}
+	bundle.Host, _ = os.Hostname()
+
+	// The device keeps its configured options, and the logs are read whatever the config says
+	i := slices.IndexFunc(conf.Partitions, func(p PartitionConfig) bool { return deviceKey(p.Path) == deviceKey(device) })
+	partition := PartitionConfig{Path: device}
+	if i >= 0 {
+		partition = conf.Partitions[i]
+	}
+	conf.Partitions = []PartitionConfig{partition}
+	conf.IncludeUnsupported = true
+	conf.CollectErrorLog = true
+	conf.CollectDeviceStatistics = true
+	conf.CollectCapabilities = true
+
+	if records := collectAll(conf); len(records) > 0 {
+		record := withAllAttributes(records[0], conf)
+		bundle.Record = &record
+		bundle.Status, _ = deviceStatus(record)
+	} else {
+		bundle.Warnings = append(bundle.Warnings, fmt.Sprintf("%s was not found", device))
+	}
+
+	// A partition is read through its disk
+	smartPath := device
+	if targets := discoverTargets(conf, time.Now()); len(targets) > 0 {
+		smartPath = targets[0].smartPath
+	}
+	if partition.Backend != BackendFake {
+		if out, err := smartctlAll(conf.SmartctlPath, smartPath); err != nil {
+			bundle.Warnings = append(bundle.Warnings, fmt.Sprintf("Could not read the smartctl output: %s", err))
+		} else {
+			bundle.Smartctl = out
+			if conf.Anonymize != nil {
+				if bundle.Smartctl, err = anonymizeSmartctl(out, *conf.Anonymize); err != nil {
+					// Leave it out rather than leak the identifiers
+					bundle.Smartctl = nil
+					bundle.Warnings = append(bundle.Warnings, fmt.Sprintf("Could not anonymize the smartctl output: %s", err))
+				}
+			}
+		}
+	}
+
+	if historyDays > 0 && (conf.Local != nil || conf.Db != nil) {
+		history, err := bundleHistory(conf, bundle, historyDays)
+		if err != nil {
+			bundle.Warnings = append(bundle.Warnings, fmt.Sprintf("Could not read history: %s", err))
+		}
+		bundle.History = history
+	}
+	return bundle
+}
+
+// withAllAttributes rebuilds the attributes of an ATA record from every attribute the drive
+// reports, not only the configured ones.
+func withAllAttributes(record PartitionLine, conf Config) PartitionLine {
+	if record.rawAttributes == nil {
+		return record
+	}
+	all := make([]uint8, 0, len(record.rawAttributes))
+	for id := range record.rawAttributes {
+		all = append(all, id)
+	}
+	conf.Attributes, conf.SkipZeroAttributes = all, false
+	return buildAttributes(record, record.rawAttributes, conf)
+}
+
+// smartctlAll returns the JSON output of smartctl -x as is.
+func smartctlAll(smartctlPath, devName string) (json.RawMessage, error) {
+	out, err := exec.Command(smartctlPath, "-j", "-x", devName).Output()
+	// As in runSmartctl, non-zero exit codes also report drive health
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	if !json.Valid(out) {
+		return nil, fmt.Errorf("smartctl did not write JSON")
+	}
+	return out, nil
+}
+
+func bundleHistory(conf Config, bundle evidenceBundle, days int) ([]bundleReading, error) {
+	name := bundle.Device
+	if bundle.Record != nil {
+		name = bundle.Record.PartitionName
+	}
+	source, err := openHistory(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	rows, err := source.historySince(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return nil, err
+	}
+	// rows are oldest first
+	var readings []bundleReading
+	for _, row := range rows {
+		if row.PartitionName == name {
+			readings = append(readings, bundleReading{Ts: row.Ts, Attributes: json.RawMessage(row.Attributes)})
+		}
+	}
+	return readings, nil
+}
+
+func writeBundleFile(path string, bundle evidenceBundle, write func(io.Writer, evidenceBundle) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, bundle); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeBundleJson(w io.Writer, bundle evidenceBundle) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", defaultJsonIndent)
+	return enc.Encode(bundle)
+}
+
+// writeBundleZip writes the parts of the bundle as separate files, the summary without them in
+// bundle.json.
+func writeBundleZip(w io.Writer, bundle evidenceBundle) error {
+	zw := zip.NewWriter(w)
+	add := func(name string, v any) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: bundle.Created})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", defaultJsonIndent)
+		return enc.Encode(v)
+	}
+
+	if bundle.Record != nil {
+		if err := add("record.json", bundle.Record); err != nil {
+			return err
+		}
+	}
+	if bundle.Smartctl != nil {
+		if err := add("smartctl.json", bundle.Smartctl); err != nil {
+			return err
+		}
+	}
+	if bundle.History != nil {
+		if err := add("history.json", bundle.History); err != nil {
+			return err
+		}
+	}
+	summary := bundle
+	summary.Record, summary.Smartctl, summary.History = nil, nil, nil
+	if err := add("bundle.json", summary); err != nil {
+		return err
+	}
+	return zw.Close()
+}
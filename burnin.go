@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SelfTestConveyance checks for damage in transport, on ATA drives that support it.
+const SelfTestConveyance = "conveyance"
+
+// burninAttributes must not grow during a burn-in, they count sectors the drive gave up on.
+var burninAttributes = []uint8{5, 187, 188, 196, 197, 198, 199}
+
+// burninReport is the outcome of a burn-in, a drive is trusted if every step passed.
+type burninReport struct {
+	Device   string         `json:"device"`
+	Model    string         `json:"model,omitempty"`
+	Serial   string         `json:"serial,omitempty"`
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Passed   bool           `json:"passed"`
+	Steps    []burninStep   `json:"steps"`
+	Baseline *PartitionLine `json:"baseline,omitempty"`
+	Final    *PartitionLine `json:"final,omitempty"`
+}
+
+type burninStep struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+	Seconds int    `json:"seconds"`
+}
+
+func (s burninStep) String() string {
+	result := "FAIL"
+	if s.Skipped {
+		result = "SKIP"
+	} else if s.Passed {
+		result = "PASS"
+	}
+	return fmt.Sprintf("%s %-10s %s", result, s.Name, s.Detail)
+}
+
+// runBurnin implements `gosmart burnin`, which takes a baseline snapshot of a new drive, runs
+// the conveyance and long self-tests one after the other and compares a final snapshot with the
+// baseline. It exits with 1 if the drive failed any step.
+func runBurnin(args []string) {
+	fs := flag.NewFlagSet("burnin", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	outPath := fs.String("o", "", "Write the report as JSON to this file")
+	tests := fs.String("tests", SelfTestConveyance+","+SelfTestLong, "Self-tests to run in order, of short, conveyance and long")
+	poll := fs.Duration("poll", time.Minute, "How often the progress of a self-test is checked")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s burnin [-f config] [-o report.json] [-tests list] device\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	device := fs.Arg(0)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+	warnStatefulThresholds(conf, "burnin")
+	i := slices.IndexFunc(conf.Partitions, func(p PartitionConfig) bool { return deviceKey(p.Path) == deviceKey(device) })
+	partition := PartitionConfig{Path: device}
+	if i >= 0 {
+		partition = conf.Partitions[i]
+	}
+	conf.Partitions = []PartitionConfig{partition}
+	conf.IncludeUnsupported = true
+	conf.CollectCapabilities = true
+
+	report := burninReport{Device: device, Started: time.Now()}
+	report.Baseline = burninSnapshot(conf)
+	if report.Baseline == nil || report.Baseline.Error != nil || !report.Baseline.SmartSupported {
+		fmt.Fprintf(os.Stderr, "Could not read the SMART data of %s\n", device)
+		os.Exit(1)
+	}
+	report.Model, report.Serial = report.Baseline.Model, report.Baseline.Serial
+	baselineStatus, reasons := deviceStatus(*report.Baseline)
+	report.addStep(burninStep{Name: "baseline", Passed: baselineStatus == StatusOk, Detail: strings.Join(reasons, "; ")})
+
+	smartPath := device
+	if targets := discoverTargets(conf, time.Now()); len(targets) > 0 {
+		smartPath = targets[0].smartPath
+	}
+	for _, test := range strings.Split(*tests, ",") {
+		test = strings.TrimSpace(test)
+		if test == SelfTestConveyance && (report.Baseline.Capabilities == nil || !report.Baseline.Capabilities.ConveyanceSelfTest) {
+			report.addStep(burninStep{Name: test, Skipped: true, Detail: "not supported by the drive"})
+			continue
+		}
+		start := time.Now()
+		step := runBurninSelfTest(smartPath, test, conf, *poll)
+		step.Seconds = int(time.Since(start).Seconds())
+		report.addStep(step)
+		if !step.Passed {
+			break
+		}
+	}
+
+	report.Final = burninSnapshot(conf)
+	report.addStep(compareBurnin(*report.Baseline, report.Final))
+	report.Finished = time.Now()
+	report.Passed = !slices.ContainsFunc(report.Steps, func(s burninStep) bool { return !s.Passed && !s.Skipped })
+
+	if *outPath != "" {
+		b, _ := json.MarshalIndent(report, "", defaultJsonIndent)
+		if err := os.WriteFile(*outPath, b, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write the report: %s\n", err)
+		}
+	}
+	if report.Passed {
+		fmt.Printf("%s passed the burn-in\n", device)
+		return
+	}
+	fmt.Printf("%s FAILED the burn-in\n", device)
+	os.Exit(1)
+}
+
+func (r *burninReport) addStep(step burninStep) {
+	r.Steps = append(r.Steps, step)
+	fmt.Println(step)
+}
+
+// burninSnapshot collects the device with all its attributes, so the comparison does not depend
+// on the configured ones.
+func burninSnapshot(conf Config) *PartitionLine {
+	records := collectAll(conf)
+	if len(records) == 0 {
+		return nil
+	}
+	record := withAllAttributes(records[0], conf)
+	return &record
+}
+
+// runBurninSelfTest starts a self-test and waits for it to finish. The result is only trusted if
+// the test was seen running or a new entry appeared in the self-test log.
+func runBurninSelfTest(devName, test string, conf Config, poll time.Duration) burninStep {
+	step := burninStep{Name: test}
+	before, err := readSelfTestStatus(devName, conf)
+	if err != nil {
+		step.Detail = err.Error()
+		return step
+	}
+	if before.InProgress {
+		step.Detail = "another self-test is running"
+		return step
+	}
+	if err := startSelfTest(devName, test, conf); err != nil {
+		step.Detail = err.Error()
+		return step
+	}
+
+	seenRunning := false
+	for {
+		time.Sleep(poll)
+		status, err := readSelfTestStatus(devName, conf)
+		if err != nil {
+			step.Detail = err.Error()
+			return step
+		}
+		if status.InProgress {
+			seenRunning = true
+			continue
+		}
+		if status.Last == nil || (!seenRunning && before.Last != nil && sameSelfTestEntry(*before.Last, *status.Last)) {
+			step.Detail = "the self-test did not run"
+			return step
+		}
+		step.Passed = status.Last.Passed
+		step.Detail = status.Last.Result
+		if status.Last.Lba != nil {
+			step.Detail += fmt.Sprintf(" at LBA %d", *status.Last.Lba)
+		}
+		return step
+	}
+}
+
+func sameSelfTestEntry(a, b SelfTestEntry) bool {
+	return a.Type == b.Type && a.Result == b.Result && a.PowerOnHours == b.PowerOnHours
+}
+
+// compareBurnin fails the drive if it is not ok after the tests, or any of burninAttributes or
+// the NVMe media errors grew.
+func compareBurnin(baseline PartitionLine, final *PartitionLine) burninStep {
+	step := burninStep{Name: "final"}
+	if final == nil || final.Error != nil {
+		step.Detail = "could not read the SMART data after the tests"
+		return step
+	}
+	var problems []string
+	if status, reasons := deviceStatus(*final); status != StatusOk {
+		problems = append(problems, reasons...)
+	}
+	for _, attr := range final.Attributes {
+		if !slices.Contains(burninAttributes, attr.Id) {
+			continue
+		}
+		for _, before := range baseline.Attributes {
+			if before.Id == attr.Id && attr.ValueDecoded > before.ValueDecoded {
+				problems = append(problems, fmt.Sprintf("%d %s grew from %d to %d", attr.Id, attr.Name, before.ValueDecoded, attr.ValueDecoded))
+			}
+		}
+	}
+	if baseline.NvmeHealth != nil && final.NvmeHealth != nil && final.NvmeHealth.MediaErrors > baseline.NvmeHealth.MediaErrors {
+		problems = append(problems, fmt.Sprintf("media errors grew from %d to %d", baseline.NvmeHealth.MediaErrors, final.NvmeHealth.MediaErrors))
+	}
+	step.Passed = len(problems) == 0
+	step.Detail = strings.Join(problems, "; ")
+	return step
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SmartCapabilities are the SMART features a drive supports, from the capabilities of the ATA
+// SMART data or the optional admin commands of an NVMe controller. They tell which self-tests and
+// logs can be requested from the drive.
+type SmartCapabilities struct {
+	SelfTest           bool `json:"self_test"`
+	ConveyanceSelfTest bool `json:"conveyance_self_test"`
+	SelectiveSelfTest  bool `json:"selective_self_test"`
+	ErrorLogging       bool `json:"error_logging"`
+	// GpLogging is support for the General Purpose Logging feature set, needed for the extended
+	// error and self-test logs
+	GpLogging bool `json:"gp_logging"`
+	// OfflineCollection is support for starting offline data collection immediately
+	OfflineCollection  bool `json:"offline_collection"`
+	OfflineSurfaceScan bool `json:"offline_surface_scan"`
+	AttributeAutosave  bool `json:"attribute_autosave"`
+	// OfflineCollectionStatus of the last offline data collection as decoded by smartctl, e.g.
+	// "was completed without error". Only ATA drives report it.
+	OfflineCollectionStatus string `json:"offline_collection_status,omitempty"`
+}
+
+func (c SmartCapabilities) String() string {
+	var supported []string
+	for _, capability := range []struct {
+		name      string
+		supported bool
+	}{
+		{"self-test", c.SelfTest},
+		{"conveyance", c.ConveyanceSelfTest},
+		{"selective", c.SelectiveSelfTest},
+		{"error log", c.ErrorLogging},
+		{"GP log", c.GpLogging},
+		{"offline", c.OfflineCollection},
+		{"surface scan", c.OfflineSurfaceScan},
+		{"autosave", c.AttributeAutosave},
+	} {
+		if capability.supported {
+			supported = append(supported, capability.name)
+		}
+	}
+	s := "none"
+	if len(supported) > 0 {
+		s = strings.Join(supported, ", ")
+	}
+	if c.OfflineCollectionStatus != "" {
+		s += fmt.Sprintf(" (offline collection %s)", c.OfflineCollectionStatus)
+	}
+	return s
+}
+
+// collectSmartCapabilities reads the SMART capabilities of devName through smartctl.
+func collectSmartCapabilities(devName string, line PartitionLine, conf Config) PartitionLine {
+	out, err := runSmartctl(conf.SmartctlPath, devName, "-c")
+	if err != nil {
+		fmt.Printf("Could not read SMART capabilities for %s with smartctl: %s\n", devName, err)
+		return line
+	}
+
+	if nvme := out.NvmeOptionalAdminCommands; nvme != nil {
+		// The error information log is mandatory for NVMe controllers
+		line.Capabilities = &SmartCapabilities{SelfTest: nvme.SelfTest, ErrorLogging: true}
+		return line
+	}
+	if out.AtaSmartData == nil || out.AtaSmartData.Capabilities == nil {
+		fmt.Printf("%s does not report SMART capabilities\n", devName)
+		return line
+	}
+	c := out.AtaSmartData.Capabilities
+	line.Capabilities = &SmartCapabilities{
+		SelfTest:           c.SelfTestsSupported,
+		ConveyanceSelfTest: c.ConveyanceSelfTestSupported,
+		SelectiveSelfTest:  c.SelectiveSelfTestSupported,
+		ErrorLogging:       c.ErrorLoggingSupported,
+		GpLogging:          c.GpLoggingSupported,
+		OfflineCollection:  c.ExecOfflineImmediateSupported,
+		OfflineSurfaceScan: c.OfflineSurfaceScanSupported,
+		AttributeAutosave:  c.AttributeAutosaveEnabled,
+	}
+	if offline := out.AtaSmartData.OfflineDataCollection; offline != nil {
+		line.Capabilities.OfflineCollectionStatus = offline.Status.String
+	}
+	return line
+}
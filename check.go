@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Exit codes of the monitoring plugins API, shared by Nagios style monitoring systems
+const (
+	checkOk      = 0
+	checkWarning = 1
+	checkUnknown = 3
+)
+
+var checkNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// checkName is the default check or service name of a device, e.g. smart-sda for /dev/sda.
+func checkName(devName string) string {
+	name := strings.TrimPrefix(devName, "/dev/")
+	return "smart-" + strings.Trim(checkNameUnsafe.ReplaceAllString(name, "-"), "-")
+}
+
+// checkResult maps a record's health status to a plugin exit code and a one line output.
+func checkResult(record PartitionLine) (int, string) {
+	health, reasons := deviceStatus(record)
+	exitStatus := checkUnknown
+	switch health {
+	case StatusOk:
+		exitStatus = checkOk
+	case StatusWarning:
+		exitStatus = checkWarning
+	}
+	output := fmt.Sprintf("SMART %s: %s", strings.ToUpper(health), deviceLocated(record))
+	if guests := guestsAtRisk(record); guests != "" && health != StatusOk {
+		reasons = append(reasons, guests)
+	}
+	if len(reasons) > 0 {
+		output += " - " + strings.Join(reasons, "; ")
+	}
+	if record.Acknowledged != nil {
+		output += " (acknowledged)"
+	}
+	return exitStatus, output
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/jmoiron/sqlx"
+	"log"
+	"time"
+)
+
+// defaultClockSkewTolerance is the skew from the database clock corrected if no tolerance is set.
+const defaultClockSkewTolerance = 60 * time.Second
+
+// databaseClockSkew returns how far the database clock is ahead of the local one, measured against
+// the middle of the round trip of the query.
+func databaseClockSkew(db *sqlx.DB, conf DBConfig) (time.Duration, error) {
+	var dbNow time.Time
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	start := time.Now()
+	if err := db.GetContext(ctx, &dbNow, "SELECT now();"); err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	return dbNow.Sub(start.Add(rtt / 2)), nil
+}
+
+// correctClockSkew moves the timestamps of records by the skew of the local clock if it is beyond
+// the tolerance, keeping the local timestamp in AgentTs, so hosts with bad clocks such as a
+// Raspberry Pi without an RTC do not interleave their history with the other hosts.
+func correctClockSkew(records []PartitionLine, db *sqlx.DB, conf DBConfig) []PartitionLine {
+	tolerance := defaultClockSkewTolerance
+	if conf.ClockSkewToleranceSeconds > 0 {
+		tolerance = time.Duration(conf.ClockSkewToleranceSeconds) * time.Second
+	}
+	skew, err := databaseClockSkew(db, conf)
+	if err != nil {
+		log.Printf("Could not read the database clock, timestamps are not corrected: %s\n", err)
+		return records
+	}
+	if skew.Abs() <= tolerance {
+		return records
+	}
+	log.Printf("The local clock is %s off the database clock, correcting the timestamps\n", -skew)
+	corrected := make([]PartitionLine, len(records))
+	for i, record := range records {
+		agentTs := record.Ts
+		record.AgentTs = &agentTs
+		record.Ts = record.Ts.Add(skew)
+		corrected[i] = record
+	}
+	return corrected
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cloud.google.com/go/compute/metadata"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cloud providers whose instance metadata services can be queried.
+const (
+	CloudEc2   = "ec2"
+	CloudGce   = "gce"
+	CloudAzure = "azure"
+)
+
+// CloudMetadataConfig tags records with the identity of the cloud instance they were collected on.
+type CloudMetadataConfig struct {
+	// Providers to try in order, ec2, gce and azure if empty
+	Providers []string `json:"providers,omitempty"`
+}
+
+// CloudInstance is the identity of a cloud instance, from its provider's metadata service.
+type CloudInstance struct {
+	Provider     string `json:"provider"`
+	InstanceId   string `json:"instance_id"`
+	InstanceType string `json:"instance_type,omitempty"`
+	Region       string `json:"region,omitempty"`
+	// Zone is the availability zone, empty on Azure for VMs not deployed to one
+	Zone string `json:"zone,omitempty"`
+}
+
+// cloudMetadataTimeout bounds each metadata request, off the cloud nothing answers.
+const cloudMetadataTimeout = 2 * time.Second
+
+const imdsAddress = "http://169.254.169.254"
+
+var (
+	cloudInstanceOnce sync.Once
+	cloudInstance     *CloudInstance
+)
+
+// lookupCloudInstance queries the metadata services of conf once per process, the instance
+// does not change while gosmart runs. It returns nil if none of them answered.
+func lookupCloudInstance(conf CloudMetadataConfig) *CloudInstance {
+	cloudInstanceOnce.Do(func() {
+		providers := conf.Providers
+		if len(providers) == 0 {
+			providers = []string{CloudEc2, CloudGce, CloudAzure}
+		}
+		var errs []string
+		for _, provider := range providers {
+			instance, err := queryCloudInstance(provider)
+			if err == nil {
+				cloudInstance = &instance
+				return
+			}
+			errs = append(errs, err.Error())
+		}
+		log.Printf("no cloud instance metadata found: %s\n", strings.Join(errs, "; "))
+	})
+	return cloudInstance
+}
+
+func queryCloudInstance(provider string) (CloudInstance, error) {
+	switch provider {
+	case CloudEc2:
+		return queryEc2Instance()
+	case CloudGce:
+		return queryGceInstance()
+	case CloudAzure:
+		return queryAzureInstance()
+	}
+	return CloudInstance{}, fmt.Errorf("unknown cloud provider %q", provider)
+}
+
+// queryEc2Instance reads the instance identity document with an IMDSv2 session token.
+func queryEc2Instance() (CloudInstance, error) {
+	client := http.Client{Timeout: cloudMetadataTimeout}
+	req, err := http.NewRequest(http.MethodPut, imdsAddress+"/latest/api/token", nil)
+	if err != nil {
+		return CloudInstance{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := metadataRequest(client, req)
+	if err != nil {
+		return CloudInstance{}, fmt.Errorf("ec2 metadata error: %w", err)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, imdsAddress+"/latest/dynamic/instance-identity/document", nil)
+	if err != nil {
+		return CloudInstance{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	body, err := metadataRequest(client, req)
+	if err != nil {
+		return CloudInstance{}, fmt.Errorf("ec2 metadata error: %w", err)
+	}
+	var doc struct {
+		InstanceId       string `json:"instanceId"`
+		InstanceType     string `json:"instanceType"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return CloudInstance{}, fmt.Errorf("ec2 metadata error: %w", err)
+	}
+	return CloudInstance{Provider: CloudEc2, InstanceId: doc.InstanceId, InstanceType: doc.InstanceType, Region: doc.Region, Zone: doc.AvailabilityZone}, nil
+}
+
+func queryGceInstance() (CloudInstance, error) {
+	if !metadata.OnGCE() {
+		return CloudInstance{}, errors.New("gce metadata error: not running on GCE")
+	}
+	instanceId, err := metadata.InstanceID()
+	if err != nil {
+		return CloudInstance{}, fmt.Errorf("gce metadata error: %w", err)
+	}
+	zone, err := metadata.Zone()
+	if err != nil {
+		return CloudInstance{}, fmt.Errorf("gce metadata error: %w", err)
+	}
+	// projects/<number>/machineTypes/<type>
+	machineType, err := metadata.Get("instance/machine-type")
+	if err != nil {
+		return CloudInstance{}, fmt.Errorf("gce metadata error: %w", err)
+	}
+	// Zones are the region with a zone suffix, e.g. us-central1-a
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	return CloudInstance{Provider: CloudGce, InstanceId: instanceId, InstanceType: path.Base(machineType), Region: region, Zone: zone}, nil
+}
+
+func queryAzureInstance() (CloudInstance, error) {
+	client := http.Client{Timeout: cloudMetadataTimeout}
+	req, err := http.NewRequest(http.MethodGet, imdsAddress+"/metadata/instance/compute?api-version=2021-02-01", nil)
+	if err != nil {
+		return CloudInstance{}, err
+	}
+	req.Header.Set("Metadata", "true")
+	body, err := metadataRequest(client, req)
+	if err != nil {
+		return CloudInstance{}, fmt.Errorf("azure metadata error: %w", err)
+	}
+	var compute struct {
+		VmId     string `json:"vmId"`
+		VmSize   string `json:"vmSize"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := json.Unmarshal(body, &compute); err != nil {
+		return CloudInstance{}, fmt.Errorf("azure metadata error: %w", err)
+	}
+	return CloudInstance{Provider: CloudAzure, InstanceId: compute.VmId, InstanceType: compute.VmSize, Region: compute.Location, Zone: compute.Zone}, nil
+}
+
+func metadataRequest(client http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s", resp.Status, body)
+	}
+	return body, nil
+}
+
+// cloudLabels are the metric labels of a record's cloud instance.
+func cloudLabels(instance *CloudInstance) map[string]string {
+	if instance == nil {
+		return nil
+	}
+	labels := map[string]string{"cloud_provider": instance.Provider, "instance_id": instance.InstanceId}
+	for k, v := range map[string]string{"instance_type": instance.InstanceType, "region": instance.Region, "zone": instance.Zone} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"log"
+	"net"
+	"time"
+)
+
+// Values of DBConfig.Dialect
+const (
+	DialectPostgres  = "postgres"
+	DialectCockroach = "cockroachdb"
+)
+
+const (
+	// txAttempts is how often a transaction is run when it keeps failing with a retryable error
+	txAttempts = 5
+	// cockroachDeleteBatch bounds the rows of each retention delete, large deletes exceed
+	// CockroachDB's transaction limits
+	cockroachDeleteBatch = 10000
+	// defaultDbTimeoutSeconds bounds each database operation if DBConfig.TimeoutSeconds is zero
+	defaultDbTimeoutSeconds = 60
+)
+
+// dbTimeout is the configured timeout of database operations, zero for none.
+func dbTimeout(conf DBConfig) time.Duration {
+	seconds := conf.TimeoutSeconds
+	if seconds == 0 {
+		seconds = defaultDbTimeoutSeconds
+	}
+	return time.Duration(max(seconds, 0)) * time.Second
+}
+
+// dbContext bounds one database operation, a statement or a transaction, by the configured
+// timeout.
+func dbContext(conf DBConfig) (context.Context, context.CancelFunc) {
+	if timeout := dbTimeout(conf); timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// deadlineDialer opens connections that fail any read or write waiting longer than timeout.
+// lib/pq only uses contexts to dial and to ask the server to cancel, a server that stopped
+// answering would otherwise block the startup or a query for good.
+type deadlineDialer struct {
+	timeout time.Duration
+}
+
+func (d deadlineDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d deadlineDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d deadlineDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{Timeout: d.timeout, KeepAlive: 5 * time.Minute}).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return deadlineConn{Conn: conn, timeout: d.timeout}, nil
+}
+
+// deadlineConn moves the deadline of its connection on before every read and write.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c deadlineConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c deadlineConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// isTimeout reports whether a database operation failed by running out of time: the timeout of
+// dbContext, the server's statement_timeout or a network timeout.
+func isTimeout(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// query_canceled, which is also what lib/pq gets back after cancelling on a deadline
+		return pqErr.Code == "57014"
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// retryableTxError reports whether the transaction failed with a serialization failure, which
+// CockroachDB returns whenever a transaction must be retried by the client.
+func retryableTxError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
+
+// runTx runs fn in a transaction and commits it, running it again on serialization failures.
+// Each attempt has its own timeout, fn runs its statements with ctx.
+func runTx(db *sqlx.DB, conf DBConfig, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	var err error
+	for attempt := 1; attempt <= txAttempts; attempt++ {
+		err = func() error {
+			ctx, cancel := dbContext(conf)
+			defer cancel()
+			tx, err := db.BeginTxx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(ctx, tx); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		}()
+		if !retryableTxError(err) {
+			return err
+		}
+		log.Printf("retrying transaction after serialization failure (attempt %d): %v\n", attempt, err)
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+	return err
+}
+
+// initializeTables creates the schema and tables. Postgres runs the statements in one
+// transaction, CockroachDB each on its own since it limits schema changes in transactions.
+func initializeTables(db *sqlx.DB, conf DBConfig) error {
+	statements := []string{
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", conf.Schema),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s ( uuid text, ts timestamp with time zone, partition_name text, label text, mount_path text, size_bytes numeric, attributes JSONB, run_id text, failed_lbas bigint[]);", conf.Schema, conf.Table),
+		fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS run_id text;", conf.Schema, conf.Table),
+		fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS failed_lbas bigint[];", conf.Schema, conf.Table),
+		fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS agent_ts timestamp with time zone;", conf.Schema, conf.Table),
+		fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS received_at timestamp with time zone DEFAULT now();", conf.Schema, conf.Table),
+		fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS severity text;", conf.Schema, conf.Table),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s_runs ( run_id text PRIMARY KEY, started timestamp with time zone, duration_seconds double precision, version text, devices integer, errors integer);", conf.Schema, conf.Table),
+		fmt.Sprintf("ALTER TABLE %s.%s_runs ADD COLUMN IF NOT EXISTS event text;", conf.Schema, conf.Table),
+		fmt.Sprintf("ALTER TABLE %s.%s_runs ADD COLUMN IF NOT EXISTS event_detail text;", conf.Schema, conf.Table),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s_acks ( partition_name text PRIMARY KEY, ts timestamp with time zone, comment text);", conf.Schema, conf.Table),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s_replacements ( ts timestamp with time zone, partition_name text, old_serial text, new_serial text, new_model text, attributes JSONB, comment text);", conf.Schema, conf.Table),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s_serials ( partition_name text PRIMARY KEY, serial text, ts timestamp with time zone);", conf.Schema, conf.Table),
+	}
+	if conf.LatestTable {
+		statements = append(statements, latestTableStatements(conf)...)
+	}
+	if conf.Dialect == DialectCockroach {
+		for _, statement := range statements {
+			ctx, cancel := dbContext(conf)
+			_, err := db.ExecContext(ctx, statement)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err := runTx(db, conf, func(ctx context.Context, tx *sqlx.Tx) error {
+			for _, statement := range statements {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The reading key fails on tables already holding a reading twice, which must not keep the
+	// other tables from being created
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, readingKeyStatement(conf)); err != nil {
+		return fmt.Errorf("could not create the unique key of readings, delete duplicate readings first: %w", err)
+	}
+	return nil
+}
+
+// readingKeyStatement makes readings unique by uuid, partition and time, so the inserts skip the
+// readings the sync of a local store sends again after a crash.
+func readingKeyStatement(conf DBConfig) string {
+	return fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %[2]s_reading_key ON %[1]s.%[2]s (uuid, partition_name, ts);", conf.Schema, conf.Table)
+}
+
+// deleteExpired deletes the rows older than the cutoff of rule that match it and returns how many
+// were deleted. On CockroachDB it deletes in batches.
+func deleteExpired(db *sqlx.DB, conf DBConfig, rule retentionRule) (int64, error) {
+	args := append([]any{rule.cutoff}, rule.args...)
+	query := fmt.Sprintf("DELETE FROM %s.%s WHERE ts < $1 AND %s;", conf.Schema, conf.Table, rule.filter)
+	if conf.Dialect != DialectCockroach {
+		var rows int64
+		err := runTx(db, conf, func(ctx context.Context, tx *sqlx.Tx) error {
+			res, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			rows, _ = res.RowsAffected()
+			return nil
+		})
+		return rows, err
+	}
+
+	query = fmt.Sprintf("DELETE FROM %s.%s WHERE ts < $1 AND %s LIMIT %d;", conf.Schema, conf.Table, rule.filter, cockroachDeleteBatch)
+	var total int64
+	for {
+		var rows int64
+		err := runTx(db, conf, func(ctx context.Context, tx *sqlx.Tx) error {
+			res, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			rows, _ = res.RowsAffected()
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += rows
+		if rows < cockroachDeleteBatch {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeConfig serves devices through the fake backend, by path in the order given.
+func fakeConfig(devices map[string]FakeDevice, paths ...string) Config {
+	conf := Config{Discovery: DiscoveryDirect, Backend: BackendFake, Attributes: []uint8{5, 9, 177, 197}, FakeDevices: devices}
+	for _, path := range paths {
+		conf.Partitions = append(conf.Partitions, PartitionConfig{Path: path})
+	}
+	return applyDefaults(conf)
+}
+
+func attributeIds(attrs []Attr) []uint8 {
+	var ids []uint8
+	for _, attr := range attrs {
+		ids = append(ids, attr.Id)
+	}
+	return ids
+}
+
+func TestCollectAllFakeDevices(t *testing.T) {
+	tests := []struct {
+		name               string
+		device             FakeDevice
+		includeUnsupported bool
+		// collected is false if the device is left out of the records
+		collected      bool
+		smartSupported bool
+		attributes     []uint8
+		unsupported    []int
+		errorClass     string
+		skipReason     string
+		severity       string
+	}{{
+		name: "ata",
+		device: FakeDevice{Model: "OLD HDD", MediaType: MediaHdd, Attributes: []FakeAttribute{
+			{Id: 5, Current: 100, Worst: 100, Raw: 8},
+			{Id: 9, Current: 50, Worst: 50, Raw: 52000},
+			{Id: 194, Current: 70, Worst: 60, Raw: 30},
+			{Id: 197, Current: 100, Worst: 100, Raw: 2},
+		}},
+		collected:      true,
+		smartSupported: true,
+		attributes:     []uint8{5, 9, 197},
+		unsupported:    []int{177},
+		severity:       SeverityCritical,
+	}, {
+		name:           "ata healthy",
+		device:         FakeDevice{Model: "WORN SSD", MediaType: MediaSsd, Attributes: []FakeAttribute{{Id: 9, Current: 99, Worst: 99, Raw: 9000}, {Id: 177, Current: 12, Worst: 12, Raw: 3000}}},
+		collected:      true,
+		smartSupported: true,
+		attributes:     []uint8{9, 177},
+		unsupported:    []int{5, 197},
+		severity:       SeverityInfo,
+	}, {
+		name:           "nvme",
+		device:         FakeDevice{Model: "NVME", NvmeHealth: &NvmeHealth{PercentageUsed: 40, MediaErrors: 3}},
+		collected:      true,
+		smartSupported: true,
+		severity:       SeverityInfo,
+	}, {
+		name:           "nvme critical warning",
+		device:         FakeDevice{Model: "NVME", NvmeHealth: &NvmeHealth{CriticalWarning: 4}},
+		collected:      true,
+		smartSupported: true,
+		severity:       SeverityCritical,
+	}, {
+		name:       "read error",
+		device:     FakeDevice{Model: "BROKEN", Error: "no answer"},
+		collected:  true,
+		errorClass: ErrorClassRead,
+		skipReason: "collection failed: Could not read fake device /dev/fake: no answer",
+		severity:   SeverityWarning,
+	}, {
+		name:       "recorded error",
+		device:     FakeDevice{Error: "permission denied", ErrorClass: ErrorClassPermission},
+		collected:  true,
+		errorClass: ErrorClassPermission,
+		skipReason: "collection failed: permission denied",
+		severity:   SeverityWarning,
+	}, {
+		name:      "unsupported",
+		device:    FakeDevice{Model: "USB BRIDGE"},
+		collected: false,
+	}, {
+		name:               "unsupported included",
+		device:             FakeDevice{Model: "USB BRIDGE"},
+		includeUnsupported: true,
+		collected:          true,
+		skipReason:         "no SMART support",
+		severity:           SeverityInfo,
+	}, {
+		name:       "smart disabled",
+		device:     FakeDevice{Model: "DISABLED", SkipReason: "SMART disabled"},
+		collected:  true,
+		skipReason: "SMART disabled",
+		severity:   SeverityInfo,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := fakeConfig(map[string]FakeDevice{"/dev/fake": tt.device}, "/dev/fake")
+			conf.IncludeUnsupported = tt.includeUnsupported
+			records := collectAll(conf)
+			if !tt.collected {
+				if len(records) != 0 {
+					t.Fatalf("got %d records, want none", len(records))
+				}
+				return
+			}
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			record := records[0]
+			if record.PartitionName != "/dev/fake" {
+				t.Errorf("partition name %q", record.PartitionName)
+			}
+			if record.SmartSupported != tt.smartSupported {
+				t.Errorf("smart supported %v, want %v", record.SmartSupported, tt.smartSupported)
+			}
+			if ids := attributeIds(record.Attributes); !slices.Equal(ids, tt.attributes) {
+				t.Errorf("attributes %v, want %v", ids, tt.attributes)
+			}
+			if !slices.Equal(record.UnsupportedAttributes, tt.unsupported) {
+				t.Errorf("unsupported attributes %v, want %v", record.UnsupportedAttributes, tt.unsupported)
+			}
+			if (tt.device.NvmeHealth != nil) != (record.NvmeHealth != nil) {
+				t.Errorf("nvme health %v", record.NvmeHealth)
+			}
+			switch {
+			case tt.errorClass == "" && record.Error != nil:
+				t.Errorf("unexpected error %+v", record.Error)
+			case tt.errorClass != "" && record.Error == nil:
+				t.Errorf("no error, want class %s", tt.errorClass)
+			case tt.errorClass != "" && (record.Error.Class != tt.errorClass || record.Error.Attempts != 1):
+				t.Errorf("error %+v, want class %s after 1 attempt", record.Error, tt.errorClass)
+			}
+			if record.SkipReason != tt.skipReason {
+				t.Errorf("skip reason %q, want %q", record.SkipReason, tt.skipReason)
+			}
+			if severity := recordSeverity(record); severity != tt.severity {
+				t.Errorf("severity %s, want %s", severity, tt.severity)
+			}
+		})
+	}
+}
+
+// A run shares its info between the records, which come out sorted however they were configured.
+func TestCollectAllRun(t *testing.T) {
+	devices := map[string]FakeDevice{
+		"/dev/fa": {Model: "OLD HDD", Attributes: []FakeAttribute{{Id: 5, Raw: 8}}},
+		"/dev/fb": {Model: "BROKEN", Error: "no answer"},
+		"/dev/fc": {Model: "NVME", NvmeHealth: &NvmeHealth{}},
+		"/dev/fd": {Model: "USB BRIDGE"},
+	}
+	records := collectAll(fakeConfig(devices, "/dev/fd", "/dev/fc", "/dev/fb", "/dev/fa", "/dev/missing"))
+
+	var names []string
+	for _, record := range records {
+		names = append(names, record.PartitionName)
+	}
+	if want := []string{"/dev/fa", "/dev/fb", "/dev/fc", "/dev/missing"}; !slices.Equal(names, want) {
+		t.Fatalf("records %v, want %v", names, want)
+	}
+	run := records[0].Run
+	if run == nil || run.Id == "" {
+		t.Fatalf("no run info: %+v", run)
+	}
+	for _, record := range records {
+		if record.Run != run {
+			t.Errorf("%s has run %+v, want %+v", record.PartitionName, record.Run, run)
+		}
+	}
+	if run.Devices != 4 || run.Errors != 2 {
+		t.Errorf("run counted %d devices and %d errors, want 4 and 2", run.Devices, run.Errors)
+	}
+	if missing := records[3].Error; missing == nil || missing.Class != ErrorClassNotFound || !strings.Contains(missing.Message, "/dev/missing") {
+		t.Errorf("missing device error %+v", missing)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Classes of CollectionError
+const (
+	ErrorClassPermission = "permission"
+	ErrorClassNotFound   = "not_found"
+	ErrorClassOpen       = "open"
+	ErrorClassRead       = "read"
+)
+
+// CollectionError describes why a device could not be collected, so outputs can tell a failing
+// collection apart from a healthy drive.
+type CollectionError struct {
+	Class   string `json:"class"`
+	Message string `json:"message"`
+	// Attempts is how often the device was tried in the run, see RetryConfig
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// errorClass refines class for errors with a more specific cause.
+func errorClass(err error, class string) string {
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return ErrorClassPermission
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, exec.ErrNotFound):
+		return ErrorClassNotFound
+	}
+	return class
+}
+
+// fail records a collection error on the line and prints it. The line is still reported, without
+// SMART data.
+func (p *PartitionLine) fail(class string, err error, format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	fmt.Printf("%s: %s\n", msg, err)
+	p.Error = &CollectionError{Class: errorClass(err, class), Message: fmt.Sprintf("%s: %s", msg, err)}
+	p.SkipReason = "collection failed: " + p.Error.Message
+}
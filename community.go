@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// CommunityConfig submits snapshots of drive models and their attributes to a shared reliability
+// data service. Only the fields in communityFields are ever sent, never device paths, host names,
+// labels or serial numbers. A drive id is only sent if anonymize is configured, as the anonymized
+// serial number.
+type CommunityConfig struct {
+	Url string `json:"url"`
+	// Token is sent as a bearer token, if the service needs one
+	Token string `json:"token,omitempty"`
+	// Fields restricts the snapshot to some of communityFields, all of them if empty
+	Fields []string `json:"fields,omitempty"`
+}
+
+// communityFields is the allowlist of snapshot fields.
+var communityFields = []string{"date", "model", "firmware", "media_type", "size_bytes", "drive_id", "attributes"}
+
+// communityAttr is an attribute in a snapshot, without the vendor bytes and flags.
+type communityAttr struct {
+	Id      uint8  `json:"id"`
+	Current uint8  `json:"current"`
+	Worst   uint8  `json:"worst"`
+	Value   uint64 `json:"value"`
+}
+
+// communitySnapshot builds the submitted fields of a record. The date is the day of the reading
+// in UTC, a finer time would help to tell hosts apart.
+func communitySnapshot(record PartitionLine, conf CommunityConfig, anonymized bool) map[string]any {
+	attrs := make([]communityAttr, 0, len(record.Attributes))
+	for _, attr := range record.Attributes {
+		attrs = append(attrs, communityAttr{Id: attr.Id, Current: attr.Current, Worst: attr.Worst, Value: attr.ValueDecoded})
+	}
+	snapshot := map[string]any{
+		"date":       record.Ts.UTC().Format(time.DateOnly),
+		"model":      record.Model,
+		"firmware":   record.Firmware,
+		"media_type": record.MediaType,
+		"size_bytes": record.SizeBytes,
+		"attributes": attrs,
+	}
+	if anonymized && record.Serial != "" {
+		snapshot["drive_id"] = record.Serial
+	}
+	for field := range snapshot {
+		if !slices.Contains(communityFields, field) || (len(conf.Fields) > 0 && !slices.Contains(conf.Fields, field)) {
+			delete(snapshot, field)
+		}
+	}
+	return snapshot
+}
+
+// saveToCommunity posts the snapshot of a record. Records without SMART data or a model are not
+// useful to the service and skipped.
+func saveToCommunity(record PartitionLine, conf CommunityConfig, anonymized bool) error {
+	if !record.SmartSupported || record.Model == "" {
+		return nil
+	}
+	for _, field := range conf.Fields {
+		if !slices.Contains(communityFields, field) {
+			log.Printf("community field %q is not allowed, ignoring it\n", field)
+		}
+	}
+	body, err := json.Marshal(communitySnapshot(record, conf, anonymized))
+	if err != nil {
+		return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, conf.Url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("community request error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+conf.Token)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("community write error for %s: %w", record.PartitionName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("community write error for %s: %s %s", record.PartitionName, resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// dashboardDevice is a record as the dashboard shows it, with its health summary.
+type dashboardDevice struct {
+	PartitionLine
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// handleDevices returns the records of the last collection.
+func (d *daemon) handleDevices(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	records := d.lastRecords
+	lastRun := d.lastRun
+	d.mu.Unlock()
+
+	devices := make([]dashboardDevice, 0, len(records))
+	for _, record := range records {
+		status, reasons := deviceStatus(record)
+		devices = append(devices, dashboardDevice{PartitionLine: record, Status: status, Reasons: reasons})
+	}
+	var last *time.Time
+	if !lastRun.IsZero() {
+		last = &lastRun
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"last_run": last, "devices": devices})
+}
+
+// handleHistory returns the stored readings of a device's attributes, oldest first, keyed by
+// attribute ID. It responds 404 without a local store or database.
+func (d *daemon) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if d.conf.Local == nil && d.conf.Db == nil {
+		http.Error(w, errNoHistory.Error(), http.StatusNotFound)
+		return
+	}
+	device := r.URL.Query().Get("device")
+	if device == "" {
+		http.Error(w, "device is required", http.StatusBadRequest)
+		return
+	}
+	readings := 50
+	if s := r.URL.Query().Get("readings"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid readings", http.StatusBadRequest)
+			return
+		}
+		readings = min(n, 1000)
+	}
+
+	source, err := openHistory(d.conf)
+	if err != nil {
+		d.recordError(err)
+		http.Error(w, "history unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer source.Close()
+	history, err := source.attributeHistory(device, readings)
+	if err != nil {
+		d.recordError(err)
+		http.Error(w, "could not read history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(history)
+}
+
+// handleDashboard registers the web dashboard and the API it reads from. The assets contain no
+// data and are served without a token.
+func (d *daemon) handleDashboard(mux *http.ServeMux, auth apiAuth) {
+	assets, _ := fs.Sub(webAssets, "web")
+	mux.Handle("/", http.FileServer(http.FS(assets)))
+	mux.HandleFunc("/api/devices", auth.require(RoleReadOnly, d.handleDevices))
+	mux.HandleFunc("/api/history", auth.require(RoleReadOnly, d.handleHistory))
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+// devicePath returns the path used to open a block device reported by ghw.
+func devicePath(name string) string {
+	return "/dev/" + name
+}
+
+// deviceKey normalizes a configured device path for matching against discovered devices.
+func deviceKey(path string) string {
+	return path
+}
+
+// smartDevicePath returns the device SMART data is read from for a partition. Linux accepts SMART
+// ioctls on partition nodes, so the partition itself is opened.
+func smartDevicePath(diskPath string, partitionPath string) string {
+	return partitionPath
+}
+
+// volumePhysicalDrive maps volumes to the drives holding them on Windows, elsewhere devices are
+// opened as configured.
+func volumePhysicalDrive(path string) (string, bool, error) {
+	return "", false, nil
+}
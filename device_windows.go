@@ -0,0 +1,93 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"regexp"
+	"strings"
+)
+
+// ioctlVolumeGetVolumeDiskExtents is IOCTL_VOLUME_GET_VOLUME_DISK_EXTENTS.
+const ioctlVolumeGetVolumeDiskExtents = 0x560000
+
+// driveLetterPath matches volumes given by drive letter, as D:, D:\ or \\.\D:.
+var driveLetterPath = regexp.MustCompile(`^(?:\\\\\.\\)?([A-Za-z]):\\?$`)
+
+// devicePath returns the path used to open a block device reported by ghw. On Windows ghw already
+// reports disks as \\.\PHYSICALDRIVEn and partitions by their volume name (e.g. C:).
+func devicePath(name string) string {
+	return name
+}
+
+// deviceKey normalizes a configured device path for matching against discovered devices, since
+// Windows device and volume names are case-insensitive and volumes can be written as d:, D:\ or
+// \\.\D:.
+func deviceKey(path string) string {
+	if m := driveLetterPath.FindStringSubmatch(path); m != nil {
+		return strings.ToUpper(m[1]) + ":"
+	}
+	return strings.ToUpper(path)
+}
+
+// smartDevicePath maps a volume to the physical drive holding it, as SMART can only be queried
+// through the \\.\PHYSICALDRIVEn handle.
+func smartDevicePath(diskPath string, partitionPath string) string {
+	return diskPath
+}
+
+// volumePhysicalDrive returns the physical drive holding a volume given by drive letter, e.g.
+// \\.\PHYSICALDRIVE1 for D:, and false for paths that are not volumes. Volumes spanning several
+// drives, such as Storage Spaces and spanned dynamic disks, have no one drive to read.
+func volumePhysicalDrive(path string) (string, bool, error) {
+	m := driveLetterPath.FindStringSubmatch(path)
+	if m == nil {
+		return "", false, nil
+	}
+	name, err := windows.UTF16PtrFromString(`\\.\` + strings.ToUpper(m[1]) + ":")
+	if err != nil {
+		return "", true, err
+	}
+	// Querying the extents needs no access rights, so this works without elevation too
+	h, err := windows.CreateFile(name, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return "", true, fmt.Errorf("could not open volume: %w", err)
+	}
+	defer windows.CloseHandle(h)
+
+	buf := make([]byte, 8+8*extentSize)
+	var n uint32
+	if err := windows.DeviceIoControl(h, ioctlVolumeGetVolumeDiskExtents, nil, 0, &buf[0], uint32(len(buf)), &n, nil); err != nil {
+		return "", true, fmt.Errorf("could not read the disk extents of the volume: %w", err)
+	}
+	disk, err := parseVolumeDiskExtents(buf[:n])
+	if err != nil {
+		return "", true, err
+	}
+	return fmt.Sprintf(`\\.\PHYSICALDRIVE%d`, disk), true, nil
+}
+
+// extentSize is the size of a DISK_EXTENT: the disk number padded to 8 bytes, the starting offset
+// and the length.
+const extentSize = 24
+
+// parseVolumeDiskExtents returns the disk number of the drive holding all extents of a
+// VOLUME_DISK_EXTENTS, which is the extent count padded to 8 bytes followed by the extents.
+func parseVolumeDiskExtents(buf []byte) (uint32, error) {
+	if len(buf) < 8 {
+		return 0, fmt.Errorf("the volume reports disk extents in %d bytes", len(buf))
+	}
+	count := int(binary.LittleEndian.Uint32(buf))
+	if count == 0 || len(buf) < 8+count*extentSize {
+		return 0, fmt.Errorf("the volume reports %d disk extents in %d bytes", count, len(buf))
+	}
+	disk := binary.LittleEndian.Uint32(buf[8:])
+	for i := 1; i < count; i++ {
+		if other := binary.LittleEndian.Uint32(buf[8+i*extentSize:]); other != disk {
+			return 0, fmt.Errorf("the volume spans drives %d and %d, configure the \\\\.\\PHYSICALDRIVEn paths instead", disk, other)
+		}
+	}
+	return disk, nil
+}
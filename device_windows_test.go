@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeviceKey(t *testing.T) {
+	for path, want := range map[string]string{
+		"d:":                  "D:",
+		`D:\`:                 "D:",
+		`\\.\d:`:              "D:",
+		`\\.\PhysicalDrive1`:  `\\.\PHYSICALDRIVE1`,
+		`\\.\PHYSICALDRIVE1`:  `\\.\PHYSICALDRIVE1`,
+		`D:\data`:             `D:\DATA`,
+		`\\?\Volume{6f1c2b}\`: `\\?\VOLUME{6F1C2B}\`,
+	} {
+		if key := deviceKey(path); key != want {
+			t.Errorf("%s has key %s, want %s", path, key, want)
+		}
+	}
+}
+
+// VOLUME_DISK_EXTENTS as IOCTL_VOLUME_GET_VOLUME_DISK_EXTENTS returns them
+const (
+	// A partition of 500 GB starting at 1 MiB of drive 1
+	basicVolumeExtents = "0100000000000000" + "010000000000000000001000000000000060b07074000000"
+	// A spanned volume of 1 GiB on each of drives 1 and 2
+	spannedVolumeExtents = "0200000000000000" + "010000000000000000001000000000000000004000000000" + "020000000000000000001000000000000000004000000000"
+	// A dynamic volume extended on drive 3, whose extents are both on it
+	extendedVolumeExtents = "0200000000000000" + "030000000000000000001000000000000000004000000000" + "030000000000000000001040000000000000004000000000"
+)
+
+func TestParseVolumeDiskExtents(t *testing.T) {
+	for name, fixture := range map[string]struct {
+		extents string
+		disk    uint32
+	}{
+		"basic":    {basicVolumeExtents, 1},
+		"extended": {extendedVolumeExtents, 3},
+	} {
+		buf, _ := hex.DecodeString(fixture.extents)
+		if disk, err := parseVolumeDiskExtents(buf); err != nil || disk != fixture.disk {
+			t.Errorf("%s volume is on drive %d, %v, want %d", name, disk, err, fixture.disk)
+		}
+	}
+
+	for name, extents := range map[string]string{
+		"spanned":   spannedVolumeExtents,
+		"truncated": spannedVolumeExtents[:2*(8+24)],
+		"empty":     "0000000000000000",
+		"short":     "01000000",
+	} {
+		buf, _ := hex.DecodeString(extents)
+		if disk, err := parseVolumeDiskExtents(buf); err == nil {
+			t.Errorf("%s volume read as drive %d", name, disk)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// DeviceStatistic is one entry of the ATA Device Statistics log (GPL log 0x04). Unlike vendor SMART
+// attributes these have standardized meanings, e.g. lifetime logical sectors written or the
+// highest temperature seen.
+type DeviceStatistic struct {
+	Page   int    `json:"page"`
+	Offset int    `json:"offset"`
+	Name   string `json:"name"`
+	Value  int64  `json:"value"`
+}
+
+// collectDeviceStatistics reads the Device Statistics log of devName through smartctl. Entries the
+// drive marks as invalid are left out.
+func collectDeviceStatistics(devName string, line PartitionLine, conf Config) PartitionLine {
+	out, err := runSmartctl(conf.SmartctlPath, devName, "-l", "devstat")
+	if err != nil {
+		fmt.Printf("Could not read device statistics for %s with smartctl: %s\n", devName, err)
+		return line
+	}
+	if out.AtaDeviceStatistics == nil {
+		fmt.Printf("%s does not support the device statistics log\n", devName)
+		return line
+	}
+
+	for _, page := range out.AtaDeviceStatistics.Pages {
+		for _, entry := range page.Table {
+			if entry.Value == nil || !entry.Flags.Valid {
+				continue
+			}
+			line.DeviceStatistics = append(line.DeviceStatistics, DeviceStatistic{
+				Page:   page.Number,
+				Offset: entry.Offset,
+				Name:   entry.Name,
+				Value:  *entry.Value,
+			})
+		}
+	}
+	return line
+}
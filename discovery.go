@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jaypipes/ghw"
+	"os"
+	"strings"
+	"time"
+)
+
+// target is a configured device found during discovery, ready to be collected.
+type target struct {
+	smartPath string
+	line      PartitionLine
+	device    PartitionConfig
+}
+
+// discoverBlockDevices enumerates disks and partitions through ghw and returns the configured ones.
+func discoverBlockDevices(partitionList map[string]PartitionConfig, runTs time.Time) []target {
+	// Get all Block Storage devices
+	block, err := ghw.Block()
+	if err != nil {
+		panic(err)
+	}
+
+	targets := make([]target, 0)
+	found := make(map[string]bool)
+
+	// Check each disk and disk partition
+	for _, disk := range block.Disks {
+		diskName := devicePath(disk.Name)
+		if device, ok := partitionList[deviceKey(diskName)]; ok {
+			found[deviceKey(diskName)] = true
+			targets = append(targets, target{
+				smartPath: diskName,
+				line: PartitionLine{
+					Ts:            runTs,
+					PartitionName: configuredName(diskName, device),
+					SizeBytes:     disk.SizeBytes,
+				},
+				device: device,
+			})
+		}
+
+		for _, p := range disk.Partitions {
+			// Skip disks we don't care about
+			devName := devicePath(p.Name)
+			device, ok := partitionList[deviceKey(devName)]
+			if !ok {
+				continue
+			}
+
+			found[deviceKey(devName)] = true
+			t := target{
+				smartPath: smartDevicePath(diskName, devName),
+				line: PartitionLine{
+					Uuid:          p.UUID,
+					Ts:            runTs,
+					PartitionName: configuredName(devName, device),
+					Label:         p.FilesystemLabel,
+					MountPath:     p.MountPoint,
+					SizeBytes:     p.SizeBytes,
+				},
+				device: device,
+			}
+			if deviceKey(t.smartPath) != deviceKey(devName) {
+				t.line.PhysicalDrive = t.smartPath
+			}
+			targets = append(targets, t)
+		}
+	}
+
+	for key, device := range partitionList {
+		if found[key] {
+			continue
+		}
+		if _, err := os.Stat(device.Path); err == nil {
+			fmt.Printf("%s exists but was not found by block device discovery, consider \"discovery\": \"%s\"\n", device.Path, DiscoveryDirect)
+		}
+	}
+	if len(found) == 0 && len(partitionList) > 0 && runningInContainer() {
+		fmt.Printf("no configured devices found and this looks like a container, sysfs may not reflect the mapped /dev nodes; consider \"discovery\": \"%s\"\n", DiscoveryDirect)
+	}
+
+	return targets
+}
+
+// configuredName names a discovered device as configured when the config gives an alias of it,
+// e.g. /dev/mapper/mpatha rather than /dev/dm-0, as kernel names of device mapper nodes change
+// between boots.
+func configuredName(devName string, device PartitionConfig) string {
+	if canonicalDevicePath(device.Path) != device.Path {
+		return device.Path
+	}
+	return devName
+}
+
+// discoverDirect opens the configured device paths as-is, without relying on sysfs enumeration. This
+// is meant for containers where only specific /dev nodes are mapped in. Partition metadata such as
+// labels and mount paths is not available in this mode. On Windows volumes given by drive letter
+// are read through the physical drive holding them.
+func discoverDirect(partitions []PartitionConfig, runTs time.Time) []target {
+	targets := make([]target, 0)
+	for _, device := range partitions {
+		// Fake devices only exist in the config
+		if _, err := os.Stat(device.Path); err != nil && device.Backend != BackendFake {
+			fmt.Printf("could not find device %s: %s\n", device.Path, err)
+			continue
+		}
+		t := target{
+			smartPath: device.Path,
+			line: PartitionLine{
+				Ts:            runTs,
+				PartitionName: device.Path,
+			},
+			device: device,
+		}
+		if drive, ok, err := volumePhysicalDrive(device.Path); err != nil {
+			fmt.Printf("could not find the drive of %s: %s\n", device.Path, err)
+			continue
+		} else if ok {
+			t.smartPath, t.line.PhysicalDrive = drive, drive
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// runningInContainer makes a best-effort guess at whether the process runs inside a container.
+func runningInContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+
+	cgroup, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, runtime := range []string{"docker", "kubepods", "containerd", "libpod", "lxc"} {
+		if strings.Contains(string(cgroup), runtime) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// DiskStats are the I/O counters of a device since boot, as in /proc/diskstats.
+type DiskStats struct {
+	Reads          uint64 `json:"reads"`
+	ReadsMerged    uint64 `json:"reads_merged"`
+	SectorsRead    uint64 `json:"sectors_read"`
+	ReadTimeMs     uint64 `json:"read_time_ms"`
+	Writes         uint64 `json:"writes"`
+	WritesMerged   uint64 `json:"writes_merged"`
+	SectorsWritten uint64 `json:"sectors_written"`
+	WriteTimeMs    uint64 `json:"write_time_ms"`
+	// InFlight is the number of requests in progress when the stats were read
+	InFlight uint64 `json:"in_flight"`
+	// IoTimeMs is the time the device was busy, WeightedIoTimeMs that time weighted by the number
+	// of requests in flight
+	IoTimeMs         uint64 `json:"io_time_ms"`
+	WeightedIoTimeMs uint64 `json:"weighted_io_time_ms"`
+}
+
+func (s DiskStats) String() string {
+	return s.format(numberFormat{})
+}
+
+func (s DiskStats) format(f numberFormat) string {
+	// Sectors are 512 bytes in diskstats regardless of the device
+	return fmt.Sprintf("%s reads (%s), %s writes (%s), %s in flight, busy %ss",
+		f.uint(s.Reads), f.bytes(s.SectorsRead*512), f.uint(s.Writes), f.bytes(s.SectorsWritten*512), f.uint(s.InFlight), f.float(float64(s.IoTimeMs)/1000, 1))
+}
+
+// collectDiskStats adds the I/O counters of the record's device.
+func collectDiskStats(line PartitionLine) PartitionLine {
+	name := line.PartitionName
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		name = resolved
+	}
+	stats, err := readDiskStats(filepath.Base(name))
+	if err != nil {
+		fmt.Printf("Could not read the I/O statistics of %s: %s\n", line.PartitionName, err)
+		return line
+	}
+	line.DiskStats = &stats
+	return line
+}
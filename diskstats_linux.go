@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readDiskStats reads the counters of the named device from /proc/diskstats.
+func readDiskStats(name string) (DiskStats, error) {
+	f, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return DiskStats{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// major minor name, then the counters in DiskStats order, newer kernels add discard and
+		// flush counters after them
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 || fields[2] != name {
+			continue
+		}
+		var counters [11]uint64
+		for i := range counters {
+			if counters[i], err = strconv.ParseUint(fields[3+i], 10, 64); err != nil {
+				return DiskStats{}, fmt.Errorf("unexpected /proc/diskstats line %q", scanner.Text())
+			}
+		}
+		return DiskStats{
+			Reads:            counters[0],
+			ReadsMerged:      counters[1],
+			SectorsRead:      counters[2],
+			ReadTimeMs:       counters[3],
+			Writes:           counters[4],
+			WritesMerged:     counters[5],
+			SectorsWritten:   counters[6],
+			WriteTimeMs:      counters[7],
+			InFlight:         counters[8],
+			IoTimeMs:         counters[9],
+			WeightedIoTimeMs: counters[10],
+		}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return DiskStats{}, err
+	}
+	return DiskStats{}, fmt.Errorf("%s is not in /proc/diskstats", name)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func readDiskStats(name string) (DiskStats, error) {
+	return DiskStats{}, errors.New("I/O statistics are only available on Linux")
+}
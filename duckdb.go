@@ -0,0 +1,18 @@
+package main
+
+// DuckdbConfig appends records to a local DuckDB database, for SQL over long histories without a
+// database server. Needs a build with cgo.
+type DuckdbConfig struct {
+	// Path of the database file, created if it does not exist
+	Path string `json:"path"`
+	// Table holds one row per record, Table_attributes one row per attribute of a record.
+	// smart_readings if empty.
+	Table string `json:"table,omitempty"`
+}
+
+func duckdbTable(conf DuckdbConfig) string {
+	if conf.Table == "" {
+		return "smart_readings"
+	}
+	return conf.Table
+}
@@ -0,0 +1,83 @@
+//go:build cgo
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+// saveToDuckdb appends records to the readings table of the database and their attributes to the
+// attributes table, in one transaction. The tables are created if they do not exist.
+func saveToDuckdb(records []PartitionLine, conf DuckdbConfig) error {
+	db, err := sql.Open("duckdb", conf.Path)
+	if err != nil {
+		return fmt.Errorf("could not open duckdb database %s: %w", conf.Path, err)
+	}
+	defer db.Close()
+
+	table := duckdbTable(conf)
+	// attributes is the JSON array of the record, VARCHAR since the bundled DuckDB lacks the json
+	// extension. The attributes table holds the same values as columns.
+	for _, statement := range []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (uuid VARCHAR, ts TIMESTAMPTZ, partition_name VARCHAR, label VARCHAR, mount_path VARCHAR, size_bytes UBIGINT, model VARCHAR, firmware VARCHAR, run_id VARCHAR, attributes VARCHAR, failed_lbas UBIGINT[]);`, table),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s_attributes (ts TIMESTAMPTZ, partition_name VARCHAR, id UTINYINT, name VARCHAR, current UTINYINT, worst UTINYINT, raw UBIGINT, value BIGINT, unit VARCHAR);`, table),
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("could not create duckdb table %s: %w", table, err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("duckdb write error: %w", err)
+	}
+	defer tx.Rollback()
+	for _, record := range records {
+		attrs, err := json.Marshal(record.Attributes)
+		if err != nil {
+			return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+		}
+		var runId *string
+		if record.Run != nil {
+			runId = &record.Run.Id
+		}
+		// Lists are passed as a literal, the driver does not bind slices
+		lbas, _ := json.Marshal(record.FailedLbas)
+		if record.FailedLbas == nil {
+			lbas = []byte("[]")
+		}
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?::UBIGINT[]);`, table),
+			record.Uuid, record.Ts, record.PartitionName, record.Label, record.MountPath, record.SizeBytes,
+			record.Model, record.Firmware, runId, string(attrs), string(lbas))
+		if err != nil {
+			return fmt.Errorf("duckdb write error for %s: %w", record.PartitionName, err)
+		}
+		for _, attr := range record.Attributes {
+			_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s_attributes VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, table),
+				record.Ts, record.PartitionName, attr.Id, attr.Name, attr.Current, attr.Worst, attr.ValueRaw, attr.ValueDecoded, attr.Unit)
+			if err != nil {
+				return fmt.Errorf("duckdb write error for %s: %w", record.PartitionName, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("duckdb write error: %w", err)
+	}
+	return nil
+}
+
+// probeDuckdb opens the database, which fails if it is locked by another process.
+func probeDuckdb(conf DuckdbConfig) error {
+	db, err := sql.Open("duckdb", conf.Path)
+	if err != nil {
+		return fmt.Errorf("could not open duckdb database %s: %w", conf.Path, err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		return fmt.Errorf("could not open duckdb database %s: %w", conf.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package main
+
+import "errors"
+
+func saveToDuckdb(records []PartitionLine, conf DuckdbConfig) error {
+	return errors.New("the duckdb output needs a build with cgo")
+}
+
+func probeDuckdb(conf DuckdbConfig) error {
+	return saveToDuckdb(nil, conf)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+// DriveLocation is the SES enclosure and slot a drive sits in, so the right caddy gets pulled.
+type DriveLocation struct {
+	// Enclosure is the logical identifier of the enclosure, usually the SAS address of its
+	// expander, or its SCSI address if it reports none
+	Enclosure string `json:"enclosure"`
+	// Slot is the slot number the enclosure reports, or the name of its slot element
+	Slot string `json:"slot"`
+}
+
+func (l DriveLocation) String() string {
+	return fmt.Sprintf("enclosure %s, slot %s", l.Enclosure, l.Slot)
+}
+
+// deviceLocated names a device with its location if known, for alerts.
+func deviceLocated(line PartitionLine) string {
+	if line.Location == nil {
+		return line.PartitionName
+	}
+	return fmt.Sprintf("%s (%s)", line.PartitionName, line.Location)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// enclosureSlotDir returns the sysfs directory of the SES slot element holding a disk. The kernel
+// links the disks of enclosures it manages as enclosure_device:<slot element name>, the link
+// points at the element in /sys/class/enclosure/<SCSI address>/.
+func enclosureSlotDir(devName string) (dir string, name string) {
+	// Aliases such as /dev/disk/by-id links are resolved to the device node first
+	if resolved, err := filepath.EvalSymlinks(devName); err == nil {
+		devName = resolved
+	}
+	blockDir := sysfsBlockDir(devName)
+	if blockDir == "" {
+		return "", ""
+	}
+	links, _ := filepath.Glob(filepath.Join(blockDir, "device", "enclosure_device:*"))
+	if len(links) == 0 {
+		return "", ""
+	}
+	name = strings.TrimPrefix(filepath.Base(links[0]), "enclosure_device:")
+	dir, err := filepath.EvalSymlinks(links[0])
+	if err != nil {
+		return "", name
+	}
+	return dir, name
+}
+
+// readDriveLocation finds the SES enclosure and slot of a disk from sysfs. It returns nil for
+// disks outside enclosures.
+func readDriveLocation(devName string) *DriveLocation {
+	element, name := enclosureSlotDir(devName)
+	if name == "" {
+		return nil
+	}
+	location := &DriveLocation{Slot: name}
+	if element == "" {
+		return location
+	}
+	if slot := readSysfsString(filepath.Join(element, "slot")); slot != "" {
+		location.Slot = slot
+	}
+	enclosure := filepath.Dir(element)
+	location.Enclosure = filepath.Base(enclosure)
+	if id := readSysfsString(filepath.Join(enclosure, "id")); id != "" {
+		location.Enclosure = id
+	}
+	return location
+}
+
+// setLocateLed turns the identify LED of the slot holding a disk on or off through the SES
+// driver, which is what ledctl does for SES enclosures.
+func setLocateLed(devName string, on bool) error {
+	element, _ := enclosureSlotDir(devName)
+	if element == "" {
+		return fmt.Errorf("%s is not in an SES enclosure, or the ses kernel module is not loaded", devName)
+	}
+	value := "0"
+	if on {
+		value = "1"
+	}
+	return os.WriteFile(filepath.Join(element, "locate"), []byte(value), 0o644)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The layout of the ses driver for disks behind a SAS expander: the SCSI device of a disk links
+// to its slot element of the enclosure, whose SCSI device has the enclosure class device.
+const (
+	sesExpander  = "devices/pci0000:00/0000:00:01.0/0000:01:00.0/host0/port-0:0/expander-0:0"
+	sesEnclosure = sesExpander + "/port-0:0:12/end_device-0:0:12/target0:0:12/0:0:12:0/enclosure/0:0:12:0"
+	sesDisk      = sesExpander + "/port-0:0:2/end_device-0:0:2/target0:0:2/0:0:2:0"
+)
+
+func TestReadDriveLocation(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		links map[string]string
+		want  *DriveLocation
+	}{{
+		name: "slot number and enclosure id",
+		files: map[string]string{
+			sesEnclosure + "/id":                  "0x500605b00abc1234",
+			sesEnclosure + "/Slot 02/slot":        "2",
+			sesEnclosure + "/Slot 02/locate":      "0",
+			sesEnclosure + "/Slot 02/type":        "array device",
+			sesDisk + "/block/sdc/dev":            "8:32",
+			sesDisk + "/block/sdc/sdc1/partition": "1",
+		},
+		links: map[string]string{
+			"class/block/sdc":                     sesDisk + "/block/sdc",
+			"class/block/sdc1":                    sesDisk + "/block/sdc/sdc1",
+			sesDisk + "/block/sdc/device":         sesDisk,
+			sesDisk + "/enclosure_device:Slot 02": sesEnclosure + "/Slot 02",
+		},
+		want: &DriveLocation{Enclosure: "0x500605b00abc1234", Slot: "2"},
+	}, {
+		name: "element name and SCSI address",
+		files: map[string]string{
+			sesDisk + "/block/sdc/dev": "8:32",
+		},
+		links: map[string]string{
+			"class/block/sdc":                   sesDisk + "/block/sdc",
+			sesDisk + "/block/sdc/device":       sesDisk,
+			sesDisk + "/enclosure_device:DISK7": sesEnclosure + "/DISK7",
+		},
+		want: &DriveLocation{Enclosure: "0:0:12:0", Slot: "DISK7"},
+	}, {
+		name: "not in an enclosure",
+		files: map[string]string{
+			sesDisk + "/block/sdc/dev": "8:32",
+		},
+		links: map[string]string{
+			"class/block/sdc":             sesDisk + "/block/sdc",
+			sesDisk + "/block/sdc/device": sesDisk,
+		},
+	}, {
+		name: "no sysfs",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, tt.files, tt.links)
+			for _, devName := range []string{"/dev/sdc", "/dev/sdc1"} {
+				got := readDriveLocation(devName)
+				if tt.want == nil && got != nil {
+					t.Errorf("%s located in %s", devName, got)
+				}
+				if tt.want != nil && (got == nil || *got != *tt.want) {
+					t.Errorf("%s located in %v, want %s", devName, got, tt.want)
+				}
+				// Partitions only resolve with their disk's sysfs entry
+				if tt.files[sesDisk+"/block/sdc/sdc1/partition"] == "" {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSetLocateLed(t *testing.T) {
+	root := fakeSysfs(t, map[string]string{
+		sesEnclosure + "/Slot 02/locate": "0",
+		sesDisk + "/block/sdc/dev":       "8:32",
+		sesDisk + "/block/sdd/dev":       "8:48",
+	}, map[string]string{
+		"class/block/sdc":                     sesDisk + "/block/sdc",
+		"class/block/sdd":                     sesDisk + "/block/sdd",
+		sesDisk + "/block/sdc/device":         sesDisk,
+		sesDisk + "/enclosure_device:Slot 02": sesEnclosure + "/Slot 02",
+	})
+	locate := filepath.Join(root, sesEnclosure, "Slot 02", "locate")
+	for _, on := range []bool{true, false} {
+		if err := setLocateLed("/dev/sdc", on); err != nil {
+			t.Fatal(err)
+		}
+		want := map[bool]string{true: "1", false: "0"}[on]
+		if got, _ := os.ReadFile(locate); string(got) != want {
+			t.Errorf("locate is %q after turning it %v, want %q", got, on, want)
+		}
+	}
+	if err := setLocateLed("/dev/sdd", true); err == nil {
+		t.Error("turned on the LED of a disk outside enclosures")
+	}
+}
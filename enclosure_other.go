@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func readDriveLocation(devName string) *DriveLocation {
+	return nil
+}
+
+func setLocateLed(devName string, on bool) error {
+	return errors.New("locate LEDs are only supported on Linux")
+}
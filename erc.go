@@ -0,0 +1,48 @@
+package main
+
+import "fmt"
+
+// SctErc holds a drive's SCT Error Recovery Control (TLER/CCTL) timeouts. Drives in RAID or ZFS
+// arrays should give up on unreadable sectors quickly, otherwise the array drops them.
+type SctErc struct {
+	ReadEnabled      bool `json:"read_enabled"`
+	ReadDeciseconds  int  `json:"read_deciseconds"`
+	WriteEnabled     bool `json:"write_enabled"`
+	WriteDeciseconds int  `json:"write_deciseconds"`
+}
+
+func (e SctErc) String() string {
+	return fmt.Sprintf("read %s, write %s", ercTimer(e.ReadEnabled, e.ReadDeciseconds), ercTimer(e.WriteEnabled, e.WriteDeciseconds))
+}
+
+func ercTimer(enabled bool, deciseconds int) string {
+	if !enabled {
+		return "disabled"
+	}
+	return fmt.Sprintf("%.1fs", float64(deciseconds)/10)
+}
+
+// collectSctErc reads the SCT ERC settings of devName through smartctl and compares them to the
+// configured expectation.
+func collectSctErc(devName string, line PartitionLine, conf Config) PartitionLine {
+	out, err := runSmartctl(conf.SmartctlPath, devName, "-l", "scterc")
+	if err != nil {
+		fmt.Printf("Could not read SCT ERC for %s with smartctl: %s\n", devName, err)
+		return line
+	}
+	if out.AtaSctErc == nil {
+		line.warn("SCT Error Recovery Control is not supported")
+		return line
+	}
+
+	line.SctErc = &SctErc{
+		ReadEnabled:      out.AtaSctErc.Read.Enabled,
+		ReadDeciseconds:  out.AtaSctErc.Read.Deciseconds,
+		WriteEnabled:     out.AtaSctErc.Write.Enabled,
+		WriteDeciseconds: out.AtaSctErc.Write.Deciseconds,
+	}
+	if conf.ExpectedSctErc != nil && *line.SctErc != *conf.ExpectedSctErc {
+		line.warn("SCT ERC is %s, expected %s", line.SctErc, conf.ExpectedSctErc)
+	}
+	return line
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxErrorLogEntries is how many of the most recent error log entries records keep.
+const maxErrorLogEntries = 5
+
+// ataErrorUnc is the UNC bit of the ATA error register, set for uncorrectable data errors.
+const ataErrorUnc = 0x40
+
+// AtaErrorLog holds the most recent entries of a drive's SMART error log, read from the Extended
+// Comprehensive error log (GPL log 0x03) where supported and from the summary log otherwise.
+type AtaErrorLog struct {
+	// Extended is false if the entries come from the summary log
+	Extended bool `json:"extended"`
+	// Count of errors over the drive's lifetime, the log only keeps the most recent ones
+	Count   int             `json:"count"`
+	Entries []AtaErrorEntry `json:"entries,omitempty"`
+}
+
+// AtaErrorEntry is one logged error, most recent first.
+type AtaErrorEntry struct {
+	Number int `json:"number"`
+	// PowerOnHours when the error occurred, to correlate it with filesystem or kernel logs
+	PowerOnHours int `json:"power_on_hours"`
+	// Description as decoded by smartctl, e.g. "Error: UNC at LBA = 0x0001e240 = 123456"
+	Description string `json:"description"`
+	Lba         uint64 `json:"lba"`
+	// Command that failed, e.g. READ FPDMA QUEUED
+	Command string `json:"command,omitempty"`
+	Status  uint8  `json:"status"`
+	Error   uint8  `json:"error"`
+}
+
+func (e AtaErrorEntry) String() string {
+	s := fmt.Sprintf("#%d at %dh: %s", e.Number, e.PowerOnHours, e.Description)
+	if e.Command != "" {
+		s += fmt.Sprintf(" (%s)", e.Command)
+	}
+	return s
+}
+
+type smartctlErrorLog struct {
+	Count int `json:"count"`
+	Table []struct {
+		ErrorNumber         int    `json:"error_number"`
+		LifetimeHours       int    `json:"lifetime_hours"`
+		ErrorDescription    string `json:"error_description"`
+		CompletionRegisters struct {
+			Error  uint8  `json:"error"`
+			Status uint8  `json:"status"`
+			Lba    uint64 `json:"lba"`
+		} `json:"completion_registers"`
+		// PreviousCommands lead up to the error, the failed command first
+		PreviousCommands []struct {
+			CommandName string `json:"command_name"`
+		} `json:"previous_commands"`
+	} `json:"table"`
+}
+
+// collectAtaErrorLog reads the SMART error log of devName through smartctl, preferring the
+// Extended Comprehensive log, and the self-test log for the LBAs of failed self-tests.
+func collectAtaErrorLog(devName string, line PartitionLine, conf Config) PartitionLine {
+	out, err := runSmartctl(conf.SmartctlPath, devName, "-l", fmt.Sprintf("xerror,%d,error", maxErrorLogEntries), "-l", "selftest")
+	if err != nil {
+		fmt.Printf("Could not read the error log of %s with smartctl: %s\n", devName, err)
+		return line
+	}
+	if testLog := out.AtaSmartSelfTestLog; testLog != nil {
+		for _, entry := range testLog.Standard.Table {
+			if entry.Lba != nil {
+				line.FailedLbas = append(line.FailedLbas, *entry.Lba)
+			}
+		}
+		line.FailedLbas = uniqueLbas(line.FailedLbas)
+	}
+	if out.AtaSmartErrorLog == nil || (out.AtaSmartErrorLog.Extended == nil && out.AtaSmartErrorLog.Summary == nil) {
+		fmt.Printf("%s does not support the SMART error log\n", devName)
+		return line
+	}
+
+	smartctlLog, extended := out.AtaSmartErrorLog.Extended, true
+	if smartctlLog == nil {
+		smartctlLog, extended = out.AtaSmartErrorLog.Summary, false
+	}
+	errorLog := &AtaErrorLog{Extended: extended, Count: smartctlLog.Count}
+	for _, entry := range smartctlLog.Table[:min(len(smartctlLog.Table), maxErrorLogEntries)] {
+		e := AtaErrorEntry{
+			Number:       entry.ErrorNumber,
+			PowerOnHours: entry.LifetimeHours,
+			Description:  entry.ErrorDescription,
+			Lba:          entry.CompletionRegisters.Lba,
+			Status:       entry.CompletionRegisters.Status,
+			Error:        entry.CompletionRegisters.Error,
+		}
+		if len(entry.PreviousCommands) > 0 {
+			e.Command = entry.PreviousCommands[0].CommandName
+		}
+		errorLog.Entries = append(errorLog.Entries, e)
+		if e.Error&ataErrorUnc != 0 {
+			line.FailedLbas = append(line.FailedLbas, e.Lba)
+		}
+	}
+	line.AtaErrorLog = errorLog
+	line.FailedLbas = uniqueLbas(line.FailedLbas)
+	return line
+}
+
+// uniqueLbas drops repeated LBAs, keeping the first occurrence.
+func uniqueLbas(lbas []uint64) []uint64 {
+	var unique []uint64
+	for _, lba := range lbas {
+		if !slices.Contains(unique, lba) {
+			unique = append(unique, lba)
+		}
+	}
+	return unique
+}
+
+func formatLbas(lbas []uint64) string {
+	s := make([]string, len(lbas))
+	for i, lba := range lbas {
+		s[i] = strconv.FormatUint(lba, 10)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// externalEvent is something that happened outside gosmart and may show in the SMART data, such
+// as a ZFS scrub or a SnapRAID sync finishing.
+type externalEvent struct {
+	Name   string `json:"name"`
+	Device string `json:"device,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// eventName keeps event names short and usable as labels and tags.
+var eventName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,63}$`)
+
+// handleEvent receives an external event as a JSON body or the name, device and detail query
+// parameters, e.g. from a ZFS zedlet or a SnapRAID post-sync hook:
+//
+//	curl -X POST 'http://localhost:9633/events?name=zfs-scrub-finish&detail=tank'
+//
+// It collects the device, or all configured devices, right away and returns the records, whose
+// run names the event. Events are rejected while collection is paused.
+func (d *daemon) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	event := externalEvent{Name: r.URL.Query().Get("name"), Device: r.URL.Query().Get("device"), Detail: r.URL.Query().Get("detail")}
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&event); err != nil {
+			http.Error(w, fmt.Sprintf("invalid event: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if !eventName.MatchString(event.Name) {
+		http.Error(w, "name must be up to 64 letters, digits and _.:-", http.StatusBadRequest)
+		return
+	}
+
+	partitions := d.conf.Partitions
+	if event.Device != "" {
+		partition, ok := d.configuredDevice(event.Device)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s is not a configured device", event.Device), http.StatusNotFound)
+			return
+		}
+		partitions = []PartitionConfig{partition}
+	}
+	if d.rejectPaused(w) {
+		return
+	}
+	log.Printf("Collecting for event %s %s\n", event.Name, event.Detail)
+	records := d.collectForEvent(partitions, &event)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(records)
+}
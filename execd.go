@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// runExecd runs as a Telegraf execd input: every newline on stdin (signal = "STDIN") or
+// collectSignals (signal = "SIGUSR1") triggers a collection whose samples are written to stdout as
+// line protocol. It returns when stdin is closed, which is how Telegraf stops the plugin.
+func runExecd(conf Config) {
+	conf = applyDefaults(conf)
+
+	// stdout belongs to Telegraf, collection errors go to stderr instead
+	out := bufio.NewWriter(os.Stdout)
+	os.Stdout = os.Stderr
+
+	triggers := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			triggers <- struct{}{}
+		}
+		close(triggers)
+	}()
+	signals := make(chan os.Signal, 1)
+	if len(collectSignals) > 0 {
+		signal.Notify(signals, collectSignals...)
+	}
+
+	for {
+		select {
+		case _, ok := <-triggers:
+			if !ok {
+				return
+			}
+		case <-signals:
+		}
+
+		for _, record := range collectAll(conf) {
+			for _, line := range recordLineProtocol(record, conf.DropMetricLabels) {
+				fmt.Fprintln(out, line)
+			}
+		}
+		if err := out.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"os"
+	"time"
+)
+
+// watermark is the last stored reading delivered to an output. Readings of one run share their
+// timestamp, so the partition name breaks ties.
+type watermark struct {
+	Ts            time.Time `json:"ts"`
+	PartitionName string    `json:"partition_name"`
+}
+
+// loadWatermarks reads the per output watermarks from path, a missing file means nothing was
+// exported yet.
+func loadWatermarks(path string) (map[string]watermark, error) {
+	marks := make(map[string]watermark)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return marks, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return marks, json.Unmarshal(b, &marks)
+}
+
+// saveWatermarks replaces the state file atomically, so a crash never leaves a partial file.
+func saveWatermarks(path string, marks map[string]watermark) error {
+	b, err := json.MarshalIndent(marks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// exportRow is a stored reading with everything the database keeps of its record.
+type exportRow struct {
+	fleetRow
+	SizeBytes uint64 `db:"size_bytes"`
+}
+
+func (r exportRow) partitionLine() (PartitionLine, error) {
+	line := PartitionLine{
+		Uuid:           r.Uuid,
+		Ts:             r.Ts,
+		PartitionName:  r.PartitionName,
+		Label:          r.Label,
+		MountPath:      r.MountPath,
+		SizeBytes:      r.SizeBytes,
+		SmartSupported: true,
+	}
+	err := json.Unmarshal([]byte(r.Attributes), &line.Attributes)
+	return line, err
+}
+
+// readExportBatch returns up to limit stored readings after mark, oldest first.
+func readExportBatch(db *sqlx.DB, conf DBConfig, mark watermark, limit int) ([]exportRow, error) {
+	var rows []exportRow
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	err := db.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT uuid, ts, partition_name, label, mount_path, size_bytes, attributes::text AS attributes FROM %s.%s WHERE (ts, partition_name) > ($1, $2) ORDER BY ts, partition_name LIMIT $3;`, conf.Schema, conf.Table), mark.Ts, mark.PartitionName, limit)
+	return rows, err
+}
+
+// runExport implements `gosmart export`, which replays the readings stored in the database to
+// another output within its sink limits. The last delivered reading of each output is kept in the
+// state file, so an interrupted export resumes after it instead of sending readings twice.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	output := fs.String("output", "", "Output to export to, configured in the config file as for collection")
+	statePath := fs.String("state", "gosmart-watermarks.json", "File the per output high-watermarks are kept in")
+	batch := fs.Int("batch", 1000, "Readings read from the database at a time")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	if conf.Db == nil {
+		fmt.Fprintln(os.Stderr, "export needs a database to read history from")
+		os.Exit(1)
+	}
+	if *output == "" || *output == OutputPostgres {
+		fmt.Fprintln(os.Stderr, "export needs an --output other than postgres")
+		os.Exit(1)
+	}
+
+	marks, err := loadWatermarks(*statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read state file %s: %s\n", *statePath, err)
+		os.Exit(1)
+	}
+	db, err := connectPostgres(*conf.Db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to database: %s\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	exported := 0
+	resolver := newAttributeResolver(db, *conf.Db)
+	w := newRecordWriter(*output, conf)
+	w.onFlush = func(batch []PartitionLine) error {
+		last := batch[len(batch)-1]
+		marks[*output] = watermark{Ts: last.Ts, PartitionName: last.PartitionName}
+		exported += len(batch)
+		return saveWatermarks(*statePath, marks)
+	}
+	for mark := marks[*output]; ; {
+		rows, err := readExportBatch(db, *conf.Db, mark, *batch)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read history: %s\n", err)
+			os.Exit(1)
+		}
+		for _, row := range rows {
+			row.Attributes, err = resolver.resolve(row.Uuid, row.PartitionName, row.Ts, row.Attributes)
+			var line PartitionLine
+			if err == nil {
+				line, err = row.partitionLine()
+			}
+			if err == nil {
+				err = w.Write(line)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Stopping export after %d readings: %s\n", exported, err)
+				os.Exit(1)
+			}
+			mark = watermark{Ts: row.Ts, PartitionName: row.PartitionName}
+		}
+		if len(rows) < *batch {
+			break
+		}
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Stopping export after %d readings: %s\n", exported, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d readings to %s\n", exported, *output)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+const (
+	FileFormatJson    = "json"
+	FileFormatMsgpack = "msgpack"
+)
+
+type FileConfig struct {
+	// Path records are appended to
+	Path string `json:"path"`
+	// Format is json for JSON lines, the default, or msgpack for a stream of MessagePack maps with
+	// the same keys
+	Format string `json:"format,omitempty"`
+	// Compression is empty, gzip or zstd. Each record appends a new gzip member or zstd frame,
+	// which zcat and zstdcat read as one continuous stream.
+	Compression string `json:"compression,omitempty"`
+	// RotateBytes moves the file aside before a record is appended once it has this many bytes,
+	// off if 0. RotateHours moves it aside when a record comes in a later period of this many hours
+	// than the last one, e.g. 24 for a file per UTC day. Rotated files are named after the time of
+	// their last record, readings.jsonl.gz becomes readings.20240501T235912Z.jsonl.gz, and each is a
+	// complete stream of its own.
+	RotateBytes int64 `json:"rotate_bytes,omitempty"`
+	RotateHours int   `json:"rotate_hours,omitempty"`
+	// KeepRotated deletes the oldest rotated files beyond this many, all are kept if 0. It does not
+	// apply with S3, where the rotated files left are those still to upload.
+	KeepRotated int `json:"keep_rotated,omitempty"`
+	// S3 uploads the rotated files and deletes them once stored, see S3Config
+	S3 *S3Config `json:"s3,omitempty"`
+}
+
+// fileMu serializes rotating and appending within the process.
+var fileMu sync.Mutex
+
+// saveToFile appends a record to the configured file.
+func saveToFile(record PartitionLine, conf FileConfig, tsFormat string) error {
+	v, err := renderRecord(record, tsFormat)
+	var data []byte
+	if err == nil {
+		switch conf.Format {
+		case "", FileFormatJson:
+			data, err = json.Marshal(v)
+			data = append(data, '\n')
+		case FileFormatMsgpack:
+			data, err = marshalMsgpack(v)
+		default:
+			err = fmt.Errorf("unknown file format %q", conf.Format)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("file output error for %s: %w", record.PartitionName, err)
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	// The record is still appended to the full file if it cannot be moved aside
+	if err := rotateFile(conf, time.Now()); err != nil {
+		log.Printf("Could not rotate %s: %v\n", conf.Path, err)
+	}
+
+	f, err := os.OpenFile(conf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("file output error: %w", err)
+	}
+	defer f.Close()
+
+	w, err := compressWriter(f, conf.Compression)
+	if err != nil {
+		return fmt.Errorf("file output error: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("file write error for %s: %w", record.PartitionName, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("file write error for %s: %w", record.PartitionName, err)
+	}
+	return nil
+}
+
+// rotateFile moves the file of conf aside if it is due for rotation at now, then uploads the
+// rotated files to S3 or deletes those beyond KeepRotated.
+func rotateFile(conf FileConfig, now time.Time) error {
+	if conf.RotateBytes <= 0 && conf.RotateHours <= 0 {
+		return nil
+	}
+	info, err := os.Stat(conf.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	full := conf.RotateBytes > 0 && info.Size() >= conf.RotateBytes
+	period := int64(conf.RotateHours) * 3600
+	expired := period > 0 && info.ModTime().Unix()/period != now.Unix()/period
+	if info.Size() == 0 || (!full && !expired) {
+		return nil
+	}
+
+	rotated := rotatedFilePath(conf.Path, info.ModTime().UTC().Format("20060102T150405Z"))
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(rotated); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		rotated = rotatedFilePath(conf.Path, fmt.Sprintf("%s-%d", info.ModTime().UTC().Format("20060102T150405Z"), i))
+	}
+	if err := os.Rename(conf.Path, rotated); err != nil {
+		return err
+	}
+	if conf.S3 != nil {
+		return uploadRotatedFiles(conf.Path, *conf.S3)
+	}
+	if conf.KeepRotated <= 0 {
+		return nil
+	}
+	files, err := rotatedFiles(conf.Path)
+	if err != nil {
+		return err
+	}
+	for len(files) > conf.KeepRotated {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// rotatedFilePath inserts stamp before the extensions of the file name of path.
+func rotatedFilePath(path, stamp string) string {
+	dir, name := filepath.Split(path)
+	if stem, ext, ok := strings.Cut(name, "."); ok {
+		return filepath.Join(dir, stem+"."+stamp+"."+ext)
+	}
+	return filepath.Join(dir, name+"."+stamp)
+}
+
+// rotatedFiles lists the rotated files of path, oldest first. They are ordered by modification
+// time, the names of files rotated within a second do not sort by age.
+func rotatedFiles(path string) ([]string, error) {
+	files, err := filepath.Glob(rotatedFilePath(path, "[0-9]*T*Z*"))
+	if err != nil {
+		return nil, err
+	}
+	modified := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modified[file] = info.ModTime()
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return modified[files[i]].Before(modified[files[j]]) })
+	return files, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressWriter compresses what is written to w with compression, empty for none. Close ends
+// the gzip member or zstd frame without closing w.
+func compressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "":
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unknown compression %q", compression)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Appending a record per write must leave a file that reads back as one stream.
+func TestSaveToFileCompressionAppends(t *testing.T) {
+	for _, compression := range []string{"", CompressionGzip, CompressionZstd} {
+		t.Run("compression="+compression, func(t *testing.T) {
+			conf := FileConfig{Path: filepath.Join(t.TempDir(), "records.jsonl"), Compression: compression}
+			ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			for _, name := range []string{"/dev/sda", "/dev/sdb", "/dev/sdc"} {
+				if err := saveToFile(PartitionLine{PartitionName: name, Ts: ts}, conf, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			names := readFileRecords(t, conf.Path, compression)
+			if len(names) != 3 || names[0] != "/dev/sda" || names[2] != "/dev/sdc" {
+				t.Errorf("read back %v", names)
+			}
+		})
+	}
+}
+
+// readFileRecords returns the partition names of the records in the file at path.
+func readFileRecords(t *testing.T, path, compression string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	case CompressionZstd:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var record struct {
+			PartitionName string `json:"partition_name"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %q: %s", scanner.Text(), err)
+		}
+		names = append(names, record.PartitionName)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// Rotated files are complete streams of their own, and only the newest ones are kept.
+func TestSaveToFileRotateBytes(t *testing.T) {
+	conf := FileConfig{Path: filepath.Join(t.TempDir(), "records.jsonl.gz"), Compression: CompressionGzip, RotateBytes: 1, KeepRotated: 2}
+	for _, name := range []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"} {
+		if err := saveToFile(PartitionLine{PartitionName: name}, conf, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, err := rotatedFiles(conf.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("kept rotated files %v, want 2", rotated)
+	}
+	var names []string
+	for _, path := range append(rotated, conf.Path) {
+		if !strings.HasSuffix(path, ".jsonl.gz") {
+			t.Errorf("rotated to %s", path)
+		}
+		names = append(names, readFileRecords(t, path, CompressionGzip)...)
+	}
+	if !slices.Equal(names, []string{"/dev/sdb", "/dev/sdc", "/dev/sdd"}) {
+		t.Errorf("read back %v, want the last three records", names)
+	}
+}
+
+func TestSaveToFileRotateHours(t *testing.T) {
+	conf := FileConfig{Path: filepath.Join(t.TempDir(), "records.jsonl"), RotateHours: 24}
+	if err := saveToFile(PartitionLine{PartitionName: "/dev/sda"}, conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Another record the same day is appended
+	if err := saveToFile(PartitionLine{PartitionName: "/dev/sdb"}, conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rotated, _ := rotatedFiles(conf.Path); len(rotated) != 0 {
+		t.Fatalf("rotated within the day: %v", rotated)
+	}
+
+	yesterday := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(conf.Path, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveToFile(PartitionLine{PartitionName: "/dev/sdc"}, conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := rotatedFilePath(conf.Path, yesterday.UTC().Format("20060102T150405Z"))
+	if rotated, _ := rotatedFiles(conf.Path); len(rotated) != 1 || rotated[0] != want {
+		t.Fatalf("rotated to %v, want %s", rotated, want)
+	}
+	if names := readFileRecords(t, want, ""); len(names) != 2 {
+		t.Errorf("rotated file holds %v", names)
+	}
+	if names := readFileRecords(t, conf.Path, ""); len(names) != 1 || names[0] != "/dev/sdc" {
+		t.Errorf("new file holds %v", names)
+	}
+}
+
+func TestSaveToFileUnknownCompression(t *testing.T) {
+	conf := FileConfig{Path: filepath.Join(t.TempDir(), "records.jsonl"), Compression: "lz4"}
+	if err := saveToFile(PartitionLine{PartitionName: "/dev/sda"}, conf, ""); err == nil {
+		t.Error("unknown compression accepted")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// fixture is a recorded device, the fake device served for it on replay and the record it belongs
+// to. Fixtures are written one per file, so single devices can be attached to bug reports.
+type fixture struct {
+	Path string `json:"path"`
+	FakeDevice
+}
+
+// fixtureFileName turns a partition name into a file name, e.g. dev_sda1.json.
+func fixtureFileName(partitionName string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, partitionName)
+	return strings.Trim(name, "_") + ".json"
+}
+
+// recordFixture writes what was read from the device of line, before any further collection.
+// Attributes are kept as the drive reported them, so replays run the same selection and decoding.
+func recordFixture(dir string, line PartitionLine, ok bool) error {
+	device := FakeDevice{
+		Label:      line.Label,
+		MountPath:  line.MountPath,
+		Uuid:       line.Uuid,
+		SizeBytes:  line.SizeBytes,
+		Model:      line.Model,
+		Serial:     line.Serial,
+		Firmware:   line.Firmware,
+		MediaType:  line.MediaType,
+		NvmeHealth: line.NvmeHealth,
+	}
+	if line.Error != nil {
+		device.Error, device.ErrorClass = line.Error.Message, line.Error.Class
+	} else if ok && !line.SmartSupported {
+		device.SkipReason = line.SkipReason
+	}
+	for _, attr := range line.rawAttributes {
+		device.Attributes = append(device.Attributes, FakeAttribute{Id: attr.Id, Name: attr.Name, Current: attr.Current, Worst: attr.Worst, Raw: attr.ValueRaw})
+	}
+	slices.SortFunc(device.Attributes, func(a, b FakeAttribute) int { return int(a.Id) - int(b.Id) })
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(fixture{Path: line.PartitionName, FakeDevice: device}, "", defaultJsonIndent)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fixtureFileName(line.PartitionName)), append(data, '\n'), 0o644)
+}
+
+// applyReplay replaces the devices of conf with the fixtures in dir, served by the fake backend.
+// Outputs and collection settings are kept, so the rest of the pipeline runs as configured.
+func applyReplay(conf Config, dir string) (Config, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return conf, err
+	}
+	if len(files) == 0 {
+		return conf, fmt.Errorf("no fixtures in %s", dir)
+	}
+
+	conf.Discovery = DiscoveryDirect
+	conf.Partitions = nil
+	conf.FakeDevices = make(map[string]FakeDevice)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return conf, err
+		}
+		var f fixture
+		if err := json.Unmarshal(data, &f); err != nil {
+			return conf, fmt.Errorf("%s: %w", file, err)
+		}
+		if f.Path == "" {
+			return conf, fmt.Errorf("%s: no device path", file)
+		}
+		conf.Partitions = append(conf.Partitions, PartitionConfig{Path: f.Path, Backend: BackendFake})
+		conf.FakeDevices[f.Path] = f.FakeDevice
+	}
+	return conf, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// fleetRow is the latest stored reading of one partition in the shared table.
+type fleetRow struct {
+	historyRow
+	Label     string `db:"label"`
+	MountPath string `db:"mount_path"`
+}
+
+// fleetEntry is a ranked partition of the fleet overview.
+type fleetEntry struct {
+	fleetRow
+	Risk     uint64
+	Failures []string
+}
+
+// riskScore sums the failure predicting attributes of a reading. Each of them counts sectors or
+// errors, so any non-zero value is a sign of trouble and larger is worse.
+func riskScore(attrs []historyAttr) (uint64, []string) {
+	var score uint64
+	var failures []string
+	for _, attr := range attrs {
+		if !slices.Contains(failureAttributes, attr.Id) || attr.value() == 0 {
+			continue
+		}
+		score += attr.value()
+		failures = append(failures, fmt.Sprintf("%d %s=%d", attr.Id, attr.Name, attr.value()))
+	}
+	return score, failures
+}
+
+// readFleet returns the latest reading of every partition in the table, identified by UUID so
+// that equally named partitions on different hosts are kept apart.
+func readFleet(db *sqlx.DB, conf DBConfig, since time.Time) ([]fleetRow, error) {
+	var rows []fleetRow
+	query := fmt.Sprintf(`SELECT DISTINCT ON (uuid, partition_name) uuid, partition_name, label, mount_path, ts, attributes::text AS attributes FROM %s.%s WHERE ts >= $1 ORDER BY uuid, partition_name, ts DESC;`, conf.Schema, conf.Table)
+	if conf.LatestTable {
+		query = fmt.Sprintf(`SELECT uuid, partition_name, label, mount_path, ts, attributes::text AS attributes FROM %s.%s_latest WHERE ts >= $1;`, conf.Schema, conf.Table)
+	}
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	err := db.SelectContext(ctx, &rows, query, since)
+	if err != nil {
+		return nil, err
+	}
+	resolver := newAttributeResolver(db, conf)
+	for i, row := range rows {
+		if rows[i].Attributes, err = resolver.resolve(row.Uuid, row.PartitionName, row.Ts, row.Attributes); err != nil {
+			return nil, fmt.Errorf("%s: %w", row.PartitionName, err)
+		}
+	}
+	return rows, nil
+}
+
+// runFleet implements `gosmart fleet`, which ranks the least healthy drives across every host
+// writing to the shared database.
+func runFleet(args []string) {
+	fs := flag.NewFlagSet("fleet", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	maxAge := fs.Duration("max-age", 7*24*time.Hour, "Ignore partitions not reported within this long")
+	top := fs.Int("top", 20, "Number of drives to list, 0 for all")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	if conf.Db == nil {
+		fmt.Fprintln(os.Stderr, "fleet needs a database to read from")
+		os.Exit(1)
+	}
+
+	db, err := connectPostgres(*conf.Db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to database: %s\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	rows, err := readFleet(db, *conf.Db, time.Now().Add(-*maxAge))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read fleet: %s\n", err)
+		os.Exit(1)
+	}
+
+	entries := make([]fleetEntry, 0, len(rows))
+	for _, row := range rows {
+		attrs, err := row.attributes()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", row.PartitionName, err)
+			continue
+		}
+		risk, failures := riskScore(attrs)
+		entries = append(entries, fleetEntry{fleetRow: row, Risk: risk, Failures: failures})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Risk > entries[j].Risk })
+	if *top > 0 && len(entries) > *top {
+		entries = entries[:*top]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RISK\tPARTITION\tLABEL\tMOUNT\tUUID\tLAST SEEN\tFAILURE ATTRIBUTES")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Risk, e.PartitionName, e.Label, e.MountPath, e.Uuid,
+			e.Ts.Format(time.DateTime), strings.Join(e.Failures, ", "))
+	}
+	_ = w.Flush()
+}
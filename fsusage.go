@@ -0,0 +1,45 @@
+package main
+
+import "fmt"
+
+// FilesystemUsage is the capacity of the filesystem mounted from a partition.
+type FilesystemUsage struct {
+	SizeBytes uint64 `json:"size_bytes"`
+	UsedBytes uint64 `json:"used_bytes"`
+	// FreeBytes is the space available to unprivileged users, excluding reserved blocks
+	FreeBytes uint64 `json:"free_bytes"`
+	// Inodes and InodesFree are zero on filesystems without a fixed inode count
+	Inodes     uint64 `json:"inodes,omitempty"`
+	InodesFree uint64 `json:"inodes_free,omitempty"`
+}
+
+func (u FilesystemUsage) String() string {
+	return u.format(numberFormat{})
+}
+
+func (u FilesystemUsage) format(f numberFormat) string {
+	s := fmt.Sprintf("%s used of %s, %s free", f.bytes(u.UsedBytes), f.bytes(u.SizeBytes), f.bytes(u.FreeBytes))
+	if u.Inodes > 0 {
+		s += fmt.Sprintf(", %s of %s inodes used", f.uint(u.Inodes-u.InodesFree), f.uint(u.Inodes))
+	}
+	return s
+}
+
+// collectFilesystemUsage adds the usage of the filesystem the partition is mounted on.
+func collectFilesystemUsage(line PartitionLine) PartitionLine {
+	if line.MountPath == "" {
+		return line
+	}
+	usage, err := filesystemUsage(line.MountPath)
+	if err != nil {
+		fmt.Printf("Could not read the filesystem usage of %s: %s\n", line.MountPath, err)
+		return line
+	}
+	line.Filesystem = &usage
+	return line
+}
+
+// formatBytes formats n in binary units, e.g. 1.5 GiB.
+func formatBytes(n uint64) string {
+	return numberFormat{}.bytes(n)
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+func filesystemUsage(path string) (FilesystemUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return FilesystemUsage{}, err
+	}
+	blockSize := uint64(st.Bsize)
+	return FilesystemUsage{
+		SizeBytes:  uint64(st.Blocks) * blockSize,
+		UsedBytes:  (uint64(st.Blocks) - uint64(st.Bfree)) * blockSize,
+		FreeBytes:  uint64(st.Bavail) * blockSize,
+		Inodes:     uint64(st.Files),
+		InodesFree: uint64(st.Ffree),
+	}, nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// filesystemUsage reads the capacity of a volume, Windows has no inode counts.
+func filesystemUsage(path string) (FilesystemUsage, error) {
+	root, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return FilesystemUsage{}, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(root, &free, &total, &totalFree); err != nil {
+		return FilesystemUsage{}, err
+	}
+	return FilesystemUsage{SizeBytes: total, UsedBytes: total - totalFree, FreeBytes: free}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"cloud.google.com/go/compute/metadata"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"io"
+	"time"
+)
+
+type GcmConfig struct {
+	// ProjectId defaults to the project of the Application Default Credentials or the GCE metadata
+	// server
+	ProjectId string `json:"project_id,omitempty"`
+	// MetricPrefix defaults to custom.googleapis.com/gosmart
+	MetricPrefix string `json:"metric_prefix,omitempty"`
+}
+
+const gcmScope = "https://www.googleapis.com/auth/monitoring.write"
+
+// gcmMaxSeries is the most time series accepted by one timeSeries.create call.
+const gcmMaxSeries = 200
+
+type gcmTimeSeries struct {
+	Metric struct {
+		Type   string            `json:"type"`
+		Labels map[string]string `json:"labels,omitempty"`
+	} `json:"metric"`
+	Resource struct {
+		Type   string            `json:"type"`
+		Labels map[string]string `json:"labels"`
+	} `json:"resource"`
+	Points []gcmPoint `json:"points"`
+}
+
+type gcmPoint struct {
+	Interval struct {
+		EndTime string `json:"endTime"`
+	} `json:"interval"`
+	Value struct {
+		DoubleValue float64 `json:"doubleValue"`
+	} `json:"value"`
+}
+
+// gcmResource describes the machine the samples come from: the GCE instance when running on
+// Compute Engine, the project wide global resource otherwise.
+func gcmResource(projectId string) (string, map[string]string) {
+	if metadata.OnGCE() {
+		instanceId, err1 := metadata.InstanceID()
+		zone, err2 := metadata.Zone()
+		if err1 == nil && err2 == nil {
+			return "gce_instance", map[string]string{"project_id": projectId, "instance_id": instanceId, "zone": zone}
+		}
+	}
+	return "global", map[string]string{"project_id": projectId}
+}
+
+// saveToGcm writes a record's samples as custom metrics with the Application Default Credentials.
+func saveToGcm(record PartitionLine, conf GcmConfig, dropLabels []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	creds, err := google.FindDefaultCredentials(ctx, gcmScope)
+	if err != nil {
+		return fmt.Errorf("google credentials error: %w", err)
+	}
+	projectId := conf.ProjectId
+	if projectId == "" {
+		projectId = creds.ProjectID
+	}
+	if projectId == "" {
+		return fmt.Errorf("no Google Cloud project configured or found in the credentials")
+	}
+	prefix := conf.MetricPrefix
+	if prefix == "" {
+		prefix = "custom.googleapis.com/gosmart"
+	}
+
+	resourceType, resourceLabels := gcmResource(projectId)
+	var series []gcmTimeSeries
+	for _, sample := range recordMetrics(record, dropLabels) {
+		var ts gcmTimeSeries
+		ts.Metric.Type = prefix + "/" + sample.Name
+		ts.Metric.Labels = sample.Labels
+		ts.Resource.Type = resourceType
+		ts.Resource.Labels = resourceLabels
+		var point gcmPoint
+		point.Interval.EndTime = record.Ts.UTC().Format(time.RFC3339Nano)
+		point.Value.DoubleValue = sample.Value
+		ts.Points = []gcmPoint{point}
+		series = append(series, ts)
+	}
+
+	client := oauth2.NewClient(ctx, creds.TokenSource)
+	url := fmt.Sprintf("https://monitoring.googleapis.com/v3/projects/%s/timeSeries", projectId)
+	for start := 0; start < len(series); start += gcmMaxSeries {
+		end := min(start+gcmMaxSeries, len(series))
+		body, err := json.Marshal(map[string]any{"timeSeries": series[start:end]})
+		if err != nil {
+			return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+		}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("google cloud monitoring write error for %s: %w", record.PartitionName, err)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("google cloud monitoring write error for %s: %s %s", record.PartitionName, resp.Status, msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GrafanaConfig receives an annotation whenever a device alert fires or resolves, or a device is
+// attached or detached, in serve mode.
+type GrafanaConfig struct {
+	// Url of Grafana, e.g. https://grafana.example.com
+	Url string `json:"url"`
+	// Token is a service account token with the annotations:write permission
+	Token string `json:"token"`
+	// DashboardUid and PanelId limit annotations to one dashboard or panel, organization wide
+	// annotations are created if empty
+	DashboardUid string `json:"dashboard_uid,omitempty"`
+	PanelId      int    `json:"panel_id,omitempty"`
+	// Tags are added to every annotation
+	Tags []string `json:"tags,omitempty"`
+}
+
+type grafanaAnnotation struct {
+	DashboardUid string   `json:"dashboardUID,omitempty"`
+	PanelId      int      `json:"panelId,omitempty"`
+	Time         int64    `json:"time"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// annotateGrafana creates an annotation for an alert firing or resolving, tagged with gosmart,
+// the device, the rule and whether it fired or resolved.
+func annotateGrafana(t alertTransition, conf GrafanaConfig) error {
+	state := "resolved"
+	if t.Firing {
+		state = "firing"
+	}
+	return postGrafanaAnnotation(t.Device, t.Ts, []string{"rule:" + t.Rule, state}, t.Text, conf)
+}
+
+// annotateDeviceEvent creates an annotation for a device attached or detached, tagged with
+// gosmart, the device and attached or detached.
+func annotateDeviceEvent(e deviceEvent, conf GrafanaConfig) error {
+	return postGrafanaAnnotation(e.Device, e.Ts, []string{e.kind()}, e.text(), conf)
+}
+
+// postGrafanaAnnotation creates an annotation tagged with gosmart, the device, tags and the
+// configured tags.
+func postGrafanaAnnotation(device string, ts time.Time, tags []string, text string, conf GrafanaConfig) error {
+	annotation := grafanaAnnotation{
+		DashboardUid: conf.DashboardUid,
+		PanelId:      conf.PanelId,
+		Time:         ts.UnixMilli(),
+		Tags:         append(append([]string{"gosmart", "device:" + device}, tags...), conf.Tags...),
+		Text:         text,
+	}
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return fmt.Errorf("json output error for %s: %w", device, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(conf.Url, "/")+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("grafana annotation error for %s: %w", device, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+conf.Token)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("grafana annotation error for %s: %w", device, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("grafana annotation error for %s: %s %s", device, resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// historyAttr is the part of a stored attribute needed for trends. Rows written before vendor
+// decoding was added have no ValueDecoded.
+type historyAttr struct {
+	Id           uint8
+	Name         string
+	Current      uint8
+	ValueRaw     uint64
+	ValueDecoded *uint64
+}
+
+func (a historyAttr) value() uint64 {
+	if a.ValueDecoded != nil {
+		return *a.ValueDecoded
+	}
+	return a.ValueRaw
+}
+
+// historyRow is one stored reading of a partition.
+type historyRow struct {
+	Uuid          string    `db:"uuid"`
+	PartitionName string    `db:"partition_name"`
+	Ts            time.Time `db:"ts"`
+	Attributes    string    `db:"attributes"`
+}
+
+func (r historyRow) attributes() ([]historyAttr, error) {
+	var attrs []historyAttr
+	err := json.Unmarshal([]byte(r.Attributes), &attrs)
+	return attrs, err
+}
+
+// historySource reads stored readings from the local store or Postgres.
+type historySource interface {
+	// attributeHistory returns the last limit stored values of each attribute of a partition,
+	// oldest first.
+	attributeHistory(partitionName string, limit int) (map[uint8][]float64, error)
+	// historySince returns all stored readings taken at or after since, oldest first.
+	historySince(since time.Time) ([]historyRow, error)
+	Close() error
+}
+
+// errNoHistory is returned by openHistory without a local store or database.
+var errNoHistory = errors.New("no local store or database configured")
+
+// openHistory opens the local store if configured, and the database otherwise.
+func openHistory(conf Config) (historySource, error) {
+	if conf.Local != nil {
+		db, err := openLocalStore(*conf.Local, true)
+		if err != nil {
+			return nil, err
+		}
+		return localHistory{db: db}, nil
+	}
+	if conf.Db != nil {
+		db, err := connectPostgres(*conf.Db)
+		if err != nil {
+			return nil, err
+		}
+		return postgresHistory{db: db, conf: *conf.Db}, nil
+	}
+	return nil, errNoHistory
+}
+
+// postgresHistory reads history from the table of the postgres output.
+type postgresHistory struct {
+	db   *sqlx.DB
+	conf DBConfig
+}
+
+func (h postgresHistory) attributeHistory(partitionName string, limit int) (map[uint8][]float64, error) {
+	var rows []historyRow
+	ctx, cancel := dbContext(h.conf)
+	defer cancel()
+	err := h.db.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT uuid, partition_name, ts, attributes::text AS attributes FROM %s.%s WHERE partition_name = $1 ORDER BY ts DESC LIMIT $2;`, h.conf.Schema, h.conf.Table), partitionName, limit)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(rows)
+	if err := resolveHistoryRows(h.db, h.conf, rows); err != nil {
+		return nil, err
+	}
+	return attributeHistory(rows)
+}
+
+func (h postgresHistory) historySince(since time.Time) ([]historyRow, error) {
+	var rows []historyRow
+	ctx, cancel := dbContext(h.conf)
+	defer cancel()
+	err := h.db.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT uuid, partition_name, ts, attributes::text AS attributes FROM %s.%s WHERE ts >= $1 ORDER BY ts;`, h.conf.Schema, h.conf.Table), since)
+	if err != nil {
+		return nil, err
+	}
+	return rows, resolveHistoryRows(h.db, h.conf, rows)
+}
+
+func (h postgresHistory) Close() error {
+	return h.db.Close()
+}
+
+// attributeHistory collects the values of each attribute of rows, in the order of rows.
+func attributeHistory(rows []historyRow) (map[uint8][]float64, error) {
+	history := make(map[uint8][]float64)
+	for _, row := range rows {
+		attrs, err := row.attributes()
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs {
+			history[attr.Id] = append(history[attr.Id], float64(attr.value()))
+		}
+	}
+	return history, nil
+}
+
+// loadHistories reads the last limit stored values of the attributes of each record, keyed by
+// partition name. It returns nil without a local store, database or limit.
+func loadHistories(conf Config, records []PartitionLine, limit int) map[string]map[uint8][]float64 {
+	if (conf.Local == nil && conf.Db == nil) || limit <= 0 || len(records) == 0 {
+		return nil
+	}
+	source, err := openHistory(conf)
+	if err != nil {
+		log.Printf("Could not load history: %s\n", err)
+		return nil
+	}
+	defer source.Close()
+
+	histories := make(map[string]map[uint8][]float64)
+	for _, record := range records {
+		history, err := source.attributeHistory(record.PartitionName, limit)
+		if err != nil {
+			log.Printf("Could not load history for %s: %s\n", record.PartitionName, err)
+			continue
+		}
+		histories[record.PartitionName] = history
+	}
+	return histories
+}
+
+// defaultSparklineReadings is how many stored readings terminal sparklines show by default.
+const defaultSparklineReadings = 20
+
+// sparklineReadings is the configured number of stored readings for terminal sparklines, 0 if
+// they are disabled.
+func sparklineReadings(conf Config) int {
+	if conf.SparklineReadings == 0 {
+		return defaultSparklineReadings
+	}
+	return max(conf.SparklineReadings, 0)
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// textSparkline draws values as a line of block characters scaled between their minimum and
+// maximum, empty for fewer than two values.
+func textSparkline(values []float64) string {
+	if len(values) < 2 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+// attributeTrend is the sparkline of an attribute's stored readings followed by its current value.
+func attributeTrend(history map[uint8][]float64, attr Attr) string {
+	values, ok := history[attr.Id]
+	if !ok {
+		return ""
+	}
+	return textSparkline(append(append([]float64{}, values...), float64(attr.ValueDecoded)))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// deviceEvent is a configured device attached or detached between two rescans.
+type deviceEvent struct {
+	Device   string
+	Attached bool
+	Ts       time.Time
+}
+
+func (e deviceEvent) kind() string {
+	if e.Attached {
+		return "attached"
+	}
+	return "detached"
+}
+
+func (e deviceEvent) text() string {
+	return fmt.Sprintf("%s %s", e.Device, e.kind())
+}
+
+// presentDevices returns the configured devices whose device node exists, keyed by deviceKey.
+func presentDevices(partitions []PartitionConfig) map[string]PartitionConfig {
+	present := make(map[string]PartitionConfig, len(partitions))
+	for _, p := range partitions {
+		if _, err := os.Stat(p.Path); err == nil {
+			present[deviceKey(p.Path)] = p
+		}
+	}
+	return present
+}
+
+// deviceEvents compares the devices present at two rescans.
+func deviceEvents(prev, cur map[string]PartitionConfig, ts time.Time) []deviceEvent {
+	var events []deviceEvent
+	for key, p := range cur {
+		if _, ok := prev[key]; !ok {
+			events = append(events, deviceEvent{Device: p.Path, Attached: true, Ts: ts})
+		}
+	}
+	for key, p := range prev {
+		if _, ok := cur[key]; !ok {
+			events = append(events, deviceEvent{Device: p.Path, Ts: ts})
+		}
+	}
+	slices.SortFunc(events, func(a, b deviceEvent) int { return strings.Compare(a.Device, b.Device) })
+	return events
+}
+
+// watchHotplug rescans the configured devices every interval. Attached devices are collected
+// right away instead of at the next scheduled collection, the records of detached devices are
+// dropped. Both are logged and annotated in Grafana if configured.
+func (d *daemon) watchHotplug(interval time.Duration) {
+	present := presentDevices(d.conf.Partitions)
+	for range time.Tick(interval) {
+		cur := presentDevices(d.conf.Partitions)
+		events := deviceEvents(present, cur, time.Now())
+		present = cur
+
+		var attached []PartitionConfig
+		for _, e := range events {
+			log.Println(e.text())
+			if e.Attached {
+				attached = append(attached, cur[deviceKey(e.Device)])
+			} else {
+				d.dropDevice(e.Device)
+			}
+			if d.conf.Grafana != nil {
+				if err := annotateDeviceEvent(e, *d.conf.Grafana); err != nil {
+					d.recordError(err)
+				}
+			}
+		}
+		if len(attached) > 0 {
+			d.collectDevices(attached)
+		}
+	}
+}
+
+// dropDevice removes a device's record and metrics from the daemon state.
+func (d *daemon) dropDevice(device string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastRecords = slices.DeleteFunc(slices.Clone(d.lastRecords), func(record PartitionLine) bool {
+		return deviceKey(record.PartitionName) == deviceKey(device)
+	})
+	for name := range d.collectSeconds {
+		if deviceKey(name) == deviceKey(device) {
+			delete(d.collectSeconds, name)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	HypervisorLibvirt = "libvirt"
+	HypervisorProxmox = "proxmox"
+)
+
+// HypervisorConfig maps the disks of a hypervisor host to the guests stored on them, so the
+// records and alerts of a failing disk name the guests at risk. Guests and their volumes are
+// listed through virsh or the Proxmox API, the volumes are followed through LVM, device mapper,
+// ZFS pools and filesystems down to the disks, on Linux.
+type HypervisorConfig struct {
+	// Source is libvirt or proxmox
+	Source string `json:"source"`
+	// VirshPath defaults to virsh, Uri is the libvirt connection URI, the virsh default if empty
+	VirshPath string `json:"virsh_path,omitempty"`
+	Uri       string `json:"uri,omitempty"`
+	// Url of the Proxmox API, e.g. https://localhost:8006, and an API token with VM.Audit and
+	// Datastore.Audit: TokenId is user@realm!name
+	Url     string `json:"url,omitempty"`
+	TokenId string `json:"token_id,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	// Node is the Proxmox node, defaults to the host name
+	Node string `json:"node,omitempty"`
+	// InsecureSkipVerify accepts the self-signed certificate Proxmox generates by default
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// Guest is a VM or container with a volume on a disk.
+type Guest struct {
+	Name string `json:"name"`
+	// Id is the Proxmox VM ID
+	Id string `json:"id,omitempty"`
+	// Volume is the path of the guest's disk image, zvol or logical volume
+	Volume string `json:"volume"`
+}
+
+func (g Guest) String() string {
+	if g.Id != "" {
+		return fmt.Sprintf("%s (%s)", g.Name, g.Id)
+	}
+	return g.Name
+}
+
+// guestsAtRisk names the guests stored on a device, for alerts.
+func guestsAtRisk(line PartitionLine) string {
+	var names []string
+	for _, g := range line.Guests {
+		if !slices.Contains(names, g.String()) {
+			names = append(names, g.String())
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "guests at risk: " + strings.Join(names, ", ")
+}
+
+// applyHypervisorGuests sets the guests stored on the disk of each record.
+func applyHypervisorGuests(records []PartitionLine, conf HypervisorConfig) []PartitionLine {
+	var guests []Guest
+	var err error
+	switch conf.Source {
+	case HypervisorLibvirt:
+		guests, err = libvirtGuests(conf)
+	case HypervisorProxmox:
+		guests, err = proxmoxGuests(conf)
+	default:
+		err = fmt.Errorf("unknown hypervisor source %q", conf.Source)
+	}
+	if err != nil {
+		log.Printf("Could not list hypervisor guests: %s\n", err)
+		return records
+	}
+
+	byDisk := make(map[string][]Guest)
+	for _, g := range guests {
+		for _, disk := range backingDisks(g.Volume) {
+			byDisk[disk] = append(byDisk[disk], g)
+		}
+	}
+	for i := range records {
+		record := &records[i]
+		for _, disk := range deviceDisks(record.PartitionName) {
+			for _, g := range byDisk[disk] {
+				if !slices.Contains(record.Guests, g) {
+					record.Guests = append(record.Guests, g)
+				}
+			}
+		}
+	}
+	return records
+}
+
+// libvirtGuests lists the disks of all libvirt domains, running or not.
+func libvirtGuests(conf HypervisorConfig) ([]Guest, error) {
+	virsh := conf.VirshPath
+	if virsh == "" {
+		virsh = "virsh"
+	}
+	run := func(args ...string) ([]byte, error) {
+		if conf.Uri != "" {
+			args = append([]string{"-c", conf.Uri}, args...)
+		}
+		out, err := exec.Command(virsh, args...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("virsh %s failed: %w", args[len(args)-1], err)
+		}
+		return out, nil
+	}
+
+	out, err := run("list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+	var guests []Guest
+	for _, domain := range strings.Fields(string(out)) {
+		out, err := run("domblklist", "--details", domain)
+		if err != nil {
+			return nil, err
+		}
+		guests = append(guests, parseDomblklist(domain, out)...)
+	}
+	return guests, nil
+}
+
+// parseDomblklist reads the disks of a domain from `virsh domblklist --details`, lines of Type
+// Device Target Source under a header and a line of dashes. CD-ROMs and empty drives are skipped.
+func parseDomblklist(domain string, out []byte) []Guest {
+	var guests []Guest
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[1] != "disk" {
+			continue
+		}
+		source := strings.Join(fields[3:], " ")
+		if source == "-" {
+			continue
+		}
+		guests = append(guests, Guest{Name: domain, Volume: source})
+	}
+	return guests
+}
+
+// proxmoxDiskKey matches the config keys of VM disks and container volumes.
+var proxmoxDiskKey = regexp.MustCompile(`^(ide|sata|scsi|virtio|efidisk|tpmstate|unused|mp)\d+$|^rootfs$`)
+
+// proxmoxGuests lists the volumes of the VMs and containers of the node through the Proxmox API.
+func proxmoxGuests(conf HypervisorConfig) ([]Guest, error) {
+	node := conf.Node
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if conf.InsecureSkipVerify {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	get := func(path string, v any) error {
+		req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(conf.Url, "/")+"/api2/json/nodes/"+url.PathEscape(node)+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", conf.TokenId, conf.Secret))
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("proxmox request error: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("proxmox %s returned %s: %s", path, resp.Status, msg)
+		}
+		return json.NewDecoder(resp.Body).Decode(&struct {
+			Data any `json:"data"`
+		}{Data: v})
+	}
+
+	var guests []Guest
+	for _, kind := range []string{"qemu", "lxc"} {
+		var list []struct {
+			Vmid any    `json:"vmid"`
+			Name string `json:"name"`
+		}
+		if err := get("/"+kind, &list); err != nil {
+			return nil, err
+		}
+		for _, vm := range list {
+			id := fmt.Sprint(vm.Vmid)
+			var config map[string]any
+			if err := get(fmt.Sprintf("/%s/%s/config", kind, id), &config); err != nil {
+				return nil, err
+			}
+			for key, value := range config {
+				spec, ok := value.(string)
+				if !ok || !proxmoxDiskKey.MatchString(key) || strings.Contains(spec, "media=cdrom") {
+					continue
+				}
+				volume, _, _ := strings.Cut(spec, ",")
+				if volume == "none" || volume == "" {
+					continue
+				}
+				path := volume
+				if storage, _, ok := strings.Cut(volume, ":"); ok && !strings.HasPrefix(volume, "/") {
+					var content struct {
+						Path string `json:"path"`
+					}
+					if err := get(fmt.Sprintf("/storage/%s/content/%s", url.PathEscape(storage), url.PathEscape(volume)), &content); err != nil {
+						log.Printf("Could not resolve %s of %s: %s\n", volume, vm.Name, err)
+						continue
+					}
+					path = content.Path
+				}
+				guests = append(guests, Guest{Name: vm.Name, Id: id, Volume: path})
+			}
+		}
+	}
+	return guests, nil
+}
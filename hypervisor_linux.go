@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// deviceDisks returns the kernel names of the disks holding a device: the disk itself, the disk of
+// a partition, or the disks under a device mapper node such as a multipath device.
+func deviceDisks(path string) []string {
+	return leafDisks(filepath.Base(canonicalDevicePath(path)))
+}
+
+// leafDisks follows the slaves of a block device down to the disks.
+func leafDisks(name string) []string {
+	dir := sysfsBlockDir(name)
+	if dir == "" {
+		return nil
+	}
+	slaves, _ := os.ReadDir(filepath.Join(dir, "slaves"))
+	if len(slaves) == 0 {
+		return []string{filepath.Base(dir)}
+	}
+	var disks []string
+	for _, slave := range slaves {
+		disks = append(disks, leafDisks(slave.Name())...)
+	}
+	return disks
+}
+
+// backingDisks returns the disks a guest volume is stored on. Block devices are followed through
+// their slaves, files through the device of their filesystem, and zvols and files on ZFS through
+// the devices of their pool.
+func backingDisks(path string) []string {
+	if pool, ok := strings.CutPrefix(path, "/dev/zvol/"); ok {
+		pool, _, _ = strings.Cut(pool, "/")
+		return zpoolDisks(pool)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return nil
+	}
+	if st.Mode&syscall.S_IFMT == syscall.S_IFBLK {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil
+		}
+		name := filepath.Base(real)
+		if strings.HasPrefix(name, "zd") {
+			return zpoolDisks(zvolPool(name))
+		}
+		return leafDisks(name)
+	}
+	devNum := fmt.Sprintf("%d:%d", devMajor(uint64(st.Dev)), devMinor(uint64(st.Dev)))
+	if real, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "dev", "block", devNum)); err == nil {
+		return leafDisks(filepath.Base(real))
+	}
+	// ZFS datasets have no block device
+	return zfsFileDisks(path)
+}
+
+func devMajor(dev uint64) uint64 {
+	return (dev >> 8) & 0xfff
+}
+
+func devMinor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) & 0xfff00)
+}
+
+// zfsFileDisks returns the disks of the pool of the ZFS filesystem holding path, found as the
+// longest mount point containing it.
+func zfsFileDisks(path string) []string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	pool, longest := "", -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root mount-point options [optional...] - fstype source options
+		fields := strings.Fields(scanner.Text())
+		for i := 5; i < len(fields)-2; i++ {
+			if fields[i] != "-" {
+				continue
+			}
+			mountPath := unescapeMountPath(fields[4])
+			inside := path == mountPath || strings.HasPrefix(path, strings.TrimSuffix(mountPath, "/")+"/")
+			if fields[i+1] == "zfs" && inside && len(mountPath) > longest {
+				pool, _, _ = strings.Cut(fields[i+2], "/")
+				longest = len(mountPath)
+			}
+			break
+		}
+	}
+	if pool == "" {
+		return nil
+	}
+	return zpoolDisks(pool)
+}
+
+// zvolPool returns the pool of the zvol with kernel name name, from the /dev/zvol/<pool>/...
+// links udev creates.
+func zvolPool(name string) string {
+	pool := ""
+	_ = filepath.WalkDir("/dev/zvol", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || pool != "" || d.IsDir() {
+			return nil
+		}
+		if real, err := filepath.EvalSymlinks(path); err == nil && filepath.Base(real) == name {
+			pool, _, _ = strings.Cut(strings.TrimPrefix(path, "/dev/zvol/"), "/")
+		}
+		return nil
+	})
+	return pool
+}
+
+// zpoolDisks returns the disks of the vdevs of a ZFS pool, as listed by zpool status.
+func zpoolDisks(pool string) []string {
+	if pool == "" {
+		return nil
+	}
+	out, err := exec.Command("zpool", "status", "-P", "-L", pool).Output()
+	if err != nil {
+		return nil
+	}
+	var disks []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "/dev/") {
+			disks = append(disks, deviceDisks(fields[0])...)
+		}
+	}
+	return disks
+}
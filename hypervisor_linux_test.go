@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// The layout of an LVM volume dm-0 on a RAID1 md0 of the partitions sda1 and sdb1.
+const (
+	lvmDm  = "devices/virtual/block/dm-0"
+	lvmMd  = "devices/virtual/block/md0"
+	lvmSda = "devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0/block/sda"
+	lvmSdb = "devices/pci0000:00/0000:00:17.0/ata2/host1/target1:0:0/1:0:0:0/block/sdb"
+)
+
+func fakeLvmSysfs(t *testing.T, links map[string]string) {
+	t.Helper()
+	all := map[string]string{
+		"class/block/dm-0":     lvmDm,
+		"class/block/md0":      lvmMd,
+		"class/block/sda":      lvmSda,
+		"class/block/sda1":     lvmSda + "/sda1",
+		"class/block/sdb":      lvmSdb,
+		"class/block/sdb1":     lvmSdb + "/sdb1",
+		lvmDm + "/slaves/md0":  lvmMd,
+		lvmMd + "/slaves/sda1": lvmSda + "/sda1",
+		lvmMd + "/slaves/sdb1": lvmSdb + "/sdb1",
+	}
+	for path, target := range links {
+		all[path] = target
+	}
+	fakeSysfs(t, map[string]string{
+		lvmDm + "/dev":             "253:0",
+		lvmMd + "/dev":             "9:0",
+		lvmSda + "/dev":            "8:0",
+		lvmSda + "/sda1/partition": "1",
+		lvmSdb + "/dev":            "8:16",
+		lvmSdb + "/sdb1/partition": "1",
+	}, all)
+}
+
+func TestLeafDisks(t *testing.T) {
+	fakeLvmSysfs(t, nil)
+	for name, want := range map[string][]string{
+		"dm-0": {"sda", "sdb"},
+		"md0":  {"sda", "sdb"},
+		"sda1": {"sda"},
+		"sdb":  {"sdb"},
+		"sdz":  nil,
+	} {
+		if got := leafDisks(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s is on %v, want %v", name, got, want)
+		}
+	}
+}
+
+// A disk image is on the disks of the block device of its filesystem.
+func TestBackingDisksFile(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "web.qcow2")
+	if err := os.WriteFile(image, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(image, &st); err != nil {
+		t.Fatal(err)
+	}
+	devNum := fmt.Sprintf("%d:%d", devMajor(uint64(st.Dev)), devMinor(uint64(st.Dev)))
+	fakeLvmSysfs(t, map[string]string{"dev/block/" + devNum: lvmDm})
+	if got := backingDisks(image); !reflect.DeepEqual(got, []string{"sda", "sdb"}) {
+		t.Errorf("image on device %s is on %v", devNum, got)
+	}
+	if got := backingDisks(image + ".missing"); got != nil {
+		t.Errorf("missing image is on %v", got)
+	}
+}
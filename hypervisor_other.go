@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+func deviceDisks(path string) []string {
+	return nil
+}
+
+func backingDisks(path string) []string {
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// domblklistWeb is `virsh domblklist --details web` of a domain with an image, a logical volume,
+// a network disk and two CD-ROM drives, one of them empty.
+const domblklistWeb = ` Type      Device   Target   Source
+--------------------------------------------------------------------
+ file      disk     vda      /var/lib/libvirt/images/web server.qcow2
+ block     disk     vdb      /dev/vg0/web-data
+ network   disk     vdc      rbd-pool/web-disk
+ file      cdrom    sda      -
+ file      cdrom    sdb      /var/lib/libvirt/images/debian-12.iso
+
+`
+
+func TestParseDomblklist(t *testing.T) {
+	want := []Guest{
+		{Name: "web", Volume: "/var/lib/libvirt/images/web server.qcow2"},
+		{Name: "web", Volume: "/dev/vg0/web-data"},
+		{Name: "web", Volume: "rbd-pool/web-disk"},
+	}
+	if got := parseDomblklist("web", []byte(domblklistWeb)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := parseDomblklist("empty", []byte(" Type   Device   Target   Source\n------------------------------\n")); got != nil {
+		t.Errorf("domain without disks has %+v", got)
+	}
+}
+
+// proxmoxApi answers like the Proxmox API of node pve, with pvesh's data envelope.
+var proxmoxApi = map[string]string{
+	"/api2/json/nodes/pve/qemu": `[{"vmid": 100, "name": "web", "status": "running"}]`,
+	"/api2/json/nodes/pve/lxc":  `[{"vmid": "101", "name": "dns", "status": "stopped"}]`,
+	"/api2/json/nodes/pve/qemu/100/config": `{"name": "web", "digest": "4f3c", "boot": "order=scsi0",
+		"scsi0": "local-lvm:vm-100-disk-0,iothread=1,size=32G",
+		"efidisk0": "local-lvm:vm-100-disk-1,efitype=4m,size=4M",
+		"ide2": "local:iso/debian-12.iso,media=cdrom,size=628M",
+		"sata0": "none,media=cdrom",
+		"unused0": "/mnt/images/old.qcow2",
+		"net0": "virtio=BC:24:11:2E:5A:01,bridge=vmbr0"}`,
+	"/api2/json/nodes/pve/lxc/101/config": `{"hostname": "dns", "cores": 1,
+		"rootfs": "local-zfs:subvol-101-disk-0,size=8G",
+		"mp0": "backup:101/vm-101-disk-1.raw,mp=/backup"}`,
+	"/api2/json/nodes/pve/storage/local-lvm/content/local-lvm:vm-100-disk-0":     `{"path": "/dev/pve/vm-100-disk-0", "format": "raw"}`,
+	"/api2/json/nodes/pve/storage/local-lvm/content/local-lvm:vm-100-disk-1":     `{"path": "/dev/pve/vm-100-disk-1", "format": "raw"}`,
+	"/api2/json/nodes/pve/storage/local-zfs/content/local-zfs:subvol-101-disk-0": `{"path": "/rpool/data/subvol-101-disk-0", "format": "subvol"}`,
+}
+
+func TestProxmoxGuests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "PVEAPIToken=gosmart@pve!audit=secret" {
+			http.Error(w, "authentication failure", http.StatusUnauthorized)
+			return
+		}
+		data, ok := proxmoxApi[r.URL.Path]
+		if !ok {
+			// The backup storage is offline
+			http.Error(w, "storage 'backup' is not online", http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]json.RawMessage{"data": json.RawMessage(data)})
+	}))
+	defer server.Close()
+
+	conf := HypervisorConfig{Source: HypervisorProxmox, Url: server.URL + "/", TokenId: "gosmart@pve!audit", Secret: "secret", Node: "pve"}
+	guests, err := proxmoxGuests(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(guests, func(a, b Guest) int { return strings.Compare(a.Volume, b.Volume) })
+	want := []Guest{
+		{Name: "web", Id: "100", Volume: "/dev/pve/vm-100-disk-0"},
+		{Name: "web", Id: "100", Volume: "/dev/pve/vm-100-disk-1"},
+		{Name: "web", Id: "100", Volume: "/mnt/images/old.qcow2"},
+		{Name: "dns", Id: "101", Volume: "/rpool/data/subvol-101-disk-0"},
+	}
+	if !reflect.DeepEqual(guests, want) {
+		t.Errorf("got %+v, want %+v", guests, want)
+	}
+
+	conf.Secret = "wrong"
+	if _, err := proxmoxGuests(conf); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("listed guests with a wrong token: %v", err)
+	}
+}
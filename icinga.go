@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type IcingaConfig struct {
+	// Url of the Icinga2 API, e.g. https://icinga.example.com:5665
+	Url      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Host is the Icinga host object, defaults to the host name
+	Host string `json:"host,omitempty"`
+	// Services maps device paths to service names. Unmapped devices use smart-<device name>,
+	// e.g. smart-sda for /dev/sda. Missing services are created as passive services on the host.
+	Services map[string]string `json:"services,omitempty"`
+	// InsecureSkipVerify accepts the self-signed certificate Icinga2 generates by default
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// icingaServiceName returns the service a device's check result is submitted to.
+func icingaServiceName(conf IcingaConfig, devName string) string {
+	if name, ok := conf.Services[devName]; ok {
+		return name
+	}
+	return checkName(devName)
+}
+
+// icingaPerfLabel quotes a performance data label.
+func icingaPerfLabel(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "") + "'"
+}
+
+// saveToIcinga submits a record as a passive check result through the process-check-result action.
+func saveToIcinga(record PartitionLine, conf IcingaConfig) error {
+	host := conf.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	service := icingaServiceName(conf, record.PartitionName)
+
+	exitStatus, output := checkResult(record)
+
+	var perfData []string
+	for _, attr := range record.Attributes {
+		perfData = append(perfData, fmt.Sprintf("%s=%d", icingaPerfLabel(fmt.Sprintf("%d_%s", attr.Id, attr.Name)), attr.ValueDecoded))
+	}
+	if temperature, ok := deviceTemperature(record); ok {
+		perfData = append(perfData, fmt.Sprintf("temperature=%d", temperature))
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"type":             "Service",
+		"filter":           fmt.Sprintf("host.name==%q && service.name==%q", host, service),
+		"exit_status":      exitStatus,
+		"plugin_output":    output,
+		"performance_data": perfData,
+		"check_source":     host,
+	})
+	if err != nil {
+		return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	if conf.InsecureSkipVerify {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	status, msg, err := icingaRequest(client, conf, http.MethodPost, "/v1/actions/process-check-result", body)
+	if err == nil && status == http.StatusNotFound {
+		// The filter matched no service, create a passive one and submit again
+		if err = createIcingaService(client, conf, host, service); err == nil {
+			status, msg, err = icingaRequest(client, conf, http.MethodPost, "/v1/actions/process-check-result", body)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("icinga write error for %s (service %s!%s): %w", record.PartitionName, host, service, err)
+	} else if status/100 != 2 {
+		return fmt.Errorf("icinga write error for %s (service %s!%s): %d %s", record.PartitionName, host, service, status, msg)
+	}
+	return nil
+}
+
+// createIcingaService creates a passive only service for a device on an existing host.
+func createIcingaService(client http.Client, conf IcingaConfig, host, service string) error {
+	body, err := json.Marshal(map[string]any{
+		"attrs": map[string]any{
+			"check_command":         "dummy",
+			"enable_active_checks":  false,
+			"enable_passive_checks": true,
+			"display_name":          "SMART " + service,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	status, msg, err := icingaRequest(client, conf, http.MethodPut, "/v1/objects/services/"+url.PathEscape(host+"!"+service), body)
+	if err != nil {
+		return err
+	}
+	if status/100 != 2 {
+		return fmt.Errorf("creating service: %d %s", status, msg)
+	}
+	log.Printf("Created Icinga service %s!%s\n", host, service)
+	return nil
+}
+
+func icingaRequest(client http.Client, conf IcingaConfig, method, path string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(conf.Url, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(conf.Username, conf.Password)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, msg, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importBatchSize keeps postgres inserts below its limit of statement parameters.
+const importBatchSize = 1000
+
+// importedDisk is the model and serial number of the drive an imported log is from.
+type importedDisk struct {
+	device string
+	model  string
+	serial string
+}
+
+// runImport implements `gosmart import`, which backfills the local store or the postgres output of
+// the config with readings from smartd attribute logs (attrlog.<model>-<serial>.ata.csv), archived
+// `smartctl -j -a` outputs or the records of a json output, one or more JSON documents per file.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	device := fs.String("device", "", "Device to store the readings under, by default the configured disk with the serial number of the log, or the device smartctl read")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import [-f config] [-device path] file...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+	if conf.Local == nil && conf.Db == nil {
+		fmt.Fprintln(os.Stderr, "Importing needs a local store or postgres output in the config")
+		os.Exit(1)
+	}
+
+	// Serial numbers are only resolved to devices once, by reading the configured disks
+	var disksBySerial map[string]string
+	deviceOf := func(disk importedDisk) string {
+		if *device != "" {
+			return *device
+		}
+		if disk.serial != "" {
+			if disksBySerial == nil {
+				disksBySerial = make(map[string]string)
+				for _, item := range collectInventory(conf) {
+					if item.Serial != "" {
+						disksBySerial[strings.ToUpper(item.Serial)] = item.Device
+					}
+				}
+			}
+			serial := disk.serial
+			if conf.Anonymize != nil {
+				// The configured disks report their serial numbers hashed
+				serial = newAnonymizer(*conf.Anonymize).hash(serial)
+			}
+			if path, ok := disksBySerial[strings.ToUpper(serial)]; ok {
+				return path
+			}
+		}
+		return disk.device
+	}
+
+	var records []PartitionLine
+	for _, path := range fs.Args() {
+		imported, err := importFile(path, conf, deviceOf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not import %s: %s\n", path, err)
+			os.Exit(1)
+		}
+		if len(imported) > 0 && imported[0].PartitionName == "" {
+			fmt.Fprintf(os.Stderr, "Could not tell which device %s is from, give it with -device\n", path)
+			os.Exit(1)
+		}
+		fmt.Printf("Read %d readings from %s\n", len(imported), path)
+		records = append(records, imported...)
+	}
+
+	if conf.Local != nil {
+		if err := saveToLocal(records, *conf.Local); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not import into the local store: %s\n", err)
+			os.Exit(1)
+		}
+		readings := conf.Local.Readings
+		if readings <= 0 {
+			readings = defaultLocalReadings
+		}
+		if conf.Local.RetentionHours > 0 || len(records) > readings {
+			fmt.Println("Readings beyond the readings and retention_hours of the local store were dropped")
+		}
+	}
+	if conf.Db != nil {
+		for start := 0; start < len(records); start += importBatchSize {
+			if err := saveBatchToPostgresDB(records[start:min(start+importBatchSize, len(records))], *conf.Db); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not import into postgres: %s\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// importFile reads the readings of a log, telling JSON from smartd CSV by its first byte.
+func importFile(path string, conf Config, deviceOf func(importedDisk) string) ([]PartitionLine, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		return importJson(bytes.NewReader(b), conf, deviceOf)
+	}
+	return importSmartdLog(bytes.NewReader(b), smartdLogDisk(path), conf, deviceOf)
+}
+
+// importJson reads a stream of `smartctl -j -a` outputs or of records of the json outputs, told
+// apart by the partition_name of records. Only ATA attributes are kept, as the store only holds
+// attributes.
+func importJson(r io.Reader, conf Config, deviceOf func(importedDisk) string) ([]PartitionLine, error) {
+	var records []PartitionLine
+	dec := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		var probe struct {
+			PartitionName *string `json:"partition_name"`
+		}
+		if err := json.Unmarshal(doc, &probe); err != nil {
+			return records, err
+		}
+		if probe.PartitionName != nil {
+			record, err := importRecord(doc, conf, deviceOf)
+			if err != nil {
+				return records, err
+			}
+			if len(record.Attributes) > 0 {
+				records = append(records, record)
+			}
+			continue
+		}
+
+		var out smartctlOutput
+		if err := json.Unmarshal(doc, &out); err != nil {
+			return records, err
+		}
+		if out.AtaSmartAttributes == nil || out.LocalTime.TimeT == 0 {
+			continue
+		}
+		disk := importedDisk{device: out.Device.Name, model: out.ModelName, serial: out.SerialNumber}
+		records = append(records, importedRecord(disk, time.Unix(out.LocalTime.TimeT, 0), out.ataAttrs(), conf, deviceOf))
+	}
+}
+
+// importRecord reads a record written by a json output of any timestamp_format and translates it
+// from the schema version of the gosmart that wrote it.
+func importRecord(doc []byte, conf Config, deviceOf func(importedDisk) string) (PartitionLine, error) {
+	var rendered struct {
+		PartitionLine
+		Ts json.RawMessage `json:"ts"`
+	}
+	if err := json.Unmarshal(doc, &rendered); err != nil {
+		return PartitionLine{}, err
+	}
+	record := rendered.PartitionLine
+	if err := json.Unmarshal(rendered.Ts, &record.Ts); err != nil {
+		var unix int64
+		if err := json.Unmarshal(rendered.Ts, &unix); err != nil {
+			return record, fmt.Errorf("record of %s has no valid ts", record.PartitionName)
+		}
+		record.Ts = time.Unix(unix, 0)
+	}
+	// The serial numbers of records are those of the agent's disks, they are kept under its name
+	record.PartitionName = deviceOf(importedDisk{device: record.PartitionName})
+	record, err := upgradeRecord(record, record.SchemaVersion, conf)
+	if err != nil {
+		return record, fmt.Errorf("record of %s: %w", record.PartitionName, err)
+	}
+	return record, nil
+}
+
+// smartdLogDisk takes the model and serial number from the name of a smartd attribute log,
+// attrlog.<model>-<serial>.ata.csv, where the model may contain dashes but the serial does not.
+func smartdLogDisk(path string) importedDisk {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "attrlog."), ".ata.csv")
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return importedDisk{}
+	}
+	return importedDisk{model: strings.ReplaceAll(name[:i], "_", " "), serial: name[i+1:]}
+}
+
+// importSmartdLog reads a smartd attribute log, lines of the time followed by tab separated
+// id;normalized;raw; triples:
+//
+//	2024-01-31 10:15:42;	1;200;0;	5;200;0;	9;71;21447;
+func importSmartdLog(r io.Reader, disk importedDisk, conf Config, deviceOf func(importedDisk) string) ([]PartitionLine, error) {
+	var records []PartitionLine
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if strings.TrimSpace(fields[0]) == "" {
+			continue
+		}
+		ts, err := time.ParseInLocation("2006-01-02 15:04:05", strings.TrimSuffix(strings.TrimSpace(fields[0]), ";"), time.Local)
+		if err != nil {
+			return records, fmt.Errorf("line %d: %w", n, err)
+		}
+		attrs := make(map[uint8]AtaSmartAttr)
+		for _, field := range fields[1:] {
+			parts := strings.Split(strings.TrimSuffix(strings.TrimSpace(field), ";"), ";")
+			if len(parts) != 3 {
+				continue
+			}
+			id, err1 := strconv.ParseUint(parts[0], 10, 8)
+			current, err2 := strconv.ParseUint(parts[1], 10, 8)
+			raw, err3 := strconv.ParseUint(parts[2], 10, 64)
+			if err := errors.Join(err1, err2, err3); err != nil {
+				return records, fmt.Errorf("line %d: %w", n, err)
+			}
+			attrs[uint8(id)] = AtaSmartAttr{Id: uint8(id), Current: uint8(current), ValueRaw: raw}
+		}
+		if len(attrs) > 0 {
+			records = append(records, importedRecord(disk, ts, attrs, conf, deviceOf))
+		}
+	}
+	return records, scanner.Err()
+}
+
+// importedRecord builds a record of the configured attributes of a reading. Logs often lack some
+// of them, which is not worth a warning for every line.
+func importedRecord(disk importedDisk, ts time.Time, attrs map[uint8]AtaSmartAttr, conf Config, deviceOf func(importedDisk) string) PartitionLine {
+	var present []uint8
+	for _, id := range conf.Attributes {
+		if _, ok := attrs[id]; ok {
+			present = append(present, id)
+		}
+	}
+	conf.Attributes = present
+	line := PartitionLine{Ts: ts, PartitionName: deviceOf(disk), Model: disk.model, Serial: disk.serial, SmartSupported: true}
+	return buildAttributes(line, attrs, conf)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// resolveIncludes merges the files listed by the include key of a config into it, so that e.g.
+// fleet-wide defaults and the devices of a host can be kept in separate files:
+//
+//	{"output_type": "postgres", "include": ["/etc/gosmart/conf.d/*.json"]}
+//
+// Patterns are relative to the directory of the config and their matches are merged in sorted
+// order after the config, each over the result so far. Objects are merged key by key, any other
+// value, lists included, replaces the earlier one. Included files cannot include further files.
+func resolveIncludes(jsonBytes []byte, dir string) ([]byte, error) {
+	var conf map[string]json.RawMessage
+	if err := json.Unmarshal(jsonBytes, &conf); err != nil {
+		return nil, err
+	}
+	var patterns []string
+	if include, ok := conf["include"]; ok {
+		if err := json.Unmarshal(include, &patterns); err != nil {
+			return nil, fmt.Errorf("include: %w", err)
+		}
+	}
+	if len(patterns) == 0 {
+		return jsonBytes, nil
+	}
+	delete(conf, "include")
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %w", pattern, err)
+		}
+		slices.Sort(paths)
+		for _, path := range paths {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			var included map[string]json.RawMessage
+			if err := json.Unmarshal(b, &included); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if _, ok := included["include"]; ok {
+				log.Printf("Ignoring include in included config %s\n", path)
+				delete(included, "include")
+			}
+			if conf, err = mergeJsonObjects(conf, included); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			log.Printf("Included config file %s\n", path)
+		}
+	}
+	return json.Marshal(conf)
+}
+
+// includeDirs returns the directories the include patterns of the config at path match files in,
+// each the deepest directory of its pattern without wildcards, so the serve sandbox allows
+// listing it and reading what matches.
+func includeDirs(path string) []string {
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var conf struct {
+		Include []string `json:"include"`
+	}
+	if json.Unmarshal(jsonBytes, &conf) != nil {
+		return nil
+	}
+	var dirs []string
+	for _, pattern := range conf.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		dir := filepath.Dir(pattern)
+		for strings.ContainsAny(dir, "*?[") {
+			dir = filepath.Dir(dir)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// mergeJsonObjects merges over into base, recursing into keys that are objects in both.
+func mergeJsonObjects(base, over map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	for key, value := range over {
+		var baseObject, overObject map[string]json.RawMessage
+		if json.Unmarshal(base[key], &baseObject) == nil && baseObject != nil && json.Unmarshal(value, &overObject) == nil && overObject != nil {
+			merged, err := mergeJsonObjects(baseObject, overObject)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = json.Marshal(merged); err != nil {
+				return nil, err
+			}
+		}
+		base[key] = value
+	}
+	return base, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"conf.d/10-db.json":      `{"db": {"host": "db1", "port": 5432}, "attributes": [5, 197]}`,
+		"conf.d/20-db.json":      `{"db": {"host": "db2"}, "include": ["other.json"]}`,
+		"conf.d/notes.txt":       `not json`,
+		"hosts/web1/device.json": `{"partitions": ["/dev/sdb"]}`,
+	})
+	main := `{"output_type": "postgres", "attributes": [9], "include": ["conf.d/*.json", "hosts/*/device.json"]}`
+	conf, err := parseConfig([]byte(main), dir, "main.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.OutputType != OutputPostgres || conf.Db == nil || conf.Db.Host != "db2" || conf.Db.Port != 5432 {
+		t.Errorf("merged %+v with db %+v", conf, conf.Db)
+	}
+	if !slices.Equal(conf.Attributes, []uint8{5, 197}) {
+		t.Errorf("attributes %v, want those of the include", conf.Attributes)
+	}
+	if len(conf.Partitions) != 1 || conf.Partitions[0].Path != "/dev/sdb" {
+		t.Errorf("partitions %+v", conf.Partitions)
+	}
+
+	if _, err := parseConfig([]byte(`{"include": ["conf.d/*.txt"]}`), dir, "main.json"); err == nil {
+		t.Error("included a file that is not JSON")
+	}
+}
+
+// The serve sandbox allows the directories includes are globbed in.
+func TestIncludeDirs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.json")
+	writeConfigFiles(t, dir, map[string]string{
+		"main.json": `{"include": ["conf.d/*.json", "hosts/*/device.json", "/etc/gosmart/site.json", "fleet/[ab]?/*.json"]}`,
+	})
+	want := []string{filepath.Join(dir, "conf.d"), filepath.Join(dir, "hosts"), "/etc/gosmart", filepath.Join(dir, "fleet")}
+	if got := includeDirs(path); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := includeDirs(filepath.Join(dir, "missing.json")); got != nil {
+		t.Errorf("missing config has include dirs %v", got)
+	}
+}
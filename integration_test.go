@@ -0,0 +1,301 @@
+//go:build integration
+
+package main
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"gosmart/itest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// integrationDevice is the fake drive the integration tests collect.
+const integrationDevice = "/dev/gosmart-it0"
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	itest.Close()
+	os.Exit(code)
+}
+
+// integrationHarness writes collections of the fake device to a scratch schema of the integration
+// database.
+type integrationHarness struct {
+	conf  Config
+	db    *sqlx.DB
+	start time.Time
+	// cycles are the power on hours of every collection written so far
+	cycles []uint64
+	// reallocated is the raw reallocated sector count of the next collection, any makes it critical
+	reallocated uint64
+}
+
+// newIntegrationHarness returns a harness on a scratch schema of server, with the dialect and
+// options of dbConf.
+func newIntegrationHarness(t *testing.T, server itest.Server, dbConf DBConfig) *integrationHarness {
+	t.Helper()
+	dbConf.Host = server.Host
+	dbConf.Port = server.Port
+	dbConf.Username = server.User
+	dbConf.Password = server.Password
+	dbConf.Schema = itest.Schema(t, server)
+	dbConf.Table = "readings"
+	dbConf.Initialize = true
+	db, err := connectPostgres(dbConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return &integrationHarness{
+		conf: applyDefaults(Config{
+			Db:          &dbConf,
+			Discovery:   DiscoveryDirect,
+			Partitions:  []PartitionConfig{{Path: integrationDevice, Backend: BackendFake}},
+			Attributes:  []uint8{5, 9, 194},
+			FakeDevices: map[string]FakeDevice{integrationDevice: {Uuid: "gosmart-it", Model: "GOSMART IT", Serial: "IT0", MediaType: MediaHdd}},
+			OutputType:  OutputPostgres,
+		}),
+		db:    db,
+		start: time.Now(),
+	}
+}
+
+func TestIntegrationCycle(t *testing.T) {
+	integrationCycle(t, newIntegrationHarness(t, itest.Postgres(t), DBConfig{}))
+}
+
+// PgBouncer in transaction pooling mode hands each transaction to any server connection, which
+// fails with "prepared statement does not exist" without the pgbouncer option.
+func TestIntegrationPgBouncer(t *testing.T) {
+	h := newIntegrationHarness(t, itest.PgBouncer(t), DBConfig{PgBouncer: true})
+	integrationCycle(t, h)
+	t.Run("resend", func(t *testing.T) {
+		records := []PartitionLine{h.collect(t, 2000, 0)}
+		if err := saveBatchToPostgresDB(records, *h.conf.Db); err != nil {
+			t.Fatal(err)
+		}
+		h.expectHistory(t, []uint64{1004, 1001, 1002, 1003, 1005, 2000})
+	})
+}
+
+// The cockroachdb dialect creates the tables outside of a transaction and deletes expired rows in
+// batches, which the retention steps of the cycle go through.
+func TestIntegrationCockroach(t *testing.T) {
+	h := newIntegrationHarness(t, itest.Cockroach(t), DBConfig{Dialect: DialectCockroach})
+	integrationCycle(t, h)
+	t.Run("resend", func(t *testing.T) {
+		records := []PartitionLine{h.collect(t, 2000, 0)}
+		if err := saveBatchToPostgresDB(records, *h.conf.Db); err != nil {
+			t.Fatal(err)
+		}
+		h.expectHistory(t, []uint64{1004, 1001, 1002, 1003, 1005, 2000})
+	})
+}
+
+// integrationCycle runs collection, insert, history, retention and fleet on h. The steps run in
+// order, each on the database the previous ones left.
+func integrationCycle(t *testing.T, h *integrationHarness) {
+	t.Helper()
+	steps := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"insert with initialize", func(t *testing.T) {
+			h.collect(t, 1000, 5*time.Hour)
+			h.expectCount(t, "", 1)
+		}},
+		{"insert further collections", func(t *testing.T) {
+			for i, age := range []time.Duration{3 * time.Hour, time.Hour} {
+				h.collect(t, uint64(1001+i), age)
+			}
+			h.expectCount(t, "", 3)
+		}},
+		{"read history", func(t *testing.T) {
+			h.expectHistory(t, h.cycles)
+		}},
+		{"retention", func(t *testing.T) {
+			retention := 4
+			h.conf.Db.DataRetentionHours = &retention
+			defer func() { h.conf.Db.DataRetentionHours = nil }()
+			h.collect(t, 1003, 0)
+			h.expectHistory(t, h.cycles[1:])
+		}},
+		{"retention by severity", func(t *testing.T) {
+			h.reallocated = 1
+			h.collect(t, 1004, 10*time.Hour)
+			h.reallocated = 0
+			retention := 4
+			h.conf.Db.DataRetentionHours = &retention
+			h.conf.Db.RetentionHoursBySeverity = map[string]int{SeverityCritical: 24}
+			defer func() { h.conf.Db.DataRetentionHours, h.conf.Db.RetentionHoursBySeverity = nil, nil }()
+			h.collect(t, 1005, 0)
+			// The critical reading outlives the base retention, oldest first by timestamp
+			h.expectHistory(t, []uint64{1004, 1001, 1002, 1003, 1005})
+		}},
+		{"fleet", func(t *testing.T) {
+			rows, err := readFleet(h.db, *h.conf.Db, h.start.Add(-24*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 {
+				t.Fatalf("fleet has %d devices, want 1", len(rows))
+			}
+			values, err := attributeHistory([]historyRow{rows[0].historyRow})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := float64(h.cycles[len(h.cycles)-1]); !slices.Equal(values[9], []float64{want}) {
+				t.Errorf("fleet power on hours are %v, want %v", values[9], want)
+			}
+		}},
+		{"runs", func(t *testing.T) {
+			h.expectCount(t, "_runs", len(h.cycles))
+		}},
+	}
+	for _, step := range steps {
+		if !t.Run(step.name, step.run) {
+			t.FailNow()
+		}
+	}
+}
+
+// Readings sent again, as the sync of a local store does after a crash, are stored once.
+func TestIntegrationResend(t *testing.T) {
+	h := newIntegrationHarness(t, itest.Postgres(t), DBConfig{})
+	records := []PartitionLine{h.collect(t, 1000, 2*time.Hour), h.collect(t, 1001, time.Hour)}
+	if err := saveBatchToPostgresDB(records, *h.conf.Db); err != nil {
+		t.Fatal(err)
+	}
+	h.expectCount(t, "", 2)
+	h.expectHistory(t, h.cycles)
+}
+
+// A table of an older gosmart already holding a reading twice gets its other tables, and an error
+// saying how to add the unique key.
+func TestIntegrationDuplicateReadings(t *testing.T) {
+	h := newIntegrationHarness(t, itest.Postgres(t), DBConfig{})
+	conf := *h.conf.Db
+	h.exec(t, fmt.Sprintf("CREATE SCHEMA %s;", conf.Schema))
+	h.exec(t, fmt.Sprintf("CREATE TABLE %s.%s ( uuid text, ts timestamp with time zone, partition_name text, label text, mount_path text, size_bytes numeric, attributes JSONB);", conf.Schema, conf.Table))
+	for i := 0; i < 2; i++ {
+		h.exec(t, fmt.Sprintf("INSERT INTO %s.%s (uuid, ts, partition_name, attributes) VALUES ('gosmart-it', '2024-05-01T12:00:00Z', '%s', '[]');", conf.Schema, conf.Table, integrationDevice))
+	}
+
+	err := initializeTables(h.db, conf)
+	if err == nil || !strings.Contains(err.Error(), "delete duplicate readings") {
+		t.Errorf("initialized a table with duplicate readings: %v", err)
+	}
+	h.expectCount(t, "_runs", 0)
+
+	h.exec(t, fmt.Sprintf("DELETE FROM %s.%s WHERE ctid NOT IN (SELECT min(ctid) FROM %[1]s.%[2]s GROUP BY uuid, partition_name, ts);", conf.Schema, conf.Table))
+	if err := initializeTables(h.db, conf); err != nil {
+		t.Errorf("initialized without the duplicates: %v", err)
+	}
+}
+
+// Delta encoded rows read back as all attributes, from the start of a chain and from within it.
+func TestIntegrationDeltaAttributes(t *testing.T) {
+	h := newIntegrationHarness(t, itest.Postgres(t), DBConfig{})
+	h.conf.Db.DeltaAttributes = true
+	h.conf.Db.DeltaKeyframeRows = 3
+	for i := 0; i < 7; i++ {
+		h.collect(t, uint64(1000+i), time.Duration(7-i)*time.Hour)
+	}
+	h.expectCount(t, "", 7)
+
+	var deltas int
+	ctx, cancel := dbContext(*h.conf.Db)
+	defer cancel()
+	query := fmt.Sprintf("SELECT count(*) FROM %s.%s WHERE jsonb_typeof(attributes) = 'object';", h.conf.Db.Schema, h.conf.Db.Table)
+	if err := h.db.GetContext(ctx, &deltas, query); err != nil {
+		t.Fatal(err)
+	}
+	if deltas == 0 || deltas == 7 {
+		t.Errorf("stored %d of 7 rows as deltas, want some between keyframes", deltas)
+	}
+	h.expectHistory(t, h.cycles)
+
+	// Rows after the first need the keyframe and deltas before the cutoff to resolve
+	rows, err := postgresHistory{db: h.db, conf: *h.conf.Db}.historySince(h.start.Add(-2*time.Hour - 30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := attributeHistory(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1005, 1006}; !slices.Equal(values[9], want) || len(values[194]) != 2 {
+		t.Errorf("power on hours since the cutoff are %v, want %v", values[9], want)
+	}
+}
+
+// collect runs a collection of the fake device reporting powerOnHours, dated age ago, writes it to
+// the database and returns it.
+func (h *integrationHarness) collect(t *testing.T, powerOnHours uint64, age time.Duration) PartitionLine {
+	t.Helper()
+	device := h.conf.FakeDevices[integrationDevice]
+	device.Attributes = []FakeAttribute{
+		{Id: 5, Current: 100, Worst: 100, Raw: h.reallocated},
+		{Id: 9, Current: 90, Worst: 90, Raw: powerOnHours},
+		{Id: 194, Current: 64, Worst: 50, Raw: 36},
+	}
+	h.conf.FakeDevices[integrationDevice] = device
+
+	records := collectAll(h.conf)
+	if len(records) != 1 || records[0].Error != nil {
+		t.Fatalf("collected %d records from the fake device, want 1 without error", len(records))
+	}
+	ts := records[0].Ts.Add(-age)
+	records[0].Ts = ts
+	records[0].AgentTs = &ts
+	if err := saveBatchToPostgresDB(records, *h.conf.Db); err != nil {
+		t.Fatal(err)
+	}
+	h.cycles = append(h.cycles, powerOnHours)
+	return records[0]
+}
+
+func (h *integrationHarness) exec(t *testing.T, statement string) {
+	t.Helper()
+	ctx, cancel := dbContext(*h.conf.Db)
+	defer cancel()
+	if _, err := h.db.ExecContext(ctx, statement); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (h *integrationHarness) expectCount(t *testing.T, suffix string, want int) {
+	t.Helper()
+	var n int
+	ctx, cancel := dbContext(*h.conf.Db)
+	defer cancel()
+	if err := h.db.GetContext(ctx, &n, fmt.Sprintf("SELECT count(*) FROM %s.%s%s;", h.conf.Db.Schema, h.conf.Db.Table, suffix)); err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Errorf("%s%s has %d rows, want %d", h.conf.Db.Table, suffix, n, want)
+	}
+}
+
+// expectHistory checks the stored power on hours of the fake device, oldest first.
+func (h *integrationHarness) expectHistory(t *testing.T, want []uint64) {
+	t.Helper()
+	history, err := postgresHistory{db: h.db, conf: *h.conf.Db}.attributeHistory(integrationDevice, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := make([]float64, len(want))
+	for i, v := range want {
+		wantValues[i] = float64(v)
+	}
+	if !slices.Equal(history[9], wantValues) {
+		t.Errorf("stored power on hours are %v, want %v", history[9], wantValues)
+	}
+	if len(history[5]) != len(want) || len(history[194]) != len(want) {
+		t.Errorf("stored %d and %d readings of attributes 5 and 194, want %d", len(history[5]), len(history[194]), len(want))
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	InventoryJson = "json"
+	InventoryCsv  = "csv"
+)
+
+// InventoryRecord describes one disk for a CMDB: what it is, where it sits and whether it is
+// healthy, without its attribute readings.
+type InventoryRecord struct {
+	Ts        time.Time `json:"ts"`
+	Host      string    `json:"host"`
+	Device    string    `json:"device"`
+	Model     string    `json:"model,omitempty"`
+	Serial    string    `json:"serial,omitempty"`
+	Firmware  string    `json:"firmware,omitempty"`
+	MediaType string    `json:"media_type,omitempty"`
+	Transport string    `json:"transport,omitempty"`
+	SizeBytes uint64    `json:"size_bytes"`
+	// Enclosure and Slot locate the disk in an SES enclosure, on Linux
+	Enclosure string   `json:"enclosure,omitempty"`
+	Slot      string   `json:"slot,omitempty"`
+	Status    string   `json:"status"`
+	Reasons   []string `json:"reasons,omitempty"`
+}
+
+var inventoryCsvHeader = []string{"ts", "host", "device", "model", "serial", "firmware", "media_type", "transport", "size_bytes", "enclosure", "slot", "status", "reasons"}
+
+func (r InventoryRecord) csvRow() []string {
+	return []string{r.Ts.Format(time.RFC3339), r.Host, r.Device, r.Model, r.Serial, r.Firmware, r.MediaType, r.Transport,
+		strconv.FormatUint(r.SizeBytes, 10), r.Enclosure, r.Slot, r.Status, strings.Join(r.Reasons, "; ")}
+}
+
+// collectInventory collects the disks of the configured devices once each, partitions are
+// reported as the disk they are on.
+func collectInventory(conf Config) []InventoryRecord {
+	var disks []PartitionConfig
+	seen := make(map[string]bool)
+	for _, t := range discoverTargets(conf, time.Now()) {
+		if seen[deviceKey(t.smartPath)] {
+			continue
+		}
+		seen[deviceKey(t.smartPath)] = true
+		disk := t.device
+		disk.Path = t.smartPath
+		disks = append(disks, disk)
+	}
+	conf.Partitions = disks
+	conf.IncludeUnsupported = true
+
+	host, _ := os.Hostname()
+	var inventory []InventoryRecord
+	for _, record := range collectAll(conf) {
+		status, reasons := deviceStatus(record)
+		item := InventoryRecord{
+			Ts:        record.Ts,
+			Host:      host,
+			Device:    record.PartitionName,
+			Model:     record.Model,
+			Serial:    record.Serial,
+			Firmware:  record.Firmware,
+			MediaType: record.MediaType,
+			Transport: record.Transport,
+			SizeBytes: record.SizeBytes,
+			Status:    status,
+			Reasons:   reasons,
+		}
+		if record.Location != nil {
+			item.Enclosure, item.Slot = record.Location.Enclosure, record.Location.Slot
+		}
+		inventory = append(inventory, item)
+	}
+	return inventory
+}
+
+// runInventory implements `gosmart inventory`, which writes one record per disk instead of the
+// attribute readings, as JSON lines or CSV.
+func runInventory(args []string) {
+	fs := flag.NewFlagSet("inventory", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	format := fs.String("format", InventoryJson, "Output format: "+InventoryJson+" (one object per line) or "+InventoryCsv)
+	outPath := fs.String("o", "-", "Output file, - for stdout")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+	if *format != InventoryJson && *format != InventoryCsv {
+		fmt.Fprintf(os.Stderr, "unknown inventory format %q\n", *format)
+		os.Exit(2)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create %s: %s\n", *outPath, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	warnStatefulThresholds(conf, "inventory")
+	inventory := collectInventory(conf)
+	if *format == InventoryCsv {
+		w := csv.NewWriter(out)
+		_ = w.Write(inventoryCsvHeader)
+		for _, item := range inventory {
+			_ = w.Write(item.csvRow())
+		}
+		w.Flush()
+		err = w.Error()
+	} else {
+		enc := json.NewEncoder(out)
+		for _, item := range inventory {
+			if err = enc.Encode(item); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write inventory: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// latestColumns are the columns of the <table>_latest table, see DBConfig.LatestTable.
+const latestColumns = "uuid, partition_name, ts, label, mount_path, size_bytes, attributes, run_id, failed_lbas, agent_ts"
+
+// latestTableStatements create the latest table and fill it from the rows stored so far. Delta
+// encoded rows are skipped, a device whose latest row is a delta gets its last full row until
+// it is collected again.
+func latestTableStatements(conf DBConfig) []string {
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s_latest ( uuid text, partition_name text, ts timestamp with time zone, label text, mount_path text, size_bytes numeric, attributes JSONB, run_id text, failed_lbas bigint[], agent_ts timestamp with time zone, PRIMARY KEY (uuid, partition_name));", conf.Schema, conf.Table),
+		fmt.Sprintf("INSERT INTO %[1]s.%[2]s_latest (%[3]s) SELECT DISTINCT ON (uuid, partition_name) %[3]s FROM %[1]s.%[2]s WHERE uuid IS NOT NULL AND partition_name IS NOT NULL AND jsonb_typeof(attributes) = 'array' ORDER BY uuid, partition_name, ts DESC ON CONFLICT (uuid, partition_name) DO NOTHING;", conf.Schema, conf.Table, latestColumns),
+	}
+}
+
+// latestRows returns the newest of rows for each uuid and partition, a statement cannot upsert
+// the same row twice.
+func latestRows(rows []PartitionLineDb) []PartitionLineDb {
+	index := make(map[rowChainKey]int)
+	var latest []PartitionLineDb
+	for _, row := range rows {
+		key := rowChainKey{uuid: row.Uuid, partitionName: row.PartitionName}
+		if i, ok := index[key]; !ok {
+			index[key] = len(latest)
+			latest = append(latest, row)
+		} else if !row.Ts.Before(latest[i].Ts) {
+			latest[i] = row
+		}
+	}
+	return latest
+}
+
+// upsertLatest replaces the rows of the latest table that rows are newer than.
+func upsertLatest(db *sqlx.DB, conf DBConfig, rows []PartitionLineDb) error {
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	_, err := db.NamedExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %[1]s.%[2]s_latest (uuid, partition_name, ts, label, mount_path, size_bytes, attributes, run_id, failed_lbas, agent_ts) VALUES (:uuid, :partition_name, :ts, :label, :mount_path, :size_bytes, :attributes, :run_id, :failed_lbas, :agent_ts) ON CONFLICT (uuid, partition_name) DO UPDATE SET ts = excluded.ts, label = excluded.label, mount_path = excluded.mount_path, size_bytes = excluded.size_bytes, attributes = excluded.attributes, run_id = excluded.run_id, failed_lbas = excluded.failed_lbas, agent_ts = excluded.agent_ts WHERE %[1]s.%[2]s_latest.ts <= excluded.ts;`,
+			conf.Schema, conf.Table),
+		latestRows(rows))
+	return err
+}
+
+// deleteExpiredLatest deletes the devices of the latest table last seen before cutoff.
+func deleteExpiredLatest(db *sqlx.DB, conf DBConfig, cutoff time.Time) error {
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	_, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s.%s_latest WHERE ts < $1;", conf.Schema, conf.Table), cutoff)
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lbaLocation is where an LBA of a disk falls, and the files occupying it if any were found.
+type lbaLocation struct {
+	lba       uint64
+	partition string
+	// offset in bytes from the start of the partition
+	offset     uint64
+	filesystem string
+	mountPath  string
+	files      []string
+	// note explains a location without files, e.g. free space or filesystem metadata
+	note string
+}
+
+func (l lbaLocation) String() string {
+	if l.partition == "" {
+		return fmt.Sprintf("LBA %d: %s", l.lba, l.note)
+	}
+	s := fmt.Sprintf("LBA %d: %s at byte %d", l.lba, l.partition, l.offset)
+	if l.filesystem != "" {
+		s += fmt.Sprintf(", %s", l.filesystem)
+	}
+	if l.mountPath != "" {
+		s += fmt.Sprintf(" mounted on %s", l.mountPath)
+	}
+	if l.note != "" {
+		s += fmt.Sprintf(", %s", l.note)
+	}
+	for _, file := range l.files {
+		s += "\n  " + file
+	}
+	return s
+}
+
+// runLbaCommand resolves LBAs of a disk to its partitions and files, the LBAs given or those of
+// the failed self-tests and uncorrectable errors logged by the drive.
+func runLbaCommand(args []string) {
+	fs := flag.NewFlagSet("lba", flag.ExitOnError)
+	smartctlPath := fs.String("smartctl", "smartctl", "smartctl binary, to read the failed LBAs of the disk if none are given")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s lba [-smartctl path] disk [lba...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	disk := fs.Arg(0)
+	var lbas []uint64
+	for _, arg := range fs.Args()[1:] {
+		lba, err := strconv.ParseUint(strings.TrimSpace(arg), 0, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid LBA %q\n", arg)
+			os.Exit(2)
+		}
+		lbas = append(lbas, lba)
+	}
+	if len(lbas) == 0 {
+		line := collectAtaErrorLog(disk, PartitionLine{PartitionName: disk}, Config{SmartctlPath: *smartctlPath})
+		if len(line.FailedLbas) == 0 {
+			fmt.Printf("%s has no failed LBAs logged\n", disk)
+			return
+		}
+		lbas = line.FailedLbas
+	}
+
+	failed := 0
+	for _, lba := range lbas {
+		loc, err := resolveLba(disk, lba)
+		if err != nil {
+			fmt.Printf("LBA %d: %s\n", lba, err)
+			failed++
+			continue
+		}
+		fmt.Println(loc)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
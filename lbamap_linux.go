@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// resolveLba finds the partition holding lba of disk and the files occupying it. ext2/3/4 are
+// resolved with debugfs, mounted or not, btrfs through its chunk tree with btrfs-progs, and other
+// mounted filesystems by mapping the extents of every file with FIEMAP like filefrag.
+func resolveLba(disk string, lba uint64) (lbaLocation, error) {
+	loc := lbaLocation{lba: lba}
+	diskDir := sysfsBlockDir(disk)
+	if diskDir == "" {
+		return loc, fmt.Errorf("%s was not found in sysfs", disk)
+	}
+	sectorSize, err := readSysfsUint(filepath.Join(diskDir, "queue", "logical_block_size"))
+	if err != nil {
+		return loc, err
+	}
+
+	partDir, offset, err := lbaPartition(diskDir, lba*sectorSize)
+	if err != nil {
+		return loc, err
+	}
+	if partDir == "" {
+		loc.note = "not in any partition"
+		return loc, nil
+	}
+	loc.partition = devicePath(filepath.Base(partDir))
+	loc.offset = offset
+
+	devNum, err := os.ReadFile(filepath.Join(partDir, "dev"))
+	if err != nil {
+		return loc, fmt.Errorf("could not read the device number of %s: %w", loc.partition, err)
+	}
+	loc.mountPath, loc.filesystem = mountOf(strings.TrimSpace(string(devNum)))
+	if loc.filesystem == "" {
+		loc.filesystem = probeFilesystem(loc.partition, strings.TrimSpace(string(devNum)))
+	}
+
+	switch {
+	case loc.filesystem == "ext2" || loc.filesystem == "ext3" || loc.filesystem == "ext4":
+		err = resolveExtLba(&loc)
+	case loc.filesystem == "btrfs" && loc.mountPath != "":
+		err = resolveBtrfsLba(&loc)
+	case loc.mountPath != "":
+		err = resolveFiemapLba(&loc)
+	case loc.filesystem == "":
+		loc.note = "no filesystem found"
+	default:
+		loc.note = "mount the filesystem to find the files"
+	}
+	return loc, err
+}
+
+func readSysfsUint(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// lbaPartition returns the sysfs directory of the partition holding the byte offset of the disk,
+// and the offset within the partition. A disk without partitions holds it itself.
+func lbaPartition(diskDir string, offset uint64) (string, uint64, error) {
+	entries, err := os.ReadDir(diskDir)
+	if err != nil {
+		return "", 0, err
+	}
+	partitioned := false
+	for _, entry := range entries {
+		dir := filepath.Join(diskDir, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, "partition")); err != nil {
+			continue
+		}
+		partitioned = true
+		// start and size are in 512 byte sectors regardless of the logical block size
+		start, err := readSysfsUint(filepath.Join(dir, "start"))
+		if err != nil {
+			return "", 0, err
+		}
+		size, err := readSysfsUint(filepath.Join(dir, "size"))
+		if err != nil {
+			return "", 0, err
+		}
+		if offset >= start*512 && offset < (start+size)*512 {
+			return dir, offset - start*512, nil
+		}
+	}
+	if !partitioned {
+		return diskDir, offset, nil
+	}
+	return "", 0, nil
+}
+
+// mountOf returns the first mount point and filesystem type of the device major:minor.
+func mountOf(devNum string) (string, string) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root mount-point options [optional...] - fstype source options
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[2] != devNum || fields[3] != "/" {
+			continue
+		}
+		for i := 5; i < len(fields)-1; i++ {
+			if fields[i] == "-" {
+				return unescapeMountPath(fields[4]), fields[i+1]
+			}
+		}
+	}
+	return "", ""
+}
+
+// unescapeMountPath decodes the octal escapes of spaces, tabs, newlines and backslashes.
+func unescapeMountPath(path string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(path)
+}
+
+// probeFilesystem returns the filesystem type of an unmounted device as probed by udev, or by
+// blkid without udev, e.g. in containers.
+func probeFilesystem(device, devNum string) string {
+	if b, err := os.ReadFile("/run/udev/data/b" + devNum); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			if fsType, ok := strings.CutPrefix(line, "E:ID_FS_TYPE="); ok {
+				return fsType
+			}
+		}
+	}
+	out, err := exec.Command("blkid", "-o", "value", "-s", "TYPE", device).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func runDebugfs(device, request string) (string, error) {
+	out, err := exec.Command("debugfs", "-R", request, device).Output()
+	if err != nil {
+		return "", fmt.Errorf("debugfs %s failed: %w", request, err)
+	}
+	return string(out), nil
+}
+
+var debugfsBlockSize = regexp.MustCompile(`(?m)^Block size:\s+(\d+)`)
+
+// resolveExtLba looks up the inode owning the block with debugfs icheck, and its paths with ncheck.
+func resolveExtLba(loc *lbaLocation) error {
+	stats, err := runDebugfs(loc.partition, "stats")
+	if err != nil {
+		return err
+	}
+	m := debugfsBlockSize.FindStringSubmatch(stats)
+	if m == nil {
+		return fmt.Errorf("debugfs did not report the block size of %s", loc.partition)
+	}
+	blockSize, _ := strconv.ParseUint(m[1], 10, 64)
+	block := loc.offset / blockSize
+
+	// Block	Inode number
+	// 123456	12
+	out, err := runDebugfs(loc.partition, fmt.Sprintf("icheck %d", block))
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 2 || fields[1] == "<block" {
+		loc.note = fmt.Sprintf("block %d is free or filesystem metadata", block)
+		return nil
+	}
+	inode, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected debugfs icheck output: %s", lines[len(lines)-1])
+	}
+	// Inodes below 11 are reserved, e.g. the journal
+	if inode < 11 {
+		loc.note = fmt.Sprintf("block %d belongs to reserved inode %d", block, inode)
+		return nil
+	}
+
+	// Inode	Pathname
+	// 12	/path/to/file
+	out, err = runDebugfs(loc.partition, fmt.Sprintf("ncheck %d", inode))
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n")[1:] {
+		if _, path, ok := strings.Cut(line, "\t"); ok {
+			loc.files = append(loc.files, filepath.Join(loc.mountPath, path))
+		}
+	}
+	if len(loc.files) == 0 {
+		loc.note = fmt.Sprintf("block %d belongs to inode %d, which has no path", block, inode)
+	}
+	return nil
+}
+
+var (
+	btrfsDevid     = regexp.MustCompile(`(?m)^dev_item\.devid\s+(\d+)`)
+	btrfsChunkItem = regexp.MustCompile(`CHUNK_ITEM (\d+)\)`)
+	btrfsChunk     = regexp.MustCompile(`length (\d+) owner \d+ stripe_len \d+ type (\S+)`)
+	btrfsStripe    = regexp.MustCompile(`stripe \d+ devid (\d+) offset (\d+)`)
+)
+
+// resolveBtrfsLba maps the device offset to a btrfs logical address through the chunk tree, and
+// the logical address to files with logical-resolve. Striped profiles are not supported.
+func resolveBtrfsLba(loc *lbaLocation) error {
+	super, err := exec.Command("btrfs", "inspect-internal", "dump-super", loc.partition).Output()
+	if err != nil {
+		return fmt.Errorf("btrfs inspect-internal dump-super failed: %w", err)
+	}
+	m := btrfsDevid.FindSubmatch(super)
+	if m == nil {
+		return fmt.Errorf("btrfs did not report the device id of %s", loc.partition)
+	}
+	devid := string(m[1])
+
+	chunks, err := exec.Command("btrfs", "inspect-internal", "dump-tree", "-t", "chunk", loc.partition).Output()
+	if err != nil {
+		return fmt.Errorf("btrfs inspect-internal dump-tree failed: %w", err)
+	}
+	var chunkLogical, length uint64
+	var chunkType string
+	scanner := bufio.NewScanner(bytes.NewReader(chunks))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := btrfsChunkItem.FindStringSubmatch(line); m != nil {
+			chunkLogical, _ = strconv.ParseUint(m[1], 10, 64)
+		} else if m := btrfsChunk.FindStringSubmatch(line); m != nil {
+			length, _ = strconv.ParseUint(m[1], 10, 64)
+			chunkType = m[2]
+		} else if m := btrfsStripe.FindStringSubmatch(line); m != nil && m[1] == devid {
+			physical, _ := strconv.ParseUint(m[2], 10, 64)
+			if loc.offset < physical || loc.offset >= physical+length {
+				continue
+			}
+			if strings.Contains(chunkType, "RAID0") || strings.Contains(chunkType, "RAID10") ||
+				strings.Contains(chunkType, "RAID5") || strings.Contains(chunkType, "RAID6") {
+				loc.note = fmt.Sprintf("in a %s chunk, striped profiles are not supported", chunkType)
+				return nil
+			}
+			if !strings.HasPrefix(chunkType, "DATA") {
+				loc.note = fmt.Sprintf("in a %s chunk, filesystem metadata", chunkType)
+				return nil
+			}
+			return btrfsLogicalResolve(loc, chunkLogical+loc.offset-physical)
+		}
+	}
+	loc.note = "not allocated to any chunk"
+	return nil
+}
+
+func btrfsLogicalResolve(loc *lbaLocation, logical uint64) error {
+	out, err := exec.Command("btrfs", "inspect-internal", "logical-resolve", strconv.FormatUint(logical, 10), loc.mountPath).Output()
+	var exitErr *exec.ExitError
+	// logical-resolve fails when no file references the address, e.g. free space in the chunk
+	if errors.As(err, &exitErr) {
+		loc.note = fmt.Sprintf("logical address %d is not used by any file", logical)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("btrfs inspect-internal logical-resolve failed: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			loc.files = append(loc.files, line)
+		}
+	}
+	return nil
+}
+
+const (
+	fsIocFiemap      = 0xc020660b
+	fiemapExtentLast = 0x1
+	fiemapBatch      = 64
+)
+
+// fiemapRequest is struct fiemap of linux/fiemap.h with room for fiemapBatch extents.
+type fiemapRequest struct {
+	start, length              uint64
+	flags                      uint32
+	mappedExtents, extentCount uint32
+	_                          uint32
+	extents                    [fiemapBatch]fiemapExtent
+}
+
+type fiemapExtent struct {
+	logical, physical, length uint64
+	_                         [2]uint64
+	flags                     uint32
+	_                         [3]uint32
+}
+
+// resolveFiemapLba walks the mounted filesystem and maps the extents of every regular file, which
+// takes a while on large filesystems.
+func resolveFiemapLba(loc *lbaLocation) error {
+	root, err := os.Stat(loc.mountPath)
+	if err != nil {
+		return err
+	}
+	rootDev := root.Sys().(*syscall.Stat_t).Dev
+	err = filepath.WalkDir(loc.mountPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are skipped rather than ending the walk
+			return nil
+		}
+		if d.IsDir() {
+			// Stay on the filesystem, other mounts below it are on other devices
+			if info, err := d.Info(); err == nil && info.Sys().(*syscall.Stat_t).Dev != rootDev {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if fileOccupies(path, loc.offset) {
+			loc.files = append(loc.files, path)
+		}
+		return nil
+	})
+	if err == nil && len(loc.files) == 0 {
+		loc.note = "free space or filesystem metadata"
+	}
+	return err
+}
+
+// fileOccupies reports whether an extent of the file covers the byte offset of its device.
+func fileOccupies(path string, offset uint64) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	var start uint64
+	for {
+		req := fiemapRequest{start: start, length: math.MaxUint64, extentCount: fiemapBatch}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&req))); errno != 0 {
+			return false
+		}
+		if req.mappedExtents == 0 {
+			return false
+		}
+		for _, e := range req.extents[:req.mappedExtents] {
+			if offset >= e.physical && offset < e.physical+e.length {
+				return true
+			}
+			if e.flags&fiemapExtentLast != 0 {
+				return false
+			}
+			start = e.logical + e.length
+		}
+	}
+}
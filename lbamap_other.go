@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func resolveLba(disk string, lba uint64) (lbaLocation, error) {
+	return lbaLocation{lba: lba}, errors.New("resolving LBAs is only supported on Linux")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	lineMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	lineTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// recordLineProtocol renders a record's samples as InfluxDB line protocol, one line per sample with
+// the labels as tags and a single value field.
+func recordLineProtocol(record PartitionLine, dropLabels []string) []string {
+	samples := recordMetrics(record, dropLabels)
+	lines := make([]string, 0, len(samples))
+	ts := strconv.FormatInt(record.Ts.UnixNano(), 10)
+	for _, sample := range samples {
+		var b strings.Builder
+		b.WriteString(lineMeasurementEscaper.Replace(sample.Name))
+
+		keys := make([]string, 0, len(sample.Labels))
+		for k, v := range sample.Labels {
+			// line protocol has no empty tag values
+			if v != "" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteByte(',')
+			b.WriteString(lineTagEscaper.Replace(k))
+			b.WriteByte('=')
+			b.WriteString(lineTagEscaper.Replace(sample.Labels[k]))
+		}
+
+		b.WriteString(" value=")
+		b.WriteString(strconv.FormatFloat(sample.Value, 'f', -1, 64))
+		b.WriteByte(' ')
+		b.WriteString(ts)
+		lines = append(lines, b.String())
+	}
+	return lines
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// LoadCycleAlertConfig warns when attribute 193 (Load_Cycle_Count) grows faster than a rate. Drives
+// that park their heads after a few seconds of idle, like WD Greens, quietly wear out their
+// ramps this way. Unlike ExpectedPowerSettings.MaxLoadCyclesPerHour, which averages over the
+// drive's lifetime, the rate is measured over recent stored readings, so it needs the local store
+// or the postgres output, and attribute 193 must be collected.
+type LoadCycleAlertConfig struct {
+	MaxPerHour float64 `json:"max_per_hour"`
+	// WindowHours is how far back the stored reading the rate is measured from may be, 24 if 0
+	WindowHours int `json:"window_hours,omitempty"`
+}
+
+const (
+	loadCycleCountId = 193
+	// minLoadCycleSpan is the shortest span a rate is measured over, a handful of cycles over a
+	// few minutes would give wild rates
+	minLoadCycleSpan = time.Hour
+)
+
+// applyLoadCycleAlerts sets the load cycle rate of records from the oldest stored reading in the
+// window and warns on records above the limit.
+func applyLoadCycleAlerts(records []PartitionLine, alert LoadCycleAlertConfig, conf Config) []PartitionLine {
+	window := 24 * time.Hour
+	if alert.WindowHours > 0 {
+		window = time.Duration(alert.WindowHours) * time.Hour
+	}
+	source, err := openHistory(conf)
+	if err != nil {
+		log.Printf("Could not load history for load cycle rates: %s\n", err)
+		return records
+	}
+	defer source.Close()
+	rows, err := source.historySince(time.Now().Add(-window))
+	if err != nil {
+		log.Printf("Could not load history for load cycle rates: %s\n", err)
+		return records
+	}
+
+	type reading struct {
+		ts    time.Time
+		value uint64
+	}
+	// rows are oldest first, so the first reading of a partition is the start of its rate
+	start := make(map[string]reading)
+	for _, row := range rows {
+		if _, ok := start[row.PartitionName]; ok {
+			continue
+		}
+		attrs, err := row.attributes()
+		if err != nil {
+			continue
+		}
+		for _, attr := range attrs {
+			if attr.Id == loadCycleCountId {
+				start[row.PartitionName] = reading{ts: row.Ts, value: attr.value()}
+			}
+		}
+	}
+
+	for i := range records {
+		record := &records[i]
+		first, ok := start[record.PartitionName]
+		if !ok {
+			continue
+		}
+		for _, attr := range record.Attributes {
+			span := record.Ts.Sub(first.ts)
+			// The count going down means the drive was swapped under the same name
+			if attr.Id != loadCycleCountId || span < minLoadCycleSpan || attr.ValueDecoded < first.value {
+				continue
+			}
+			rate := float64(attr.ValueDecoded-first.value) / span.Hours()
+			record.LoadCyclesPerHour = &rate
+			if rate > alert.MaxPerHour {
+				record.warn("load cycle count grew %.1f per hour over the last %.1f hours, above %g", rate, span.Hours(), alert.MaxPerHour)
+			}
+		}
+	}
+	return records
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"slices"
+	"time"
+)
+
+// LocalConfig keeps a rolling window of readings in a file on the host, for history without an
+// external database.
+type LocalConfig struct {
+	// Path of the store, created if it does not exist
+	Path string `json:"path"`
+	// Readings kept per partition, 1000 if zero
+	Readings int `json:"readings,omitempty"`
+	// RetentionHours drops older readings, kept regardless of age if zero
+	RetentionHours int `json:"retention_hours,omitempty"`
+}
+
+// localReading is a stored reading, keyed by its timestamp in the bucket of its partition. The
+// identity of the partition is kept for syncing the reading to other outputs later.
+type localReading struct {
+	SchemaVersion int             `json:"schema_version,omitempty"`
+	Ts            time.Time       `json:"ts"`
+	Attributes    json.RawMessage `json:"attributes"`
+	Uuid          string          `json:"uuid,omitempty"`
+	Label         string          `json:"label,omitempty"`
+	MountPath     string          `json:"mount_path,omitempty"`
+	SizeBytes     uint64          `json:"size_bytes,omitempty"`
+}
+
+const defaultLocalReadings = 1000
+
+// openLocalStore opens the store, waiting for another gosmart process to close it.
+func openLocalStore(conf LocalConfig, readOnly bool) (*bbolt.DB, error) {
+	db, err := bbolt.Open(conf.Path, 0o644, &bbolt.Options{Timeout: 10 * time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("could not open local store %s: %w", conf.Path, err)
+	}
+	return db, nil
+}
+
+func localKey(ts time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(ts.UnixNano()))
+}
+
+// saveToLocal stores records in one transaction and drops the readings beyond the window.
+func saveToLocal(records []PartitionLine, conf LocalConfig) error {
+	db, err := openLocalStore(conf, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	readings := conf.Readings
+	if readings <= 0 {
+		readings = defaultLocalReadings
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		for _, record := range records {
+			attrs, err := json.Marshal(record.Attributes)
+			if err != nil {
+				return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+			}
+			value, err := json.Marshal(localReading{SchemaVersion: recordSchemaVersion, Ts: record.Ts, Attributes: attrs, Uuid: record.Uuid, Label: record.Label, MountPath: record.MountPath, SizeBytes: record.SizeBytes})
+			if err != nil {
+				return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+			}
+			bucket, err := tx.CreateBucketIfNotExists([]byte(record.PartitionName))
+			if err != nil {
+				return fmt.Errorf("local store write error for %s: %w", record.PartitionName, err)
+			}
+			if err := bucket.Put(localKey(record.Ts), value); err != nil {
+				return fmt.Errorf("local store write error for %s: %w", record.PartitionName, err)
+			}
+
+			// Keys sort by time, so the oldest readings come first
+			var keys [][]byte
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				keys = append(keys, k)
+			}
+			var drop [][]byte
+			excess := len(keys) - readings
+			for _, k := range keys {
+				expired := conf.RetentionHours > 0 && time.Since(time.Unix(0, int64(binary.BigEndian.Uint64(k)))) > time.Duration(conf.RetentionHours)*time.Hour
+				if len(drop) >= excess && !expired {
+					break
+				}
+				drop = append(drop, k)
+			}
+			for _, k := range drop {
+				if err := bucket.Delete(k); err != nil {
+					return fmt.Errorf("local store retention error for %s: %w", record.PartitionName, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// localHistory reads history from the local store.
+type localHistory struct {
+	db *bbolt.DB
+}
+
+func (h localHistory) attributeHistory(partitionName string, limit int) (map[uint8][]float64, error) {
+	var rows []historyRow
+	err := h.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(partitionName))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil && len(rows) < limit; k, v = c.Prev() {
+			row, err := localHistoryRow(partitionName, v)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(rows)
+	return attributeHistory(rows)
+}
+
+func (h localHistory) historySince(since time.Time) ([]historyRow, error) {
+	var rows []historyRow
+	err := h.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			if localMetaBucket(name) {
+				return nil
+			}
+			c := bucket.Cursor()
+			for k, v := c.Seek(localKey(since)); k != nil; k, v = c.Next() {
+				row, err := localHistoryRow(string(name), v)
+				if err != nil {
+					return err
+				}
+				rows = append(rows, row)
+			}
+			return nil
+		})
+	})
+	slices.SortStableFunc(rows, func(a, b historyRow) int { return a.Ts.Compare(b.Ts) })
+	return rows, err
+}
+
+func (h localHistory) Close() error {
+	return h.db.Close()
+}
+
+func localHistoryRow(partitionName string, value []byte) (historyRow, error) {
+	var reading localReading
+	if err := json.Unmarshal(value, &reading); err != nil {
+		return historyRow{}, fmt.Errorf("local store read error for %s: %w", partitionName, err)
+	}
+	return historyRow{PartitionName: partitionName, Ts: reading.Ts, Attributes: string(reading.Attributes)}, nil
+}
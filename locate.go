@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runLocate implements `gosmart locate`, which blinks the identify LED of the enclosure slot
+// holding a disk, given as a device path, an alias such as a /dev/disk/by-id link, or the serial
+// number of a configured disk.
+func runLocate(args []string) {
+	fs := flag.NewFlagSet("locate", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage+", to find disks by serial number")
+	off := fs.Bool("off", false, "Turn the LED off instead")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s locate [-f config] [-off] device|serial\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	device := fs.Arg(0)
+	if !strings.HasPrefix(device, "/") {
+		conf, err := loadConfig(*confFiPath)
+		if err != nil {
+			panic(fmt.Sprintf("Could not read config: %s\n", err))
+		}
+		disk, ok := diskBySerial(applyDefaults(conf), device)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "No configured disk has serial number %s\n", device)
+			os.Exit(1)
+		}
+		device = disk
+	}
+
+	if err := setLocateLed(device, !*off); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not set the locate LED: %s\n", err)
+		os.Exit(1)
+	}
+	state := "on"
+	if *off {
+		state = "off"
+	}
+	if location := readDriveLocation(device); location != nil {
+		fmt.Printf("Locate LED of %s (%s) %s\n", device, location, state)
+	} else {
+		fmt.Printf("Locate LED of %s %s\n", device, state)
+	}
+}
+
+// diskBySerial returns the disk of the configured devices with a serial number, which may also be
+// given as its anonymized hash.
+func diskBySerial(conf Config, serial string) (string, bool) {
+	if conf.Anonymize != nil && !strings.HasPrefix(serial, "anon-") {
+		serial = newAnonymizer(*conf.Anonymize).hash(serial)
+	}
+	for _, item := range collectInventory(conf) {
+		if item.Serial != "" && strings.EqualFold(item.Serial, serial) {
+			return item.Device, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"time"
+)
+
+// nextRun returns when the collection after one started at last is due. With align runs fall on
+// wall-clock multiples of interval, e.g. on the full hour for 1h, wherever the first run happened.
+func nextRun(last time.Time, interval time.Duration, align bool) time.Time {
+	if align {
+		return last.Truncate(interval).Add(interval)
+	}
+	return last.Add(interval)
+}
+
+// collectLoop calls collect immediately and then every interval, forever.
+func collectLoop(interval time.Duration, align bool, collect func()) {
+	for {
+		start := time.Now()
+		collect()
+		time.Sleep(time.Until(nextRun(start, interval, align)))
+	}
+}
@@ -1,46 +1,197 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"github.com/anatol/smart.go"
-	"github.com/jaypipes/ghw"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// defaultJsonIndent is used by --pretty
+const defaultJsonIndent = "  "
+
 const (
 	OutputJson     = "json"
 	OutputTable    = "table"
 	OutputPostgres = "postgres"
+	OutputRedis    = "redis"
+	OutputVictoria = "victoriametrics"
+	OutputGcm      = "gcm"
+	OutputAzure    = "azure"
+	OutputNewRelic = "newrelic"
+	OutputIcinga   = "icinga"
+	OutputSensu    = "sensu"
+	OutputFile     = "file"
+	OutputAvro     = "avro"
+	OutputProtobuf = "protobuf"
+	OutputLocal    = "local"
+	OutputDuckdb   = "duckdb"
+	OutputBigQuery = "bigquery"
+	OutputArchive  = "archive"
+	// OutputCommunity is opt-in only, it is never a default
+	OutputCommunity = "community"
 )
 
-type Attr smart.AtaSmartAttr
+// outputTypeNames are the output types writeRecord handles, besides plugin outputs, in the order
+// the usage lists them.
+var outputTypeNames = []string{
+	OutputJson, OutputTable, OutputPostgres, OutputRedis, OutputVictoria, OutputGcm, OutputAzure,
+	OutputNewRelic, OutputIcinga, OutputSensu, OutputFile, OutputAvro, OutputProtobuf, OutputLocal,
+	OutputDuckdb, OutputBigQuery, OutputArchive, OutputCommunity,
+}
+
+const (
+	BackendSmartGo  = "smartgo"
+	BackendSmartctl = "smartctl"
+	// BackendFake serves the canned payloads of Config.FakeDevices instead of reading hardware
+	BackendFake = "fake"
+)
+
+const (
+	DiscoveryBlock  = "block"
+	DiscoveryDirect = "direct"
+)
+
+// Attr is a SMART attribute as reported in records.
+type Attr struct {
+	AtaSmartAttr
+	// ValueDecoded is ValueRaw after vendor specific decoding, comparable across drive brands
+	ValueDecoded uint64
+	Temperature  *Temperature `json:",omitempty"`
+	// Unit of ValueDecoded: count, sectors, hours or celsius, empty if unknown
+	Unit string `json:",omitempty"`
+	// metricType tells metric outputs whether ValueDecoded is a counter or a gauge
+	metricType string
+}
+
+// Temperature is a decoded temperature attribute in degrees Celsius. Min and Max are only present
+// when the drive records them.
+type Temperature struct {
+	Current int
+	Min     *int `json:",omitempty"`
+	Max     *int `json:",omitempty"`
+}
 
 type PartitionLine struct {
-	Uuid          string               `json:"uuid" db:"uuid"`
-	Ts            time.Time            `json:"ts" db:"ts"`
-	PartitionName string               `json:"partition_name" db:"partition_name"`
-	Label         string               `json:"label" db:"label"`
-	MountPath     string               `json:"mount_path" db:"mount_path"`
-	SizeBytes     uint64               `json:"size_bytes" db:"size_bytes"`
-	Attributes    []smart.AtaSmartAttr `json:"attributes" db:"attributes"`
+	// SchemaVersion is recordSchemaVersion in rendered records
+	SchemaVersion int       `json:"schema_version" db:"-"`
+	Uuid          string    `json:"uuid" db:"uuid"`
+	Ts            time.Time `json:"ts" db:"ts"`
+	PartitionName string    `json:"partition_name" db:"partition_name"`
+	Label         string    `json:"label" db:"label"`
+	MountPath     string    `json:"mount_path" db:"mount_path"`
+	SizeBytes     uint64    `json:"size_bytes" db:"size_bytes"`
+	Model         string    `json:"model,omitempty" db:"-"`
+	Serial        string    `json:"serial,omitempty" db:"-"`
+	Firmware      string    `json:"firmware,omitempty" db:"-"`
+	// Location is the enclosure slot of the drive, on Linux for drives in SES enclosures
+	Location *DriveLocation `json:"location,omitempty" db:"-"`
+	// Multipath is the multipath device the drive is reached through, on Linux
+	Multipath *MultipathInfo `json:"multipath,omitempty" db:"-"`
+	// PhysicalDrive is the drive SMART was read from when it is not the partition itself, on
+	// Windows the \\.\PHYSICALDRIVEn holding a volume such as D:
+	PhysicalDrive string `json:"physical_drive,omitempty" db:"-"`
+	// Guests are the VMs and containers stored on the drive, on hypervisor hosts
+	Guests []Guest `json:"guests,omitempty" db:"-"`
+	// Zfs are the pools the drive is a member of, with collect_zfs
+	Zfs []ZfsMember `json:"zfs,omitempty" db:"-"`
+	// MediaType is ssd or hdd when the drive reports its rotation rate
+	MediaType  string `json:"media_type,omitempty" db:"-"`
+	Attributes []Attr `json:"attributes" db:"attributes"`
+	// UnsupportedAttributes lists requested attribute IDs the device does not report
+	UnsupportedAttributes []int `json:"unsupported_attributes,omitempty" db:"-"`
+	// Transport is set for NVMe devices, anything but pcie is an NVMe-oF namespace
+	Transport  string      `json:"transport,omitempty" db:"-"`
+	NvmeHealth *NvmeHealth `json:"nvme_health,omitempty" db:"-"`
+	// NvmeOcpSmart and NvmeVendorSmart are only collected with CollectNvmeExtendedLogs
+	NvmeOcpSmart    *NvmeOcpSmart    `json:"nvme_ocp_smart,omitempty" db:"-"`
+	NvmeVendorSmart []NvmeVendorAttr `json:"nvme_vendor_smart,omitempty" db:"-"`
+	PcieLink        *PcieLink        `json:"pcie_link,omitempty" db:"-"`
+	// NvmeSanitize is only collected with CollectNvmeSanitize
+	NvmeSanitize *NvmeSanitizeStatus `json:"nvme_sanitize,omitempty" db:"-"`
+	// SmartSupported is false for devices reported without SMART data, SkipReason says why.
+	// Deferred devices were not collected because the run budget ran out.
+	SmartSupported   bool              `json:"smart_supported" db:"-"`
+	SkipReason       string            `json:"skip_reason,omitempty" db:"-"`
+	Deferred         bool              `json:"deferred,omitempty" db:"-"`
+	SctErc           *SctErc           `json:"sct_erc,omitempty" db:"-"`
+	DeviceStatistics []DeviceStatistic `json:"device_statistics,omitempty" db:"-"`
+	AtaErrorLog      *AtaErrorLog      `json:"ata_error_log,omitempty" db:"-"`
+	// Capabilities are the SMART features the drive supports, with collect_capabilities
+	Capabilities *SmartCapabilities `json:"capabilities,omitempty" db:"-"`
+	// PowerSettings are the APM, AAM and cache settings, with collect_power_settings
+	PowerSettings *PowerSettings `json:"power_settings,omitempty" db:"-"`
+	// AtaSecurity is the security feature set state of ATA drives, with collect_ata_security
+	AtaSecurity *AtaSecurity `json:"ata_security,omitempty" db:"-"`
+	// Filesystem is the usage of the mounted filesystem, with collect_filesystem_usage
+	Filesystem *FilesystemUsage `json:"filesystem,omitempty" db:"-"`
+	// DiskStats are the I/O counters of the device, with collect_disk_stats
+	DiskStats *DiskStats `json:"disk_stats,omitempty" db:"-"`
+	// FailedLbas are the first failed sectors of logged self-tests and uncorrectable errors
+	FailedLbas []uint64 `json:"failed_lbas,omitempty" db:"failed_lbas"`
+	// SilencedUntil is the end of the silence covering the device, its alerts are muted until then
+	SilencedUntil *time.Time `json:"silenced_until,omitempty" db:"-"`
+	// Predictions are the estimated dates attributes reach their limits, with failure_prediction
+	Predictions []FailurePrediction `json:"predictions,omitempty" db:"-"`
+	// AgentTs is the timestamp by the local clock when Ts was corrected for clock skew
+	AgentTs *time.Time `json:"agent_ts,omitempty" db:"-"`
+	// Acknowledged is set while the alert of the device is acknowledged
+	Acknowledged *Acknowledgement `json:"acknowledged,omitempty" db:"-"`
+	// Backblaze is how the model performed in the Backblaze drive stats, with backblaze
+	Backblaze *BackblazeModel `json:"backblaze,omitempty" db:"-"`
+	// LoadCyclesPerHour is the recent growth of attribute 193, with load_cycle_alert
+	LoadCyclesPerHour *float64 `json:"load_cycles_per_hour,omitempty" db:"-"`
+	// Warnings lists settings or readings that differ from configured expectations
+	Warnings []string `json:"warnings,omitempty" db:"-"`
+	// Advisories lists known issues of the drive model and firmware
+	Advisories []string `json:"advisories,omitempty" db:"-"`
+	// Error is set when the device could not be collected
+	Error *CollectionError `json:"error,omitempty" db:"-"`
+	// Run is the collection run the record belongs to
+	Run *RunInfo `json:"run,omitempty" db:"-"`
+	// Cloud is the instance the record was collected on, with cloud_metadata configured
+	Cloud *CloudInstance `json:"cloud,omitempty" db:"-"`
+	// CollectSeconds is how long reading the device took
+	CollectSeconds float64 `json:"collect_seconds,omitempty" db:"-"`
+	// rawAttributes is the attribute table as read, before selection and decoding, for --record
+	rawAttributes map[uint8]AtaSmartAttr
+}
+
+// warn records a warning on the line and logs it.
+func (p *PartitionLine) warn(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	log.Printf("%s: %s\n", p.PartitionName, msg)
+	p.Warnings = append(p.Warnings, msg)
 }
 
 type PartitionLineDb struct {
-	Uuid          string       `db:"uuid"`
-	Ts            time.Time    `db:"ts"`
-	PartitionName string       `db:"partition_name"`
-	Label         string       `db:"label"`
-	MountPath     string       `db:"mount_path"`
-	SizeBytes     uint64       `db:"size_bytes"`
-	Attributes    driver.Value `db:"attributes"`
+	Uuid          string        `db:"uuid"`
+	Ts            time.Time     `db:"ts"`
+	PartitionName string        `db:"partition_name"`
+	Label         string        `db:"label"`
+	MountPath     string        `db:"mount_path"`
+	SizeBytes     uint64        `db:"size_bytes"`
+	Attributes    driver.Value  `db:"attributes"`
+	RunId         *string       `db:"run_id"`
+	FailedLbas    pq.Int64Array `db:"failed_lbas"`
+	// AgentTs is the timestamp by the clock of the collecting host, Ts unless it was corrected.
+	// The database sets received_at itself.
+	AgentTs time.Time `db:"agent_ts"`
+	// Severity is the severity of the record before silences and acknowledgements
+	Severity string `db:"severity"`
 }
 
 func (p *PartitionLine) partitionLineToDb() PartitionLineDb {
@@ -48,6 +199,19 @@ func (p *PartitionLine) partitionLineToDb() PartitionLineDb {
 	//attrStr := string(attrs)
 	//attrStr = strings.Replace(attrStr, ":", "::", -1)
 	//attrJson, _ := types.JSONText(attrs).Value()
+	var runId *string
+	if p.Run != nil {
+		runId = &p.Run.Id
+	}
+	// LBAs are 48 bit, so they fit a bigint
+	var failedLbas pq.Int64Array
+	for _, lba := range p.FailedLbas {
+		failedLbas = append(failedLbas, int64(lba))
+	}
+	agentTs := p.Ts
+	if p.AgentTs != nil {
+		agentTs = *p.AgentTs
+	}
 	return PartitionLineDb{
 		Uuid:          p.Uuid,
 		Ts:            p.Ts,
@@ -56,14 +220,203 @@ func (p *PartitionLine) partitionLineToDb() PartitionLineDb {
 		MountPath:     p.MountPath,
 		SizeBytes:     p.SizeBytes,
 		Attributes:    string(attrs),
+		RunId:         runId,
+		FailedLbas:    failedLbas,
+		AgentTs:       agentTs,
+		Severity:      storedSeverity(*p),
 	}
 }
 
 type Config struct {
-	Db         *DBConfig `json:"db,omitempty"`
-	Attributes []uint8   `json:"attributes,omitempty"`
-	Partitions []string  `json:"partitions"`
-	OutputType string    `json:"output_type,omitempty"`
+	Db              *DBConfig              `json:"db,omitempty"`
+	Redis           *RedisConfig           `json:"redis,omitempty"`
+	VictoriaMetrics *VictoriaMetricsConfig `json:"victoriametrics,omitempty"`
+	Gcm             *GcmConfig             `json:"gcm,omitempty"`
+	Azure           *AzureConfig           `json:"azure,omitempty"`
+	NewRelic        *NewRelicConfig        `json:"newrelic,omitempty"`
+	Community       *CommunityConfig       `json:"community,omitempty"`
+	Icinga          *IcingaConfig          `json:"icinga,omitempty"`
+	Sensu           *SensuConfig           `json:"sensu,omitempty"`
+	File            *FileConfig            `json:"file,omitempty"`
+	Avro            *AvroConfig            `json:"avro,omitempty"`
+	Protobuf        *ProtobufConfig        `json:"protobuf,omitempty"`
+	Duckdb          *DuckdbConfig          `json:"duckdb,omitempty"`
+	BigQuery        *BigQueryConfig        `json:"bigquery,omitempty"`
+	Archive         *ArchiveConfig         `json:"archive,omitempty"`
+	Grafana         *GrafanaConfig         `json:"grafana,omitempty"`
+	Retry           *RetryConfig           `json:"retry,omitempty"`
+	Local           *LocalConfig           `json:"local,omitempty"`
+	Priority        *PriorityConfig        `json:"priority,omitempty"`
+	CloudMetadata   *CloudMetadataConfig   `json:"cloud_metadata,omitempty"`
+	Attributes      []uint8                `json:"attributes,omitempty"`
+	Partitions      []PartitionConfig      `json:"partitions"`
+	OutputType      string                 `json:"output_type,omitempty"`
+	// OutputTypes writes every record to each of these outputs instead of OutputType
+	OutputTypes  []string `json:"output_types,omitempty"`
+	Backend      string   `json:"backend,omitempty"`
+	SmartctlPath string   `json:"smartctl_path,omitempty"`
+	Discovery    string   `json:"discovery,omitempty"`
+	// AttributeNames overrides the display name of attribute IDs on all drives, ModelAttributeNames
+	// does the same for drives with the given model and takes precedence.
+	AttributeNames      map[uint8]string            `json:"attribute_names,omitempty"`
+	ModelAttributeNames map[string]map[uint8]string `json:"model_attribute_names,omitempty"`
+	// RawDecodeRules are checked before the built-in vendor rules
+	RawDecodeRules     []RawDecodeRule `json:"raw_decode_rules,omitempty"`
+	SkipZeroAttributes bool            `json:"skip_zero_attributes,omitempty"`
+	// NvmeFabrics controls whether NVMe-oF namespaces are collected over the fabric or skipped
+	NvmeFabrics string `json:"nvme_fabrics,omitempty"`
+	// EnableSmart turns on SMART and attribute autosave on drives that support SMART but have it
+	// disabled, which otherwise are reported without SMART data
+	EnableSmart bool `json:"enable_smart,omitempty"`
+	// IncludeUnsupported reports devices that have no SMART data to read, e.g. SCSI devices or
+	// skipped NVMe-oF namespaces, with smart_supported false instead of leaving them out. Records
+	// without SMART data are then also saved to Postgres, DuckDB and the local store, so the
+	// output covers every configured disk.
+	IncludeUnsupported bool `json:"include_unsupported,omitempty"`
+	// AttemptVirtualDevices reads loop, zram, rbd, nbd, virtio, xen and iSCSI devices and disks
+	// emulated by hypervisors instead of reporting them as not supporting SMART. SCSI disks passed
+	// through to a VM are always read.
+	AttemptVirtualDevices bool `json:"attempt_virtual_devices,omitempty"`
+	// CollectSctErc reads SCT Error Recovery Control timeouts of ATA drives through smartctl, and
+	// warns when they differ from ExpectedSctErc if set
+	CollectSctErc  bool    `json:"collect_sct_erc,omitempty"`
+	ExpectedSctErc *SctErc `json:"expected_sct_erc,omitempty"`
+	// CollectDeviceStatistics reads the ATA Device Statistics log through smartctl
+	CollectDeviceStatistics bool `json:"collect_device_statistics,omitempty"`
+	// CollectErrorLog reads the most recent entries of the ATA SMART error log and the self-test
+	// log through smartctl, and reports the LBAs of uncorrectable errors and failed self-tests
+	CollectErrorLog bool `json:"collect_error_log,omitempty"`
+	// CollectCapabilities reads which self-tests and logs ATA and NVMe drives support through
+	// smartctl
+	CollectCapabilities bool `json:"collect_capabilities,omitempty"`
+	// CollectPowerSettings reads the APM and AAM levels, write cache and read look-ahead of ATA
+	// drives through smartctl, and warns when they differ from ExpectedPowerSettings if set
+	CollectPowerSettings  bool                   `json:"collect_power_settings,omitempty"`
+	ExpectedPowerSettings *ExpectedPowerSettings `json:"expected_power_settings,omitempty"`
+	// LoadCycleAlert warns when the load cycle count grows faster than a rate
+	LoadCycleAlert *LoadCycleAlertConfig `json:"load_cycle_alert,omitempty"`
+	// FailurePrediction estimates when attributes reach their limits from their trend
+	FailurePrediction *FailurePredictionConfig `json:"failure_prediction,omitempty"`
+	// Backblaze compares drives with the failure rates of their models in a local copy of the
+	// Backblaze drive stats
+	Backblaze *BackblazeConfig `json:"backblaze,omitempty"`
+	// CollectFilesystemUsage reads the size, free space and inodes of mounted partitions
+	CollectFilesystemUsage bool `json:"collect_filesystem_usage,omitempty"`
+	// CollectDiskStats reads the I/O counters of devices from /proc/diskstats, only on Linux
+	CollectDiskStats bool `json:"collect_disk_stats,omitempty"`
+	// CollectZfs adds the ZFS pools disks are members of, with their error counts and the last
+	// scrub or resilver, from zpool status
+	CollectZfs bool `json:"collect_zfs,omitempty"`
+	// RecordDir is where --record writes the SMART data of every device as read
+	RecordDir string `json:"record_dir,omitempty"`
+	// FakeDevices are canned SMART payloads keyed by device path, served by the fake backend to
+	// exercise the pipeline without hardware
+	FakeDevices map[string]FakeDevice `json:"fake_devices,omitempty"`
+	// CollectNvmeExtendedLogs reads the OCP extended SMART log and known vendor SMART logs of NVMe
+	// drives, only supported by the smartgo backend on Linux
+	CollectNvmeExtendedLogs bool `json:"collect_nvme_extended_logs,omitempty"`
+	// CollectNvmeSanitize reads the sanitize status log of NVMe drives and warns while a sanitize
+	// runs, only supported by the smartgo backend on Linux. BlockSelfTestsDuringSanitize refuses to
+	// start self-tests on drives being sanitized, through serve, burnin or offline collection.
+	CollectNvmeSanitize          bool `json:"collect_nvme_sanitize,omitempty"`
+	BlockSelfTestsDuringSanitize bool `json:"block_self_tests_during_sanitize,omitempty"`
+	// CollectAtaSecurity reads whether ATA drives are security frozen or locked and their secure
+	// erase time estimates, only supported by the smartgo backend on Linux
+	CollectAtaSecurity bool `json:"collect_ata_security,omitempty"`
+	// ExpectedFirmware maps drive models to their approved firmware versions
+	ExpectedFirmware map[string][]string `json:"expected_firmware,omitempty"`
+	// JsonIndent indents the json output with this string, compact if empty
+	JsonIndent string `json:"json_indent,omitempty"`
+	// FailurePolicy decides what happens when devices cannot be collected: continue writes the
+	// other devices and exits zero (the default), exit_nonzero does the same but exits 1, abort
+	// writes nothing and exits 1. Only single runs exit, --interval and serve keep running.
+	FailurePolicy string `json:"failure_policy,omitempty"`
+	// Display formats the numbers of the table output, --watch and the tui for a locale
+	Display *DisplayConfig `json:"display,omitempty"`
+	// OutputProbe checks at startup that every output can be reached or written to: warn logs
+	// those that cannot, require exits instead and off skips the check. serve and --interval
+	// probe with warn unless set, single runs only probe when it is set.
+	OutputProbe string `json:"output_probe,omitempty"`
+	// PluginDir is searched for output plugins, written to as plugin:<name> outputs, instead of
+	// the default directories, see pluginDirs. Plugins are given their entry of Plugins as config.
+	PluginDir string                     `json:"plugin_dir,omitempty"`
+	Plugins   map[string]json.RawMessage `json:"plugins,omitempty"`
+	// SparklineReadings is how many stored readings the table, watch and tui outputs draw attribute
+	// sparklines from when a local store or database is configured, 20 if zero and none if negative
+	SparklineReadings int `json:"sparkline_readings,omitempty"`
+	// TimestampFormat renders record timestamps in the json, table, file and Redis outputs as
+	// local (RFC3339 in local time, the default), utc (RFC3339 in UTC) or unix (epoch seconds).
+	// Metric outputs always use the epoch timestamps their protocols require.
+	TimestampFormat string `json:"timestamp_format,omitempty"`
+	// SinkLimits rate limits and batches writes, keyed by output type
+	SinkLimits map[string]SinkLimit `json:"sink_limits,omitempty"`
+	// MinSeverity routes records by severity, keyed by output type: outputs only receive records
+	// at or above info, warning or critical. Check outputs like Icinga and Sensu then do not hear
+	// of devices that recovered.
+	MinSeverity map[string]string `json:"min_severity,omitempty"`
+	// Silences are maintenance windows, see Silence. SilencesPath is the file silences created
+	// through the serve API at /silences are kept in, they are applied to every collection reading
+	// it, so serve and single runs share them.
+	Silences     []Silence    `json:"silences,omitempty"`
+	SilencesPath string       `json:"silences_path,omitempty"`
+	Serve        *ServeConfig `json:"serve,omitempty"`
+	// ThresholdTemplates are checked against every drive they match, see ThresholdTemplate
+	ThresholdTemplates []ThresholdTemplate `json:"threshold_templates,omitempty"`
+	// CompositeRules combine attributes with and, or and not, see CompositeRule
+	CompositeRules []CompositeRule `json:"composite_rules,omitempty"`
+	// AttributeUnits overrides or adds units of attribute IDs, see attributeUnits
+	AttributeUnits map[uint8]string `json:"attribute_units,omitempty"`
+	// OutputAttributes filters the attributes written to an output, keyed by output type, with
+	// prometheus for the serve /metrics endpoint. E.g. Postgres can keep every collected attribute
+	// while Prometheus only exports a few.
+	OutputAttributes map[string]AttributeFilter `json:"output_attributes,omitempty"`
+	// DropMetricLabels are labels left out of the metric outputs: prometheus, victoriametrics, gcm,
+	// azure, newrelic and execd. Partition uuid, label and mount_path give every volume its own
+	// series; they are still written to the database and JSON outputs.
+	DropMetricLabels []string `json:"drop_metric_labels,omitempty"`
+	// AttributeMetricTypes overrides or adds whether attribute IDs are a counter or a gauge in metric
+	// outputs, see attributeCounters
+	AttributeMetricTypes map[uint8]string `json:"attribute_metric_types,omitempty"`
+	// Advisories are known model issues checked in addition to the built-in advisories.json
+	Advisories []Advisory `json:"advisories,omitempty"`
+	// Sync sends the readings of the local store to other outputs when they can be reached
+	Sync *SyncConfig `json:"sync,omitempty"`
+	// Anonymize hashes serial numbers and WWNs before records are written anywhere
+	Anonymize *AnonymizeConfig `json:"anonymize,omitempty"`
+	// DetectReplacements records a replacement when the serial number at a device path changes
+	// between collections, in the local store or database, see Replacement
+	DetectReplacements bool `json:"detect_replacements,omitempty"`
+	// Hypervisor names the guests stored on each disk, see HypervisorConfig
+	Hypervisor *HypervisorConfig `json:"hypervisor,omitempty"`
+	// RunSummaryPath is where a JSON summary of every run is written: devices attempted, succeeded
+	// and failed, alerting devices and the records delivered to each output. A path is replaced on
+	// every run, fd:N appends a line to the open file descriptor N.
+	RunSummaryPath string `json:"run_summary_path,omitempty"`
+	// RunBudgetSeconds bounds the time a run spends collecting, including discovery and retries.
+	// Once it runs out the remaining devices are reported as deferred without SMART data, and a
+	// device still being read is abandoned, so cron jobs and supervised daemons finish in time
+	// even when a drive hangs.
+	RunBudgetSeconds int `json:"run_budget_seconds,omitempty"`
+	// Include lists files or glob patterns of configs merged over this one, see resolveIncludes
+	Include []string `json:"include,omitempty"`
+}
+
+// PartitionConfig selects a device to collect. In the config file it may be given either as a plain
+// device path or as an object with per-device options.
+type PartitionConfig struct {
+	Path    string `json:"path"`
+	Backend string `json:"backend,omitempty"`
+}
+
+func (p *PartitionConfig) UnmarshalJSON(b []byte) error {
+	var path string
+	if err := json.Unmarshal(b, &path); err == nil {
+		p.Path = path
+		return nil
+	}
+
+	type partitionConfig PartitionConfig
+	return json.Unmarshal(b, (*partitionConfig)(p))
 }
 
 type DBConfig struct {
@@ -75,185 +428,992 @@ type DBConfig struct {
 	Table              string `json:"table"`
 	Initialize         bool   `json:"initialize,omitempty"`
 	DataRetentionHours *int   `json:"data_retention_hours,omitempty"`
+	// RetentionHoursBySeverity keeps the rows of a severity (warning or critical, before silences
+	// and acknowledgements) for their own time instead of DataRetentionHours, e.g. readings that
+	// alerted for a year and healthy ones for a month. Rows written before Initialize added the
+	// severity column count as info.
+	RetentionHoursBySeverity map[string]int `json:"retention_hours_by_severity,omitempty"`
+	// Dialect is postgres (the default) or cockroachdb, which creates the tables outside of a
+	// transaction and deletes expired rows in batches, see TestIntegrationCockroach. The retries of
+	// transactions failing with 40001 are not covered by it, a single client rarely conflicts.
+	Dialect string `json:"dialect,omitempty"`
+	// PgBouncer sends each parameterized query in a single round trip with the unnamed statement,
+	// so the connection works through PgBouncer or other poolers in transaction pooling mode, see
+	// TestIntegrationPgBouncer.
+	PgBouncer bool `json:"pgbouncer,omitempty"`
+	// CorrectClockSkew moves the timestamps of records by the offset of the local clock from the
+	// database clock, if it is more than ClockSkewToleranceSeconds (60 if 0). Rows keep the local
+	// timestamp in agent_ts either way.
+	CorrectClockSkew          bool `json:"correct_clock_skew,omitempty"`
+	ClockSkewToleranceSeconds int  `json:"clock_skew_tolerance_seconds,omitempty"`
+	// DeltaAttributes stores only the attributes that changed since the previous row of a device,
+	// as {"changed": [...]} instead of the array of all attributes, and every DeltaKeyframeRows
+	// rows (100 if 0) all of them. gosmart reads the full attributes back, SQL of your own over
+	// the attributes column has to skip rows where jsonb_typeof(attributes) is not 'array'.
+	DeltaAttributes   bool `json:"delta_attributes,omitempty"`
+	DeltaKeyframeRows int  `json:"delta_keyframe_rows,omitempty"`
+	// LatestTable keeps the latest row of each uuid and partition, with all attributes, in
+	// <table>_latest, so dashboards and fleet read the current state without scanning the
+	// history. The table is created and filled by Initialize.
+	LatestTable bool `json:"latest_table,omitempty"`
+	// MaxOpenConns and MaxIdleConns bound the connections of one process, unlimited and 2 if 0.
+	// ConnMaxLifetimeSeconds closes connections older than this, never if 0.
+	MaxOpenConns           int `json:"max_open_conns,omitempty"`
+	MaxIdleConns           int `json:"max_idle_conns,omitempty"`
+	ConnMaxLifetimeSeconds int `json:"conn_max_lifetime_seconds,omitempty"`
+	// StatementTimeoutSeconds makes the server cancel statements running longer, the server's
+	// setting if 0. It is sent when connecting, which PgBouncer only accepts if statement_timeout
+	// is in its ignore_startup_parameters.
+	StatementTimeoutSeconds int `json:"statement_timeout_seconds,omitempty"`
+	// TimeoutSeconds bounds connecting and each statement or transaction on the client side, so a
+	// hung connection cannot stall collection, 60 if 0 and none if negative
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
 }
 
-func saveToPostgresDB(record PartitionLine, conf DBConfig) {
+func connectPostgres(conf DBConfig) (*sqlx.DB, error) {
 	connStr := fmt.Sprintf("postgresql://%s:%s@%s:%d/postgres?sslmode=disable", conf.Username, conf.Password, conf.Host, conf.Port)
-	db, err := sqlx.Connect("postgres", connStr)
+	if conf.PgBouncer {
+		// Otherwise lib/pq parses the unnamed statement and executes it in two round trips, and a
+		// transaction pooler may hand the second to a server connection that never saw the first
+		connStr += "&binary_parameters=yes"
+	}
+	if conf.StatementTimeoutSeconds > 0 {
+		// lib/pq sends parameters it does not know as run-time parameters, in milliseconds here
+		connStr += fmt.Sprintf("&statement_timeout=%d", conf.StatementTimeoutSeconds*1000)
+	}
+	connector, err := pq.NewConnector(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w; %s", err, connStr)
+	}
+	if timeout := dbTimeout(conf); timeout > 0 {
+		connector.Dialer(deadlineDialer{timeout: timeout})
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "postgres")
+	ctx, cancel := dbContext(conf)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%w; %s", err, connStr)
+	}
+	if conf.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(conf.MaxOpenConns)
+	}
+	if conf.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(conf.MaxIdleConns)
+	}
+	if conf.ConnMaxLifetimeSeconds > 0 {
+		db.SetConnMaxLifetime(time.Duration(conf.ConnMaxLifetimeSeconds) * time.Second)
+	}
+	return db, nil
+}
+
+func saveToPostgresDB(record PartitionLine, conf DBConfig) error {
+	return saveBatchToPostgresDB([]PartitionLine{record}, conf)
+}
+
+// saveBatchToPostgresDB inserts records in a single statement and applies the retention rule.
+func saveBatchToPostgresDB(records []PartitionLine, conf DBConfig) error {
+	db, err := connectPostgres(conf)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v; %s", err, connStr)
+		return fmt.Errorf("postgres connection error: %w", err)
 	}
+	defer db.Close()
 
 	if conf.Initialize {
-		initTx := db.MustBegin()
-		initTx.MustExec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", conf.Schema))
-		initTx.MustExec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s ( uuid text, ts timestamp with time zone, partition_name text, label text, mount_path text, size_bytes numeric, attributes JSONB);", conf.Schema, conf.Table))
-		if err != nil {
+		if err := initializeTables(db, conf); err != nil {
 			log.Println(err)
-			_ = initTx.Rollback()
 		} else {
 			fmt.Println("Committing Initialization")
-			_ = initTx.Commit()
 		}
 	}
 
-	towrite := record.partitionLineToDb()
+	if conf.CorrectClockSkew {
+		records = correctClockSkew(records, db, conf)
+	}
+	towrite := make([]PartitionLineDb, 0, len(records))
+	for _, record := range records {
+		towrite = append(towrite, record.partitionLineToDb())
+	}
+	// The latest table always holds all attributes
+	latest := slices.Clone(towrite)
+	if conf.DeltaAttributes {
+		if err := encodeAttributeDeltas(db, conf, towrite); err != nil {
+			log.Printf("Storing all attributes, could not read the previous rows: %v\n", err)
+		}
+	}
 
-	tx := db.MustBegin()
-	res, err := tx.NamedExec(
-		fmt.Sprintf(`INSERT INTO %s.%s (uuid, ts, partition_name, label, mount_path, size_bytes, attributes) VALUES (:uuid, :ts, :partition_name, :label, :mount_path, :size_bytes, :attributes);`,
-			conf.Schema, conf.Table),
-		&towrite)
-	if err != nil {
-		log.Println(err)
-		_ = tx.Rollback()
+	var rows int64
+	insertErr := runTx(db, conf, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.NamedExecContext(ctx,
+			fmt.Sprintf(`INSERT INTO %s.%s (uuid, ts, partition_name, label, mount_path, size_bytes, attributes, run_id, failed_lbas, agent_ts, severity) VALUES (:uuid, :ts, :partition_name, :label, :mount_path, :size_bytes, :attributes, :run_id, :failed_lbas, :agent_ts, :severity) ON CONFLICT DO NOTHING;`,
+				conf.Schema, conf.Table),
+			towrite)
+		if err != nil {
+			return err
+		}
+		rows, _ = res.RowsAffected()
+		return nil
+	})
+	if insertErr != nil {
+		log.Println(insertErr)
 	} else {
-		rows, _ := res.RowsAffected()
 		fmt.Printf("Commiting %d rows\n", rows)
-		_ = tx.Commit()
+		if skipped := int64(len(towrite)) - rows; skipped > 0 {
+			fmt.Printf("Skipped %d readings already stored\n", skipped)
+		}
+	}
+
+	// Like the runs table, the latest table is only created by Initialize
+	if insertErr == nil && conf.LatestTable {
+		if err := upsertLatest(db, conf, latest); err != nil {
+			log.Printf("Failed to update the latest table: %v\n", err)
+		}
 	}
 
-	if conf.DataRetentionHours != nil {
-		if *conf.DataRetentionHours <= 0 {
-			println("data retention days must be greater than zero if present, skipping")
+	// The runs table is only created by Initialize, rows are kept if it is missing
+	if runs := recordRuns(records); insertErr == nil && len(runs) > 0 {
+		ctx, cancel := dbContext(conf)
+		_, err = db.NamedExecContext(ctx,
+			fmt.Sprintf(`INSERT INTO %s.%s_runs (run_id, started, duration_seconds, version, devices, errors, event, event_detail) VALUES (:run_id, :started, :duration_seconds, :version, :devices, :errors, :event, :event_detail) ON CONFLICT (run_id) DO NOTHING;`,
+				conf.Schema, conf.Table),
+			runs)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to save run metadata: %v\n", err)
+		}
+	}
+
+	applyRetention(db, conf)
+	return insertErr
+}
+
+// collectDevice reads the configured SMART data from devName and fills it into line. It returns
+// false if the device could not be read or is not a supported type.
+func collectDevice(devName string, line PartitionLine, device PartitionConfig, conf Config) (PartitionLine, bool) {
+	line, ok := readDevice(devName, line, device, conf)
+	if conf.RecordDir != "" {
+		if err := recordFixture(conf.RecordDir, line, ok); err != nil {
+			log.Printf("could not record fixture of %s: %s\n", line.PartitionName, err)
+		}
+	}
+	// Fake devices have no filesystem, counters or logs to read, only the checks on their data run
+	fake := device.Backend == BackendFake
+	if conf.CollectFilesystemUsage && !fake {
+		line = collectFilesystemUsage(line)
+	}
+	if !fake {
+		line.Location = readDriveLocation(devName)
+	}
+	if conf.CollectDiskStats && !fake {
+		line = collectDiskStats(line)
+	}
+	if !ok || !line.SmartSupported {
+		return line, ok
+	}
+
+	if expected, ok := conf.ExpectedFirmware[line.Model]; ok && !slices.Contains(expected, line.Firmware) {
+		line.warn("firmware %s of %s is not one of the expected versions %v", line.Firmware, line.Model, expected)
+	}
+	line = applyThresholdTemplates(line, conf.ThresholdTemplates)
+	line = applyAdvisories(line, conf.Advisories)
+	if fake {
+		return line, true
+	}
+	if line.NvmeHealth != nil && line.Transport == NvmeTransportPcie {
+		line = collectPcieLink(devName, line)
+	}
+	if conf.CollectSctErc && line.NvmeHealth == nil {
+		line = collectSctErc(devName, line, conf)
+	}
+	if conf.CollectDeviceStatistics && line.NvmeHealth == nil {
+		line = collectDeviceStatistics(devName, line, conf)
+	}
+	if conf.CollectErrorLog && line.NvmeHealth == nil {
+		line = collectAtaErrorLog(devName, line, conf)
+	}
+	if conf.CollectPowerSettings && line.NvmeHealth == nil {
+		line = collectPowerSettings(devName, line, conf)
+	}
+	if conf.CollectCapabilities {
+		line = collectSmartCapabilities(devName, line, conf)
+	}
+	return line, true
+}
+
+// readDevice reads SMART attributes or NVMe health from devName with the device's backend. Virtual
+// devices are reported without SMART data unless AttemptVirtualDevices is set.
+func readDevice(devName string, line PartitionLine, device PartitionConfig, conf Config) (PartitionLine, bool) {
+	if kind := virtualDeviceKind(devName); kind != "" && !conf.AttemptVirtualDevices {
+		line.SkipReason = fmt.Sprintf("%s device, no SMART support", kind)
+		return line, true
+	}
+
+	line.Transport = nvmeTransport(devName)
+	fabric := line.Transport != "" && line.Transport != NvmeTransportPcie
+	if fabric && conf.NvmeFabrics == NvmeFabricsSkip {
+		fmt.Printf("skipping %s: NVMe-oF namespace over %s\n", devName, line.Transport)
+		return line, false
+	}
+
+	backend, ok := deviceBackends[device.Backend]
+	if !ok {
+		backend = deviceBackends[defaultBackend]
+	}
+	return backend.ReadDevice(devName, line, conf)
+}
+
+// writeRecord writes a record to the output. It returns an error if the output did not accept it.
+func writeRecord(results PartitionLine, outputType string, conf Config) error {
+	if outputType == OutputJson {
+		j, err := marshalRecord(results, conf)
+		if err != nil {
+			return fmt.Errorf("json output error for %s: %w", results.PartitionName, err)
+		}
+		fmt.Println(string(j))
+
+	} else if outputType == OutputTable {
+		printTable(results, conf)
+	} else if outputType == OutputPostgres {
+		if conf.Db == nil {
+			println("No DB config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else if !savesRecord(results, conf) {
+			fmt.Printf("Not saving %s without SMART data: %s\n", results.PartitionName, results.SkipReason)
+		} else {
+			return saveToPostgresDB(results, *conf.Db)
+		}
+	} else if outputType == OutputRedis {
+		if conf.Redis == nil {
+			println("No Redis config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToRedis(results, *conf.Redis, conf.TimestampFormat)
+		}
+	} else if outputType == OutputVictoria {
+		if conf.VictoriaMetrics == nil {
+			println("No VictoriaMetrics config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToVictoriaMetrics(results, *conf.VictoriaMetrics, conf.DropMetricLabels)
+		}
+	} else if outputType == OutputGcm {
+		// GCM needs no config, everything is taken from the Application Default Credentials
+		gcm := GcmConfig{}
+		if conf.Gcm != nil {
+			gcm = *conf.Gcm
+		}
+		return saveToGcm(results, gcm, conf.DropMetricLabels)
+	} else if outputType == OutputAzure {
+		if conf.Azure == nil {
+			println("No Azure config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToAzure(results, *conf.Azure, conf.DropMetricLabels)
+		}
+	} else if outputType == OutputNewRelic {
+		if conf.NewRelic == nil {
+			println("No New Relic config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToNewRelic(results, *conf.NewRelic, conf.DropMetricLabels)
+		}
+	} else if outputType == OutputCommunity {
+		if conf.Community == nil {
+			println("No community config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToCommunity(results, *conf.Community, conf.Anonymize != nil)
+		}
+	} else if outputType == OutputIcinga {
+		if conf.Icinga == nil {
+			println("No Icinga config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToIcinga(results, *conf.Icinga)
+		}
+	} else if outputType == OutputSensu {
+		// Without config events go to the local agent
+		sensu := SensuConfig{}
+		if conf.Sensu != nil {
+			sensu = *conf.Sensu
+		}
+		return saveToSensu(results, sensu)
+	} else if outputType == OutputFile {
+		if conf.File == nil {
+			println("No file config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToFile(results, *conf.File, conf.TimestampFormat)
+		}
+	} else if outputType == OutputAvro {
+		if conf.Avro == nil {
+			println("No Avro config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToAvro(results, *conf.Avro)
+		}
+	} else if outputType == OutputProtobuf {
+		if conf.Protobuf == nil {
+			println("No protobuf config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToProtobuf(results, *conf.Protobuf)
+		}
+	} else if outputType == OutputLocal {
+		if conf.Local == nil {
+			println("No local store config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else if !savesRecord(results, conf) {
+			fmt.Printf("Not saving %s without SMART data: %s\n", results.PartitionName, results.SkipReason)
+		} else {
+			return saveToLocal([]PartitionLine{results}, *conf.Local)
+		}
+	} else if outputType == OutputDuckdb {
+		if conf.Duckdb == nil {
+			println("No DuckDB config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else if !savesRecord(results, conf) {
+			fmt.Printf("Not saving %s without SMART data: %s\n", results.PartitionName, results.SkipReason)
+		} else {
+			return saveToDuckdb([]PartitionLine{results}, *conf.Duckdb)
+		}
+	} else if outputType == OutputBigQuery {
+		if conf.BigQuery == nil {
+			println("No BigQuery config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToBigQuery([]PartitionLine{results}, *conf.BigQuery)
+		}
+	} else if outputType == OutputArchive {
+		if conf.Archive == nil {
+			println("No archive config, printing json")
+			return writeRecord(results, OutputJson, conf)
+		} else {
+			return saveToArchive([]PartitionLine{results}, *conf.Archive, conf.TimestampFormat)
+		}
+	} else if _, ok := pluginName(outputType); ok {
+		return runPlugin(PluginWrite, []PartitionLine{results}, outputType, conf)
+	}
+	return nil
+}
+
+func printTable(results PartitionLine, conf Config) {
+	println(results.PartitionName)
+	if ts, err := formatTimestamp(results.Ts, conf.TimestampFormat); err == nil {
+		fmt.Printf("Collected: %v\n", ts)
+	}
+	histories := loadHistories(conf, []PartitionLine{results}, sparklineReadings(conf))
+	for _, line := range tableLines(results, histories[results.PartitionName], newNumberFormat(conf.Display)) {
+		fmt.Println(line)
+	}
+	println()
+}
+
+// tableLines renders the details of a record for the table output, with a sparkline of each
+// attribute's stored readings if history is given and numbers formatted by f.
+func tableLines(results PartitionLine, history map[uint8][]float64, f numberFormat) []string {
+	var lines []string
+	if results.Error != nil {
+		lines = append(lines, fmt.Sprintf("Collection error (%s): %s", results.Error.Class, results.Error.Message))
+	} else if !results.SmartSupported {
+		lines = append(lines, fmt.Sprintf("No SMART data: %s", results.SkipReason))
+	}
+	if results.Location != nil {
+		lines = append(lines, fmt.Sprintf("Location: %s", results.Location))
+	}
+	if results.Multipath != nil {
+		lines = append(lines, fmt.Sprintf("Multipath: %s", results.Multipath))
+	}
+	if results.PhysicalDrive != "" {
+		lines = append(lines, "Physical drive: "+results.PhysicalDrive)
+	}
+	if guests := guestsAtRisk(results); guests != "" {
+		lines = append(lines, "Guests: "+strings.TrimPrefix(guests, "guests at risk: "))
+	}
+	if results.SilencedUntil != nil {
+		lines = append(lines, fmt.Sprintf("Silenced until %s", results.SilencedUntil.Format(time.RFC3339)))
+	}
+	if ack := results.Acknowledged; ack != nil {
+		lines = append(lines, fmt.Sprintf("Acknowledged %s %s", ack.Ts.Format(time.RFC3339), ack.Comment))
+	}
+	// Usage and I/O are read without SMART data too
+	if results.Filesystem != nil {
+		lines = append(lines, "Filesystem: "+results.Filesystem.format(f))
+	}
+	if results.DiskStats != nil {
+		lines = append(lines, "I/O: "+results.DiskStats.format(f))
+	}
+	for _, member := range results.Zfs {
+		lines = append(lines, fmt.Sprintf("ZFS: %s", member))
+	}
+	if results.Error != nil || !results.SmartSupported {
+		return lines
+	}
+	if len(results.UnsupportedAttributes) > 0 {
+		lines = append(lines, fmt.Sprintf("Unsupported attributes: %v", results.UnsupportedAttributes))
+	}
+	if results.Model != "" {
+		lines = append(lines, fmt.Sprintf("Model: %s, firmware %s", results.Model, results.Firmware))
+	}
+	for _, warning := range results.Warnings {
+		lines = append(lines, fmt.Sprintf("Warning: %s", warning))
+	}
+	for _, advisory := range results.Advisories {
+		lines = append(lines, fmt.Sprintf("Advisory: %s", advisory))
+	}
+	for _, prediction := range results.Predictions {
+		lines = append(lines, fmt.Sprintf("Prediction: %s", prediction))
+	}
+	if results.Backblaze != nil {
+		lines = append(lines, fmt.Sprintf("Backblaze: %s", results.Backblaze))
+	}
+	if results.Capabilities != nil {
+		lines = append(lines, fmt.Sprintf("Capabilities: %s", results.Capabilities))
+	}
+	if results.SctErc != nil {
+		lines = append(lines, fmt.Sprintf("SCT ERC: %s", results.SctErc))
+	}
+	if results.PowerSettings != nil {
+		lines = append(lines, fmt.Sprintf("Power: %s", results.PowerSettings))
+	}
+	if results.AtaSecurity != nil {
+		lines = append(lines, fmt.Sprintf("Security: %s", results.AtaSecurity))
+	}
+	for _, stat := range results.DeviceStatistics {
+		lines = append(lines, fmt.Sprintf("%s: %s", stat.Name, f.int(stat.Value)))
+	}
+	if errorLog := results.AtaErrorLog; errorLog != nil {
+		lines = append(lines, fmt.Sprintf("Error log: %s errors", f.int(int64(errorLog.Count))))
+		for _, entry := range errorLog.Entries {
+			lines = append(lines, "  "+entry.String())
+		}
+	}
+	if len(results.FailedLbas) > 0 {
+		lines = append(lines, fmt.Sprintf("Failed LBAs: %s", formatLbas(results.FailedLbas)))
+	}
+	if results.NvmeHealth != nil {
+		h := results.NvmeHealth
+		lines = append(lines, fmt.Sprintf("NVMe %s: temperature %s %s %s, spare %d%% (threshold %d%%), used %d%%, media errors %s, critical warning %#x",
+			results.Transport, f.temperature(h.TemperatureC), f.temperatureUnit(), f.temperatures(h.TemperatureSensorsC), h.AvailableSpare, h.AvailableSpareThreshold, h.PercentageUsed, f.uint(h.MediaErrors), h.CriticalWarning))
+	}
+	if link := results.PcieLink; link != nil {
+		lines = append(lines, fmt.Sprintf("PCIe link: %s GT/s x%d (max %s GT/s x%d)", f.float(link.CurrentSpeed, -1), link.CurrentWidth, f.float(link.MaxSpeed, -1), link.MaxWidth))
+	}
+	if ocp := results.NvmeOcpSmart; ocp != nil {
+		lines = append(lines, fmt.Sprintf("OCP: bad user NAND blocks %s, uncorrectable reads %s, thermal throttling events %d, PCIe correctable errors %s",
+			f.uint(ocp.BadUserNandBlocks), f.uint(ocp.UncorrectableReadErrors), ocp.ThermalThrottlingEvents, f.uint(ocp.PcieCorrectableErrors)))
+	}
+	for _, attr := range results.NvmeVendorSmart {
+		lines = append(lines, fmt.Sprintf("%s: %d/%s", attr.Name, attr.Normalized, f.uint(attr.Raw)))
+	}
+	if results.NvmeSanitize != nil {
+		lines = append(lines, fmt.Sprintf("Sanitize: %s", results.NvmeSanitize))
+	}
+	lines = append(lines, "Current/Raw")
+	for _, attr := range results.Attributes {
+		var line string
+		if t := attr.Temperature; t != nil && t.Min != nil {
+			line = fmt.Sprintf("%d (%s): %d/%s %s (min %s, max %s)", attr.Id, attr.Name, attr.Current, f.temperature(t.Current), f.temperatureUnit(), f.temperature(*t.Min), f.temperature(*t.Max))
+		} else if t != nil {
+			line = fmt.Sprintf("%d (%s): %d/%s %s", attr.Id, attr.Name, attr.Current, f.temperature(t.Current), f.temperatureUnit())
+		} else if attr.ValueDecoded != attr.ValueRaw {
+			line = fmt.Sprintf("%d (%s): %d/%s (decoded %s)", attr.Id, attr.Name, attr.Current, f.uint(attr.ValueRaw), f.uint(attr.ValueDecoded))
 		} else {
-			cutoffTime := time.Now().Add(-1 * time.Hour * time.Duration(*conf.DataRetentionHours))
-			txDel := db.MustBegin()
-			delQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE ts < '%s';", conf.Schema, conf.Table, cutoffTime.Format(time.RFC3339))
-			res := txDel.MustExec(delQuery)
+			line = fmt.Sprintf("%d (%s): %d/%s", attr.Id, attr.Name, attr.Current, f.uint(attr.ValueRaw))
+		}
+		if trend := attributeTrend(history, attr); trend != "" {
+			line += "  " + trend
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseCollectArgs builds a Config for `gosmart collect [flags] device...` so single devices can be
+// checked without a config file. Flags may appear before or after the devices.
+func parseCollectArgs(args []string) (Config, time.Duration) {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	output := fs.String("output", OutputJson, fmt.Sprintf("Output type: %s or %s<name>", strings.Join(outputTypeNames, ", "), OutputPluginPrefix))
+	attributes := fs.String("attributes", "", "Comma separated SMART attribute IDs to read (default 5,187,188,197,198)")
+	skipZero := fs.Bool("skip-zero", false, "Leave out attributes with a zero raw value")
+	direct := fs.Bool("direct", false, "Open the devices directly instead of discovering them through sysfs")
+	backend := fs.String("backend", "", fmt.Sprintf("Collection backend: %s or %s (default %s)", BackendSmartGo, BackendSmartctl, defaultBackend))
+	pretty := fs.Bool("pretty", false, "Indent JSON output")
+	watch := fs.Duration("watch", 0, "Refresh the table output on this interval, highlighting changed values")
+	failurePolicy := fs.String("failure-policy", FailureContinue, fmt.Sprintf("When devices fail: %s, %s or %s", FailureContinue, FailureExitNonzero, FailureAbort))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s collect [flags] device...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var devices []string
+	for {
+		_ = fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		devices = append(devices, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(devices) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
 
+	conf := Config{OutputType: *output, Backend: *backend, SkipZeroAttributes: *skipZero, FailurePolicy: *failurePolicy}
+	if *pretty {
+		conf.JsonIndent = defaultJsonIndent
+	}
+	if *direct {
+		conf.Discovery = DiscoveryDirect
+	}
+	for _, device := range devices {
+		conf.Partitions = append(conf.Partitions, PartitionConfig{Path: device})
+	}
+	if *attributes != "" {
+		for _, s := range strings.Split(*attributes, ",") {
+			attrNum, err := strconv.ParseUint(strings.TrimSpace(s), 10, 8)
 			if err != nil {
-				log.Println(err)
-				_ = txDel.Rollback()
-			} else {
-				rows, _ := res.RowsAffected()
-				fmt.Printf("Deleted %d rows since %s by retention rule\n", rows, cutoffTime.Format(time.RFC3339))
-				_ = txDel.Commit()
+				fmt.Fprintf(fs.Output(), "invalid attribute id %q\n", s)
+				os.Exit(2)
 			}
+			conf.Attributes = append(conf.Attributes, uint8(attrNum))
+		}
+	}
+	return conf, *watch
+}
+
+// configFileName is the name of the config file searched for without -f.
+const configFileName = "conf.json"
+
+const configFlagUsage = "Config File Path or URL, or - to read from stdin. Without it conf.json is searched in the working directory, the user config directory, /etc/gosmart and next to the binary"
+
+// configSearchPaths are the locations searched for the config file when none is given, in order.
+func configSearchPaths() []string {
+	paths := []string{configFileName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "gosmart", configFileName))
+	}
+	if runtime.GOOS != "windows" {
+		paths = append(paths, filepath.Join("/etc/gosmart", configFileName))
+	}
+	if exe, err := os.Executable(); err == nil {
+		paths = append(paths, filepath.Join(filepath.Dir(exe), configFileName))
+	}
+	return paths
+}
+
+// findConfig returns the first of the search paths that exists.
+func findConfig() (string, error) {
+	paths := configSearchPaths()
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
 		}
 	}
+	return "", fmt.Errorf("no %s found, searched %s", configFileName, strings.Join(paths, ", "))
+}
+
+// loadConfig reads the config file at path, stdin if path is -, or a URL, see fetchRemoteConfig.
+// An empty path searches the standard locations.
+func loadConfig(path string) (Config, error) {
+	var confFi io.Reader = os.Stdin
+	if path == "" {
+		found, err := findConfig()
+		if err != nil {
+			return Config{}, err
+		}
+		path = found
+	}
+	if isRemoteConfig(path) {
+		jsonBytes, err := fetchRemoteConfig(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", redactedUrl(path), err)
+		}
+		return parseConfig(jsonBytes, ".", redactedUrl(path))
+	}
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return Config{}, err
+		}
+		defer f.Close()
+		confFi = f
+		log.Printf("Using config file %s\n", path)
+	}
+
+	jsonBytes, err := io.ReadAll(confFi)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := "."
+	if path != "-" {
+		dir = filepath.Dir(path)
+	}
+	return parseConfig(jsonBytes, dir, path)
+}
+
+// parseConfig decodes a config read from path, with its includes relative to dir.
+func parseConfig(jsonBytes []byte, dir string, path string) (Config, error) {
+	var conf Config
+	jsonBytes, err := resolveIncludes(jsonBytes, dir)
+	if err != nil {
+		return conf, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := json.Unmarshal(jsonBytes, &conf); err != nil {
+		return conf, fmt.Errorf("%s: %w", path, err)
+	}
+	return conf, nil
 }
 
 // https://www.backblaze.com/blog/what-smart-stats-indicate-hard-drive-failures/
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "collect":
+			conf, watch := parseCollectArgs(os.Args[2:])
+			applyPriority(conf.Priority)
+			if watch > 0 {
+				runWatch(conf, watch)
+			}
+			os.Exit(failureExitCode(conf.FailurePolicy, run(conf)))
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "summary":
+			runSummary(os.Args[2:])
+			return
+		case "fleet":
+			runFleet(os.Args[2:])
+			return
+		case "snmp":
+			runSnmp(os.Args[2:])
+			return
+		case "schema":
+			runSchema(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "tui":
+			runTui(os.Args[2:])
+			return
+		case "smart":
+			runSmartCommand(os.Args[2:])
+			return
+		case "lba":
+			runLbaCommand(os.Args[2:])
+			return
+		case "ack":
+			runAckCommand(os.Args[2:])
+			return
+		case "bundle":
+			runBundle(os.Args[2:])
+			return
+		case "inventory":
+			runInventory(os.Args[2:])
+			return
+		case "locate":
+			runLocate(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "burnin":
+			runBurnin(os.Args[2:])
+			return
+		case "verify-replacement":
+			runVerifyReplacement(os.Args[2:])
+			return
+		case "retire":
+			runRetire(os.Args[2:])
+			return
+		case "archive":
+			runArchiveCommand(os.Args[2:])
+			return
+		case "plugins":
+			runPluginsCommand(os.Args[2:])
+			return
+		case "pause", "resume":
+			runPauseCommand(os.Args[1], os.Args[2:])
+			return
+		}
+	}
+
 	// Load Config
-	confFiPath := flag.String("f", "conf.json", "Config File Path")
+	confFiPath := flag.String("f", "", configFlagUsage)
+	execd := flag.Bool("execd", false, "Run persistently as a Telegraf execd input, collecting on every stdin newline or SIGUSR1")
+	once := flag.Bool("once", false, "Collect once and exit, the default")
+	interval := flag.Duration("interval", 0, "Collect immediately and then on this interval until stopped")
+	align := flag.Bool("align", false, "With --interval, run on wall-clock multiples of the interval")
+	pretty := flag.Bool("pretty", false, "Indent JSON output, overriding json_indent")
+	watch := flag.Duration("watch", 0, "Refresh the table output on this interval, highlighting changed values")
+	record := flag.String("record", "", "Write the SMART data of every device as read to fixtures in this directory")
+	replay := flag.String("replay", "", "Collect from the fixtures in this directory instead of the devices")
 	flag.Parse()
 
-	confFi, _ := os.Open(*confFiPath)
-
-	var conf Config
-	jsonBytes, _ := io.ReadAll(confFi)
+	if *once && *interval > 0 {
+		fmt.Fprintln(os.Stderr, "--once and --interval are mutually exclusive")
+		os.Exit(2)
+	}
+	if *watch > 0 && (*once || *interval > 0 || *execd) {
+		fmt.Fprintln(os.Stderr, "--watch cannot be combined with --once, --interval or --execd")
+		os.Exit(2)
+	}
 
-	err := json.Unmarshal(jsonBytes, &conf)
+	conf, err := loadConfig(*confFiPath)
 	if err != nil {
-		panic(fmt.Sprintf("Could not read Config File %s: %s\n", *confFiPath, err))
-	} else {
-		fmt.Printf("Using config file %s\n", *confFiPath)
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	if *pretty {
+		conf.JsonIndent = defaultJsonIndent
+	}
+	if *record != "" {
+		conf.RecordDir = *record
+	}
+	if *replay != "" {
+		if conf, err = applyReplay(conf, *replay); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load fixtures: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	applyPriority(conf.Priority)
+	if *execd {
+		runExecd(conf)
+		return
+	}
+	if *watch > 0 {
+		runWatch(conf, *watch)
 	}
 
-	// Set Defaults
-	attrListToRead := []uint8{5, 187, 188, 197, 198}
-	outputType := OutputJson
+	if *interval > 0 || conf.OutputProbe != "" {
+		checkOutputsReady(applyDefaults(conf))
+	}
+	if *interval > 0 {
+		collectLoop(*interval, *align, func() { run(conf) })
+	}
+	if stateful := statefulThresholds(conf.ThresholdTemplates); len(stateful) > 0 {
+		fmt.Fprintf(os.Stderr, "Thresholds %s need earlier collections, which a single run does not keep: run with --interval or --execd, or serve\n", strings.Join(stateful, ", "))
+		os.Exit(2)
+	}
+	os.Exit(failureExitCode(conf.FailurePolicy, run(conf)))
+}
 
-	if conf.Attributes != nil {
-		attrListToRead = conf.Attributes
+// run collects and writes all devices once, returning the number of devices that failed.
+func run(conf Config) int {
+	conf = applyDefaults(conf)
+	started := time.Now()
+	records := collectAll(conf)
+	failed := 0
+	for _, record := range records {
+		if record.Error != nil {
+			failed++
+		}
 	}
-	if conf.OutputType != "" {
-		outputType = conf.OutputType
+	if failed > 0 && conf.FailurePolicy == FailureAbort {
+		fmt.Printf("%d of %d devices failed, aborting without writing\n", failed, len(records))
+		summary := summarizeRun(records, nil, started)
+		summary.Aborted = true
+		writeRunSummary(conf.RunSummaryPath, summary)
+		return failed
 	}
-	partitionList := make(map[string]bool)
-	for _, partition := range conf.Partitions {
-		partitionList[partition] = true
 
+	outputs := newOutputSet(conf)
+	for _, results := range records {
+		if err := outputs.Write(results); err != nil {
+			fmt.Println(err)
+		}
+	}
+	if err := outputs.Flush(); err != nil {
+		fmt.Println(err)
 	}
+	sinks := outputs.status()
+	for _, status := range sinks {
+		log.Printf("output %s\n", status)
+	}
+	writeRunSummary(conf.RunSummaryPath, summarizeRun(records, sinks, started))
+	syncLocalStore(conf)
+	detectReplacements(records, conf)
+	return failed
+}
 
-	runTs := time.Now()
+func applyDefaults(conf Config) Config {
+	if conf.Attributes == nil {
+		conf.Attributes = []uint8{5, 187, 188, 197, 198}
+	}
+	if conf.OutputType == "" {
+		conf.OutputType = OutputJson
+	}
+	if conf.Backend == "" {
+		conf.Backend = defaultBackend
+	}
+	if conf.SmartctlPath == "" {
+		conf.SmartctlPath = "smartctl"
+	}
+	if conf.NvmeFabrics == "" {
+		conf.NvmeFabrics = NvmeFabricsCollect
+	}
+	switch conf.FailurePolicy {
+	case "":
+		conf.FailurePolicy = FailureContinue
+	case FailureContinue, FailureExitNonzero, FailureAbort:
+	default:
+		log.Printf("unknown failure policy %q, continuing\n", conf.FailurePolicy)
+		conf.FailurePolicy = FailureContinue
+	}
+	switch conf.OutputProbe {
+	case "", ProbeWarn, ProbeRequire, ProbeOff:
+	default:
+		log.Printf("unknown output probe %q, warning about outputs that are not ready\n", conf.OutputProbe)
+		conf.OutputProbe = ProbeWarn
+	}
+	for i, partition := range conf.Partitions {
+		if partition.Backend == "" {
+			conf.Partitions[i].Backend = conf.Backend
+		}
+		if _, ok := deviceBackends[conf.Partitions[i].Backend]; !ok {
+			log.Printf("unknown backend %q for %s, using %s\n", conf.Partitions[i].Backend, partition.Path, defaultBackend)
+			conf.Partitions[i].Backend = defaultBackend
+		}
+	}
+	return conf
+}
 
-	// Get all Block Storage devices
-	block, err := ghw.Block()
-	if err != nil {
-		panic(err)
+// discoverTargets returns the configured devices found with the configured discovery, each device
+// once however many paths the config reaches it through.
+func discoverTargets(conf Config, runTs time.Time) []target {
+	if conf.Discovery == DiscoveryDirect {
+		return dedupeTargets(discoverDirect(conf.Partitions, runTs))
 	}
 
-	// Check each disk partition
-	for _, disk := range block.Disks {
-		for _, p := range disk.Partitions {
-			// Skip disks we don't care about
-			devName := "/dev/" + p.Name
-			if !partitionList[devName] {
+	partitionList := make(map[string]PartitionConfig)
+	for _, partition := range conf.Partitions {
+		partitionList[deviceKey(canonicalDevicePath(partition.Path))] = partition
+	}
+	return dedupeTargets(discoverBlockDevices(partitionList, runTs))
+}
+
+// collectAll discovers the configured devices and collects a record for each readable one.
+func collectAll(conf Config) []PartitionLine {
+	run := &RunInfo{Id: newRunId(), Started: time.Now(), Version: collectorVersion()}
+	targets := discoverTargets(conf, run.Started)
+	var cloud *CloudInstance
+	if conf.CloudMetadata != nil {
+		cloud = lookupCloudInstance(*conf.CloudMetadata)
+	}
+	collected := make([]*PartitionLine, len(targets))
+	pending := make([]int, len(targets))
+	for i := range targets {
+		pending[i] = i
+	}
+	deadline := runDeadline(conf, run.Started)
+	deferred := 0
+	// Failed devices are retried after all others, so one busy device does not hold up the run
+	for attempt := 0; ; attempt++ {
+		var failed []int
+		for _, i := range pending {
+			t := targets[i]
+			if pastDeadline(deadline) {
+				// A retried device keeps the error of its last attempt
+				if collected[i] == nil {
+					line := deferredLine(t.line, conf)
+					collected[i] = &line
+					deferred++
+				}
 				continue
 			}
-
-			dev, err := smart.Open(devName)
-			if err != nil {
-				// some devices (like dmcrypt) do not support SMART interface
-				fmt.Printf("could not open disk %s, check sudo?: %s\n", devName, err)
+			start := time.Now()
+			results, ok, done := collectDeviceBefore(deadline, t, conf)
+			if !done {
+				results = deferredLine(results, conf)
+				collected[i] = &results
+				deferred++
 				continue
 			}
-
-			defer dev.Close()
-
-			switch sm := dev.(type) {
-			case *smart.SataDevice:
-				data, err := sm.ReadSMARTData()
-				if err != nil {
-					fmt.Printf("Could not read Sata Disk SMART data for %s: %s\n", devName, err)
+			if !ok && results.Error == nil {
+				if !conf.IncludeUnsupported {
+					collected[i] = nil
 					continue
 				}
-
-				attrResults := make([]smart.AtaSmartAttr, 0)
-
-				for _, attrNum := range attrListToRead {
-					attrResults = append(attrResults, data.Attrs[attrNum])
+				if results.SkipReason == "" {
+					results.SkipReason = "no SMART support"
 				}
-
-				results := PartitionLine{
-					Uuid:          p.UUID,
-					Ts:            runTs,
-					PartitionName: devName,
-					Label:         p.FilesystemLabel,
-					MountPath:     p.MountPoint,
-					SizeBytes:     p.SizeBytes,
-					Attributes:    attrResults,
+			}
+			results.CollectSeconds = time.Since(start).Seconds()
+			if results.Error != nil {
+				results.Error.Attempts = attempt + 1
+				if retryable(results.Error) {
+					failed = append(failed, i)
 				}
+			}
+			collected[i] = &results
+		}
+		if len(failed) == 0 || attempt >= retryAttempts(conf.Retry) {
+			break
+		}
+		delay := retryDelay(*conf.Retry, attempt)
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			log.Printf("not retrying %d failed devices, the run budget runs out first\n", len(failed))
+			break
+		}
+		log.Printf("retrying %d failed devices in %s\n", len(failed), delay)
+		time.Sleep(delay)
+		pending = failed
+	}
 
-				if outputType == OutputJson {
-					j, err := json.Marshal(results)
-					if err != nil {
-						fmt.Printf("json output error for %s: %s\n", devName, err)
-						continue
-					}
-					fmt.Println(string(j))
-
-				} else if outputType == OutputTable {
-					println(devName)
-					fmt.Printf("Current/Raw\n5 (%s): %d/%d\n187 (%s): %d/%d\n188 (%s): %d/%d\n197 (%s): %d/%d\n198 (%s): %d/%d\n",
-						data.Attrs[5].Name, data.Attrs[5].Current, data.Attrs[5].ValueRaw,
-						data.Attrs[187].Name, data.Attrs[187].Current, data.Attrs[187].ValueRaw,
-						data.Attrs[188].Name, data.Attrs[188].Current, data.Attrs[188].ValueRaw,
-						data.Attrs[197].Name, data.Attrs[197].Current, data.Attrs[197].ValueRaw,
-						data.Attrs[198].Name, data.Attrs[198].Current, data.Attrs[198].ValueRaw)
-					println()
-				} else if outputType == OutputPostgres {
-					if conf.Db == nil {
-						println("No DB config, printing json")
-						j, err := json.Marshal(results)
-						if err != nil {
-							fmt.Printf("json output error for %s: %s\n", devName, err)
-							continue
-						}
-						fmt.Println(string(j))
-					} else {
-						saveToPostgresDB(results, *conf.Db)
-					}
-				}
+	if deferred > 0 {
+		log.Printf("run budget of %ds exceeded, deferred %d of %d devices\n", conf.RunBudgetSeconds, deferred, len(targets))
+	}
 
-			case *smart.ScsiDevice:
-				_, _ = sm.Capacity()
-			case *smart.NVMeDevice:
-				_, _ = sm.ReadSMART()
-			}
+	records := make([]PartitionLine, 0, len(targets))
+	for _, results := range collected {
+		if results == nil {
+			continue
+		}
+		if results.Error != nil {
+			run.Errors++
 		}
+		results.Run = run
+		results.Cloud = cloud
+		records = append(records, *results)
 	}
+	if conf.LoadCycleAlert != nil {
+		records = applyLoadCycleAlerts(records, *conf.LoadCycleAlert, conf)
+	}
+	if conf.FailurePrediction != nil {
+		records = applyFailurePredictions(records, *conf.FailurePrediction, conf)
+	}
+	if conf.Backblaze != nil {
+		records = applyBackblazeStats(records, *conf.Backblaze)
+	}
+	if conf.Hypervisor != nil {
+		records = applyHypervisorGuests(records, *conf.Hypervisor)
+	}
+	if conf.CollectZfs {
+		records = applyZfsStatus(records)
+	}
+	if len(conf.CompositeRules) > 0 {
+		records = applyCompositeRules(records, conf.CompositeRules, conf)
+	}
+	records = applySilences(records, conf)
+	records = applyAcknowledgements(records, conf)
+	if conf.Anonymize != nil {
+		records = anonymizeRecords(records, *conf.Anonymize)
+	}
+	run.Devices = len(records)
+	run.DurationSeconds = time.Since(run.Started).Seconds()
+	// Discovery order depends on the platform and the config, sort so runs are comparable
+	slices.SortStableFunc(records, func(a, b PartitionLine) int {
+		if c := strings.Compare(a.PartitionName, b.PartitionName); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Uuid, b.Uuid)
+	})
+	return records
 }
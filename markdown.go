@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// markdownCell escapes a value for use inside a Markdown table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// renderMarkdownReport writes the report as GitHub flavoured Markdown, suitable for pasting into
+// tickets and issues.
+func renderMarkdownReport(w io.Writer, devices []reportDevice, generated time.Time) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# gosmart report\n\nGenerated %s\n\n", generated.Format("2006-01-02 15:04:05 MST"))
+
+	b.WriteString("## Summary\n\n| Device | Model | Mount | Status | Details |\n|---|---|---|---|---|\n")
+	for _, d := range devices {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", markdownCell(d.PartitionName), markdownCell(d.Model),
+			markdownCell(d.MountPath), d.Status, markdownCell(strings.Join(d.Reasons, "; ")))
+	}
+
+	for _, d := range devices {
+		fmt.Fprintf(&b, "\n## %s\n\n", d.PartitionName)
+		fmt.Fprintf(&b, "%s %s, %d bytes\n", d.Model, d.Firmware, d.SizeBytes)
+		for _, advisory := range d.Advisories {
+			fmt.Fprintf(&b, "\n> **Advisory:** %s\n", advisory)
+		}
+		for _, prediction := range d.Predictions {
+			fmt.Fprintf(&b, "\n> **Prediction:** %s\n", prediction)
+		}
+		if d.Backblaze != nil {
+			fmt.Fprintf(&b, "\n> **Backblaze:** %s\n", d.Backblaze)
+		}
+
+		if h := d.NvmeHealth; h != nil {
+			b.WriteString("\n| Temperature | Available spare | Used | Media errors | Unsafe shutdowns | Power on hours |\n|---|---|---|---|---|---|\n")
+			fmt.Fprintf(&b, "| %d C | %d%% | %d%% | %d | %d | %d |\n", h.TemperatureC, h.AvailableSpare, h.PercentageUsed,
+				h.MediaErrors, h.UnsafeShutdowns, h.PowerOnHours)
+		}
+
+		if len(d.Attributes) > 0 {
+			b.WriteString("\n| ID | Name | Current | Worst | Raw | Value |\n|---|---|---|---|---|---|\n")
+			for _, attr := range d.Attributes {
+				fmt.Fprintf(&b, "| %d | %s | %d | %d | %d | %d |\n", attr.Id, markdownCell(attr.Name), attr.Current,
+					attr.Worst, attr.ValueRaw, attr.ValueDecoded)
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
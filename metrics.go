@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strconv"
+)
+
+const (
+	MetricGauge   = "gauge"
+	MetricCounter = "counter"
+)
+
+// metricSample is one numeric value of a record, flattened for metric oriented outputs. Type is
+// MetricCounter for values that only grow over the life of the drive, MetricGauge otherwise.
+type metricSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+	Type   string
+}
+
+// recordMetrics flattens a record into samples named smart_*. Every sample carries the device
+// labels, attribute samples additionally the attribute ID and name. Labels in dropLabels are
+// left out.
+func recordMetrics(record PartitionLine, dropLabels []string) []metricSample {
+	device := map[string]string{"device": record.PartitionName}
+	if record.Model != "" {
+		device["model"] = record.Model
+	}
+	if record.Label != "" {
+		device["label"] = record.Label
+	}
+	if record.Uuid != "" {
+		device["uuid"] = record.Uuid
+	}
+	for k, v := range cloudLabels(record.Cloud) {
+		device[k] = v
+	}
+	withLabels := func(extra map[string]string) map[string]string {
+		labels := make(map[string]string, len(device)+len(extra))
+		for k, v := range device {
+			labels[k] = v
+		}
+		for k, v := range extra {
+			labels[k] = v
+		}
+		for _, k := range dropLabels {
+			delete(labels, k)
+		}
+		return labels
+	}
+
+	supported := 0.0
+	if record.SmartSupported {
+		supported = 1
+	}
+	samples := []metricSample{{Name: "smart_supported", Labels: withLabels(nil), Value: supported, Type: MetricGauge}}
+	// Only ATA records have an attribute table to miss requested attributes in
+	if record.SmartSupported && record.NvmeHealth == nil {
+		samples = append(samples, metricSample{Name: "smart_unsupported_attributes", Labels: withLabels(nil), Value: float64(len(record.UnsupportedAttributes)), Type: MetricGauge})
+	}
+	if record.Error != nil {
+		samples = append(samples, metricSample{Name: "smart_collection_error", Labels: withLabels(map[string]string{"class": record.Error.Class}), Value: 1, Type: MetricGauge})
+	}
+
+	for _, attr := range record.Attributes {
+		labels := withLabels(map[string]string{"attribute_id": strconv.Itoa(int(attr.Id)), "attribute_name": attr.Name})
+		valueLabels := labels
+		if attr.Unit != "" {
+			valueLabels = withLabels(map[string]string{"attribute_id": strconv.Itoa(int(attr.Id)), "attribute_name": attr.Name, "unit": attr.Unit})
+		}
+		samples = append(samples,
+			metricSample{Name: "smart_attribute_value", Labels: valueLabels, Value: float64(attr.ValueDecoded), Type: attr.metricType},
+			metricSample{Name: "smart_attribute_current", Labels: labels, Value: float64(attr.Current), Type: MetricGauge},
+			metricSample{Name: "smart_attribute_worst", Labels: labels, Value: float64(attr.Worst), Type: MetricGauge},
+		)
+	}
+
+	if record.LoadCyclesPerHour != nil {
+		samples = append(samples, metricSample{Name: "smart_load_cycles_per_hour", Labels: withLabels(nil), Value: *record.LoadCyclesPerHour, Type: MetricGauge})
+	}
+
+	if h := record.NvmeHealth; h != nil {
+		labels := withLabels(nil)
+		for _, m := range []struct {
+			name  string
+			value float64
+			kind  string
+		}{
+			{"smart_nvme_critical_warning", float64(h.CriticalWarning), MetricGauge},
+			{"smart_nvme_temperature_c", float64(h.TemperatureC), MetricGauge},
+			{"smart_nvme_available_spare", float64(h.AvailableSpare), MetricGauge},
+			{"smart_nvme_percentage_used", float64(h.PercentageUsed), MetricGauge},
+			{"smart_nvme_data_units_read", float64(h.DataUnitsRead), MetricCounter},
+			{"smart_nvme_data_units_written", float64(h.DataUnitsWritten), MetricCounter},
+			{"smart_nvme_power_cycles", float64(h.PowerCycles), MetricCounter},
+			{"smart_nvme_power_on_hours", float64(h.PowerOnHours), MetricCounter},
+			{"smart_nvme_unsafe_shutdowns", float64(h.UnsafeShutdowns), MetricCounter},
+			{"smart_nvme_media_errors", float64(h.MediaErrors), MetricCounter},
+			{"smart_nvme_error_log_entries", float64(h.ErrorLogEntries), MetricCounter},
+		} {
+			samples = append(samples, metricSample{Name: m.name, Labels: labels, Value: m.value, Type: m.kind})
+		}
+	}
+	if fs := record.Filesystem; fs != nil {
+		labels := withLabels(map[string]string{"mount_path": record.MountPath})
+		for _, m := range []struct {
+			name  string
+			value float64
+			kind  string
+		}{
+			{"smart_filesystem_size_bytes", float64(fs.SizeBytes), MetricGauge},
+			{"smart_filesystem_used_bytes", float64(fs.UsedBytes), MetricGauge},
+			{"smart_filesystem_free_bytes", float64(fs.FreeBytes), MetricGauge},
+		} {
+			samples = append(samples, metricSample{Name: m.name, Labels: labels, Value: m.value, Type: m.kind})
+		}
+		if fs.Inodes > 0 {
+			samples = append(samples,
+				metricSample{Name: "smart_filesystem_inodes", Labels: labels, Value: float64(fs.Inodes), Type: MetricGauge},
+				metricSample{Name: "smart_filesystem_inodes_free", Labels: labels, Value: float64(fs.InodesFree), Type: MetricGauge})
+		}
+	}
+	if io := record.DiskStats; io != nil {
+		labels := withLabels(nil)
+		for _, m := range []struct {
+			name  string
+			value float64
+			kind  string
+		}{
+			{"smart_disk_reads", float64(io.Reads), MetricCounter},
+			{"smart_disk_read_bytes", float64(io.SectorsRead * 512), MetricCounter},
+			{"smart_disk_read_time_seconds", float64(io.ReadTimeMs) / 1000, MetricCounter},
+			{"smart_disk_writes", float64(io.Writes), MetricCounter},
+			{"smart_disk_written_bytes", float64(io.SectorsWritten * 512), MetricCounter},
+			{"smart_disk_write_time_seconds", float64(io.WriteTimeMs) / 1000, MetricCounter},
+			{"smart_disk_in_flight", float64(io.InFlight), MetricGauge},
+			{"smart_disk_io_time_seconds", float64(io.IoTimeMs) / 1000, MetricCounter},
+			{"smart_disk_weighted_io_time_seconds", float64(io.WeightedIoTimeMs) / 1000, MetricCounter},
+		} {
+			samples = append(samples, metricSample{Name: m.name, Labels: labels, Value: m.value, Type: m.kind})
+		}
+	}
+	if s := record.NvmeSanitize; s != nil && s.ProgressPercent != nil {
+		samples = append(samples, metricSample{Name: "smart_nvme_sanitize_percent_done", Labels: withLabels(nil), Value: *s.ProgressPercent, Type: MetricGauge})
+	}
+	if s := record.AtaSecurity; s != nil && s.Supported {
+		frozen := 0.0
+		if s.Frozen {
+			frozen = 1
+		}
+		samples = append(samples, metricSample{Name: "smart_ata_security_frozen", Labels: withLabels(nil), Value: frozen, Type: MetricGauge})
+	}
+	for _, member := range record.Zfs {
+		labels := withLabels(map[string]string{"pool": member.Pool, "vdev": member.Vdev})
+		samples = append(samples,
+			metricSample{Name: "smart_zfs_read_errors", Labels: labels, Value: float64(member.ReadErrors), Type: MetricGauge},
+			metricSample{Name: "smart_zfs_write_errors", Labels: labels, Value: float64(member.WriteErrors), Type: MetricGauge},
+			metricSample{Name: "smart_zfs_checksum_errors", Labels: labels, Value: float64(member.ChecksumErrors), Type: MetricGauge})
+		if member.Scan != nil && member.Scan.PercentDone != nil {
+			samples = append(samples, metricSample{Name: "smart_zfs_scan_percent_done", Labels: withLabels(map[string]string{"pool": member.Pool, "function": member.Scan.Function}), Value: *member.Scan.PercentDone, Type: MetricGauge})
+		}
+	}
+	return samples
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// MultipathInfo is the multipath device a disk is reached through, with the state of each path.
+// Paths that are not running count as failed.
+type MultipathInfo struct {
+	Name        string          `json:"name"`
+	Device      string          `json:"device"`
+	Paths       []MultipathPath `json:"paths"`
+	ActivePaths int             `json:"active_paths"`
+	FailedPaths int             `json:"failed_paths"`
+}
+
+// MultipathPath is one path of a multipath device. State is the SCSI device state, e.g. running,
+// offline or blocked.
+type MultipathPath struct {
+	Device string `json:"device"`
+	State  string `json:"state,omitempty"`
+}
+
+func (m MultipathInfo) String() string {
+	paths := make([]string, len(m.Paths))
+	for i, p := range m.Paths {
+		paths[i] = fmt.Sprintf("%s %s", p.Device, p.State)
+	}
+	return fmt.Sprintf("%s (%s)", m.Name, strings.Join(paths, ", "))
+}
+
+// runningPath returns the first path that is up.
+func (m MultipathInfo) runningPath() (string, bool) {
+	for _, p := range m.Paths {
+		if p.State == "running" {
+			return p.Device, true
+		}
+	}
+	return "", false
+}
+
+// dedupeTargets keeps one target per device when the config reaches it more than once, through
+// aliases such as /dev/disk/by-id links or the paths and device mapper node of a multipath
+// device. Multipath devices are read through a path that is up and report the state of all paths.
+func dedupeTargets(targets []target) []target {
+	deduped := make([]target, 0, len(targets))
+	seen := make(map[string]string)
+	for _, t := range targets {
+		key, multipath := physicalDevice(t.line.PartitionName)
+		if first, ok := seen[key]; ok {
+			log.Printf("%s is the same device as %s, collecting it once\n", t.line.PartitionName, first)
+			continue
+		}
+		seen[key] = t.line.PartitionName
+		if multipath != nil {
+			t.line.Multipath = multipath
+			if path, ok := multipath.runningPath(); ok {
+				t.smartPath = path
+			} else {
+				t.line.warn("multipath %s has no running path", multipath.Name)
+			}
+			if multipath.ActivePaths > 0 && multipath.FailedPaths > 0 {
+				t.line.warn("multipath %s: %d of %d paths failed", multipath.Name, multipath.FailedPaths, len(multipath.Paths))
+			}
+		}
+		if t.line.MountPath != "" {
+			// With bind mounts the device is mounted more than once, report where its root is
+			if mountPath := primaryMountPath(t.line.PartitionName); mountPath != "" {
+				t.line.MountPath = mountPath
+			}
+		}
+		deduped = append(deduped, t)
+	}
+	return deduped
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// physicalDevice returns a key shared by all paths to the same device, and the multipath device
+// it belongs to if any. Aliases resolve to the kernel name, the paths and device mapper node of a
+// multipath device, and their partitions, to the device mapper node.
+func physicalDevice(path string) (string, *MultipathInfo) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path, nil
+	}
+	name := filepath.Base(real)
+	if dm, ok := multipathOf(name); ok {
+		return "multipath:" + dm, readMultipath(dm)
+	}
+	// A partition, of a path or of the device mapper node
+	partition := readSysfsString(filepath.Join(sysfsRoot, "class", "block", name, "partition"))
+	if partition == "" {
+		return real, nil
+	}
+	disk := filepath.Base(sysfsBlockDir(name))
+	if strings.HasPrefix(name, "dm-") {
+		if slaves, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", name, "slaves")); len(slaves) == 1 {
+			disk = slaves[0].Name()
+		}
+	}
+	if dm, ok := multipathOf(disk); ok {
+		return "multipath:" + dm + "/" + partition, readMultipath(dm)
+	}
+	return real, nil
+}
+
+// multipathOf returns the multipath device mapper node of name, which is either the node itself
+// or one of its paths.
+func multipathOf(name string) (string, bool) {
+	if isMultipath(name) {
+		return name, true
+	}
+	holders, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", name, "holders"))
+	for _, holder := range holders {
+		if isMultipath(holder.Name()) {
+			return holder.Name(), true
+		}
+	}
+	return "", false
+}
+
+func isMultipath(name string) bool {
+	return strings.HasPrefix(readSysfsString(filepath.Join(sysfsRoot, "class", "block", name, "dm", "uuid")), "mpath-")
+}
+
+func readMultipath(dm string) *MultipathInfo {
+	name := readSysfsString(filepath.Join(sysfsRoot, "class", "block", dm, "dm", "name"))
+	info := &MultipathInfo{Name: name, Device: "/dev/mapper/" + name}
+	slaves, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", dm, "slaves"))
+	for _, slave := range slaves {
+		info.Paths = append(info.Paths, MultipathPath{
+			Device: devicePath(slave.Name()),
+			State:  readSysfsString(filepath.Join(sysfsRoot, "class", "block", slave.Name(), "device", "state")),
+		})
+	}
+	slices.SortFunc(info.Paths, func(a, b MultipathPath) int { return strings.Compare(a.Device, b.Device) })
+	for _, p := range info.Paths {
+		if p.State == "running" {
+			info.ActivePaths++
+		} else {
+			info.FailedPaths++
+		}
+	}
+	return info
+}
+
+// primaryMountPath returns where the root of the filesystem on devName is mounted, rather than a
+// bind mount of it or of a directory in it.
+func primaryMountPath(devName string) string {
+	real, err := filepath.EvalSymlinks(devName)
+	if err != nil {
+		return ""
+	}
+	devNum := readSysfsString(filepath.Join(sysfsRoot, "class", "block", filepath.Base(real), "dev"))
+	if devNum == "" {
+		return ""
+	}
+	mountPath, _ := mountOf(devNum)
+	return mountPath
+}
+
+// canonicalDevicePath resolves aliases of a device, such as /dev/mapper and /dev/disk links, to
+// the kernel name block device discovery reports.
+func canonicalDevicePath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil && strings.HasPrefix(real, "/dev/") {
+		return real
+	}
+	return path
+}
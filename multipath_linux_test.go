@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The layout of a multipath device dm-3 over the SCSI disks sdc and sdd, with a partition sdc1
+// on one of its paths and the local disk sda outside of it.
+const (
+	mpathDm  = "devices/virtual/block/dm-3"
+	mpathSdc = "devices/pci0000:00/0000:00:02.0/0000:03:00.0/host1/rport-1:0-0/target1:0:0/1:0:0:1"
+	mpathSdd = "devices/pci0000:00/0000:00:02.0/0000:03:00.1/host2/rport-2:0-0/target2:0:0/2:0:0:1"
+	localSda = "devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0"
+)
+
+func fakeMultipathSysfs(t *testing.T, sddState string) {
+	t.Helper()
+	fakeSysfs(t, map[string]string{
+		mpathDm + "/dev":                       "253:3",
+		mpathDm + "/dm/name":                   "mpatha",
+		mpathDm + "/dm/uuid":                   "mpath-3600508b400105e210000900000490000",
+		mpathSdc + "/state":                    "running",
+		mpathSdc + "/block/sdc/dev":            "8:32",
+		mpathSdc + "/block/sdc/sdc1/partition": "1",
+		mpathSdd + "/state":                    sddState,
+		mpathSdd + "/block/sdd/dev":            "8:48",
+		localSda + "/block/sda/dev":            "8:0",
+	}, map[string]string{
+		"class/block/dm-3":                   mpathDm,
+		"class/block/sdc":                    mpathSdc + "/block/sdc",
+		"class/block/sdc1":                   mpathSdc + "/block/sdc/sdc1",
+		"class/block/sdd":                    mpathSdd + "/block/sdd",
+		"class/block/sda":                    localSda + "/block/sda",
+		mpathDm + "/slaves/sdc":              mpathSdc + "/block/sdc",
+		mpathDm + "/slaves/sdd":              mpathSdd + "/block/sdd",
+		mpathSdc + "/block/sdc/holders/dm-3": mpathDm,
+		mpathSdd + "/block/sdd/holders/dm-3": mpathDm,
+		mpathSdc + "/block/sdc/device":       mpathSdc,
+		mpathSdd + "/block/sdd/device":       mpathSdd,
+	})
+}
+
+func TestReadMultipath(t *testing.T) {
+	fakeMultipathSysfs(t, "offline")
+	want := &MultipathInfo{
+		Name:        "mpatha",
+		Device:      "/dev/mapper/mpatha",
+		Paths:       []MultipathPath{{Device: "/dev/sdc", State: "running"}, {Device: "/dev/sdd", State: "offline"}},
+		ActivePaths: 1,
+		FailedPaths: 1,
+	}
+	if got := readMultipath("dm-3"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if path, ok := want.runningPath(); !ok || path != "/dev/sdc" {
+		t.Errorf("read through %s", path)
+	}
+
+	for name, want := range map[string]string{"dm-3": "dm-3", "sdc": "dm-3", "sdd": "dm-3", "sda": ""} {
+		if dm, ok := multipathOf(name); dm != want || ok != (want != "") {
+			t.Errorf("%s is a path of %q, want %q", name, dm, want)
+		}
+	}
+}
+
+// The paths and node of a multipath device share a key, as do the partitions of its paths, and
+// other disks keep their own.
+func TestPhysicalDevice(t *testing.T) {
+	fakeMultipathSysfs(t, "running")
+	dev := t.TempDir()
+	for _, name := range []string{"dm-3", "sdc", "sdd", "sdc1", "sda"} {
+		if err := os.WriteFile(filepath.Join(dev, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dev, "dm-3"), filepath.Join(dev, "mpatha")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"dm-3":   "multipath:dm-3",
+		"mpatha": "multipath:dm-3",
+		"sdc":    "multipath:dm-3",
+		"sdd":    "multipath:dm-3",
+		"sdc1":   "multipath:dm-3/1",
+		"sda":    filepath.Join(dev, "sda"),
+	} {
+		key, info := physicalDevice(filepath.Join(dev, name))
+		if key != want {
+			t.Errorf("%s has key %s, want %s", name, key, want)
+		}
+		if multipath := want != filepath.Join(dev, "sda"); multipath != (info != nil) {
+			t.Errorf("%s has multipath %v", name, info)
+		} else if multipath && info.ActivePaths != 2 {
+			t.Errorf("%s has %d active paths, want 2", name, info.ActivePaths)
+		}
+	}
+}
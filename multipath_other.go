@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+func physicalDevice(path string) (string, *MultipathInfo) {
+	return deviceKey(path), nil
+}
+
+func canonicalDevicePath(path string) string {
+	return path
+}
+
+func primaryMountPath(devName string) string {
+	return ""
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+type NewRelicConfig struct {
+	LicenseKey string `json:"license_key"`
+	// Region is us or eu, defaults to us
+	Region string `json:"region,omitempty"`
+	// Attributes are added to every metric, host.name is set to the host name unless given here
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type newRelicMetric struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Value      float64           `json:"value"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type newRelicPayload struct {
+	Common struct {
+		Timestamp  int64             `json:"timestamp"`
+		Attributes map[string]string `json:"attributes"`
+	} `json:"common"`
+	Metrics []newRelicMetric `json:"metrics"`
+}
+
+func newRelicUrl(region string) string {
+	if region == "eu" {
+		return "https://metric-api.eu.newrelic.com/metric/v1"
+	}
+	return "https://metric-api.newrelic.com/metric/v1"
+}
+
+// saveToNewRelic sends a record's samples as gauges to the New Relic Metric API.
+func saveToNewRelic(record PartitionLine, conf NewRelicConfig, dropLabels []string) error {
+	var payload newRelicPayload
+	payload.Common.Timestamp = record.Ts.UnixMilli()
+	payload.Common.Attributes = map[string]string{}
+	if host, err := os.Hostname(); err == nil {
+		payload.Common.Attributes["host.name"] = host
+	}
+	for k, v := range conf.Attributes {
+		payload.Common.Attributes[k] = v
+	}
+	for _, sample := range recordMetrics(record, dropLabels) {
+		payload.Metrics = append(payload.Metrics, newRelicMetric{Name: sample.Name, Type: "gauge", Value: sample.Value, Attributes: sample.Labels})
+	}
+
+	body, err := json.Marshal([]newRelicPayload{payload})
+	if err != nil {
+		return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, newRelicUrl(conf.Region), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new relic request error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", conf.LicenseKey)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("new relic write error for %s: %w", record.PartitionName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("new relic write error for %s: %s %s", record.PartitionName, resp.Status, msg)
+	}
+	return nil
+}
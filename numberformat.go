@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	TemperatureCelsius    = "celsius"
+	TemperatureFahrenheit = "fahrenheit"
+)
+
+// DisplayConfig formats the numbers of the outputs read by people: the table output, --watch and
+// the tui. Machine readable outputs, json, metrics, databases and files, always write plain digits
+// with a decimal point and temperatures in Celsius, whatever is set here or in the environment.
+type DisplayConfig struct {
+	// Locale picks separators by language, e.g. de or en_US.UTF-8, or from LC_ALL, LC_NUMERIC or
+	// LANG if system. Temperatures are in Fahrenheit for US locales.
+	Locale string `json:"locale,omitempty"`
+	// ThousandsSeparator groups digits in threes, e.g. "," or ".", overriding the locale
+	ThousandsSeparator string `json:"thousands_separator,omitempty"`
+	// DecimalSeparator replaces the decimal point, e.g. ",", overriding the locale
+	DecimalSeparator string `json:"decimal_separator,omitempty"`
+	// TemperatureUnit is celsius or fahrenheit, overriding the locale
+	TemperatureUnit string `json:"temperature_unit,omitempty"`
+}
+
+// Digit grouping by language, languages not listed use plain digits and a decimal point.
+var (
+	localeCommaDecimal = []string{"da", "de", "el", "es", "hr", "id", "it", "nl", "pt", "ro", "sl", "sr", "tr"}
+	localeSpaceGroups  = []string{"bg", "cs", "et", "fi", "fr", "hu", "lt", "lv", "nb", "nn", "no", "pl", "ru", "sk", "sv", "uk"}
+	localeCommaGroups  = []string{"en", "he", "hi", "ja", "ko", "th", "zh"}
+)
+
+// numberFormat renders numbers for people. The zero value formats like fmt, so the String methods
+// of machine facing types stay locale independent.
+type numberFormat struct {
+	thousands  string
+	decimal    string
+	fahrenheit bool
+}
+
+func newNumberFormat(conf *DisplayConfig) numberFormat {
+	var f numberFormat
+	if conf == nil {
+		return f
+	}
+	locale := conf.Locale
+	if locale == "system" {
+		locale = systemLocale()
+	}
+	// e.g. de_DE.UTF-8 or en-US
+	locale, _, _ = strings.Cut(locale, ".")
+	language, region, _ := strings.Cut(strings.ReplaceAll(locale, "-", "_"), "_")
+	language = strings.ToLower(language)
+	switch {
+	case slices.Contains(localeCommaDecimal, language):
+		f.thousands, f.decimal = ".", ","
+	case slices.Contains(localeSpaceGroups, language):
+		f.thousands, f.decimal = " ", ","
+	case slices.Contains(localeCommaGroups, language):
+		f.thousands = ","
+	}
+	f.fahrenheit = strings.EqualFold(region, "US")
+
+	if conf.ThousandsSeparator != "" {
+		f.thousands = conf.ThousandsSeparator
+	}
+	if conf.DecimalSeparator != "" {
+		f.decimal = conf.DecimalSeparator
+	}
+	switch conf.TemperatureUnit {
+	case "":
+	case TemperatureCelsius, TemperatureFahrenheit:
+		f.fahrenheit = conf.TemperatureUnit == TemperatureFahrenheit
+	default:
+		log.Printf("unknown temperature unit %q, using the locale's\n", conf.TemperatureUnit)
+	}
+	return f
+}
+
+// systemLocale is the locale numbers are formatted in by the environment, as POSIX resolves it.
+func systemLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// group inserts the thousands separator into a string of digits with an optional sign.
+func (f numberFormat) group(digits string) string {
+	if f.thousands == "" {
+		return digits
+	}
+	sign := ""
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		sign, digits = digits[:1], digits[1:]
+	}
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(f.thousands)
+		}
+		b.WriteRune(c)
+	}
+	return sign + b.String()
+}
+
+func (f numberFormat) int(v int64) string {
+	return f.group(strconv.FormatInt(v, 10))
+}
+
+func (f numberFormat) uint(v uint64) string {
+	return f.group(strconv.FormatUint(v, 10))
+}
+
+// float formats v with prec decimals, as few as needed if prec is -1.
+func (f numberFormat) float(v float64, prec int) string {
+	s := strconv.FormatFloat(v, 'f', prec, 64)
+	whole, fraction, ok := strings.Cut(s, ".")
+	whole = f.group(whole)
+	if !ok {
+		return whole
+	}
+	decimal := f.decimal
+	if decimal == "" {
+		decimal = "."
+	}
+	return whole + decimal + fraction
+}
+
+// temperature converts a reading in Celsius to the display unit, without the unit.
+func (f numberFormat) temperature(celsius int) string {
+	if f.fahrenheit {
+		return f.int(int64(math.Round(float64(celsius)*9/5 + 32)))
+	}
+	return f.int(int64(celsius))
+}
+
+// temperatures formats several readings in Celsius like %v, e.g. [45 50].
+func (f numberFormat) temperatures(celsius []int) string {
+	values := make([]string, len(celsius))
+	for i, c := range celsius {
+		values[i] = f.temperature(c)
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+// temperatureUnit is the symbol of the display unit of temperatures.
+func (f numberFormat) temperatureUnit() string {
+	if f.fahrenheit {
+		return "F"
+	}
+	return "C"
+}
+
+// bytes formats a size in binary units, e.g. 1.5 GiB.
+func (f numberFormat) bytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return f.uint(n) + " B"
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%s %ciB", f.float(float64(n)/float64(div), 1), "KMGTPE"[exp])
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	NvmeFabricsCollect = "collect"
+	NvmeFabricsSkip    = "skip"
+)
+
+// NvmeTransportPcie is the transport of locally attached NVMe controllers, anything else is NVMe-oF.
+const NvmeTransportPcie = "pcie"
+
+// NvmeHealth is the NVMe SMART / Health Information log (log page 0x02).
+type NvmeHealth struct {
+	CriticalWarning uint8 `json:"critical_warning"`
+	TemperatureC    int   `json:"temperature_c"`
+	AvailableSpare  uint8 `json:"available_spare"`
+	// AvailableSpareThreshold is the spare percentage below which the drive raises a critical warning
+	AvailableSpareThreshold uint8 `json:"available_spare_threshold"`
+	// TemperatureSensorsC lists the controller's additional temperature sensors that are implemented
+	TemperatureSensorsC []int  `json:"temperature_sensors_c,omitempty"`
+	PercentageUsed      uint8  `json:"percentage_used"`
+	DataUnitsRead       uint64 `json:"data_units_read"`
+	DataUnitsWritten    uint64 `json:"data_units_written"`
+	PowerCycles         uint64 `json:"power_cycles"`
+	PowerOnHours        uint64 `json:"power_on_hours"`
+	UnsafeShutdowns     uint64 `json:"unsafe_shutdowns"`
+	MediaErrors         uint64 `json:"media_errors"`
+	ErrorLogEntries     uint64 `json:"error_log_entries"`
+}
+
+// nvmeTransport returns the transport (pcie, tcp, rdma, fc, loop) of the controller behind an NVMe
+// namespace or partition, read from sysfs. It returns an empty string for other devices or when
+// sysfs is unavailable.
+func nvmeTransport(devName string) string {
+	name := filepath.Base(devName)
+	if !strings.HasPrefix(name, "nvme") {
+		return ""
+	}
+
+	dir := sysfsBlockDir(devName)
+	if dir == "" {
+		return ""
+	}
+
+	transport, err := os.ReadFile(filepath.Join(dir, "device", "transport"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(transport))
+}
+
+// PcieLink is the negotiated and maximum PCIe link of an NVMe controller, speeds in GT/s.
+type PcieLink struct {
+	CurrentSpeed float64 `json:"current_speed_gts"`
+	CurrentWidth int     `json:"current_width"`
+	MaxSpeed     float64 `json:"max_speed_gts"`
+	MaxWidth     int     `json:"max_width"`
+}
+
+// readPcieLink reads the PCIe link of the controller behind an NVMe namespace from sysfs.
+func readPcieLink(devName string) *PcieLink {
+	dir := sysfsBlockDir(devName)
+	if dir == "" {
+		return nil
+	}
+
+	// namespace -> controller -> PCI function
+	pciDir := filepath.Join(dir, "device", "device")
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(pciDir, name))
+		fields := strings.Fields(string(b))
+		if err != nil || len(fields) == 0 {
+			return ""
+		}
+		// speeds look like "8.0 GT/s PCIe", widths are plain numbers
+		return fields[0]
+	}
+
+	link := PcieLink{}
+	var err error
+	if link.CurrentSpeed, err = strconv.ParseFloat(read("current_link_speed"), 64); err != nil {
+		return nil
+	}
+	link.MaxSpeed, _ = strconv.ParseFloat(read("max_link_speed"), 64)
+	link.CurrentWidth, _ = strconv.Atoi(read("current_link_width"))
+	link.MaxWidth, _ = strconv.Atoi(read("max_link_width"))
+	return &link
+}
+
+// collectPcieLink reports the PCIe link of a locally attached NVMe drive and warns when it trained
+// below the speed or width the device supports.
+func collectPcieLink(devName string, line PartitionLine) PartitionLine {
+	line.PcieLink = readPcieLink(devName)
+	link := line.PcieLink
+	if link != nil && (link.CurrentSpeed < link.MaxSpeed || link.CurrentWidth < link.MaxWidth) {
+		line.warn("PCIe link trained at %g GT/s x%d, device supports %g GT/s x%d",
+			link.CurrentSpeed, link.CurrentWidth, link.MaxSpeed, link.MaxWidth)
+	}
+	return line
+}
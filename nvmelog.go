@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	nvmeLogOcpSmart             = 0xC0
+	nvmeLogIntelSmartAdditional = 0xCA
+
+	nvmeVendorIntel    = 0x8086
+	nvmeVendorSolidigm = 0x025E
+)
+
+// ocpSmartGuid identifies log page 0xC0 as the OCP "SMART / Health Information Extended" log, as
+// other vendors use the same page for their own formats.
+var ocpSmartGuid = []byte{0xC5, 0xAF, 0x10, 0x28, 0xEA, 0xBF, 0xF2, 0xA4, 0x9C, 0x4F, 0x6F, 0x7C, 0xC9, 0x14, 0xD5, 0xAF}
+
+// NvmeOcpSmart is the OCP Datacenter NVMe SSD extended SMART log (log page 0xC0). 128 bit counters
+// are truncated to their low 64 bits.
+type NvmeOcpSmart struct {
+	PhysicalMediaUnitsWritten uint64 `json:"physical_media_units_written"`
+	PhysicalMediaUnitsRead    uint64 `json:"physical_media_units_read"`
+	BadUserNandBlocks         uint64 `json:"bad_user_nand_blocks"`
+	BadSystemNandBlocks       uint64 `json:"bad_system_nand_blocks"`
+	XorRecoveryCount          uint64 `json:"xor_recovery_count"`
+	UncorrectableReadErrors   uint64 `json:"uncorrectable_read_errors"`
+	SoftEccErrors             uint64 `json:"soft_ecc_errors"`
+	EndToEndDetectedErrors    uint32 `json:"end_to_end_detected_errors"`
+	EndToEndCorrectedErrors   uint32 `json:"end_to_end_corrected_errors"`
+	SystemDataPercentUsed     uint8  `json:"system_data_percent_used"`
+	RefreshCount              uint64 `json:"refresh_count"`
+	MaxUserDataEraseCount     uint32 `json:"max_user_data_erase_count"`
+	MinUserDataEraseCount     uint32 `json:"min_user_data_erase_count"`
+	ThermalThrottlingEvents   uint8  `json:"thermal_throttling_events"`
+	ThermalThrottlingStatus   uint8  `json:"thermal_throttling_status"`
+	PcieCorrectableErrors     uint64 `json:"pcie_correctable_errors"`
+	IncompleteShutdowns       uint32 `json:"incomplete_shutdowns"`
+	PercentFreeBlocks         uint8  `json:"percent_free_blocks"`
+}
+
+// NvmeVendorAttr is one entry of a vendor specific NVMe SMART log.
+type NvmeVendorAttr struct {
+	Name       string `json:"name"`
+	Normalized uint8  `json:"normalized"`
+	Raw        uint64 `json:"raw"`
+}
+
+// intelSmartAdditionalKeys names the entries of Intel's (and Solidigm's) additional SMART log.
+var intelSmartAdditionalKeys = map[uint8]string{
+	0xAB: "program_fail_count",
+	0xAC: "erase_fail_count",
+	0xAD: "wear_leveling",
+	0xB8: "end_to_end_error_detection_count",
+	0xC7: "crc_error_count",
+	0xE2: "timed_workload_media_wear",
+	0xE3: "timed_workload_host_reads",
+	0xE4: "timed_workload_timer",
+	0xEA: "thermal_throttle_status",
+	0xF0: "retry_buffer_overflow_count",
+	0xF3: "pll_lock_loss_count",
+	0xF4: "nand_bytes_written",
+	0xF5: "host_bytes_written",
+}
+
+func le48(b []byte) uint64 {
+	return uint64(binary.LittleEndian.Uint32(b)) | uint64(binary.LittleEndian.Uint16(b[4:]))<<32
+}
+
+// parseNvmeOcpSmart decodes the 512 byte OCP extended SMART log.
+func parseNvmeOcpSmart(buf []byte) (*NvmeOcpSmart, error) {
+	if len(buf) != 512 || !bytes.Equal(buf[496:512], ocpSmartGuid) {
+		return nil, fmt.Errorf("log page 0x%X is not an OCP extended SMART log", nvmeLogOcpSmart)
+	}
+
+	le := binary.LittleEndian
+	return &NvmeOcpSmart{
+		PhysicalMediaUnitsWritten: le.Uint64(buf[0:]),
+		PhysicalMediaUnitsRead:    le.Uint64(buf[16:]),
+		BadUserNandBlocks:         le48(buf[32:]),
+		BadSystemNandBlocks:       le48(buf[40:]),
+		XorRecoveryCount:          le.Uint64(buf[48:]),
+		UncorrectableReadErrors:   le.Uint64(buf[56:]),
+		SoftEccErrors:             le.Uint64(buf[64:]),
+		EndToEndDetectedErrors:    le.Uint32(buf[72:]),
+		EndToEndCorrectedErrors:   le.Uint32(buf[76:]),
+		SystemDataPercentUsed:     buf[80],
+		RefreshCount:              le.Uint64(append(append([]byte{}, buf[81:88]...), 0)),
+		MaxUserDataEraseCount:     le.Uint32(buf[88:]),
+		MinUserDataEraseCount:     le.Uint32(buf[92:]),
+		ThermalThrottlingEvents:   buf[96],
+		ThermalThrottlingStatus:   buf[97],
+		PcieCorrectableErrors:     le.Uint64(buf[104:]),
+		IncompleteShutdowns:       le.Uint32(buf[112:]),
+		PercentFreeBlocks:         buf[120],
+	}, nil
+}
+
+// parseIntelSmartAdditional decodes Intel's additional SMART log, a list of 12 byte entries of
+// key, normalized value and 48 bit raw value.
+func parseIntelSmartAdditional(buf []byte) []NvmeVendorAttr {
+	var attrs []NvmeVendorAttr
+	for off := 0; off+12 <= len(buf); off += 12 {
+		name, ok := intelSmartAdditionalKeys[buf[off]]
+		if !ok {
+			continue
+		}
+		attrs = append(attrs, NvmeVendorAttr{
+			Name:       name,
+			Normalized: buf[off+3],
+			Raw:        le48(buf[off+5:]),
+		})
+	}
+	return attrs
+}
+
+// collectNvmeExtendedLogs reads the OCP extended SMART log and, for known vendors, their vendor
+// SMART log. Drives that do not implement a page are skipped silently.
+func collectNvmeExtendedLogs(devName string, vendorID uint16, line PartitionLine) PartitionLine {
+	if buf, err := readNvmeLogPage(devName, nvmeLogOcpSmart, 512); err == nil {
+		if ocp, err := parseNvmeOcpSmart(buf); err == nil {
+			line.NvmeOcpSmart = ocp
+		}
+	}
+
+	if vendorID == nvmeVendorIntel || vendorID == nvmeVendorSolidigm {
+		buf, err := readNvmeLogPage(devName, nvmeLogIntelSmartAdditional, 512)
+		if err != nil {
+			fmt.Printf("Could not read vendor SMART log for %s: %s\n", devName, err)
+			return line
+		}
+		line.NvmeVendorSmart = parseIntelSmartAdditional(buf)
+	}
+	return line
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// nvmePassthruCmd is the kernel's struct nvme_passthru_cmd.
+type nvmePassthruCmd struct {
+	opcode      uint8
+	flags       uint8
+	rsvd1       uint16
+	nsid        uint32
+	cdw2        uint32
+	cdw3        uint32
+	metadata    uint64
+	addr        uint64
+	metadataLen uint32
+	dataLen     uint32
+	cdw10       uint32
+	cdw11       uint32
+	cdw12       uint32
+	cdw13       uint32
+	cdw14       uint32
+	cdw15       uint32
+	timeoutMs   uint32
+	result      uint32
+}
+
+const (
+	nvmeAdminGetLogPage = 0x02
+	// _IOWR('N', 0x41, struct nvme_passthru_cmd)
+	nvmeIoctlAdminCmd = 0xC0484E41
+)
+
+// readNvmeLogPage reads a controller scoped log page. smart.go only exposes the standard SMART log,
+// so vendor pages are read with a separate admin passthrough command.
+func readNvmeLogPage(devName string, logID uint8, size int) ([]byte, error) {
+	f, err := os.Open(devName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, size)
+	cmd := nvmePassthruCmd{
+		opcode:  nvmeAdminGetLogPage,
+		nsid:    0xffffffff,
+		addr:    uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		dataLen: uint32(size),
+		cdw10:   uint32(logID) | (uint32(size/4)-1)<<16,
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), nvmeIoctlAdminCmd, uintptr(unsafe.Pointer(&cmd)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return buf, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func readNvmeLogPage(devName string, logID uint8, size int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// SyncConfig delivers the readings kept in the local store to other outputs whenever they can be
+// reached, for sites that are only connected now and then. Write to the local output only and
+// list the central outputs here: each run sends the readings the outputs have not received yet,
+// oldest first, and keeps the rest for the next run if an output fails. How long a site can stay
+// offline is bounded by the readings and retention_hours of the local store, older readings are
+// dropped unsent.
+type SyncConfig struct {
+	Outputs []string `json:"outputs"`
+	// StatePath keeps the last reading delivered to each output, <local path>.sync.json if empty
+	StatePath string `json:"state_path,omitempty"`
+}
+
+func syncStatePath(conf Config) string {
+	if conf.Sync.StatePath != "" {
+		return conf.Sync.StatePath
+	}
+	return conf.Local.Path + ".sync.json"
+}
+
+// syncLocalStore sends the readings of the local store after the watermark of each sync output
+// to it. Readings are delivered at least once: the watermark moves after every batch the output
+// accepted, so only a crash between the two sends a batch again. Postgres skips the readings it
+// already holds once initialize created the unique key of readings.
+func syncLocalStore(conf Config) {
+	if conf.Sync == nil || len(conf.Sync.Outputs) == 0 {
+		return
+	}
+	if conf.Local == nil {
+		log.Println("sync needs a local store to send readings from")
+		return
+	}
+	statePath := syncStatePath(conf)
+	marks, err := loadWatermarks(statePath)
+	if err != nil {
+		log.Printf("Could not read sync state %s: %s\n", statePath, err)
+		return
+	}
+	db, err := openLocalStore(*conf.Local, true)
+	if err != nil {
+		log.Printf("Could not sync: %s\n", err)
+		return
+	}
+	defer db.Close()
+
+	for _, output := range conf.Sync.Outputs {
+		readings, err := localReadingsAfter(db, marks[output], conf)
+		if err != nil {
+			log.Printf("Could not sync to %s: %s\n", output, err)
+			continue
+		}
+		if len(readings) == 0 {
+			continue
+		}
+		w := newRecordWriter(output, conf)
+		w.onFlush = func(batch []PartitionLine) error {
+			last := batch[len(batch)-1]
+			marks[output] = watermark{Ts: last.Ts, PartitionName: last.PartitionName}
+			return saveWatermarks(statePath, marks)
+		}
+		for _, reading := range readings {
+			if err = w.Write(reading); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Printf("Could not sync to %s, %d readings left to send: %s\n", output, len(readings)-w.status.Delivered, err)
+		} else {
+			log.Printf("Synced %d readings to %s\n", w.status.Delivered, output)
+		}
+	}
+}
+
+// localReadingsAfter returns the readings of the local store after mark, oldest first, translated
+// to the current schema version.
+func localReadingsAfter(db *bbolt.DB, mark watermark, conf Config) ([]PartitionLine, error) {
+	var readings []PartitionLine
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			if localMetaBucket(name) {
+				return nil
+			}
+			c := bucket.Cursor()
+			k, v := c.First()
+			if !mark.Ts.IsZero() {
+				k, v = c.Seek(localKey(mark.Ts))
+			}
+			for ; k != nil; k, v = c.Next() {
+				// Readings of one run share the timestamp of the watermark, the name breaks the tie
+				if bytes.Equal(k, localKey(mark.Ts)) && string(name) <= mark.PartitionName {
+					continue
+				}
+				var reading localReading
+				if err := json.Unmarshal(v, &reading); err != nil {
+					return fmt.Errorf("local store read error for %s: %w", name, err)
+				}
+				line := PartitionLine{
+					Uuid:           reading.Uuid,
+					Ts:             reading.Ts,
+					PartitionName:  string(name),
+					Label:          reading.Label,
+					MountPath:      reading.MountPath,
+					SizeBytes:      reading.SizeBytes,
+					SmartSupported: true,
+				}
+				if err := json.Unmarshal(reading.Attributes, &line.Attributes); err != nil {
+					return fmt.Errorf("local store read error for %s: %w", name, err)
+				}
+				line, err := upgradeRecord(line, reading.SchemaVersion, conf)
+				if err != nil {
+					return fmt.Errorf("local store read error for %s: %w", name, err)
+				}
+				readings = append(readings, line)
+			}
+			return nil
+		})
+	})
+	slices.SortStableFunc(readings, func(a, b PartitionLine) int {
+		if c := a.Ts.Compare(b.Ts); c != 0 {
+			return c
+		}
+		return strings.Compare(a.PartitionName, b.PartitionName)
+	})
+	return readings, err
+}
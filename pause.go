@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// PauseStatus is whether serve collects, as /pause, /health and /debug/state report it.
+type PauseStatus struct {
+	Paused bool       `json:"paused"`
+	Since  *time.Time `json:"since,omitempty"`
+	// Until is when collection resumes on its own, unset if it waits to be resumed
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+	// Skipped counts the collections skipped while paused since serve started
+	Skipped int `json:"skipped"`
+}
+
+// pauseStatus returns the pause state, resuming first if the pause has run out. d.mu must be held.
+func (d *daemon) pauseStatus(now time.Time) PauseStatus {
+	if !d.pausedAt.IsZero() && !d.pauseUntil.IsZero() && !now.Before(d.pauseUntil) {
+		log.Printf("Resuming collection, the pause of %s ended\n", d.pauseUntil.Sub(d.pausedAt).Round(time.Second))
+		d.pausedAt, d.pauseUntil, d.pauseReason = time.Time{}, time.Time{}, ""
+	}
+	status := PauseStatus{Paused: !d.pausedAt.IsZero(), Reason: d.pauseReason, Skipped: d.skipped}
+	if status.Paused {
+		since := d.pausedAt
+		status.Since = &since
+	}
+	if !d.pauseUntil.IsZero() {
+		until := d.pauseUntil
+		status.Until = &until
+	}
+	return status
+}
+
+// paused reports whether collections are paused.
+func (d *daemon) paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pauseStatus(time.Now()).Paused
+}
+
+// skipPaused counts a collection as skipped if collections are paused, for the loops that would
+// otherwise read the devices.
+func (d *daemon) skipPaused(what string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.pauseStatus(time.Now()).Paused {
+		return false
+	}
+	d.skipped++
+	log.Printf("Skipping %s, collection is paused\n", what)
+	return true
+}
+
+// rejectPaused answers requests that would read the devices with a conflict while paused.
+func (d *daemon) rejectPaused(w http.ResponseWriter) bool {
+	if !d.paused() {
+		return false
+	}
+	http.Error(w, "collection is paused, resume it with DELETE /pause", http.StatusConflict)
+	return true
+}
+
+// handlePause reports the pause state, pauses collection for the duration given, or until resumed
+// without one, or resumes it. Scheduled, hotplug and udev collections and offline data collection
+// are skipped while paused, on-demand collections, events and self-tests are rejected. The state
+// is kept in memory, a restarted serve collects again.
+func (d *daemon) handlePause(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		query := r.URL.Query()
+		var until time.Time
+		if v := query.Get("duration"); v != "" {
+			duration, err := time.ParseDuration(v)
+			if err != nil || duration <= 0 {
+				http.Error(w, "duration must be a positive duration like 90m or 6h", http.StatusBadRequest)
+				return
+			}
+			until = now.Add(duration)
+		}
+		// Pausing again while paused extends or shortens the pause, keeping when it started
+		if !d.pauseStatus(now).Paused {
+			d.pausedAt = now
+		}
+		d.pauseUntil, d.pauseReason = until, query.Get("reason")
+		if until.IsZero() {
+			log.Printf("Pausing collection until resumed: %s\n", d.pauseReason)
+		} else {
+			log.Printf("Pausing collection until %s: %s\n", until.Format(time.RFC3339), d.pauseReason)
+		}
+	case http.MethodDelete:
+		if d.pauseStatus(now).Paused {
+			log.Printf("Resuming collection after %s\n", now.Sub(d.pausedAt).Round(time.Second))
+		}
+		d.pausedAt, d.pauseUntil, d.pauseReason = time.Time{}, time.Time{}, ""
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d.pauseStatus(now))
+}
+
+// handleHealth reports that serve is up and whether it collects, for load balancers and service
+// monitors. A pause is deliberate, so it answers 200 either way and tells them apart by status.
+func (d *daemon) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	pause := d.pauseStatus(time.Now())
+	health := map[string]any{
+		"status": "ok",
+		"paused": pause.Paused,
+		"runs":   d.runs,
+	}
+	if pause.Paused {
+		health["status"] = "paused"
+		health["paused_since"] = pause.Since
+		if pause.Until != nil {
+			health["paused_until"] = pause.Until
+		}
+	}
+	if !d.lastRun.IsZero() {
+		health["last_run"] = d.lastRun
+	}
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(health)
+}
+
+// serveUrl is the base URL of the serve of a config, on the local host if it listens on all
+// addresses.
+func serveUrl(conf Config) string {
+	listen := ":9633"
+	if conf.Serve != nil && conf.Serve.Listen != "" {
+		listen = conf.Serve.Listen
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "http://" + listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// operatorToken returns the first operator token of a config, so the commands can talk to the
+// serve of the same config without passing a token.
+func operatorToken(conf Config) string {
+	if conf.Serve == nil {
+		return ""
+	}
+	for _, t := range conf.Serve.Tokens {
+		if t.Role == RoleOperator {
+			return t.Token
+		}
+	}
+	return ""
+}
+
+// runPauseCommand implements `gosmart pause` and `gosmart resume`, which pause and resume the
+// collections of a running serve through its /pause endpoint, e.g. around firmware updates.
+func runPauseCommand(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	serve := fs.String("url", "", "URL of the serve to control, defaults to the listen address of the config")
+	token := fs.String("token", "", "API token, defaults to the first operator token of the config")
+	var duration *time.Duration
+	var reason *string
+	var status *bool
+	if name == "pause" {
+		duration = fs.Duration("for", 0, "Resume on its own after this long, e.g. 2h, instead of waiting for resume")
+		reason = fs.String("reason", "", "Why collection is paused, e.g. a ticket")
+		status = fs.Bool("status", false, "Only print whether collection is paused")
+	}
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	if *serve == "" {
+		*serve = serveUrl(conf)
+	}
+	if *token == "" {
+		*token = operatorToken(conf)
+	}
+
+	method, query := http.MethodDelete, url.Values{}
+	if name == "pause" {
+		method = http.MethodPost
+		if *status {
+			method = http.MethodGet
+		}
+		if *duration > 0 {
+			query.Set("duration", duration.String())
+		}
+		if *reason != "" {
+			query.Set("reason", *reason)
+		}
+	}
+	target := strings.TrimSuffix(*serve, "/") + "/pause"
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not reach serve: %s\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "serve answered %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		os.Exit(1)
+	}
+
+	var pause PauseStatus
+	if err := json.Unmarshal(body, &pause); err != nil {
+		fmt.Fprintf(os.Stderr, "serve answered an invalid pause state: %s\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case !pause.Paused:
+		fmt.Printf("Collecting, %d collections skipped while paused\n", pause.Skipped)
+	case pause.Until != nil:
+		fmt.Printf("Paused since %s until %s", pause.Since.Format(time.RFC3339), pause.Until.Format(time.RFC3339))
+	default:
+		fmt.Printf("Paused since %s until resumed", pause.Since.Format(time.RFC3339))
+	}
+	if pause.Paused && pause.Reason != "" {
+		fmt.Printf(": %s", pause.Reason)
+	}
+	if pause.Paused {
+		fmt.Println()
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// OutputPluginPrefix marks output types written by plugins, e.g. plugin:pagerduty runs the
+// gosmart-output-pagerduty executable found in the plugin directories.
+const OutputPluginPrefix = "plugin:"
+
+// pluginExecutablePrefix names the executables discovered as output plugins.
+const pluginExecutablePrefix = "gosmart-output-"
+
+// pluginProtocolVersion is sent with every request and changes when the contract breaks.
+const pluginProtocolVersion = 1
+
+// Actions of a pluginRequest.
+const (
+	PluginWrite = "write"
+	PluginProbe = "probe"
+)
+
+// pluginRequest is the JSON object written to the stdin of a plugin, which is started for every
+// request and exits once it is handled. A probe carries no records, the plugin only checks it
+// could deliver them, as for OutputProbe.
+type pluginRequest struct {
+	Version int    `json:"version"`
+	Action  string `json:"action"`
+	// Config is the plugin's entry of Config.Plugins, null without one
+	Config json.RawMessage `json:"config"`
+	// Records are rendered like the json output
+	Records []any `json:"records"`
+}
+
+// pluginResponse is the JSON object a plugin may print to stdout, nothing means success. A
+// plugin also fails by exiting non-zero, the last line of its stderr is then the error.
+type pluginResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// pluginName returns the plugin an output type is written by.
+func pluginName(outputType string) (string, bool) {
+	name, ok := strings.CutPrefix(outputType, OutputPluginPrefix)
+	return name, ok && name != ""
+}
+
+// pluginDirs are searched for plugins in order: PluginDir if set, otherwise the user config
+// directory, /etc/gosmart and next to the binary, like the config file.
+func pluginDirs(conf Config) []string {
+	if conf.PluginDir != "" {
+		return []string{conf.PluginDir}
+	}
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "gosmart", "plugins"))
+	}
+	if runtime.GOOS != "windows" {
+		dirs = append(dirs, "/etc/gosmart/plugins")
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Join(filepath.Dir(exe), "plugins"))
+	}
+	return dirs
+}
+
+// pluginExecutable returns the plugin name of an executable, false for other files. On Windows
+// plugins are .exe files, elsewhere files with an execute bit.
+func pluginExecutable(path string) (string, bool) {
+	name, ok := strings.CutPrefix(filepath.Base(path), pluginExecutablePrefix)
+	if !ok {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	if runtime.GOOS == "windows" {
+		name, ok = strings.CutSuffix(name, ".exe")
+		return name, ok && name != ""
+	}
+	return name, name != "" && info.Mode()&0o111 != 0
+}
+
+// discoverPlugins returns the path of every plugin by name, the first directory holding a name
+// taking precedence.
+func discoverPlugins(conf Config) map[string]string {
+	plugins := make(map[string]string)
+	for _, dir := range pluginDirs(conf) {
+		paths, _ := filepath.Glob(filepath.Join(dir, pluginExecutablePrefix+"*"))
+		for _, path := range paths {
+			if name, ok := pluginExecutable(path); ok {
+				if _, seen := plugins[name]; !seen {
+					plugins[name] = path
+				}
+			}
+		}
+	}
+	return plugins
+}
+
+func findPlugin(name string, conf Config) (string, error) {
+	if path, ok := discoverPlugins(conf)[name]; ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("no %s%s executable in %s", pluginExecutablePrefix, name, strings.Join(pluginDirs(conf), ", "))
+}
+
+// runPlugin sends a request to the plugin writing outputType. The plugin is killed once the
+// output's SinkLimit timeout passes.
+func runPlugin(action string, records []PartitionLine, outputType string, conf Config) error {
+	name, _ := pluginName(outputType)
+	path, err := findPlugin(name, conf)
+	if err != nil {
+		return fmt.Errorf("plugin output error: %w", err)
+	}
+	request := pluginRequest{Version: pluginProtocolVersion, Action: action, Config: conf.Plugins[name], Records: []any{}}
+	for _, record := range records {
+		v, err := renderRecord(record, conf.TimestampFormat)
+		if err != nil {
+			return fmt.Errorf("plugin %s output error for %s: %w", name, record.PartitionName, err)
+		}
+		request.Records = append(request.Records, v)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("plugin %s output error: %w", name, err)
+	}
+
+	ctx := context.Background()
+	if timeout := conf.SinkLimits[outputType].timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// Children of a killed plugin may hold its output open, stop waiting for them
+	cmd.WaitDelay = time.Second
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("plugin %s killed after %s", name, conf.SinkLimits[outputType].timeout())
+	} else if err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			return fmt.Errorf("plugin %s failed: %w: %s", name, err, last)
+		}
+		return fmt.Errorf("plugin %s failed: %w", name, err)
+	}
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		var response pluginResponse
+		if err := json.Unmarshal(out, &response); err != nil {
+			return fmt.Errorf("plugin %s printed an invalid response: %w", name, err)
+		}
+		if response.Error != "" {
+			return fmt.Errorf("plugin %s failed: %s", name, response.Error)
+		}
+	}
+	return nil
+}
+
+// runPluginsCommand implements `gosmart plugins`, which lists the discovered plugins and, with
+// -probe, whether each could deliver records.
+func runPluginsCommand(args []string) {
+	fs := flag.NewFlagSet("plugins", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	probe := fs.Bool("probe", false, "Send every plugin a probe request")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+
+	plugins := discoverPlugins(conf)
+	if len(plugins) == 0 {
+		fmt.Printf("No plugins found in %s\n", strings.Join(pluginDirs(conf), ", "))
+		return
+	}
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	configured := make(map[string]bool)
+	for _, outputType := range outputTypes(conf) {
+		configured[outputType] = true
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "OUTPUT\tCONFIGURED\tPATH\tPROBE")
+	for _, name := range names {
+		outputType := OutputPluginPrefix + name
+		result := "-"
+		if *probe {
+			start := time.Now()
+			if err := runPlugin(PluginProbe, nil, outputType, conf); err != nil {
+				result = err.Error()
+			} else {
+				result = fmt.Sprintf("ready in %s", time.Since(start).Round(time.Millisecond))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\n", outputType, configured[outputType], plugins[name], result)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PowerSettings are a drive's ATA power management and cache settings. The standby timer is not
+// included, ATA drives accept it but cannot report it back.
+type PowerSettings struct {
+	// ApmLevel is the Advanced Power Management level, 1 to 254. Levels up to 127 allow the drive
+	// to spin down and low levels park the heads aggressively.
+	ApmEnabled bool `json:"apm_enabled"`
+	ApmLevel   int  `json:"apm_level,omitempty"`
+	// AamLevel is the Automatic Acoustic Management level, 128 (quiet) to 254 (fast)
+	AamEnabled bool `json:"aam_enabled"`
+	AamLevel   int  `json:"aam_level,omitempty"`
+	// WriteCache and ReadLookahead are nil if the drive does not report them
+	WriteCache    *bool `json:"write_cache,omitempty"`
+	ReadLookahead *bool `json:"read_lookahead,omitempty"`
+}
+
+func (s PowerSettings) String() string {
+	parts := []string{"APM " + powerLevel(s.ApmEnabled, s.ApmLevel), "AAM " + powerLevel(s.AamEnabled, s.AamLevel)}
+	if s.WriteCache != nil {
+		parts = append(parts, "write cache "+enabledString(*s.WriteCache))
+	}
+	if s.ReadLookahead != nil {
+		parts = append(parts, "read look-ahead "+enabledString(*s.ReadLookahead))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func powerLevel(enabled bool, level int) string {
+	if !enabled {
+		return "disabled"
+	}
+	return fmt.Sprintf("%d", level)
+}
+
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// ExpectedPowerSettings are checked against the power settings of ATA drives, each mismatch is
+// reported as a warning. Unset fields are not checked.
+type ExpectedPowerSettings struct {
+	// MinApmLevel warns when APM is enabled with a lower level, 128 or above keeps drives from
+	// spinning down and parking their heads on short idle periods
+	MinApmLevel   int   `json:"min_apm_level,omitempty"`
+	WriteCache    *bool `json:"write_cache,omitempty"`
+	ReadLookahead *bool `json:"read_lookahead,omitempty"`
+	// MaxLoadCyclesPerHour warns when attribute 193 (Load_Cycle_Count) has grown faster than this
+	// over the power-on hours of attribute 9, a sign of aggressive head parking. Both attributes
+	// must be collected.
+	MaxLoadCyclesPerHour float64 `json:"max_load_cycles_per_hour,omitempty"`
+}
+
+// collectPowerSettings reads the APM, AAM and cache settings of devName through smartctl and
+// compares them to the configured expectations.
+func collectPowerSettings(devName string, line PartitionLine, conf Config) PartitionLine {
+	out, err := runSmartctl(conf.SmartctlPath, devName, "-g", "apm", "-g", "aam", "-g", "wcache", "-g", "lookahead")
+	if err != nil {
+		fmt.Printf("Could not read power settings for %s with smartctl: %s\n", devName, err)
+		return line
+	}
+
+	settings := &PowerSettings{}
+	if out.AtaApm != nil {
+		settings.ApmEnabled, settings.ApmLevel = out.AtaApm.Enabled, out.AtaApm.Level
+	}
+	if out.AtaAam != nil {
+		settings.AamEnabled, settings.AamLevel = out.AtaAam.Enabled, out.AtaAam.Level
+	}
+	if out.WriteCache != nil {
+		settings.WriteCache = &out.WriteCache.Enabled
+	}
+	if out.ReadLookahead != nil {
+		settings.ReadLookahead = &out.ReadLookahead.Enabled
+	}
+	line.PowerSettings = settings
+
+	if expected := conf.ExpectedPowerSettings; expected != nil {
+		line = checkPowerSettings(line, *expected)
+	}
+	return line
+}
+
+func checkPowerSettings(line PartitionLine, expected ExpectedPowerSettings) PartitionLine {
+	settings := line.PowerSettings
+	if expected.MinApmLevel > 0 && settings.ApmEnabled && settings.ApmLevel < expected.MinApmLevel {
+		line.warn("APM level %d is below %d", settings.ApmLevel, expected.MinApmLevel)
+	}
+	if expected.WriteCache != nil && settings.WriteCache != nil && *settings.WriteCache != *expected.WriteCache {
+		line.warn("write cache is %s, expected %s", enabledString(*settings.WriteCache), enabledString(*expected.WriteCache))
+	}
+	if expected.ReadLookahead != nil && settings.ReadLookahead != nil && *settings.ReadLookahead != *expected.ReadLookahead {
+		line.warn("read look-ahead is %s, expected %s", enabledString(*settings.ReadLookahead), enabledString(*expected.ReadLookahead))
+	}
+	if expected.MaxLoadCyclesPerHour > 0 {
+		var loadCycles, hours *Attr
+		for i, attr := range line.Attributes {
+			switch attr.Id {
+			case 193:
+				loadCycles = &line.Attributes[i]
+			case 9:
+				hours = &line.Attributes[i]
+			}
+		}
+		if loadCycles != nil && hours != nil && hours.ValueDecoded > 0 {
+			rate := float64(loadCycles.ValueDecoded) / float64(hours.ValueDecoded)
+			if rate > expected.MaxLoadCyclesPerHour {
+				line.warn("%.1f load cycles per power-on hour, above %g, check the APM level", rate, expected.MaxLoadCyclesPerHour)
+			}
+		}
+	}
+	return line
+}
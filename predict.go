@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// FailurePredictionConfig fits a linear trend to the stored readings of attributes that grow
+// toward failure, such as reallocated sectors or SSD wear, and estimates when they reach a limit.
+// It needs the local store or the postgres output.
+type FailurePredictionConfig struct {
+	Limits []PredictionLimit `json:"limits"`
+	// WindowDays of stored readings the trend is fitted to, 30 if 0
+	WindowDays int `json:"window_days,omitempty"`
+	// WarnDays warns when a limit is predicted to be reached within this many days, off if 0
+	WarnDays int `json:"warn_days,omitempty"`
+}
+
+// PredictionLimit is the level of an attribute a drive is considered worn out at, either a
+// decoded raw value it grows to or a normalized value it falls to.
+type PredictionLimit struct {
+	Attribute  uint8   `json:"attribute"`
+	MaxValue   *uint64 `json:"max_value,omitempty"`
+	MinCurrent *uint8  `json:"min_current,omitempty"`
+}
+
+// FailurePrediction is the estimated date an attribute reaches its limit at its recent rate.
+type FailurePrediction struct {
+	Attribute uint8  `json:"attribute"`
+	Name      string `json:"name"`
+	Limit     uint64 `json:"limit"`
+	// Normalized is set when Limit is a normalized value rather than a decoded raw value
+	Normalized bool      `json:"normalized,omitempty"`
+	Date       time.Time `json:"date"`
+}
+
+func (p FailurePrediction) String() string {
+	verb := "reaches"
+	if p.Normalized {
+		verb = "falls to"
+	}
+	return fmt.Sprintf("%d %s %s %d around %s, ~%s left", p.Attribute, p.Name, verb, p.Limit, p.Date.Format("2006-01-02"), timeLeft(time.Until(p.Date)))
+}
+
+// timeLeft rounds d to days, weeks or months.
+func timeLeft(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	switch {
+	case days < 14:
+		return fmt.Sprintf("%d days", max(days, 0))
+	case days < 120:
+		return fmt.Sprintf("%d weeks", days/7)
+	}
+	return fmt.Sprintf("%d months", days/30)
+}
+
+const (
+	// minPredictionSpan and minPredictionReadings keep a few readings close together from
+	// producing wild trends
+	minPredictionSpan     = 24 * time.Hour
+	minPredictionReadings = 3
+	// maxPredictionYears drops predictions too far out to mean anything
+	maxPredictionYears = 5
+)
+
+// predictionPoint is a reading of an attribute, hours since the start of the window.
+type predictionPoint struct {
+	hours float64
+	value float64
+}
+
+// fitSlope returns the least squares slope of the points per hour.
+func fitSlope(points []predictionPoint) float64 {
+	var meanX, meanY float64
+	for _, p := range points {
+		meanX += p.hours
+		meanY += p.value
+	}
+	meanX /= float64(len(points))
+	meanY /= float64(len(points))
+	var cov, variance float64
+	for _, p := range points {
+		cov += (p.hours - meanX) * (p.value - meanY)
+		variance += (p.hours - meanX) * (p.hours - meanX)
+	}
+	if variance == 0 {
+		return 0
+	}
+	return cov / variance
+}
+
+// applyFailurePredictions sets the predictions of records from their stored readings and the
+// current reading, and warns when a limit is near.
+func applyFailurePredictions(records []PartitionLine, prediction FailurePredictionConfig, conf Config) []PartitionLine {
+	window := 30 * 24 * time.Hour
+	if prediction.WindowDays > 0 {
+		window = time.Duration(prediction.WindowDays) * 24 * time.Hour
+	}
+	source, err := openHistory(conf)
+	if err != nil {
+		log.Printf("Could not load history for failure predictions: %s\n", err)
+		return records
+	}
+	defer source.Close()
+	start := time.Now().Add(-window)
+	rows, err := source.historySince(start)
+	if err != nil {
+		log.Printf("Could not load history for failure predictions: %s\n", err)
+		return records
+	}
+
+	type seriesKey struct {
+		partitionName string
+		attribute     uint8
+		normalized    bool
+	}
+	series := make(map[seriesKey][]predictionPoint)
+	for _, row := range rows {
+		attrs, err := row.attributes()
+		if err != nil {
+			continue
+		}
+		hours := row.Ts.Sub(start).Hours()
+		for _, attr := range attrs {
+			series[seriesKey{row.PartitionName, attr.Id, false}] = append(series[seriesKey{row.PartitionName, attr.Id, false}], predictionPoint{hours, float64(attr.value())})
+			series[seriesKey{row.PartitionName, attr.Id, true}] = append(series[seriesKey{row.PartitionName, attr.Id, true}], predictionPoint{hours, float64(attr.Current)})
+		}
+	}
+
+	for i := range records {
+		record := &records[i]
+		for _, limit := range prediction.Limits {
+			for _, attr := range record.Attributes {
+				if attr.Id != limit.Attribute {
+					continue
+				}
+				hours := record.Ts.Sub(start).Hours()
+				if limit.MaxValue != nil {
+					points := append(series[seriesKey{record.PartitionName, attr.Id, false}], predictionPoint{hours, float64(attr.ValueDecoded)})
+					record.predict(points, attr, *limit.MaxValue, float64(attr.ValueDecoded), false, prediction.WarnDays)
+				}
+				if limit.MinCurrent != nil {
+					points := append(series[seriesKey{record.PartitionName, attr.Id, true}], predictionPoint{hours, float64(attr.Current)})
+					record.predict(points, attr, uint64(*limit.MinCurrent), float64(attr.Current), true, prediction.WarnDays)
+				}
+			}
+		}
+	}
+	return records
+}
+
+// predict adds the prediction of attr reaching limit from current at the trend of points, if it
+// is heading there and has not reached it yet.
+func (p *PartitionLine) predict(points []predictionPoint, attr Attr, limit uint64, current float64, normalized bool, warnDays int) {
+	if len(points) < minPredictionReadings || points[len(points)-1].hours-points[0].hours < minPredictionSpan.Hours() {
+		return
+	}
+	slope := fitSlope(points)
+	remaining := float64(limit) - current
+	// Heading away from the limit, flat, or already past it
+	if slope == 0 || remaining == 0 || (remaining > 0) != (slope > 0) {
+		return
+	}
+	hours := remaining / slope
+	if hours > maxPredictionYears*365*24 {
+		return
+	}
+	prediction := FailurePrediction{Attribute: attr.Id, Name: attr.Name, Limit: limit, Normalized: normalized, Date: p.Ts.Add(time.Duration(hours * float64(time.Hour)))}
+	p.Predictions = append(p.Predictions, prediction)
+	if warnDays > 0 && hours < float64(warnDays*24) {
+		p.warn("predicted failure: %s", prediction)
+	}
+}
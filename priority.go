@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+// I/O scheduling classes of PriorityConfig.IoClass
+const (
+	IoClassRealtime   = "realtime"
+	IoClassBestEffort = "best-effort"
+	IoClassIdle       = "idle"
+)
+
+// PriorityConfig lowers the priority of gosmart and the smartctl processes it starts, so collection
+// does not compete with production workloads. Linux only.
+type PriorityConfig struct {
+	// Nice level from -20 to 19, unchanged if zero
+	Nice int `json:"nice,omitempty"`
+	// IoClass is realtime, best-effort or idle, unchanged if empty. Idle only gets disk time when
+	// no other process needs it.
+	IoClass string `json:"io_class,omitempty"`
+	// IoLevel within the realtime and best-effort classes, from 0 (highest) to 7
+	IoLevel int `json:"io_level,omitempty"`
+	// Cgroup is the directory of an existing cgroup v2 group to move the process into, e.g.
+	// /sys/fs/cgroup/gosmart with io.max or cpu.max limits set
+	Cgroup string `json:"cgroup,omitempty"`
+}
+
+// ioClasses are the IOPRIO_CLASS_* values of the I/O scheduling classes.
+var ioClasses = map[string]int{IoClassRealtime: 1, IoClassBestEffort: 2, IoClassIdle: 3}
+
+// applyPriority applies the configured priority to the process. Failures are logged, collection
+// goes ahead at the normal priority.
+func applyPriority(conf *PriorityConfig) {
+	if conf == nil {
+		return
+	}
+	if conf.Nice != 0 {
+		if err := setNice(conf.Nice); err != nil {
+			log.Printf("could not set nice level %d: %s\n", conf.Nice, err)
+		}
+	}
+	if conf.IoClass != "" {
+		class, ok := ioClasses[conf.IoClass]
+		if !ok {
+			log.Printf("unknown I/O class %q\n", conf.IoClass)
+		} else if conf.IoLevel < 0 || conf.IoLevel > 7 {
+			log.Printf("I/O level %d is not between 0 and 7\n", conf.IoLevel)
+		} else if err := setIoPriority(class, conf.IoLevel); err != nil {
+			log.Printf("could not set I/O class %s: %s\n", conf.IoClass, err)
+		}
+	}
+	if conf.Cgroup != "" {
+		if err := joinCgroup(conf.Cgroup); err != nil {
+			log.Printf("could not join cgroup %s: %s\n", conf.Cgroup, err)
+		}
+	}
+}
+
+var errPriorityUnsupported = errors.New("not supported on this platform")
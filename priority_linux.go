@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const ioprioWhoProcess = 1
+
+// forEachThread calls set with the ID of every thread of the process. Nice levels and I/O
+// priorities are per thread on Linux, new threads inherit them from the thread creating them.
+func forEachThread(set func(tid int) error) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		// Threads may exit meanwhile
+		if err := set(tid); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+func setNice(nice int) error {
+	return forEachThread(func(tid int) error {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice)
+	})
+}
+
+func setIoPriority(class, level int) error {
+	prio := class<<13 | level
+	return forEachThread(func(tid int) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+func joinCgroup(dir string) error {
+	// Without O_CREATE, a directory that is not a cgroup fails instead of getting a plain file
+	f, err := os.OpenFile(filepath.Join(dir, "cgroup.procs"), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+	return err
+}
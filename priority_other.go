@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+func setNice(nice int) error {
+	return errPriorityUnsupported
+}
+
+func setIoPriority(class, level int) error {
+	return errPriorityUnsupported
+}
+
+func joinCgroup(dir string) error {
+	return errPriorityUnsupported
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches the process to userName and groupName, the user's primary group if
+// empty, and drops supplementary groups.
+func dropPrivileges(userName, groupName string) error {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return fmt.Errorf("could not drop privileges: %w", err)
+	}
+	gid := u.Gid
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return fmt.Errorf("could not drop privileges: %w", err)
+		}
+		gid = g.Gid
+	}
+	uidN, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("could not drop privileges: uid %s: %w", u.Uid, err)
+	}
+	gidN, err := strconv.Atoi(gid)
+	if err != nil {
+		return fmt.Errorf("could not drop privileges: gid %s: %w", gid, err)
+	}
+
+	// Groups first, they cannot be changed once the user is no longer root
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return fmt.Errorf("could not drop supplementary groups: %w", err)
+	}
+	if err := syscall.Setgid(gidN); err != nil {
+		return fmt.Errorf("could not switch to group %s: %w", gid, err)
+	}
+	if err := syscall.Setuid(uidN); err != nil {
+		return fmt.Errorf("could not switch to user %s: %w", userName, err)
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package main
+
+import "errors"
+
+func dropPrivileges(userName, groupName string) error {
+	return errors.New("dropping privileges is not supported on Windows")
+}
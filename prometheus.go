@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Histogram buckets of /metrics, collection latency in seconds and temperature in degrees Celsius.
+var (
+	collectSecondsBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	temperatureBuckets    = []float64{20, 25, 30, 35, 40, 45, 50, 55, 60, 65, 70}
+)
+
+// promHistogram is a Prometheus histogram of the observations since serve started.
+type promHistogram struct {
+	bounds []float64
+	// counts per bucket, not cumulative, the last one is +Inf
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newPromHistogram(bounds []float64) *promHistogram {
+	return &promHistogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *promHistogram) observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// write writes the _bucket, _sum and _count series of the histogram.
+func (h *promHistogram) write(w io.Writer, name string, labels map[string]string) {
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		le := math.Inf(1)
+		if i < len(h.bounds) {
+			le = h.bounds[i]
+		}
+		bucket := map[string]string{"le": promFloat(le)}
+		for k, v := range labels {
+			bucket[k] = v
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, promLabels(bucket), cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, promLabels(labels), promFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, promLabels(labels), h.count)
+}
+
+// observeRecords adds a collection's records to the daemon's histograms, d.mu must be held.
+func (d *daemon) observeRecords(records []PartitionLine) {
+	if d.collectSeconds == nil {
+		d.collectSeconds = make(map[string]*promHistogram)
+		d.temperatures = newPromHistogram(temperatureBuckets)
+	}
+	for _, record := range records {
+		h, ok := d.collectSeconds[record.PartitionName]
+		if !ok {
+			h = newPromHistogram(collectSecondsBuckets)
+			d.collectSeconds[record.PartitionName] = h
+		}
+		h.observe(record.CollectSeconds)
+		if temperature, ok := deviceTemperature(record); ok && record.Error == nil {
+			d.temperatures.observe(float64(temperature))
+		}
+	}
+}
+
+// handleMetrics serves the samples of the last collection as gauges or counters in the
+// Prometheus text format, along with histograms of the collection latency per device and of the temperature of
+// all devices since serve started.
+func (d *daemon) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var samples []metricSample
+	for _, record := range d.lastRecords {
+		record = filterAttributes(record, d.conf.OutputAttributes["prometheus"])
+		for _, sample := range recordMetrics(record, d.conf.DropMetricLabels) {
+			// A name has one type, counter attributes are split off from the gauges
+			if sample.Name == "smart_attribute_value" && sample.Type == MetricCounter {
+				sample.Name = "smart_attribute_total"
+			}
+			samples = append(samples, sample)
+		}
+	}
+	// A name's samples must be grouped under one TYPE line
+	slices.SortStableFunc(samples, func(a, b metricSample) int { return strings.Compare(a.Name, b.Name) })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for i, sample := range samples {
+		if i == 0 || samples[i-1].Name != sample.Name {
+			metricType := MetricGauge
+			if sample.Type == MetricCounter {
+				metricType = MetricCounter
+			}
+			fmt.Fprintf(w, "# TYPE %s %s\n", sample.Name, metricType)
+		}
+		fmt.Fprintf(w, "%s%s %s\n", sample.Name, promLabels(sample.Labels), promFloat(sample.Value))
+	}
+
+	if len(d.collectSeconds) > 0 {
+		fmt.Fprintln(w, "# HELP smart_collect_duration_seconds Time to read a device.")
+		fmt.Fprintln(w, "# TYPE smart_collect_duration_seconds histogram")
+		devices := make([]string, 0, len(d.collectSeconds))
+		for device := range d.collectSeconds {
+			devices = append(devices, device)
+		}
+		slices.Sort(devices)
+		for _, device := range devices {
+			d.collectSeconds[device].write(w, "smart_collect_duration_seconds", map[string]string{"device": device})
+		}
+	}
+	if d.temperatures != nil {
+		fmt.Fprintln(w, "# HELP smart_temperature_celsius Temperatures of all devices at each collection.")
+		fmt.Fprintln(w, "# TYPE smart_temperature_celsius histogram")
+		d.temperatures.write(w, "smart_temperature_celsius", nil)
+	}
+
+	pause := d.pauseStatus(time.Now())
+	paused := 0
+	if pause.Paused {
+		paused = 1
+	}
+	fmt.Fprintln(w, "# HELP smart_collection_paused Whether collection is paused.")
+	fmt.Fprintln(w, "# TYPE smart_collection_paused gauge")
+	fmt.Fprintf(w, "smart_collection_paused %d\n", paused)
+	fmt.Fprintln(w, "# HELP smart_collections_skipped_total Collections skipped while paused.")
+	fmt.Fprintln(w, "# TYPE smart_collections_skipped_total counter")
+	fmt.Fprintf(w, "smart_collections_skipped_total %d\n", pause.Skipped)
+}
+
+// promLabels formats labels as {k="v",...} sorted by name, or nothing without labels.
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	pairs := make([]string, len(names))
+	for i, k := range names {
+		pairs[i] = k + `="` + promEscaper.Replace(labels[k]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"os"
+	"time"
+)
+
+// The protobuf output encodes gosmart.proto directly with protowire, so no generated code has to be
+// kept in sync. Field numbers below must match the .proto, TestProtobufRecordSchema checks them.
+
+type ProtobufConfig struct {
+	// Path records are appended to, each prefixed with its varint length
+	Path string `json:"path"`
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendProtoDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// appendProtoTimestamp appends t as a google.protobuf.Timestamp.
+func appendProtoTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	var ts []byte
+	ts = appendProtoVarint(ts, 1, uint64(t.Unix()))
+	ts = appendProtoVarint(ts, 2, uint64(t.Nanosecond()))
+	return appendProtoMessage(b, num, ts)
+}
+
+// marshalProtoRecord encodes a record as a gosmart.PartitionRecord message.
+func marshalProtoRecord(record PartitionLine) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, record.Uuid)
+
+	b = appendProtoTimestamp(b, 2, record.Ts)
+
+	b = appendProtoString(b, 3, record.PartitionName)
+	b = appendProtoString(b, 4, record.Label)
+	b = appendProtoString(b, 5, record.MountPath)
+	b = appendProtoVarint(b, 6, record.SizeBytes)
+	b = appendProtoString(b, 7, record.Model)
+	b = appendProtoString(b, 8, record.Firmware)
+	b = appendProtoVarint(b, 9, protowire.EncodeBool(record.SmartSupported))
+	b = appendProtoString(b, 10, record.SkipReason)
+
+	for _, attr := range record.Attributes {
+		var a []byte
+		a = appendProtoVarint(a, 1, uint64(attr.Id))
+		a = appendProtoString(a, 2, attr.Name)
+		a = appendProtoVarint(a, 3, uint64(attr.Current))
+		a = appendProtoVarint(a, 4, uint64(attr.Worst))
+		a = appendProtoVarint(a, 5, attr.ValueRaw)
+		a = appendProtoVarint(a, 6, attr.ValueDecoded)
+		b = appendProtoMessage(b, 11, a)
+	}
+
+	if h := record.NvmeHealth; h != nil {
+		var n []byte
+		n = appendProtoVarint(n, 1, uint64(h.CriticalWarning))
+		n = appendProtoVarint(n, 2, protowire.EncodeZigZag(int64(h.TemperatureC)))
+		n = appendProtoVarint(n, 3, uint64(h.AvailableSpare))
+		n = appendProtoVarint(n, 4, uint64(h.AvailableSpareThreshold))
+		n = appendProtoVarint(n, 5, uint64(h.PercentageUsed))
+		n = appendProtoVarint(n, 6, h.DataUnitsRead)
+		n = appendProtoVarint(n, 7, h.DataUnitsWritten)
+		n = appendProtoVarint(n, 8, h.PowerCycles)
+		n = appendProtoVarint(n, 9, h.PowerOnHours)
+		n = appendProtoVarint(n, 10, h.UnsafeShutdowns)
+		n = appendProtoVarint(n, 11, h.MediaErrors)
+		n = appendProtoVarint(n, 12, h.ErrorLogEntries)
+		b = appendProtoMessage(b, 12, n)
+	}
+
+	for _, w := range record.Warnings {
+		b = protowire.AppendTag(b, 13, protowire.BytesType)
+		b = protowire.AppendString(b, w)
+	}
+	b = appendProtoString(b, 14, record.Serial)
+
+	if e := record.Error; e != nil {
+		var m []byte
+		m = appendProtoString(m, 1, e.Class)
+		m = appendProtoString(m, 2, e.Message)
+		m = appendProtoVarint(m, 3, uint64(e.Attempts))
+		b = appendProtoMessage(b, 15, m)
+	}
+
+	if run := record.Run; run != nil {
+		var r []byte
+		r = appendProtoString(r, 1, run.Id)
+		r = appendProtoTimestamp(r, 2, run.Started)
+		r = appendProtoDouble(r, 3, run.DurationSeconds)
+		r = appendProtoString(r, 4, run.Version)
+		r = appendProtoVarint(r, 5, uint64(run.Devices))
+		r = appendProtoVarint(r, 6, uint64(run.Errors))
+		r = appendProtoString(r, 7, run.Event)
+		r = appendProtoString(r, 8, run.EventDetail)
+		b = appendProtoMessage(b, 16, r)
+	}
+
+	b = appendProtoVarint(b, 17, recordSchemaVersion)
+	return b
+}
+
+// saveToProtobuf appends a length prefixed record to the configured file.
+func saveToProtobuf(record PartitionLine, conf ProtobufConfig) error {
+	msg := marshalProtoRecord(record)
+	framed := protowire.AppendVarint(nil, uint64(len(msg)))
+	framed = append(framed, msg...)
+
+	f, err := os.OpenFile(conf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("protobuf output error: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(framed); err != nil {
+		return fmt.Errorf("protobuf write error for %s: %w", record.PartitionName, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// protoRecordDescriptor compiles gosmart.proto, so the output is checked against the schema users
+// generate their code from.
+func protoRecordDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	compiler := protocompile.Compiler{Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{})}
+	files, err := compiler.Compile(context.Background(), "gosmart.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := files[0].Messages().ByName("PartitionRecord")
+	if desc == nil {
+		t.Fatal("gosmart.proto has no PartitionRecord")
+	}
+	return desc
+}
+
+// expectAllFields fails for fields of m that are unset, and for data that decoded to no field of
+// the schema, recursing into nested messages.
+func expectAllFields(t *testing.T, path string, m protoreflect.Message) {
+	t.Helper()
+	if len(m.GetUnknown()) > 0 {
+		t.Errorf("%s has data of no field of the schema, a field number or wire type differs", path)
+	}
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := path + "." + string(fd.Name())
+		if !m.Has(fd) {
+			t.Errorf("%s is not written", name)
+			continue
+		}
+		if fd.Kind() != protoreflect.MessageKind {
+			continue
+		}
+		if fd.IsList() {
+			list := m.Get(fd).List()
+			for j := 0; j < list.Len(); j++ {
+				expectAllFields(t, name, list.Get(j).Message())
+			}
+		} else {
+			expectAllFields(t, name, m.Get(fd).Message())
+		}
+	}
+}
+
+func TestProtobufRecordSchema(t *testing.T) {
+	desc := protoRecordDescriptor(t)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	full := PartitionLine{
+		Uuid: "0b8c", Ts: ts, PartitionName: "/dev/nvme0n1", Label: "data", MountPath: "/data", SizeBytes: 1 << 40,
+		Model: "WD Blue SN570", Firmware: "234110WD", Serial: "22181L800123", SmartSupported: true, SkipReason: "standby",
+		Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 9, Name: "Power_On_Hours", Current: 98, Worst: 97, ValueRaw: 1 << 33}, ValueDecoded: 21000}},
+		NvmeHealth: &NvmeHealth{
+			CriticalWarning: 4, TemperatureC: -5, AvailableSpare: 100, AvailableSpareThreshold: 10, PercentageUsed: 3,
+			DataUnitsRead: 1, DataUnitsWritten: 2, PowerCycles: 3, PowerOnHours: 4, UnsafeShutdowns: 5, MediaErrors: 6, ErrorLogEntries: 7,
+		},
+		Warnings: []string{"media errors"},
+		Error:    &CollectionError{Class: ErrorClassPermission, Message: "open /dev/nvme0n1: permission denied", Attempts: 2},
+		Run: &RunInfo{
+			Id: "run-1", Started: ts, DurationSeconds: 1.5, Version: "1.2.0", Devices: 3, Errors: 1,
+			Event: "udev", EventDetail: "add nvme0n1",
+		},
+	}
+	failed := PartitionLine{Ts: ts, PartitionName: "/dev/sdb", Error: &CollectionError{Class: ErrorClassPermission, Message: "denied"}}
+
+	path := filepath.Join(t.TempDir(), "records.pb")
+	for _, record := range []PartitionLine{full, failed} {
+		if err := saveToProtobuf(record, ProtobufConfig{Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var msgs []*dynamicpb.Message
+	for i := 0; i < 2; i++ {
+		msg := dynamicpb.NewMessage(desc)
+		if err := protodelim.UnmarshalFrom(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	expectAllFields(t, "PartitionRecord", msgs[0])
+	get := func(m protoreflect.Message, name string) protoreflect.Value {
+		return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+	}
+	if v := get(msgs[0], "serial").String(); v != full.Serial {
+		t.Errorf("serial %q", v)
+	}
+	if v := get(msgs[0], "schema_version").Uint(); v != recordSchemaVersion {
+		t.Errorf("schema version %d", v)
+	}
+	if v := get(get(msgs[0], "nvme_health").Message(), "temperature_c").Int(); v != -5 {
+		t.Errorf("temperature %d", v)
+	}
+	attr := get(msgs[0], "attributes").List().Get(0).Message()
+	if raw, value := get(attr, "raw").Uint(), get(attr, "value").Uint(); raw != 1<<33 || value != 21000 {
+		t.Errorf("attribute raw %d value %d", raw, value)
+	}
+	run := get(msgs[0], "run").Message()
+	started := get(run, "started").Message()
+	if get(run, "duration_seconds").Float() != 1.5 || get(started, "seconds").Int() != ts.Unix() || get(started, "nanos").Int() != 500 {
+		t.Errorf("run %v", run)
+	}
+
+	// A failed collection is told apart from a drive without attributes by its error
+	e := get(msgs[1], "error").Message()
+	if get(e, "class").String() != ErrorClassPermission || get(e, "message").String() != "denied" {
+		t.Errorf("error %v", e)
+	}
+	if get(msgs[1], "attributes").List().Len() != 0 || get(msgs[1], "schema_version").Uint() != recordSchemaVersion {
+		t.Errorf("failed record %v", msgs[1])
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2/google"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Values of Config.OutputProbe
+const (
+	ProbeWarn    = "warn"
+	ProbeRequire = "require"
+	ProbeOff     = "off"
+)
+
+// probeTimeout bounds the probe of each output.
+const probeTimeout = 15 * time.Second
+
+// OutputReadiness is the outcome of probing one output at startup.
+type OutputReadiness struct {
+	Output string `json:"output"`
+	Ready  bool   `json:"ready"`
+	// Target is what was probed, e.g. the address connected to
+	Target string `json:"target,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (r OutputReadiness) String() string {
+	msg := r.Output
+	if r.Target != "" {
+		msg += " (" + r.Target + ")"
+	}
+	if r.Ready {
+		return msg + ": ready"
+	}
+	return msg + ": not ready, " + r.Error
+}
+
+// probeHttp sends req and fails if nothing answers, the server fails or it rejects the credentials
+// sent. Other client errors are expected, probes do not send a valid payload.
+func probeHttp(client http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	rejected := req.Header.Get("Authorization") != "" && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden)
+	if resp.StatusCode >= 500 || rejected {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// probeUrl sends a HEAD request to url, see probeHttp.
+func probeUrl(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	return probeHttp(http.Client{}, req)
+}
+
+// probeWritablePath checks path can be appended to, or created if it does not exist yet.
+func probeWritablePath(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return probeWritableDir(filepath.Dir(path))
+}
+
+func probeWritableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".gosmart-probe-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// probeGoogleCredentials fetches a token with the credentials, which fails if they are missing,
+// revoked or the token endpoint is unreachable.
+func probeGoogleCredentials(creds *google.Credentials, err error, projectId string) error {
+	if err != nil {
+		return fmt.Errorf("google credentials error: %w", err)
+	}
+	if projectId == "" && creds.ProjectID == "" {
+		return errors.New("no Google Cloud project configured or found in the credentials")
+	}
+	if _, err := creds.TokenSource.Token(); err != nil {
+		return fmt.Errorf("google credentials error: %w", err)
+	}
+	return nil
+}
+
+// probeOutput checks that an output can be written to, without writing a record. It returns what
+// was probed. Outputs without a config print json like writeRecord does.
+func probeOutput(ctx context.Context, outputType string, conf Config) (string, error) {
+	switch {
+	case outputType == OutputJson || outputType == OutputTable:
+		return "stdout", nil
+	case outputType == OutputPostgres && conf.Db != nil:
+		target := fmt.Sprintf("%s:%d", conf.Db.Host, conf.Db.Port)
+		db, err := connectPostgres(*conf.Db)
+		if err != nil {
+			return target, err
+		}
+		return target, db.Close()
+	case outputType == OutputRedis && conf.Redis != nil:
+		c, err := dialRedis(*conf.Redis)
+		if err != nil {
+			return conf.Redis.Address, fmt.Errorf("redis connection error: %w", err)
+		}
+		defer c.Close()
+		return conf.Redis.Address, c.do("PING")
+	case outputType == OutputVictoria && conf.VictoriaMetrics != nil:
+		url := strings.TrimSuffix(conf.VictoriaMetrics.Url, "/") + "/health"
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return url, err
+		}
+		if conf.VictoriaMetrics.Username != "" {
+			req.SetBasicAuth(conf.VictoriaMetrics.Username, conf.VictoriaMetrics.Password)
+		}
+		return url, probeHttp(http.Client{}, req)
+	case outputType == OutputGcm:
+		gcm := GcmConfig{}
+		if conf.Gcm != nil {
+			gcm = *conf.Gcm
+		}
+		creds, err := google.FindDefaultCredentials(ctx, gcmScope)
+		return "application default credentials", probeGoogleCredentials(creds, err, gcm.ProjectId)
+	case outputType == OutputBigQuery && conf.BigQuery != nil:
+		creds, err := bigQueryCredentials(ctx, *conf.BigQuery)
+		return "credentials", probeGoogleCredentials(creds, err, conf.BigQuery.ProjectId)
+	case outputType == OutputAzure && conf.Azure != nil:
+		url := fmt.Sprintf("https://%s.ods.opinsights.azure.com", conf.Azure.WorkspaceId)
+		return url, probeUrl(ctx, url)
+	case outputType == OutputNewRelic && conf.NewRelic != nil:
+		url := newRelicUrl(conf.NewRelic.Region)
+		return url, probeUrl(ctx, url)
+	case outputType == OutputCommunity && conf.Community != nil:
+		return conf.Community.Url, probeUrl(ctx, conf.Community.Url)
+	case outputType == OutputIcinga && conf.Icinga != nil:
+		client := http.Client{Timeout: probeTimeout}
+		if conf.Icinga.InsecureSkipVerify {
+			client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		}
+		status, _, err := icingaRequest(client, *conf.Icinga, http.MethodGet, "/v1", nil)
+		if err == nil && (status >= 500 || status == http.StatusUnauthorized || status == http.StatusForbidden) {
+			err = fmt.Errorf("icinga answered %d", status)
+		}
+		return conf.Icinga.Url, err
+	case outputType == OutputSensu:
+		url := "http://127.0.0.1:3031"
+		if conf.Sensu != nil && conf.Sensu.BackendUrl != "" {
+			url = conf.Sensu.BackendUrl
+		} else if conf.Sensu != nil && conf.Sensu.AgentUrl != "" {
+			url = conf.Sensu.AgentUrl
+		}
+		return url, probeUrl(ctx, url)
+	case outputType == OutputFile && conf.File != nil:
+		return conf.File.Path, probeWritablePath(conf.File.Path)
+	case outputType == OutputAvro && conf.Avro != nil:
+		return conf.Avro.Path, probeWritablePath(conf.Avro.Path)
+	case outputType == OutputProtobuf && conf.Protobuf != nil:
+		return conf.Protobuf.Path, probeWritablePath(conf.Protobuf.Path)
+	case outputType == OutputArchive && conf.Archive != nil:
+		if err := os.MkdirAll(conf.Archive.Dir, 0o755); err != nil {
+			return conf.Archive.Dir, err
+		}
+		return conf.Archive.Dir, probeWritableDir(conf.Archive.Dir)
+	case outputType == OutputLocal && conf.Local != nil:
+		db, err := openLocalStore(*conf.Local, false)
+		if err != nil {
+			return conf.Local.Path, err
+		}
+		return conf.Local.Path, db.Close()
+	case outputType == OutputDuckdb && conf.Duckdb != nil:
+		return conf.Duckdb.Path, probeDuckdb(*conf.Duckdb)
+	case strings.HasPrefix(outputType, OutputPluginPrefix):
+		name, _ := pluginName(outputType)
+		path, err := findPlugin(name, conf)
+		if err != nil {
+			return "", err
+		}
+		return path, runPlugin(PluginProbe, nil, outputType, conf)
+	case slices.Contains(outputTypeNames, outputType):
+		return "stdout, no " + outputType + " config", nil
+	}
+	return "", errors.New("unknown output type, records would be dropped")
+}
+
+// probeOutputs probes every configured output at once, each for at most probeTimeout. A probe that
+// does not return in time is left behind like a timed out delivery.
+func probeOutputs(conf Config) []OutputReadiness {
+	types := outputTypes(conf)
+	results := make([]chan OutputReadiness, len(types))
+	for i, outputType := range types {
+		// Buffered so a probe that timed out can still send and exit
+		results[i] = make(chan OutputReadiness, 1)
+		go func(outputType string, result chan<- OutputReadiness) {
+			ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+			defer cancel()
+			r := OutputReadiness{Output: outputType}
+			var err error
+			r.Target, err = probeOutput(ctx, outputType, conf)
+			if err != nil {
+				r.Error = err.Error()
+			}
+			r.Ready = err == nil
+			result <- r
+		}(outputType, results[i])
+	}
+
+	readiness := make([]OutputReadiness, len(types))
+	deadline := time.NewTimer(probeTimeout)
+	defer deadline.Stop()
+	for i, result := range results {
+		select {
+		case readiness[i] = <-result:
+		case <-deadline.C:
+			// The deadline is shared, the remaining probes only get what was sent already
+			for ; i < len(results); i++ {
+				select {
+				case readiness[i] = <-results[i]:
+				default:
+					readiness[i] = OutputReadiness{Output: types[i], Error: fmt.Sprintf("no answer within %s", probeTimeout)}
+				}
+			}
+			return readiness
+		}
+	}
+	return readiness
+}
+
+// checkOutputsReady probes the outputs and logs whether each is ready, unless probing is off.
+// With ProbeRequire it exits if any is not ready, so a broken output is not first discovered by a
+// failed write after the first collection.
+func checkOutputsReady(conf Config) []OutputReadiness {
+	if conf.OutputProbe == ProbeOff {
+		return nil
+	}
+	readiness := probeOutputs(conf)
+	ready := 0
+	for _, r := range readiness {
+		if r.Ready {
+			ready++
+		}
+		log.Printf("output %s\n", r)
+	}
+	if ready == len(readiness) {
+		log.Printf("%d of %d outputs ready\n", ready, len(readiness))
+		return readiness
+	}
+	if conf.OutputProbe == ProbeRequire {
+		log.Fatalf("%d of %d outputs ready, exiting as output_probe is %s\n", ready, len(readiness), ProbeRequire)
+	}
+	log.Printf("%d of %d outputs ready, records for the others will fail until they are\n", ready, len(readiness))
+	return readiness
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RedisConfig struct {
+	// Address is host:port of the Redis server
+	Address  string `json:"address"`
+	Password string `json:"password,omitempty"`
+	Database int    `json:"database,omitempty"`
+	// KeyPrefix defaults to gosmart. The latest reading of each partition is kept in the hash
+	// <prefix>:latest keyed by partition name.
+	KeyPrefix string `json:"key_prefix,omitempty"`
+	// Stream also appends every reading to the stream <prefix>:readings, trimmed to about
+	// StreamMaxLen entries if set
+	Stream       bool `json:"stream,omitempty"`
+	StreamMaxLen int  `json:"stream_max_len,omitempty"`
+}
+
+// redisConn is a minimal RESP client, enough to send commands and check their replies.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRedis(conf RedisConfig) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", conf.Address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if conf.Password != "" {
+		if err := c.do("AUTH", conf.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if conf.Database != 0 {
+		if err := c.do("SELECT", strconv.Itoa(conf.Database)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// do sends a command and reads its reply, returning Redis errors as Go errors.
+func (c *redisConn) do(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return fmt.Errorf("empty redis reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return fmt.Errorf("redis: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return err
+		}
+		_, err = c.r.Discard(n + 2)
+		return err
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := c.readReply(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected redis reply %q", line)
+}
+
+func saveToRedis(record PartitionLine, conf RedisConfig, tsFormat string) error {
+	v, err := renderRecord(record, tsFormat)
+	if err != nil {
+		return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+	}
+
+	c, err := dialRedis(conf)
+	if err != nil {
+		return fmt.Errorf("redis connection error: %w", err)
+	}
+	defer c.Close()
+
+	prefix := conf.KeyPrefix
+	if prefix == "" {
+		prefix = "gosmart"
+	}
+	if err := c.do("HSET", prefix+":latest", record.PartitionName, string(j)); err != nil {
+		return fmt.Errorf("redis write error for %s: %w", record.PartitionName, err)
+	}
+	if conf.Stream {
+		args := []string{"XADD", prefix + ":readings"}
+		if conf.StreamMaxLen > 0 {
+			args = append(args, "MAXLEN", "~", strconv.Itoa(conf.StreamMaxLen))
+		}
+		args = append(args, "*", "partition_name", record.PartitionName, "reading", string(j))
+		if err := c.do(args...); err != nil {
+			return fmt.Errorf("redis stream error for %s: %w", record.PartitionName, err)
+		}
+	}
+	return nil
+}
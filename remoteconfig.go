@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const remoteConfigTimeout = 30 * time.Second
+
+// isRemoteConfig reports whether the config path is a URL fetchRemoteConfig reads.
+func isRemoteConfig(path string) bool {
+	for _, scheme := range []string{"http://", "https://", "consul://", "consul+https://", "etcd://", "etcd+https://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchRemoteConfig reads the config from an HTTP(S) URL, a Consul key
+// (consul://host:8500/path/to/key, with the token in CONSUL_HTTP_TOKEN) or an etcd v3 key
+// (etcd://host:2379/path/to/key, the key includes the leading slash). Add +https to the consul
+// and etcd schemes for TLS.
+//
+// A #sha256=<hex> fragment pins the checksum of the config, configs that do not match are
+// rejected. The last config that was read is cached and used while the source cannot be read.
+func fetchRemoteConfig(rawUrl string) ([]byte, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	checksum, hasChecksum := strings.CutPrefix(u.Fragment, "sha256=")
+	u.Fragment = ""
+	verify := func(b []byte) error {
+		if sum := sha256.Sum256(b); hasChecksum && !strings.EqualFold(hex.EncodeToString(sum[:]), checksum) {
+			return fmt.Errorf("sha256 %x does not match %s", sum, checksum)
+		}
+		return nil
+	}
+	cachePath := remoteConfigCachePath(u.String())
+
+	b, err := readRemoteConfig(u)
+	if err == nil {
+		err = verify(b)
+	}
+	if err == nil {
+		if cachePath != "" {
+			if err := os.MkdirAll(filepath.Dir(cachePath), 0o700); err != nil {
+				log.Printf("Could not cache config: %s\n", err)
+			} else if err := writeFileAtomic(cachePath, b); err != nil {
+				log.Printf("Could not cache config: %s\n", err)
+			}
+		}
+		log.Printf("Using config from %s\n", u.Redacted())
+		return b, nil
+	}
+
+	if cachePath == "" {
+		return nil, err
+	}
+	cached, cacheErr := os.ReadFile(cachePath)
+	if cacheErr != nil || verify(cached) != nil {
+		return nil, err
+	}
+	log.Printf("Could not read config from %s: %s, using the copy cached %s\n", u.Redacted(), err, cachePath)
+	return cached, nil
+}
+
+// redactedUrl hides the password of a URL for logs and errors.
+func redactedUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	return u.Redacted()
+}
+
+// remoteConfigCacheDir holds the cached copies of configs read from URLs, empty if there is no
+// cache directory.
+func remoteConfigCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gosmart")
+}
+
+// remoteConfigCachePath is where the config read from a URL is cached, empty if there is no cache
+// directory.
+func remoteConfigCachePath(rawUrl string) string {
+	dir := remoteConfigCacheDir()
+	if dir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(rawUrl))
+	return filepath.Join(dir, fmt.Sprintf("config-%x.json", sum[:8]))
+}
+
+// remoteConfigPort is the TCP port the config at a URL is read from.
+func remoteConfigPort(rawUrl string) (int, bool) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(u.Port()); err == nil {
+		return n, true
+	}
+	if u.Scheme == "https" || strings.HasSuffix(u.Scheme, "+https") {
+		return 443, true
+	}
+	return 80, true
+}
+
+func readRemoteConfig(u *url.URL) ([]byte, error) {
+	scheme, tls := strings.CutSuffix(u.Scheme, "+https")
+	base := "http://" + u.Host
+	if tls {
+		base = "https://" + u.Host
+	}
+
+	var req *http.Request
+	var err error
+	switch scheme {
+	case "consul":
+		req, err = http.NewRequest(http.MethodGet, base+"/v1/kv/"+strings.TrimPrefix(u.Path, "/")+"?raw", nil)
+		if token := os.Getenv("CONSUL_HTTP_TOKEN"); err == nil && token != "" {
+			req.Header.Set("X-Consul-Token", token)
+		}
+	case "etcd":
+		// The JSON gateway of etcd v3 takes and returns keys and values base64 encoded
+		body, _ := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(u.Path))})
+		req, err = http.NewRequest(http.MethodPost, base+"/v3/kv/range", bytes.NewReader(body))
+	default:
+		req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: remoteConfigTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s", resp.Status, bytes.TrimSpace(b[:min(len(b), 1024)]))
+	}
+	if scheme != "etcd" {
+		return b, nil
+	}
+
+	var result struct {
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, fmt.Errorf("could not parse etcd response: %w", err)
+	}
+	if len(result.Kvs) == 0 {
+		return nil, fmt.Errorf("etcd key %s not found", u.Path)
+	}
+	return base64.StdEncoding.DecodeString(result.Kvs[0].Value)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"go.etcd.io/bbolt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Replacement records that a failing drive was swapped for a new one, for auditing.
+type Replacement struct {
+	Ts        time.Time `json:"ts" db:"ts"`
+	Device    string    `json:"device" db:"partition_name"`
+	OldSerial string    `json:"old_serial" db:"old_serial"`
+	NewSerial string    `json:"new_serial" db:"new_serial"`
+	NewModel  string    `json:"new_model,omitempty" db:"new_model"`
+	// Attributes is the baseline reading of the new drive
+	Attributes json.RawMessage `json:"attributes" db:"attributes"`
+	Comment    string          `json:"comment,omitempty" db:"comment"`
+}
+
+// localReplacementsBucket holds replacements in the local store, keyed by time.
+const localReplacementsBucket = "gosmart:replacements"
+
+// localMetaBucket is true for the buckets of the local store that do not hold readings. Partition
+// names are device paths, so they cannot start with gosmart:.
+func localMetaBucket(name []byte) bool {
+	return strings.HasPrefix(string(name), "gosmart:")
+}
+
+// runVerifyReplacement implements `gosmart verify-replacement`. Before the swap it confirms the
+// device holds the failing drive, after it with -replaced it confirms the device holds a new,
+// healthy drive and records the swap in the local store or database.
+func runVerifyReplacement(args []string) {
+	fs := flag.NewFlagSet("verify-replacement", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	failing := fs.String("failing", "", "Serial number of the failing drive")
+	replaced := fs.Bool("replaced", false, "The drive was swapped, verify the new one and record the replacement")
+	comment := fs.String("comment", "", "Recorded with the replacement, e.g. a ticket")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify-replacement [-f config] -failing serial [-replaced [-comment text]] device\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *failing == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	device := fs.Arg(0)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+	if *replaced && conf.Local == nil && conf.Db == nil {
+		fmt.Fprintln(os.Stderr, "Recording the replacement needs a local store or database")
+		os.Exit(1)
+	}
+	failingSerial := *failing
+	if conf.Anonymize != nil {
+		failingSerial = newAnonymizer(*conf.Anonymize).hash(failingSerial)
+	}
+
+	i := slices.IndexFunc(conf.Partitions, func(p PartitionConfig) bool { return deviceKey(p.Path) == deviceKey(device) })
+	partition := PartitionConfig{Path: device}
+	if i >= 0 {
+		partition = conf.Partitions[i]
+	}
+	conf.Partitions = []PartitionConfig{partition}
+	conf.IncludeUnsupported = true
+	records := collectAll(conf)
+	if len(records) == 0 || records[0].Error != nil || records[0].Serial == "" {
+		fmt.Fprintf(os.Stderr, "Could not read the serial number of %s\n", device)
+		os.Exit(1)
+	}
+	record := withAllAttributes(records[0], conf)
+	located := deviceLocated(record)
+
+	if !*replaced {
+		if record.Serial != failingSerial {
+			fmt.Printf("FAIL %s holds %s, not the failing drive %s, do not pull it\n", located, record.Serial, *failing)
+			os.Exit(1)
+		}
+		fmt.Printf("PASS %s holds the failing drive %s, it can be pulled\n", located, *failing)
+		return
+	}
+
+	if record.Serial == failingSerial {
+		fmt.Printf("FAIL %s still holds the failing drive %s\n", located, *failing)
+		os.Exit(1)
+	}
+	fmt.Printf("PASS %s holds a new drive %s %s\n", located, record.Model, record.Serial)
+	if problems := replacementProblems(record); len(problems) > 0 {
+		fmt.Printf("FAIL the new drive is not healthy: %s\n", strings.Join(problems, "; "))
+		os.Exit(1)
+	}
+	fmt.Println("PASS the new drive is healthy")
+
+	attrs, _ := json.Marshal(record.Attributes)
+	replacement := Replacement{Ts: time.Now(), Device: record.PartitionName, OldSerial: failingSerial, NewSerial: record.Serial, NewModel: record.Model, Attributes: attrs, Comment: *comment}
+	if err := recordReplacement(conf, replacement); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not record the replacement: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("PASS recorded the replacement")
+}
+
+// replacementProblems returns why a new drive cannot be trusted: it is not ok, or counts sectors
+// or transfers it gave up on already.
+func replacementProblems(record PartitionLine) []string {
+	var problems []string
+	if status, reasons := deviceStatus(record); status != StatusOk {
+		problems = append(problems, reasons...)
+	}
+	for _, attr := range record.Attributes {
+		if slices.Contains(burninAttributes, attr.Id) && attr.ValueDecoded > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s is %d", attr.Id, attr.Name, attr.ValueDecoded))
+		}
+	}
+	if record.NvmeHealth != nil && record.NvmeHealth.MediaErrors > 0 {
+		problems = append(problems, fmt.Sprintf("%d media errors", record.NvmeHealth.MediaErrors))
+	}
+	return problems
+}
+
+// replacementStore keeps replacements and the last serial number seen at each device in the
+// local store or the database, whichever history is read from.
+type replacementStore interface {
+	recordReplacement(r Replacement) error
+	serials() (map[string]string, error)
+	setSerial(device, serial string) error
+	Close() error
+}
+
+// localSerialsBucket holds the last serial number seen at each device in the local store.
+const localSerialsBucket = "gosmart:serials"
+
+func openReplacementStore(conf Config) (replacementStore, error) {
+	if conf.Local != nil {
+		db, err := openLocalStore(*conf.Local, false)
+		if err != nil {
+			return nil, err
+		}
+		return localReplacements{db: db}, nil
+	}
+	if conf.Db != nil {
+		db, err := connectPostgres(*conf.Db)
+		if err != nil {
+			return nil, err
+		}
+		return postgresReplacements{db: db, conf: *conf.Db}, nil
+	}
+	return nil, errNoHistory
+}
+
+type localReplacements struct {
+	db *bbolt.DB
+}
+
+func (s localReplacements) recordReplacement(r Replacement) error {
+	value, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(localReplacementsBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(localKey(r.Ts), value)
+	})
+}
+
+func (s localReplacements) serials() (map[string]string, error) {
+	serials := make(map[string]string)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(localSerialsBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			serials[string(k)] = string(v)
+			return nil
+		})
+	})
+	return serials, err
+}
+
+func (s localReplacements) setSerial(device, serial string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(localSerialsBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(device), []byte(serial))
+	})
+}
+
+func (s localReplacements) Close() error {
+	return s.db.Close()
+}
+
+// postgresReplacements keeps replacements in the <table>_replacements table and serial numbers in
+// the <table>_serials table, created with initialize.
+type postgresReplacements struct {
+	db   *sqlx.DB
+	conf DBConfig
+}
+
+func (s postgresReplacements) recordReplacement(r Replacement) error {
+	ctx, cancel := dbContext(s.conf)
+	defer cancel()
+	_, err := s.db.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s.%s_replacements (ts, partition_name, old_serial, new_serial, new_model, attributes, comment) VALUES (:ts, :partition_name, :old_serial, :new_serial, :new_model, :attributes, :comment);`, s.conf.Schema, s.conf.Table), r)
+	return err
+}
+
+func (s postgresReplacements) serials() (map[string]string, error) {
+	var rows []struct {
+		Device string `db:"partition_name"`
+		Serial string `db:"serial"`
+	}
+	ctx, cancel := dbContext(s.conf)
+	defer cancel()
+	if err := s.db.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT partition_name, serial FROM %s.%s_serials;`, s.conf.Schema, s.conf.Table)); err != nil {
+		return nil, err
+	}
+	serials := make(map[string]string, len(rows))
+	for _, row := range rows {
+		serials[row.Device] = row.Serial
+	}
+	return serials, nil
+}
+
+func (s postgresReplacements) setSerial(device, serial string) error {
+	ctx, cancel := dbContext(s.conf)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s.%s_serials (partition_name, serial, ts) VALUES ($1, $2, now()) ON CONFLICT (partition_name) DO UPDATE SET serial = EXCLUDED.serial, ts = EXCLUDED.ts;`, s.conf.Schema, s.conf.Table), device, serial)
+	return err
+}
+
+func (s postgresReplacements) Close() error {
+	return s.db.Close()
+}
+
+// recordReplacement stores the replacement and the serial number of the new drive, so detection
+// does not report the swap again.
+func recordReplacement(conf Config, r Replacement) error {
+	store, err := openReplacementStore(conf)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.recordReplacement(r); err != nil {
+		return err
+	}
+	return store.setSerial(r.Device, r.NewSerial)
+}
+
+// detectReplacements records a replacement for every device whose serial number changed since
+// the last collection, and annotates it in Grafana if configured. Devices seen for the first time
+// only have their serial number stored.
+func detectReplacements(records []PartitionLine, conf Config) {
+	if !conf.DetectReplacements || (conf.Local == nil && conf.Db == nil) {
+		return
+	}
+	store, err := openReplacementStore(conf)
+	if err != nil {
+		log.Printf("Could not detect drive replacements: %s\n", err)
+		return
+	}
+	defer store.Close()
+	serials, err := store.serials()
+	if err != nil {
+		log.Printf("Could not detect drive replacements: %s\n", err)
+		return
+	}
+
+	for _, record := range records {
+		old, ok := serials[record.PartitionName]
+		if record.Error != nil || record.Serial == "" || old == record.Serial {
+			continue
+		}
+		if ok {
+			attrs, _ := json.Marshal(record.Attributes)
+			r := Replacement{Ts: record.Ts, Device: record.PartitionName, OldSerial: old, NewSerial: record.Serial, NewModel: record.Model, Attributes: attrs, Comment: "detected"}
+			if err := store.recordReplacement(r); err != nil {
+				log.Printf("Could not record the replacement of %s: %s\n", record.PartitionName, err)
+				continue
+			}
+			log.Println(r.text())
+			if conf.Grafana != nil {
+				if err := postGrafanaAnnotation(r.Device, r.Ts, []string{"replaced"}, r.text(), *conf.Grafana); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+		if err := store.setSerial(record.PartitionName, record.Serial); err != nil {
+			log.Printf("Could not store the serial number of %s: %s\n", record.PartitionName, err)
+		}
+	}
+}
+
+func (r Replacement) text() string {
+	return fmt.Sprintf("%s drive replaced: %s -> %s", r.Device, r.OldSerial, r.NewSerial)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ReportHtml     = "html"
+	ReportMarkdown = "markdown"
+)
+
+const (
+	StatusOk      = "ok"
+	StatusWarning = "warning"
+	StatusNoData  = "no data"
+	StatusError   = "error"
+)
+
+// failureAttributes are the attributes Backblaze found to predict drive failure when their raw
+// value is non-zero.
+var failureAttributes = []uint8{5, 187, 188, 197, 198}
+
+// reportDevice is a collected record with its health summary and attribute history.
+type reportDevice struct {
+	PartitionLine
+	Status  string
+	Reasons []string
+	History map[uint8][]float64
+}
+
+// deviceStatus summarizes a record's health from its warnings, the failure predicting attributes
+// and the NVMe critical warning byte.
+func deviceStatus(line PartitionLine) (string, []string) {
+	if line.Error != nil {
+		return StatusError, []string{line.Error.Message}
+	} else if !line.SmartSupported {
+		return StatusNoData, []string{line.SkipReason}
+	}
+
+	reasons := append([]string{}, line.Warnings...)
+	for _, attr := range line.Attributes {
+		for _, id := range failureAttributes {
+			if attr.Id == id && attr.ValueDecoded > 0 {
+				reasons = append(reasons, fmt.Sprintf("%d %s is %d", attr.Id, attr.Name, attr.ValueDecoded))
+			}
+		}
+	}
+	if line.NvmeHealth != nil && line.NvmeHealth.CriticalWarning != 0 {
+		reasons = append(reasons, fmt.Sprintf("NVMe critical warning %#x", line.NvmeHealth.CriticalWarning))
+	}
+
+	if len(reasons) > 0 {
+		return StatusWarning, reasons
+	}
+	return StatusOk, nil
+}
+
+// Severities of a record, in increasing order.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severityRanks = map[string]int{SeverityInfo: 0, SeverityWarning: 1, SeverityCritical: 2}
+
+// recordSeverity rates a record for routing: critical when the drive shows signs of failing (a
+// failure predicting attribute or the NVMe critical warning), warning for other warnings and
+// collection errors, info otherwise and for silenced or acknowledged records.
+func recordSeverity(line PartitionLine) string {
+	if line.SilencedUntil != nil || line.Acknowledged != nil {
+		return SeverityInfo
+	}
+	if line.NvmeHealth != nil && line.NvmeHealth.CriticalWarning != 0 {
+		return SeverityCritical
+	}
+	for _, attr := range line.Attributes {
+		if slices.Contains(failureAttributes, attr.Id) && attr.ValueDecoded > 0 {
+			return SeverityCritical
+		}
+	}
+	if line.Error != nil || len(line.Warnings) > 0 {
+		return SeverityWarning
+	}
+	return SeverityInfo
+}
+
+// buildReport collects all configured devices and loads their history if a local store or database
+// is configured.
+func buildReport(conf Config, historyLen int) []reportDevice {
+	records := collectAll(conf)
+	histories := loadHistories(conf, records, historyLen)
+
+	devices := make([]reportDevice, 0, len(records))
+	for _, record := range records {
+		status, reasons := deviceStatus(record)
+		devices = append(devices, reportDevice{
+			PartitionLine: record,
+			Status:        status,
+			Reasons:       reasons,
+			History:       histories[record.PartitionName],
+		})
+	}
+	return devices
+}
+
+// runReport implements `gosmart report`, which collects all configured devices once and renders a
+// human readable report instead of writing records to the configured output.
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	format := fs.String("format", ReportHtml, "Report format: "+ReportHtml+" or "+ReportMarkdown)
+	outPath := fs.String("o", "-", "Output file, - for stdout")
+	historyLen := fs.Int("history", 30, "Number of stored readings to draw trends from, if a database is configured")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+
+	var out io.Writer = os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create %s: %s\n", *outPath, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	warnStatefulThresholds(conf, "report")
+	devices := buildReport(conf, *historyLen)
+	switch *format {
+	case ReportHtml:
+		err = renderHtmlReport(out, devices, time.Now())
+	case ReportMarkdown:
+		err = renderMarkdownReport(out, devices, time.Now())
+	default:
+		err = fmt.Errorf("unknown report format %q", *format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not render report: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// sparkline renders values as a small inline SVG line chart.
+func sparkline(values []float64) template.HTML {
+	if len(values) < 2 {
+		return ""
+	}
+
+	const width, height = 120.0, 24.0
+	min, max := values[0], values[0]
+	for _, v := range values {
+		min = minFloat(min, v)
+		max = maxFloat(max, v)
+	}
+
+	points := make([]string, len(values))
+	for i, v := range values {
+		y := height / 2
+		if max > min {
+			y = height - 2 - (v-min)/(max-min)*(height-4)
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", float64(i)/float64(len(values)-1)*width, y)
+	}
+	return template.HTML(fmt.Sprintf(`<svg width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f"><polyline fill="none" stroke="#36c" stroke-width="1.5" points="%s"/></svg>`,
+		width, height, width, height, strings.Join(points, " ")))
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"sparkline": sparkline,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gosmart report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f3f3f3; }
+.ok { color: #1a7f37; } .warning { color: #c4432b; } .nodata { color: #777; } .error { color: #8250df; }
+</style>
+</head>
+<body>
+<h1>gosmart report</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Device</th><th>Model</th><th>Mount</th><th>Status</th><th>Details</th></tr>
+{{range .Devices}}<tr><td>{{.PartitionName}}</td><td>{{.Model}}</td><td>{{.MountPath}}</td><td class="{{if eq .Status "ok"}}ok{{else if eq .Status "warning"}}warning{{else if eq .Status "error"}}error{{else}}nodata{{end}}">{{.Status}}</td><td>{{range .Reasons}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>
+
+{{range .Devices}}{{$history := .History}}
+<h2>{{.PartitionName}}</h2>
+<p>{{.Model}} {{.Firmware}}{{if .Label}}, label {{.Label}}{{end}}, {{.SizeBytes}} bytes</p>
+{{range .Advisories}}<p class="warning">Advisory: {{.}}</p>
+{{end}}{{range .Predictions}}<p class="warning">Prediction: {{.}}</p>
+{{end}}{{if .Backblaze}}<p>Backblaze: {{.Backblaze}}</p>
+{{end}}{{if .NvmeHealth}}<table>
+<tr><th>Temperature</th><th>Available spare</th><th>Used</th><th>Media errors</th><th>Unsafe shutdowns</th><th>Power on hours</th></tr>
+<tr><td>{{.NvmeHealth.TemperatureC}} C</td><td>{{.NvmeHealth.AvailableSpare}}%</td><td>{{.NvmeHealth.PercentageUsed}}%</td><td>{{.NvmeHealth.MediaErrors}}</td><td>{{.NvmeHealth.UnsafeShutdowns}}</td><td>{{.NvmeHealth.PowerOnHours}}</td></tr>
+</table>{{end}}
+{{if .Attributes}}<table>
+<tr><th>ID</th><th>Name</th><th>Current</th><th>Worst</th><th>Raw</th><th>Value</th><th>Trend</th></tr>
+{{range .Attributes}}<tr><td>{{.Id}}</td><td>{{.Name}}</td><td>{{.Current}}</td><td>{{.Worst}}</td><td>{{.ValueRaw}}</td><td>{{.ValueDecoded}}</td><td>{{sparkline (index $history .Id)}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func renderHtmlReport(w io.Writer, devices []reportDevice, generated time.Time) error {
+	return htmlReport.Execute(w, struct {
+		Generated time.Time
+		Devices   []reportDevice
+	}{generated, devices})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"log"
+	"sort"
+	"time"
+)
+
+// retentionRule deletes the rows older than cutoff matching filter, an SQL condition taking args
+// as $2 and up.
+type retentionRule struct {
+	name   string
+	cutoff time.Time
+	filter string
+	args   []any
+	// keepsOlder is set when rows older than cutoff not matching filter are kept
+	keepsOlder bool
+	// base is the rule of DataRetentionHours, which also expires the latest table
+	base bool
+}
+
+// storedSeverity is the severity of a record before silences and acknowledgements, so the
+// readings of known issues are kept as long as those of new ones.
+func storedSeverity(record PartitionLine) string {
+	record.SilencedUntil, record.Acknowledged = nil, nil
+	return recordSeverity(record)
+}
+
+// retentionRules returns the rule of DataRetentionHours, for the rows of severities without a
+// window of their own, and one rule per window of RetentionHoursBySeverity.
+func retentionRules(conf DBConfig, now time.Time) []retentionRule {
+	var rules []retentionRule
+	var severities []string
+	keys := make([]string, 0, len(conf.RetentionHoursBySeverity))
+	for severity := range conf.RetentionHoursBySeverity {
+		keys = append(keys, severity)
+	}
+	sort.Strings(keys)
+	for _, severity := range keys {
+		hours := conf.RetentionHoursBySeverity[severity]
+		if _, ok := severityRanks[severity]; !ok || hours <= 0 {
+			log.Printf("ignoring retention of %d hours for severity %q, it must be info, warning or critical and positive\n", hours, severity)
+			continue
+		}
+		severities = append(severities, severity)
+		rules = append(rules, retentionRule{
+			name:       severity + " retention rule",
+			cutoff:     now.Add(-time.Duration(hours) * time.Hour),
+			filter:     "coalesce(severity, 'info') = $2",
+			args:       []any{severity},
+			keepsOlder: true,
+		})
+	}
+	if conf.DataRetentionHours != nil {
+		if *conf.DataRetentionHours <= 0 {
+			println("data retention days must be greater than zero if present, skipping")
+		} else {
+			rules = append(rules, retentionRule{
+				name:       "retention rule",
+				cutoff:     now.Add(-time.Duration(*conf.DataRetentionHours) * time.Hour),
+				filter:     "coalesce(severity, 'info') <> ALL($2)",
+				args:       []any{pq.StringArray(severities)},
+				keepsOlder: len(severities) > 0,
+				base:       true,
+			})
+		}
+	}
+	return rules
+}
+
+// applyRetention deletes the rows past their retention. Delta encoded rows that would lose the
+// rows they build on are stored in full first.
+func applyRetention(db *sqlx.DB, conf DBConfig) {
+	for _, rule := range retentionRules(conf, time.Now()) {
+		if err := rebaseAttributeDeltas(db, conf, rule); err != nil {
+			log.Printf("Could not store the rows kept past the %s in full: %v\n", rule.name, err)
+		}
+		if rows, err := deleteExpired(db, conf, rule); err != nil {
+			log.Println(err)
+		} else {
+			fmt.Printf("Deleted %d rows since %s by %s\n", rows, rule.cutoff.Format(time.RFC3339), rule.name)
+		}
+		// The latest row of a device is dropped once it was gone for the base retention
+		if rule.base && conf.LatestTable {
+			if err := deleteExpiredLatest(db, conf, rule.cutoff); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
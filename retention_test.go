@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/lib/pq"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionRules(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		conf DBConfig
+		want []retentionRule
+	}{{
+		name: "none",
+	}, {
+		name: "base only",
+		conf: DBConfig{DataRetentionHours: ptr(48)},
+		want: []retentionRule{
+			{name: "retention rule", cutoff: now.Add(-48 * time.Hour), filter: "coalesce(severity, 'info') <> ALL($2)", args: []any{pq.StringArray(nil)}, base: true},
+		},
+	}, {
+		name: "by severity",
+		conf: DBConfig{DataRetentionHours: ptr(48), RetentionHoursBySeverity: map[string]int{SeverityWarning: 720, SeverityCritical: 8760}},
+		want: []retentionRule{
+			{name: "critical retention rule", cutoff: now.Add(-8760 * time.Hour), filter: "coalesce(severity, 'info') = $2", args: []any{SeverityCritical}, keepsOlder: true},
+			{name: "warning retention rule", cutoff: now.Add(-720 * time.Hour), filter: "coalesce(severity, 'info') = $2", args: []any{SeverityWarning}, keepsOlder: true},
+			{name: "retention rule", cutoff: now.Add(-48 * time.Hour), filter: "coalesce(severity, 'info') <> ALL($2)", args: []any{pq.StringArray{SeverityCritical, SeverityWarning}}, keepsOlder: true, base: true},
+		},
+	}, {
+		name: "invalid windows are ignored",
+		conf: DBConfig{DataRetentionHours: ptr(0), RetentionHoursBySeverity: map[string]int{"fatal": 10, SeverityInfo: -1, SeverityCritical: 100}},
+		want: []retentionRule{
+			{name: "critical retention rule", cutoff: now.Add(-100 * time.Hour), filter: "coalesce(severity, 'info') = $2", args: []any{SeverityCritical}, keepsOlder: true},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retentionRules(tt.conf, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Silenced and acknowledged records are kept as long as the readings they hide.
+func TestStoredSeverity(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	record := PartitionLine{SilencedUntil: &until, Acknowledged: &Acknowledgement{}, Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 5}, ValueDecoded: 1}}}
+	if severity := recordSeverity(record); severity != SeverityInfo {
+		t.Errorf("silenced record is %s", severity)
+	}
+	if severity := storedSeverity(record); severity != SeverityCritical {
+		t.Errorf("silenced record is stored as %s, want %s", severity, SeverityCritical)
+	}
+	if record.SilencedUntil == nil || record.Acknowledged == nil {
+		t.Error("storedSeverity changed the record")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	RetireText = "text"
+	RetireJson = "json"
+)
+
+// Replacement priorities of `gosmart retire`, most urgent first.
+const (
+	RetireNow    = "replace now"
+	RetirePlan   = "plan replacement"
+	RetireWatch  = "watch"
+	RetireOk     = "ok"
+	RetireNoData = "no data"
+)
+
+// Scores from which drives are given a priority. Each kind of evidence is scored so that one
+// strong sign, worn out flash or pending sectors that keep growing, plans a replacement alone.
+const (
+	retireNowScore  = 50
+	retirePlanScore = 25
+	// retireWearPoints are scored for fully used up flash, proportionally below
+	retireWearPoints = 50
+	// Sector counts score a base for any non-zero value and more if they grew over the history
+	retireReallocatedPoints = 10
+	retirePendingPoints     = 15
+	retireGrowthPoints      = 20
+	// retireErrorPoints is the base for logged errors, each error adds one up to retireMaxErrorPoints
+	retireErrorPoints    = 5
+	retireMaxErrorPoints = 20
+	// Drives older than retireAgeYears score retireYearPoints per further year of power on time
+	retireAgeYears     = 3
+	retireYearPoints   = 5
+	retireMaxAgePoints = 20
+	hoursPerYear       = 8766
+)
+
+// wearAttributes report the remaining life of SSDs as their normalized value, falling from 100.
+var wearAttributes = []uint8{177, 202, 231, 233}
+
+// RetireEvidence is one finding adding to the replacement priority of a drive.
+type RetireEvidence struct {
+	// Kind is wear, reallocated, pending, errors or age
+	Kind   string  `json:"kind"`
+	Detail string  `json:"detail"`
+	Score  float64 `json:"score"`
+}
+
+// RetireCandidate is a drive ranked by `gosmart retire`, with the evidence its score adds up from.
+type RetireCandidate struct {
+	PartitionName string           `json:"partition_name"`
+	Model         string           `json:"model,omitempty"`
+	Serial        string           `json:"serial,omitempty"`
+	Priority      string           `json:"priority"`
+	Score         float64          `json:"score"`
+	Evidence      []RetireEvidence `json:"evidence,omitempty"`
+}
+
+// retireEvidence scores a collected drive against its stored history, oldest reading first.
+func retireEvidence(line PartitionLine, history map[uint8][]float64) []RetireEvidence {
+	var evidence []RetireEvidence
+	attrs := make(map[uint8]Attr, len(line.Attributes))
+	for _, attr := range line.Attributes {
+		attrs[attr.Id] = attr
+	}
+
+	if h := line.NvmeHealth; h != nil && h.PercentageUsed > 0 {
+		evidence = append(evidence, RetireEvidence{
+			Kind:   "wear",
+			Detail: fmt.Sprintf("%d%% of rated endurance used", h.PercentageUsed),
+			Score:  min(float64(h.PercentageUsed), 100) / 100 * retireWearPoints,
+		})
+	} else if line.MediaType != MediaHdd {
+		for _, id := range wearAttributes {
+			attr, ok := attrs[id]
+			if !ok || attr.Current >= 100 {
+				continue
+			}
+			used := 100 - float64(attr.Current)
+			evidence = append(evidence, RetireEvidence{
+				Kind:   "wear",
+				Detail: fmt.Sprintf("%d %s is %d, %.0f%% of life used", attr.Id, attr.Name, attr.Current, used),
+				Score:  used / 100 * retireWearPoints,
+			})
+			break
+		}
+	}
+
+	for _, sectors := range []struct {
+		kind   string
+		ids    []uint8
+		points float64
+	}{{"reallocated", []uint8{5}, retireReallocatedPoints}, {"pending", []uint8{197, 198}, retirePendingPoints}} {
+		for _, id := range sectors.ids {
+			attr, ok := attrs[id]
+			if !ok || attr.ValueDecoded == 0 {
+				continue
+			}
+			e := RetireEvidence{Kind: sectors.kind, Detail: fmt.Sprintf("%d %s is %d", attr.Id, attr.Name, attr.ValueDecoded), Score: sectors.points}
+			if readings := history[id]; len(readings) > 0 && float64(attr.ValueDecoded) > readings[0] {
+				e.Detail += fmt.Sprintf(", up %.0f over the last %d readings", float64(attr.ValueDecoded)-readings[0], len(readings))
+				e.Score += retireGrowthPoints
+			}
+			evidence = append(evidence, e)
+		}
+	}
+
+	var errorCount uint64
+	var errorSource string
+	if line.AtaErrorLog != nil && line.AtaErrorLog.Count > 0 {
+		errorCount, errorSource = uint64(line.AtaErrorLog.Count), "errors in the ATA error log"
+	} else if line.NvmeHealth != nil && line.NvmeHealth.MediaErrors > 0 {
+		errorCount, errorSource = line.NvmeHealth.MediaErrors, "NVMe media errors"
+	}
+	if errorCount > 0 {
+		evidence = append(evidence, RetireEvidence{
+			Kind:   "errors",
+			Detail: fmt.Sprintf("%d %s", errorCount, errorSource),
+			Score:  min(retireErrorPoints+float64(errorCount), retireMaxErrorPoints),
+		})
+	}
+
+	var hours uint64
+	if line.NvmeHealth != nil {
+		hours = line.NvmeHealth.PowerOnHours
+	} else if attr, ok := attrs[9]; ok {
+		hours = attr.ValueDecoded
+	}
+	if years := float64(hours) / hoursPerYear; years > retireAgeYears {
+		evidence = append(evidence, RetireEvidence{
+			Kind:   "age",
+			Detail: fmt.Sprintf("%.1f years powered on", years),
+			Score:  min((years-retireAgeYears)*retireYearPoints, retireMaxAgePoints),
+		})
+	}
+	return evidence
+}
+
+// rankRetirement scores every device and sorts them by replacement priority, drives without SMART
+// data last.
+func rankRetirement(devices []reportDevice) []RetireCandidate {
+	candidates := make([]RetireCandidate, 0, len(devices))
+	for _, device := range devices {
+		c := RetireCandidate{PartitionName: device.PartitionName, Model: device.Model, Serial: device.Serial}
+		if device.Status == StatusError || device.Status == StatusNoData {
+			c.Priority = RetireNoData
+			c.Evidence = []RetireEvidence{{Kind: "no data", Detail: strings.Join(device.Reasons, "; ")}}
+			candidates = append(candidates, c)
+			continue
+		}
+		c.Evidence = retireEvidence(device.PartitionLine, device.History)
+		for _, e := range c.Evidence {
+			c.Score += e.Score
+		}
+		switch {
+		case c.Score >= retireNowScore:
+			c.Priority = RetireNow
+		case c.Score >= retirePlanScore:
+			c.Priority = RetirePlan
+		case c.Score > 0:
+			c.Priority = RetireWatch
+		default:
+			c.Priority = RetireOk
+		}
+		candidates = append(candidates, c)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if noData := candidates[i].Priority == RetireNoData; noData != (candidates[j].Priority == RetireNoData) {
+			return !noData
+		}
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates
+}
+
+func renderRetireText(out io.Writer, candidates []RetireCandidate) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PRIORITY\tSCORE\tPARTITION\tMODEL\tSERIAL\tEVIDENCE")
+	for _, c := range candidates {
+		score := fmt.Sprintf("%.0f", c.Score)
+		if c.Priority == RetireNoData {
+			score = "-"
+		}
+		details := make([]string, len(c.Evidence))
+		for i, e := range c.Evidence {
+			details[i] = e.Detail
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Priority, score, c.PartitionName, c.Model, c.Serial, strings.Join(details, "; "))
+	}
+	return w.Flush()
+}
+
+// runRetire implements `gosmart retire`, which collects all configured devices once and ranks them
+// by how urgently they should be replaced, from flash wear, reallocated and pending sectors and
+// their growth over the stored history, logged errors and power on time.
+func runRetire(args []string) {
+	fs := flag.NewFlagSet("retire", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	format := fs.String("format", RetireText, "Output format: "+RetireText+" or "+RetireJson+" (one object per line)")
+	outPath := fs.String("o", "-", "Output file, - for stdout")
+	historyLen := fs.Int("history", 30, "Number of stored readings sector growth is measured over, with a local store or database")
+	top := fs.Int("top", 0, "Number of drives to list, 0 for all")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+	if *format != RetireText && *format != RetireJson {
+		fmt.Fprintf(os.Stderr, "unknown retire format %q\n", *format)
+		os.Exit(2)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create %s: %s\n", *outPath, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	warnStatefulThresholds(conf, "retire")
+	candidates := rankRetirement(buildReport(conf, *historyLen))
+	if *top > 0 && len(candidates) > *top {
+		candidates = candidates[:*top]
+	}
+	if *format == RetireText {
+		err = renderRetireText(out, candidates)
+	} else {
+		enc := json.NewEncoder(out)
+		for _, c := range candidates {
+			if err = enc.Encode(c); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write retirement report: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+)
+
+// RetryConfig retries devices that failed to collect later in the same run, after the other
+// devices, waiting longer before each attempt.
+type RetryConfig struct {
+	// Attempts after the first one, 2 if zero
+	Attempts int `json:"attempts,omitempty"`
+	// DelaySeconds before the first retry, doubled for each further one, 1 if zero
+	DelaySeconds float64 `json:"delay_seconds,omitempty"`
+	// MaxDelaySeconds caps the delay, 30 if zero
+	MaxDelaySeconds float64 `json:"max_delay_seconds,omitempty"`
+}
+
+// retryAttempts returns how often failed devices are retried in a run.
+func retryAttempts(conf *RetryConfig) int {
+	if conf == nil {
+		return 0
+	}
+	if conf.Attempts <= 0 {
+		return 2
+	}
+	return conf.Attempts
+}
+
+// retryDelay returns the wait before retry number attempt, counting from 0.
+func retryDelay(conf RetryConfig, attempt int) time.Duration {
+	delay, maxDelay := conf.DelaySeconds, conf.MaxDelaySeconds
+	if delay <= 0 {
+		delay = 1
+	}
+	if maxDelay <= 0 {
+		maxDelay = 30
+	}
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return time.Duration(min(delay, maxDelay) * float64(time.Second))
+}
+
+// retryable reports whether a collection error may go away on its own, as with a busy device, a
+// timeout or a permission that is granted later. Devices that do not exist are not retried.
+func retryable(err *CollectionError) bool {
+	return err != nil && err.Class != ErrorClassNotFound
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// CompositeRule warns when its condition holds, to encode failure heuristics that combine
+// attributes, e.g. pending sectors while the reallocated count is still growing:
+//
+//	{"name": "remapping", "when": {"all": [{"attribute": 197, "above": 0}, {"attribute": 5, "increased_days": 7}]}}
+type CompositeRule struct {
+	Name string        `json:"name"`
+	When RuleCondition `json:"when"`
+}
+
+// RuleCondition is either a test of one attribute or a combination of conditions. The fields
+// that are set must all hold. Attributes that are not collected fail their tests.
+type RuleCondition struct {
+	Attribute uint8 `json:"attribute,omitempty"`
+	// Above and Below compare the decoded raw value
+	Above *uint64 `json:"above,omitempty"`
+	Below *uint64 `json:"below,omitempty"`
+	// IncreasedDays holds when the decoded raw value is higher than in the oldest stored reading
+	// of the last this many days, it needs the local store or the postgres output
+	IncreasedDays int `json:"increased_days,omitempty"`
+
+	All []RuleCondition `json:"all,omitempty"`
+	Any []RuleCondition `json:"any,omitempty"`
+	Not *RuleCondition  `json:"not,omitempty"`
+}
+
+func (c RuleCondition) String() string {
+	var parts []string
+	if c.Above != nil {
+		parts = append(parts, fmt.Sprintf("%d > %d", c.Attribute, *c.Above))
+	}
+	if c.Below != nil {
+		parts = append(parts, fmt.Sprintf("%d < %d", c.Attribute, *c.Below))
+	}
+	if c.IncreasedDays > 0 {
+		parts = append(parts, fmt.Sprintf("%d increased in %d days", c.Attribute, c.IncreasedDays))
+	}
+	for _, group := range []struct {
+		conditions []RuleCondition
+		op         string
+	}{{c.All, " and "}, {c.Any, " or "}} {
+		if len(group.conditions) == 0 {
+			continue
+		}
+		var sub []string
+		for _, condition := range group.conditions {
+			sub = append(sub, condition.String())
+		}
+		parts = append(parts, "("+strings.Join(sub, group.op)+")")
+	}
+	if c.Not != nil {
+		parts = append(parts, "not "+c.Not.String())
+	}
+	return strings.Join(parts, " and ")
+}
+
+// ruleBaseline returns the decoded raw value of an attribute in the oldest stored reading since a
+// time.
+type ruleBaseline func(attribute uint8, since time.Time) (uint64, bool)
+
+func (c RuleCondition) holds(line PartitionLine, baseline ruleBaseline) bool {
+	if c.Above != nil || c.Below != nil || c.IncreasedDays > 0 {
+		var attr *Attr
+		for i := range line.Attributes {
+			if line.Attributes[i].Id == c.Attribute {
+				attr = &line.Attributes[i]
+			}
+		}
+		if attr == nil {
+			return false
+		}
+		if c.Above != nil && attr.ValueDecoded <= *c.Above {
+			return false
+		}
+		if c.Below != nil && attr.ValueDecoded >= *c.Below {
+			return false
+		}
+		if c.IncreasedDays > 0 {
+			start, ok := baseline(c.Attribute, line.Ts.AddDate(0, 0, -c.IncreasedDays))
+			if !ok || attr.ValueDecoded <= start {
+				return false
+			}
+		}
+	}
+	for _, condition := range c.All {
+		if !condition.holds(line, baseline) {
+			return false
+		}
+	}
+	if len(c.Any) > 0 {
+		matched := false
+		for _, condition := range c.Any {
+			matched = matched || condition.holds(line, baseline)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return c.Not == nil || !c.Not.holds(line, baseline)
+}
+
+// historyDays is the longest IncreasedDays of the condition and its subconditions.
+func (c RuleCondition) historyDays() int {
+	days := c.IncreasedDays
+	for _, conditions := range [][]RuleCondition{c.All, c.Any} {
+		for _, condition := range conditions {
+			days = max(days, condition.historyDays())
+		}
+	}
+	if c.Not != nil {
+		days = max(days, c.Not.historyDays())
+	}
+	return days
+}
+
+// applyCompositeRules adds a warning to the records every rule holds for. Stored readings are
+// only loaded if a rule looks at how attributes changed.
+func applyCompositeRules(records []PartitionLine, rules []CompositeRule, conf Config) []PartitionLine {
+	days := 0
+	for _, rule := range rules {
+		days = max(days, rule.When.historyDays())
+	}
+	// rows are oldest first
+	rows := make(map[string][]historyRow)
+	if days > 0 {
+		source, err := openHistory(conf)
+		if err != nil {
+			log.Printf("Could not load history for composite rules: %s\n", err)
+		} else {
+			defer source.Close()
+			all, err := source.historySince(time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				log.Printf("Could not load history for composite rules: %s\n", err)
+			}
+			for _, row := range all {
+				rows[row.PartitionName] = append(rows[row.PartitionName], row)
+			}
+		}
+	}
+
+	for i := range records {
+		record := &records[i]
+		baseline := func(attribute uint8, since time.Time) (uint64, bool) {
+			for _, row := range rows[record.PartitionName] {
+				if row.Ts.Before(since) {
+					continue
+				}
+				attrs, err := row.attributes()
+				if err != nil {
+					continue
+				}
+				for _, attr := range attrs {
+					if attr.Id == attribute {
+						return attr.value(), true
+					}
+				}
+			}
+			return 0, false
+		}
+		for _, rule := range rules {
+			if rule.When.holds(*record, baseline) {
+				record.warn("%s: %s", rule.Name, rule.When)
+			}
+		}
+	}
+	return records
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleConditionHolds(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	line := PartitionLine{PartitionName: "/dev/sda", Ts: now, Attributes: []Attr{
+		{AtaSmartAttr: AtaSmartAttr{Id: 5}, ValueDecoded: 8},
+		{AtaSmartAttr: AtaSmartAttr{Id: 197}, ValueDecoded: 2},
+	}}
+	// Attribute 5 was 3 eight days ago and 8 since, attribute 197 has no history
+	baseline := func(attribute uint8, since time.Time) (uint64, bool) {
+		if attribute != 5 {
+			return 0, false
+		}
+		if since.Before(now.AddDate(0, 0, -8)) {
+			return 3, true
+		}
+		return 8, true
+	}
+	tests := []struct {
+		name      string
+		condition RuleCondition
+		want      bool
+	}{
+		{"above", RuleCondition{Attribute: 197, Above: ptr[uint64](0)}, true},
+		{"not above", RuleCondition{Attribute: 197, Above: ptr[uint64](2)}, false},
+		{"below", RuleCondition{Attribute: 5, Below: ptr[uint64](9)}, true},
+		{"not below", RuleCondition{Attribute: 5, Below: ptr[uint64](8)}, false},
+		{"between", RuleCondition{Attribute: 5, Above: ptr[uint64](1), Below: ptr[uint64](10)}, true},
+		{"not collected", RuleCondition{Attribute: 187, Above: ptr[uint64](0)}, false},
+		{"not collected negated", RuleCondition{Not: &RuleCondition{Attribute: 187, Above: ptr[uint64](0)}}, true},
+		{"increased", RuleCondition{Attribute: 5, IncreasedDays: 10}, true},
+		{"not increased lately", RuleCondition{Attribute: 5, IncreasedDays: 7}, false},
+		{"no history", RuleCondition{Attribute: 197, IncreasedDays: 7}, false},
+		{"all", RuleCondition{All: []RuleCondition{{Attribute: 197, Above: ptr[uint64](0)}, {Attribute: 5, IncreasedDays: 10}}}, true},
+		{"not all", RuleCondition{All: []RuleCondition{{Attribute: 197, Above: ptr[uint64](0)}, {Attribute: 5, IncreasedDays: 7}}}, false},
+		{"any", RuleCondition{Any: []RuleCondition{{Attribute: 197, Above: ptr[uint64](5)}, {Attribute: 5, Above: ptr[uint64](5)}}}, true},
+		{"none", RuleCondition{Any: []RuleCondition{{Attribute: 197, Above: ptr[uint64](5)}, {Attribute: 5, Above: ptr[uint64](10)}}}, false},
+		{"test and group", RuleCondition{Attribute: 197, Above: ptr[uint64](0), Any: []RuleCondition{{Attribute: 5, Above: ptr[uint64](10)}}}, false},
+		{"empty", RuleCondition{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.condition.holds(line, baseline); got != tt.want {
+				t.Errorf("%s holds %v, want %v", tt.condition, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleConditionString(t *testing.T) {
+	condition := RuleCondition{All: []RuleCondition{
+		{Attribute: 197, Above: ptr[uint64](0)},
+		{Any: []RuleCondition{{Attribute: 5, IncreasedDays: 7}, {Attribute: 5, Below: ptr[uint64](3)}}},
+	}, Not: &RuleCondition{Attribute: 9, Above: ptr[uint64](50000)}}
+	want := "(197 > 0 and (5 increased in 7 days or 5 < 3)) and not 9 > 50000"
+	if got := condition.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if days := condition.historyDays(); days != 7 {
+		t.Errorf("history of %d days, want 7", days)
+	}
+}
+
+// Rules without IncreasedDays need no history, so they run without a store.
+func TestApplyCompositeRules(t *testing.T) {
+	rules := []CompositeRule{
+		{Name: "pending", When: RuleCondition{Attribute: 197, Above: ptr[uint64](0)}},
+		{Name: "worn", When: RuleCondition{Attribute: 177, Below: ptr[uint64](10)}},
+	}
+	records := []PartitionLine{
+		{PartitionName: "/dev/sda", Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 197}, ValueDecoded: 2}}},
+		{PartitionName: "/dev/sdb", Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 177}, ValueDecoded: 50}}},
+	}
+	records = applyCompositeRules(records, rules, Config{})
+	if len(records[0].Warnings) != 1 || records[0].Warnings[0] != "pending: 197 > 0" {
+		t.Errorf("/dev/sda warned %q", records[0].Warnings)
+	}
+	if len(records[1].Warnings) != 0 {
+		t.Errorf("/dev/sdb warned %q", records[1].Warnings)
+	}
+}
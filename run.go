@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=...", otherwise the module version
+// from the build info is reported.
+var version = ""
+
+func collectorVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+// Values of Config.FailurePolicy
+const (
+	FailureContinue    = "continue"
+	FailureExitNonzero = "exit_nonzero"
+	FailureAbort       = "abort"
+)
+
+// failureExitCode is the exit status of a single run with failed devices under policy.
+func failureExitCode(policy string, failed int) int {
+	if failed > 0 && (policy == FailureExitNonzero || policy == FailureAbort) {
+		return 1
+	}
+	return 0
+}
+
+// RunInfo describes the collection run that produced a record, shared by all records of the run.
+type RunInfo struct {
+	Id              string    `json:"id" db:"run_id"`
+	Started         time.Time `json:"started" db:"started"`
+	DurationSeconds float64   `json:"duration_seconds" db:"duration_seconds"`
+	Version         string    `json:"version" db:"version"`
+	// Devices is the number of records of the run, Errors the number of them with a collection error
+	Devices int `json:"devices" db:"devices"`
+	Errors  int `json:"errors" db:"errors"`
+	// Event is the external event that triggered the run through the serve API at /events
+	Event       string `json:"event,omitempty" db:"event"`
+	EventDetail string `json:"event_detail,omitempty" db:"event_detail"`
+}
+
+// newRunId returns a random (version 4) UUID.
+func newRunId() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// recordRuns lists the distinct runs of records in order of appearance.
+func recordRuns(records []PartitionLine) []RunInfo {
+	var runs []RunInfo
+	seen := map[string]bool{}
+	for _, record := range records {
+		if record.Run != nil && !seen[record.Run.Id] {
+			seen[record.Run.Id] = true
+			runs = append(runs, *record.Run)
+		}
+	}
+	return runs
+}
+
+// RunSummary is the outcome of a collection, written to Config.RunSummaryPath for wrapper scripts
+// and health checks.
+type RunSummary struct {
+	RunId           string    `json:"run_id,omitempty"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Attempted counts the devices reported, Unsupported those without SMART data, Failed those
+	// with a collection error and Deferred those skipped when the run budget ran out
+	Attempted   int `json:"attempted"`
+	Succeeded   int `json:"succeeded"`
+	Unsupported int `json:"unsupported"`
+	Failed      int `json:"failed"`
+	Deferred    int `json:"deferred"`
+	// Aborted is set if the failure policy kept the records from being written
+	Aborted bool         `json:"aborted,omitempty"`
+	Alerts  []RunAlert   `json:"alerts"`
+	Sinks   []SinkStatus `json:"sinks"`
+}
+
+// RunAlert is a device whose status alerts, see alertStatus.
+type RunAlert struct {
+	Device  string   `json:"device"`
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func summarizeRun(records []PartitionLine, sinks []SinkStatus, started time.Time) RunSummary {
+	summary := RunSummary{
+		Started:         started,
+		DurationSeconds: time.Since(started).Seconds(),
+		Attempted:       len(records),
+		Alerts:          make([]RunAlert, 0),
+		Sinks:           sinks,
+	}
+	if runs := recordRuns(records); len(runs) > 0 {
+		summary.RunId = runs[0].Id
+	}
+	if summary.Sinks == nil {
+		summary.Sinks = make([]SinkStatus, 0)
+	}
+	for _, record := range records {
+		switch {
+		case record.Error != nil:
+			summary.Failed++
+		case record.Deferred:
+			summary.Deferred++
+		case !record.SmartSupported:
+			summary.Unsupported++
+		default:
+			summary.Succeeded++
+		}
+		if status, reasons := alertStatus(record); alertingStatus(status) {
+			summary.Alerts = append(summary.Alerts, RunAlert{Device: record.PartitionName, Status: status, Reasons: reasons})
+		}
+	}
+	return summary
+}
+
+// writeRunSummary writes summary as a line of JSON to path, replacing the file, or to the open
+// file descriptor N given as fd:N.
+func writeRunSummary(path string, summary RunSummary) {
+	if path == "" {
+		return
+	}
+	b, err := json.Marshal(summary)
+	if err != nil {
+		log.Printf("Could not encode run summary: %v\n", err)
+		return
+	}
+	b = append(b, '\n')
+	if fdStr, ok := strings.CutPrefix(path, "fd:"); ok {
+		fd, err := strconv.Atoi(fdStr)
+		if err != nil {
+			log.Printf("Invalid run summary file descriptor %q\n", path)
+			return
+		}
+		// The descriptor is left open for the next run
+		_, err = os.NewFile(uintptr(fd), path).Write(b)
+		if err != nil {
+			log.Printf("Could not write run summary to %s: %v\n", path, err)
+		}
+		return
+	}
+	if err := writeFileAtomic(path, b); err != nil {
+		log.Printf("Could not write run summary to %s: %v\n", path, err)
+	}
+}
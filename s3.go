@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config uploads the rotated files of the file output to an S3 bucket or a compatible object
+// store such as MinIO. Objects are addressed path style and signed with AWS Signature Version 4.
+type S3Config struct {
+	Bucket string `json:"bucket"`
+	// Prefix is put before the file names in the object keys, e.g. gosmart/host1/
+	Prefix string `json:"prefix,omitempty"`
+	// Region of the bucket, us-east-1 if empty
+	Region string `json:"region,omitempty"`
+	// Endpoint of the object store, https://s3.<region>.amazonaws.com if empty
+	Endpoint string `json:"endpoint,omitempty"`
+	// AccessKeyId, SecretAccessKey and SessionToken are read from AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN if empty
+	AccessKeyId     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+}
+
+func (c S3Config) region() string {
+	if c.Region == "" {
+		return "us-east-1"
+	}
+	return c.Region
+}
+
+func (c S3Config) endpoint() string {
+	if c.Endpoint == "" {
+		return "https://s3." + c.region() + ".amazonaws.com"
+	}
+	return strings.TrimSuffix(c.Endpoint, "/")
+}
+
+// s3Credentials are the keys requests to the object store are signed with.
+type s3Credentials struct {
+	accessKeyId, secretAccessKey, sessionToken string
+}
+
+func (c S3Config) credentials() s3Credentials {
+	if c.AccessKeyId != "" {
+		return s3Credentials{c.AccessKeyId, c.SecretAccessKey, c.SessionToken}
+	}
+	return s3Credentials{os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}
+}
+
+// uploadRotatedFiles uploads the rotated files of path to the bucket of conf, oldest first, and
+// deletes each once it is stored. Files that failed stay to be uploaded after the next rotation.
+func uploadRotatedFiles(path string, conf S3Config) error {
+	files, err := rotatedFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := putS3Object(conf, conf.Prefix+filepath.Base(file), file, time.Now()); err != nil {
+			return fmt.Errorf("s3 upload error for %s: %w", file, err)
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putS3Object stores the file at path as the object key of the bucket of conf.
+func putS3Object(conf S3Config, key, path string, now time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// The payload is signed, so the file is read twice instead of held in memory
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, conf.endpoint()+"/"+awsUriEncode(conf.Bucket, true)+"/"+awsUriEncode(key, false), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	signS3Request(req, hex.EncodeToString(hash.Sum(nil)), conf.region(), conf.credentials(), now)
+
+	client := http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// signS3Request signs req for S3 with AWS Signature Version 4, over the host and all headers set
+// on req. payloadHash is the hex SHA-256 of the body.
+func signS3Request(req *http.Request, payloadHash, region string, creds s3Credentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), canonicalQuery(req), canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSha256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s", creds.accessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery is the query of req with its parameters sorted and encoded as SigV4 requires.
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, awsUriEncode(name, true)+"="+awsUriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsUriEncode percent-encodes everything but the unreserved characters, and slashes unless
+// encodeSlash is false, as SigV4 requires.
+func awsUriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SandboxConfig restricts serve to the files and ports it needs with Landlock, on Linux 5.13 or
+// later. TCP ports are only restricted on Linux 6.7 or later.
+type SandboxConfig struct {
+	// Required stops serve if the kernel cannot sandbox it, instead of running unsandboxed
+	Required bool `json:"required,omitempty"`
+	// ReadPaths and WritePaths are allowed in addition to what the config needs
+	ReadPaths  []string `json:"read_paths,omitempty"`
+	WritePaths []string `json:"write_paths,omitempty"`
+	// ConnectPorts are allowed in addition to the ports of the configured outputs
+	ConnectPorts []int `json:"connect_ports,omitempty"`
+}
+
+// sandboxedEnv is set when serve re-executes itself inside the sandbox.
+const sandboxedEnv = "GOSMART_SANDBOXED"
+
+// sandboxRules are the paths and ports a sandboxed serve may use.
+type sandboxRules struct {
+	// read allows reading and executing, write also creating, writing and replacing files
+	read, write []string
+	// devices may be read, written and sent ioctls
+	devices      []string
+	bindPorts    []int
+	connectPorts []int
+}
+
+// systemReadPaths are read for discovery, name resolution, certificates, time zones and running
+// smartctl. Paths that do not exist are left out.
+var systemReadPaths = []string{"/etc", "/usr", "/lib", "/lib64", "/bin", "/sbin", "/opt", "/proc", "/sys", "/run/udev"}
+
+// buildSandboxRules collects what serve needs for conf: its own binary, its config and included
+// files or the port and cache of a config URL, the configured devices, the files of file based
+// outputs and the ports of network outputs.
+func buildSandboxRules(conf Config, serveConf ServeConfig, confPath string) sandboxRules {
+	sandbox := *serveConf.Sandbox
+	rules := sandboxRules{read: slices.Clone(sandbox.ReadPaths), write: slices.Clone(sandbox.WritePaths)}
+	rules.read = append(rules.read, systemReadPaths...)
+	if exe, err := os.Executable(); err == nil {
+		rules.read = append(rules.read, exe)
+	}
+	// A config read from a URL is read again by the sandboxed serve, falling back to its cache
+	if isRemoteConfig(confPath) {
+		if port, ok := remoteConfigPort(confPath); ok {
+			rules.connectPorts = append(rules.connectPorts, port)
+		}
+		if dir := remoteConfigCacheDir(); dir != "" {
+			rules.write = append(rules.write, dir)
+		}
+	} else {
+		rules.read = append(rules.read, confPath)
+		rules.read = append(rules.read, includeDirs(confPath)...)
+	}
+	if filepath.IsAbs(conf.SmartctlPath) {
+		rules.read = append(rules.read, conf.SmartctlPath)
+	}
+	if conf.Backblaze != nil {
+		rules.read = append(rules.read, conf.Backblaze.Path)
+	}
+	// Plugins run in the sandbox too, with the ports of sandbox.ConnectPorts
+	for _, outputType := range outputTypes(conf) {
+		if name, ok := pluginName(outputType); ok {
+			if path, err := findPlugin(name, conf); err == nil {
+				rules.read = append(rules.read, path)
+			}
+		}
+	}
+
+	rules.devices = append(rules.devices, os.DevNull)
+	for _, p := range conf.Partitions {
+		rules.devices = append(rules.devices, p.Path)
+	}
+	for _, t := range discoverTargets(conf, time.Now()) {
+		rules.devices = append(rules.devices, t.smartPath)
+	}
+
+	// Outputs may create their files, so their directories are writable
+	if conf.File != nil {
+		rules.write = append(rules.write, filepath.Dir(conf.File.Path))
+	}
+	if conf.Avro != nil {
+		rules.write = append(rules.write, filepath.Dir(conf.Avro.Path))
+	}
+	if conf.Protobuf != nil {
+		rules.write = append(rules.write, filepath.Dir(conf.Protobuf.Path))
+	}
+	if conf.Local != nil {
+		rules.write = append(rules.write, filepath.Dir(conf.Local.Path))
+		// The watermarks of sync are replaced after every batch an output accepted
+		if conf.Sync != nil {
+			rules.write = append(rules.write, filepath.Dir(syncStatePath(conf)))
+		}
+	}
+	if conf.Duckdb != nil {
+		rules.write = append(rules.write, filepath.Dir(conf.Duckdb.Path))
+	}
+	if conf.Archive != nil {
+		rules.write = append(rules.write, conf.Archive.Dir)
+	}
+	// Fixtures are written on every collection
+	if conf.RecordDir != "" {
+		rules.write = append(rules.write, conf.RecordDir)
+	}
+	if conf.SilencesPath != "" {
+		rules.write = append(rules.write, filepath.Dir(conf.SilencesPath))
+	}
+	// The summary is replaced through a temporary file next to it, a descriptor is already open
+	if conf.RunSummaryPath != "" && !strings.HasPrefix(conf.RunSummaryPath, "fd:") {
+		rules.write = append(rules.write, filepath.Dir(conf.RunSummaryPath))
+	}
+
+	if _, port, err := net.SplitHostPort(serveConf.Listen); err == nil {
+		if n, err := strconv.Atoi(port); err == nil {
+			rules.bindPorts = append(rules.bindPorts, n)
+		}
+	}
+	rules.connectPorts = append(rules.connectPorts, sandbox.ConnectPorts...)
+	rules.connectPorts = append(rules.connectPorts, outputPorts(conf)...)
+	return rules
+}
+
+// outputPorts returns the TCP ports the configured outputs and notifiers connect to.
+func outputPorts(conf Config) []int {
+	var ports []int
+	addUrl := func(raw string) {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return
+		}
+		if n, err := strconv.Atoi(u.Port()); err == nil {
+			ports = append(ports, n)
+		} else if u.Scheme == "http" {
+			ports = append(ports, 80)
+		} else {
+			ports = append(ports, 443)
+		}
+	}
+
+	if conf.Db != nil {
+		port := conf.Db.Port
+		if port == 0 {
+			port = 5432
+		}
+		ports = append(ports, port)
+	}
+	if conf.Redis != nil {
+		if _, port, err := net.SplitHostPort(conf.Redis.Address); err == nil {
+			if n, err := strconv.Atoi(port); err == nil {
+				ports = append(ports, n)
+			}
+		}
+	}
+	if conf.File != nil && conf.File.S3 != nil {
+		addUrl(conf.File.S3.endpoint())
+	}
+	if conf.VictoriaMetrics != nil {
+		addUrl(conf.VictoriaMetrics.Url)
+	}
+	if conf.Grafana != nil {
+		addUrl(conf.Grafana.Url)
+	}
+	if conf.Icinga != nil {
+		addUrl(conf.Icinga.Url)
+	}
+	// The Proxmox API names the guests on each disk
+	if conf.Hypervisor != nil && conf.Hypervisor.Source == HypervisorProxmox {
+		addUrl(conf.Hypervisor.Url)
+	}
+	if conf.Community != nil {
+		addUrl(conf.Community.Url)
+	}
+	if conf.Sensu != nil {
+		switch {
+		case conf.Sensu.BackendUrl != "":
+			addUrl(conf.Sensu.BackendUrl)
+		case conf.Sensu.AgentUrl != "":
+			addUrl(conf.Sensu.AgentUrl)
+		default:
+			ports = append(ports, 3031)
+		}
+	}
+	// Cloud APIs, and the GCE metadata server for GCM credentials
+	if conf.Gcm != nil || slices.Contains(outputTypes(conf), OutputGcm) {
+		ports = append(ports, 443, 80)
+	}
+	// Instance metadata services, ports 80 to 169.254.169.254 and metadata.google.internal
+	if conf.CloudMetadata != nil {
+		ports = append(ports, 80)
+	}
+	if conf.Azure != nil || conf.NewRelic != nil || conf.BigQuery != nil {
+		ports = append(ports, 443)
+	}
+	slices.Sort(ports)
+	return slices.Compact(ports)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock system calls and flags, see linux/landlock.h.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+	landlockRuleNetPort          = 2
+
+	landlockAccessExecute    = 1 << 0
+	landlockAccessWriteFile  = 1 << 1
+	landlockAccessReadFile   = 1 << 2
+	landlockAccessReadDir    = 1 << 3
+	landlockAccessRemoveDir  = 1 << 4
+	landlockAccessRemoveFile = 1 << 5
+	landlockAccessMakeChar   = 1 << 6
+	landlockAccessMakeDir    = 1 << 7
+	landlockAccessMakeReg    = 1 << 8
+	landlockAccessMakeSock   = 1 << 9
+	landlockAccessMakeFifo   = 1 << 10
+	landlockAccessMakeBlock  = 1 << 11
+	landlockAccessMakeSym    = 1 << 12
+	landlockAccessRefer      = 1 << 13
+	landlockAccessTruncate   = 1 << 14
+	landlockAccessIoctlDev   = 1 << 15
+
+	landlockAccessBindTcp    = 1 << 0
+	landlockAccessConnectTcp = 1 << 1
+
+	prSetNoNewPrivs = 38
+	// oPath is O_PATH, which the syscall package does not define
+	oPath = 0x200000
+)
+
+// landlockFileAccess are the rights that apply to files rather than directories.
+const landlockFileAccess = landlockAccessExecute | landlockAccessWriteFile | landlockAccessReadFile | landlockAccessTruncate | landlockAccessIoctlDev
+
+type landlockRulesetAttr struct {
+	handledAccessFs  uint64
+	handledAccessNet uint64
+}
+
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+type landlockNetPortAttr struct {
+	allowedAccess uint64
+	port          uint64
+}
+
+// landlockAbi returns the Landlock ABI version of the kernel, 0 if Landlock is not available.
+func landlockAbi() int {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0
+	}
+	return int(abi)
+}
+
+// enterSandbox restricts this thread to rules and re-executes serve in it, so every thread of
+// the new process is restricted. It only returns on error.
+func enterSandbox(rules sandboxRules) error {
+	abi := landlockAbi()
+	if abi == 0 {
+		return errors.New("landlock is not available, it needs Linux 5.13 or later with landlock enabled")
+	}
+
+	// Rights of later ABIs are only handled, i.e. denied by default, if the kernel knows them
+	handledFs := uint64(landlockAccessExecute | landlockAccessWriteFile | landlockAccessReadFile | landlockAccessReadDir |
+		landlockAccessRemoveDir | landlockAccessRemoveFile | landlockAccessMakeChar | landlockAccessMakeDir |
+		landlockAccessMakeReg | landlockAccessMakeSock | landlockAccessMakeFifo | landlockAccessMakeBlock | landlockAccessMakeSym)
+	if abi >= 2 {
+		handledFs |= landlockAccessRefer
+	}
+	if abi >= 3 {
+		handledFs |= landlockAccessTruncate
+	}
+	if abi >= 5 {
+		handledFs |= landlockAccessIoctlDev
+	}
+	attr := landlockRulesetAttr{handledAccessFs: handledFs}
+	attrSize := unsafe.Sizeof(attr.handledAccessFs)
+	if abi >= 4 {
+		attr.handledAccessNet = landlockAccessBindTcp | landlockAccessConnectTcp
+		attrSize = unsafe.Sizeof(attr)
+	}
+
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), attrSize, 0)
+	if errno != 0 {
+		return fmt.Errorf("could not create landlock ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer syscall.Close(ruleset)
+
+	read := uint64(landlockAccessExecute | landlockAccessReadFile | landlockAccessReadDir)
+	// Files are replaced by renaming a temporary file over them, which removes the old one
+	write := read | landlockAccessWriteFile | landlockAccessMakeReg | landlockAccessRemoveFile | landlockAccessTruncate
+	device := uint64(landlockAccessReadFile | landlockAccessWriteFile | landlockAccessIoctlDev)
+	for _, rule := range []struct {
+		paths  []string
+		access uint64
+	}{{rules.read, read}, {rules.write, write}, {rules.devices, device}} {
+		for _, path := range rule.paths {
+			if err := landlockAllowPath(ruleset, path, rule.access&handledFs); err != nil {
+				return err
+			}
+		}
+	}
+	if abi >= 4 {
+		for _, port := range rules.bindPorts {
+			if err := landlockAllowPort(ruleset, port, landlockAccessBindTcp); err != nil {
+				return err
+			}
+		}
+		for _, port := range rules.connectPorts {
+			if err := landlockAllowPort(ruleset, port, landlockAccessConnectTcp); err != nil {
+				return err
+			}
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not re-execute in the sandbox: %w", err)
+	}
+	// Both restrictions only apply to this thread, and to the process it executes
+	runtime.LockOSThread()
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("could not set no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("could not enter landlock sandbox: %w", errno)
+	}
+	return syscall.Exec(exe, os.Args, append(os.Environ(), sandboxedEnv+"=1"))
+}
+
+// landlockAllowPath allows access beneath path, or to path if it is a file. Paths that do not
+// exist are skipped.
+func landlockAllowPath(ruleset int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if errors.Is(err, syscall.ENOENT) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not open %s for the sandbox: %w", path, err)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("could not open %s for the sandbox: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= landlockFileAccess
+	}
+	attr := landlockPathBeneathAttr{allowedAccess: access, parentFd: int32(fd)}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("could not allow %s in the sandbox: %w", path, errno)
+	}
+	return nil
+}
+
+func landlockAllowPort(ruleset int, port int, access uint64) error {
+	attr := landlockNetPortAttr{allowedAccess: access, port: uint64(port)}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRuleNetPort, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("could not allow port %d in the sandbox: %w", port, errno)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func enterSandbox(rules sandboxRules) error {
+	return errors.New("the sandbox is only available on Linux")
+}
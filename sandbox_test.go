@@ -0,0 +1,114 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRemoteConfigPort(t *testing.T) {
+	for rawUrl, want := range map[string]int{
+		"http://config.example.com/gosmart.json":            80,
+		"https://config.example.com/gosmart.json#sha256=ab": 443,
+		"consul://consul:8500/gosmart/config":               8500,
+		"consul+https://consul/gosmart/config":              443,
+		"etcd://etcd:2379/gosmart/config":                   2379,
+		"etcd+https://etcd:2379/gosmart/config":             2379,
+	} {
+		if port, ok := remoteConfigPort(rawUrl); !ok || port != want {
+			t.Errorf("%s is read from port %d, want %d", rawUrl, port, want)
+		}
+	}
+}
+
+func TestBuildSandboxRulesConfig(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	serveConf := ServeConfig{Listen: "127.0.0.1:9633", Sandbox: &SandboxConfig{ConnectPorts: []int{25}}}
+	conf := Config{Discovery: DiscoveryDirect}
+
+	confPath := filepath.Join(t.TempDir(), "conf.json")
+	rules := buildSandboxRules(conf, serveConf, confPath)
+	if !slices.Contains(rules.read, confPath) {
+		t.Errorf("config file %s not readable: %v", confPath, rules.read)
+	}
+	if !slices.Equal(rules.bindPorts, []int{9633}) || !slices.Equal(rules.connectPorts, []int{25}) {
+		t.Errorf("ports bind %v connect %v", rules.bindPorts, rules.connectPorts)
+	}
+
+	// A config URL is read again from its server or the cache, it is no file to allow
+	confUrl := "consul://consul.internal:8500/gosmart/config"
+	rules = buildSandboxRules(conf, serveConf, confUrl)
+	if slices.Contains(rules.read, confUrl) {
+		t.Errorf("config URL in the read paths: %v", rules.read)
+	}
+	if !slices.Contains(rules.connectPorts, 8500) || !slices.Contains(rules.connectPorts, 25) {
+		t.Errorf("connect ports %v, want the config server and the configured ports", rules.connectPorts)
+	}
+	if dir := remoteConfigCacheDir(); dir == "" || !slices.Contains(rules.write, dir) {
+		t.Errorf("config cache %s not writable: %v", dir, rules.write)
+	}
+}
+
+func TestBuildSandboxRulesSyncState(t *testing.T) {
+	serveConf := ServeConfig{Listen: "127.0.0.1:9633", Sandbox: &SandboxConfig{}}
+	conf := Config{Discovery: DiscoveryDirect, Local: &LocalConfig{Path: "/var/lib/gosmart/local.db"}, Sync: &SyncConfig{Outputs: []string{OutputPostgres}}}
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); !slices.Contains(rules.write, "/var/lib/gosmart") {
+		t.Errorf("sync state next to the local store not writable: %v", rules.write)
+	}
+	conf.Sync.StatePath = "/var/spool/gosmart/sync.json"
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); !slices.Contains(rules.write, "/var/spool/gosmart") {
+		t.Errorf("sync state %s not writable: %v", conf.Sync.StatePath, rules.write)
+	}
+}
+
+func TestBuildSandboxRulesRunSummary(t *testing.T) {
+	serveConf := ServeConfig{Listen: "127.0.0.1:9633", Sandbox: &SandboxConfig{}}
+	conf := Config{Discovery: DiscoveryDirect, RunSummaryPath: "/run/gosmart/summary.json"}
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); !slices.Contains(rules.write, "/run/gosmart") {
+		t.Errorf("run summary %s not writable: %v", conf.RunSummaryPath, rules.write)
+	}
+	conf.RunSummaryPath = "fd:3"
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); slices.Contains(rules.write, ".") {
+		t.Errorf("run summary descriptor made the working directory writable: %v", rules.write)
+	}
+}
+
+func TestBuildSandboxRulesCommunity(t *testing.T) {
+	serveConf := ServeConfig{Listen: "127.0.0.1:9633", Sandbox: &SandboxConfig{}}
+	conf := Config{Discovery: DiscoveryDirect, OutputTypes: []string{OutputCommunity}, Community: &CommunityConfig{Url: "https://stats.example.org:8443/submit"}}
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); !slices.Contains(rules.connectPorts, 8443) {
+		t.Errorf("community service port not allowed: %v", rules.connectPorts)
+	}
+}
+
+func TestBuildSandboxRulesProxmox(t *testing.T) {
+	serveConf := ServeConfig{Listen: "127.0.0.1:9633", Sandbox: &SandboxConfig{}}
+	conf := Config{Discovery: DiscoveryDirect, Hypervisor: &HypervisorConfig{Source: HypervisorProxmox, Url: "https://localhost:8006"}}
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); !slices.Contains(rules.connectPorts, 8006) {
+		t.Errorf("Proxmox API port not allowed: %v", rules.connectPorts)
+	}
+}
+
+func TestBuildSandboxRulesBackblaze(t *testing.T) {
+	serveConf := ServeConfig{Listen: "127.0.0.1:9633", Sandbox: &SandboxConfig{}}
+	conf := Config{Discovery: DiscoveryDirect, Backblaze: &BackblazeConfig{Path: "/var/lib/gosmart/drive_stats.csv"}}
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); !slices.Contains(rules.read, conf.Backblaze.Path) {
+		t.Errorf("drive stats %s not readable: %v", conf.Backblaze.Path, rules.read)
+	}
+}
+
+func TestBuildSandboxRulesRecordDir(t *testing.T) {
+	serveConf := ServeConfig{Listen: "127.0.0.1:9633", Sandbox: &SandboxConfig{}}
+	conf := Config{Discovery: DiscoveryDirect, RecordDir: "/var/lib/gosmart/fixtures"}
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); !slices.Contains(rules.write, conf.RecordDir) {
+		t.Errorf("record dir %s not writable: %v", conf.RecordDir, rules.write)
+	}
+}
+
+func TestBuildSandboxRulesS3(t *testing.T) {
+	serveConf := ServeConfig{Listen: "127.0.0.1:9633", Sandbox: &SandboxConfig{}}
+	conf := Config{Discovery: DiscoveryDirect, File: &FileConfig{Path: "/var/lib/gosmart/readings.jsonl", S3: &S3Config{Bucket: "readings", Endpoint: "http://minio.internal:9000"}}}
+	if rules := buildSandboxRules(conf, serveConf, "/etc/gosmart/conf.json"); !slices.Contains(rules.connectPorts, 9000) {
+		t.Errorf("object store port not allowed: %v", rules.connectPorts)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	nvmeLogSanitizeStatus = 0x81
+	// sanitizeEstimateNotAvailable is the estimated time of sanitize kinds the drive cannot tell
+	sanitizeEstimateNotAvailable = 0xFFFFFFFF
+)
+
+// Values of NvmeSanitizeStatus.Status, from the low 3 bits of SSTAT
+const (
+	SanitizeNever              = "never"
+	SanitizeCompleted          = "completed"
+	SanitizeInProgress         = "in_progress"
+	SanitizeFailed             = "failed"
+	SanitizeCompletedNoDealloc = "completed_no_deallocate"
+)
+
+var sanitizeStatuses = map[uint16]string{
+	0: SanitizeNever,
+	1: SanitizeCompleted,
+	2: SanitizeInProgress,
+	3: SanitizeFailed,
+	4: SanitizeCompletedNoDealloc,
+}
+
+// NvmeSanitizeStatus is the NVMe Sanitize Status log (log page 0x81): the state of the most
+// recent sanitize operation and, while one runs, its progress.
+type NvmeSanitizeStatus struct {
+	Status string `json:"status"`
+	// ProgressPercent is only set while a sanitize operation is in progress
+	ProgressPercent *float64 `json:"progress_percent,omitempty"`
+	// OverwritePasses is the number of passes completed by the last overwrite sanitize
+	OverwritePasses int `json:"overwrite_passes,omitempty"`
+	// GlobalDataErased is set by the controller when no user data has been written since a
+	// sanitize or since manufacture
+	GlobalDataErased bool `json:"global_data_erased"`
+	// Estimated seconds a sanitize of each kind takes, if the drive reports them
+	OverwriteSeconds   *uint32 `json:"overwrite_seconds,omitempty"`
+	BlockEraseSeconds  *uint32 `json:"block_erase_seconds,omitempty"`
+	CryptoEraseSeconds *uint32 `json:"crypto_erase_seconds,omitempty"`
+}
+
+func (s NvmeSanitizeStatus) String() string {
+	msg := strings.ReplaceAll(s.Status, "_", " ")
+	if s.ProgressPercent != nil {
+		msg += fmt.Sprintf(" %.1f%%", *s.ProgressPercent)
+	}
+	if s.OverwritePasses > 0 {
+		msg += fmt.Sprintf(", %d overwrite passes", s.OverwritePasses)
+	}
+	if s.GlobalDataErased {
+		msg += ", no data written since"
+	}
+	return msg
+}
+
+// parseNvmeSanitizeStatus decodes the first 20 bytes of the sanitize status log, the rest holds
+// estimates for no-deallocate variants and is reserved.
+func parseNvmeSanitizeStatus(buf []byte) (*NvmeSanitizeStatus, error) {
+	if len(buf) < 20 {
+		return nil, fmt.Errorf("sanitize status log is %d bytes, want at least 20", len(buf))
+	}
+	le := binary.LittleEndian
+	sstat := le.Uint16(buf[2:])
+	status, ok := sanitizeStatuses[sstat&0x7]
+	if !ok {
+		status = fmt.Sprintf("unknown (%d)", sstat&0x7)
+	}
+	s := &NvmeSanitizeStatus{
+		Status:           status,
+		OverwritePasses:  int(sstat>>3) & 0x1F,
+		GlobalDataErased: sstat&0x100 != 0,
+	}
+	if status == SanitizeInProgress {
+		// SPROG is the fraction completed, numerator over 65536
+		progress := float64(le.Uint16(buf[0:])) / 65536 * 100
+		s.ProgressPercent = &progress
+	}
+	for _, estimate := range []struct {
+		offset int
+		target **uint32
+	}{{8, &s.OverwriteSeconds}, {12, &s.BlockEraseSeconds}, {16, &s.CryptoEraseSeconds}} {
+		if v := le.Uint32(buf[estimate.offset:]); v != sanitizeEstimateNotAvailable {
+			*estimate.target = &v
+		}
+	}
+	return s, nil
+}
+
+// collectNvmeSanitizeStatus reads the sanitize status log, and warns while a sanitize runs or if
+// the last one failed. Drives without sanitize support are skipped silently.
+func collectNvmeSanitizeStatus(devName string, line PartitionLine) PartitionLine {
+	buf, err := readNvmeLogPage(devName, nvmeLogSanitizeStatus, 512)
+	if err != nil {
+		return line
+	}
+	status, err := parseNvmeSanitizeStatus(buf)
+	if err != nil {
+		fmt.Printf("Could not read sanitize status of %s: %s\n", devName, err)
+		return line
+	}
+	line.NvmeSanitize = status
+	switch status.Status {
+	case SanitizeInProgress:
+		line.warn("sanitize in progress, %.1f%% done", *status.ProgressPercent)
+	case SanitizeFailed:
+		line.warn("the last sanitize failed")
+	}
+	return line
+}
+
+// sanitizeInProgress reports whether devName is an NVMe drive running a sanitize operation. Any
+// other device, or one whose log cannot be read, is not.
+func sanitizeInProgress(devName string) bool {
+	buf, err := readNvmeLogPage(devName, nvmeLogSanitizeStatus, 512)
+	if err != nil {
+		return false
+	}
+	status, err := parseNvmeSanitizeStatus(buf)
+	return err == nil && status.Status == SanitizeInProgress
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// DeviceInterval collects a group of devices on its own interval instead of the serve interval,
+// e.g. NVMe drives every minute and archive disks hourly so they can spin down in between.
+type DeviceInterval struct {
+	// Class is nvme, ssd or hdd, as found by the last collection of the device. Devices are
+	// collected on the serve interval until their class is known.
+	Class string `json:"class,omitempty"`
+	// Paths are glob patterns of device paths, e.g. /dev/sd[c-f]
+	Paths           []string `json:"paths,omitempty"`
+	IntervalSeconds int      `json:"interval_seconds"`
+}
+
+const DeviceClassNvme = "nvme"
+
+// deviceClass is nvme for NVMe devices and the media type otherwise.
+func deviceClass(record PartitionLine) string {
+	if record.NvmeHealth != nil {
+		return DeviceClassNvme
+	}
+	return record.MediaType
+}
+
+func (i DeviceInterval) matches(path string, class string) bool {
+	if i.Class != "" && i.Class != class {
+		return false
+	}
+	if len(i.Paths) == 0 {
+		return i.Class != ""
+	}
+	for _, pattern := range i.Paths {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceInterval returns the interval of the first group the device matches, or interval.
+func (d *daemon) deviceInterval(partition PartitionConfig, interval time.Duration, intervals []DeviceInterval) time.Duration {
+	class := ""
+	d.mu.Lock()
+	for _, record := range d.lastRecords {
+		if record.PartitionName == partition.Path {
+			class = deviceClass(record)
+		}
+	}
+	d.mu.Unlock()
+	for _, group := range intervals {
+		if group.IntervalSeconds > 0 && group.matches(partition.Path, class) {
+			return time.Duration(group.IntervalSeconds) * time.Second
+		}
+	}
+	return interval
+}
+
+// scheduleLoop collects each device when its interval is up, devices that are due together in
+// one collection. All devices are collected at startup.
+func (d *daemon) scheduleLoop(interval time.Duration, intervals []DeviceInterval) {
+	next := make(map[string]time.Time)
+	for {
+		now := time.Now()
+		var due []PartitionConfig
+		for _, partition := range d.conf.Partitions {
+			if !now.Before(next[partition.Path]) {
+				due = append(due, partition)
+			}
+		}
+		if len(due) > 0 {
+			d.collectDevices(due)
+			for _, partition := range due {
+				next[partition.Path] = now.Add(d.deviceInterval(partition, interval, intervals))
+			}
+		}
+
+		wake := now.Add(interval)
+		for _, partition := range d.conf.Partitions {
+			if t := next[partition.Path]; t.Before(wake) {
+				wake = t
+			}
+		}
+		time.Sleep(time.Until(wake))
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// recordSchemaVersion is the layout of the JSON records, written as their schema_version. Records
+// read back by import or sync are translated from older versions and refused from newer ones, so
+// agents and the hosts collecting their records can be upgraded independently. Records without
+// it are version 1, written before it, whose attributes may lack decoded values.
+const recordSchemaVersion = 2
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// upgradeRecord translates a record of an older schema version to the current one.
+func upgradeRecord(record PartitionLine, version int, conf Config) (PartitionLine, error) {
+	if version == 0 {
+		version = 1
+	}
+	if version > recordSchemaVersion {
+		return record, fmt.Errorf("schema version %d is newer than %d, upgrade gosmart to read it", version, recordSchemaVersion)
+	}
+	if version < 2 {
+		// Decoding again would lose model specific rules where the model is not kept, so only the
+		// missing values are filled in
+		decoded := decodeTemperatures(decodeRawValues(slices.Clone(record.Attributes), record.Model, conf))
+		for i, attr := range record.Attributes {
+			if attr.ValueDecoded == 0 {
+				record.Attributes[i] = decoded[i]
+			}
+		}
+	}
+	record.SchemaVersion = recordSchemaVersion
+	return record, nil
+}
+
+// jsonSchemaFor derives the JSON Schema of the encoding/json output of t, so it cannot drift from
+// the structs. Pointers, slices and maps may also be null, as encoding/json writes them when nil.
+func jsonSchemaFor(t reflect.Type) map[string]any {
+	nullable := func(schema map[string]any) map[string]any {
+		schema["type"] = []any{schema["type"], "null"}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(jsonSchemaFor(t.Elem()))
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchemaFor(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Slice:
+		return nullable(map[string]any{"type": "array", "items": jsonSchemaFor(t.Elem())})
+	case reflect.Map:
+		return nullable(map[string]any{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem())})
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		properties := map[string]any{}
+		var required []string
+		addStructFields(t, properties, &required)
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]any{}
+}
+
+// addStructFields adds the JSON fields of t, following encoding/json's rules for tags and embedded
+// structs. Fields without omitempty are always present and therefore required.
+func addStructFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructFields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = jsonSchemaFor(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// recordJsonSchema is the JSON Schema of one record of the json output.
+func recordJsonSchema() map[string]any {
+	schema := jsonSchemaFor(reflect.TypeOf(PartitionLine{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "gosmart record"
+	return schema
+}
+
+// runSchema implements `gosmart schema`, which prints the schema of a structured output.
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	format := fs.String("format", "avro", "Schema format: avro or json")
+	_ = fs.Parse(args)
+
+	switch *format {
+	case "avro":
+		fmt.Println(avroSchema)
+	case "json":
+		b, _ := json.MarshalIndent(recordJsonSchema(), "", "  ")
+		fmt.Println(string(b))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown schema format %q\n", *format)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seagateReadErrors is a Seagate Raw_Read_Error_Rate of 5 errors in 1000 operations.
+var seagateReadErrors = AtaSmartAttr{Id: 1, ValueRaw: 5<<32 | 1000}
+
+func TestUpgradeRecord(t *testing.T) {
+	legacy := PartitionLine{Model: "ST4000DM004", Attributes: []Attr{
+		{AtaSmartAttr: seagateReadErrors},
+		{AtaSmartAttr: AtaSmartAttr{Id: 9, ValueRaw: 21447}, ValueDecoded: 21000},
+	}}
+	got, err := upgradeRecord(legacy, 0, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SchemaVersion != recordSchemaVersion {
+		t.Errorf("upgraded to version %d", got.SchemaVersion)
+	}
+	if got.Attributes[0].ValueDecoded != 5 {
+		t.Errorf("read errors decoded to %d, want 5", got.Attributes[0].ValueDecoded)
+	}
+	if got.Attributes[1].ValueDecoded != 21000 {
+		t.Errorf("decoded value replaced by %d", got.Attributes[1].ValueDecoded)
+	}
+
+	current := PartitionLine{Model: "ST4000DM004", Attributes: []Attr{{AtaSmartAttr: seagateReadErrors}}}
+	if got, err := upgradeRecord(current, recordSchemaVersion, Config{}); err != nil || got.Attributes[0].ValueDecoded != 0 {
+		t.Errorf("current record translated to %+v, %v", got.Attributes, err)
+	}
+	if _, err := upgradeRecord(current, recordSchemaVersion+1, Config{}); err == nil {
+		t.Error("read a record of a newer schema version")
+	}
+}
+
+func TestImportJsonRecords(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	current, err := marshalRecord(PartitionLine{
+		Ts: ts, PartitionName: "/dev/sda", Model: "ST4000DM004",
+		Attributes: []Attr{{AtaSmartAttr: seagateReadErrors, ValueDecoded: 5}},
+	}, Config{TimestampFormat: TimestampUnix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"uuid": "", "ts": "2024-05-01T11:00:00Z", "partition_name": "/dev/sdb", "model": "ST8000VN004", "attributes": [{"Id": 1, "ValueRaw": 21474837480}]}`
+	failed := `{"schema_version": 2, "ts": "2024-05-01T12:00:00Z", "partition_name": "/dev/sdc", "attributes": null, "error": {"class": "open", "message": "no answer"}}`
+	input := strings.Join([]string{string(current), legacy, failed}, "\n")
+
+	keep := func(disk importedDisk) string { return disk.device }
+	records, err := importJson(strings.NewReader(input), Config{}, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("imported %d records, want 2 without the failed one", len(records))
+	}
+	if !records[0].Ts.Equal(ts) || records[0].PartitionName != "/dev/sda" {
+		t.Errorf("unix record imported as %s at %s", records[0].PartitionName, records[0].Ts)
+	}
+	if records[1].SchemaVersion != recordSchemaVersion || records[1].Attributes[0].ValueDecoded != 5 {
+		t.Errorf("legacy record imported as version %d with %+v", records[1].SchemaVersion, records[1].Attributes)
+	}
+
+	newer := `{"schema_version": 99, "ts": "2024-05-01T12:00:00Z", "partition_name": "/dev/sda", "attributes": []}`
+	if _, err := importJson(strings.NewReader(newer), Config{}, keep); err == nil {
+		t.Error("imported a record of a newer schema version")
+	}
+}
+
+// Readings kept by the local store are synced at the current schema version, and readings stored
+// by a newer gosmart are not sent on as if they were understood.
+func TestLocalReadingsSchemaVersion(t *testing.T) {
+	conf := LocalConfig{Path: filepath.Join(t.TempDir(), "local.db")}
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := PartitionLine{Ts: ts, PartitionName: "/dev/sda", Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 9, ValueRaw: 100}, ValueDecoded: 100}}}
+	if err := saveToLocal([]PartitionLine{record}, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openLocalStore(conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	readings, err := localReadingsAfter(db, watermark{}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 1 || readings[0].SchemaVersion != recordSchemaVersion || readings[0].Attributes[0].ValueDecoded != 100 {
+		t.Fatalf("synced %+v", readings)
+	}
+
+	newer, _ := json.Marshal(localReading{SchemaVersion: recordSchemaVersion + 1, Ts: ts.Add(time.Hour), Attributes: json.RawMessage("[]")})
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("/dev/sda")).Put(localKey(ts.Add(time.Hour)), newer)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := localReadingsAfter(db, watermark{Ts: ts, PartitionName: "/dev/sda"}, Config{}); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("synced a reading of a newer schema version: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	SelfTestShort = "short"
+	SelfTestLong  = "long"
+	// SelfTestOffline is ATA EXECUTE OFF-LINE IMMEDIATE, offline data collection rather than a
+	// test. Some attributes are only updated by it.
+	SelfTestOffline = "offline"
+)
+
+// SelfTestStatus is the progress of a running self-test and the result of the last completed one.
+type SelfTestStatus struct {
+	Device     string `json:"device"`
+	InProgress bool   `json:"in_progress"`
+	// RemainingPercent of the running self-test, if the drive reports it
+	RemainingPercent *int           `json:"remaining_percent,omitempty"`
+	Status           string         `json:"status,omitempty"`
+	Last             *SelfTestEntry `json:"last,omitempty"`
+}
+
+// SelfTestEntry is a completed self-test from the drive's self-test log.
+type SelfTestEntry struct {
+	Type         string `json:"type"`
+	Result       string `json:"result"`
+	Passed       bool   `json:"passed"`
+	PowerOnHours int    `json:"power_on_hours"`
+	// Lba of the first failure, if the drive logged one
+	Lba *uint64 `json:"lba,omitempty"`
+}
+
+// startSelfTest starts a short, conveyance or long self-test, or offline data collection, in the
+// background on the drive. smartctl returns as soon as the drive accepted it.
+func startSelfTest(devName, testType string, conf Config) error {
+	if testType != SelfTestShort && testType != SelfTestLong && testType != SelfTestOffline && testType != SelfTestConveyance {
+		return fmt.Errorf("unknown self-test type %q", testType)
+	}
+	if conf.BlockSelfTestsDuringSanitize && sanitizeInProgress(devName) {
+		return fmt.Errorf("%s is being sanitized, not starting a self-test", devName)
+	}
+	out, err := runSmartctl(conf.SmartctlPath, devName, "-t", testType)
+	if err != nil {
+		return err
+	}
+	// Bit 2: a command to the drive failed, here the one starting the test
+	if out.Smartctl.ExitStatus&0x4 != 0 {
+		return fmt.Errorf("%s did not accept the self-test", devName)
+	}
+	return nil
+}
+
+// readSelfTestStatus reads the self-test progress of ATA drives from their SMART data, and of
+// NVMe drives from their self-test log, along with the last logged result.
+func readSelfTestStatus(devName string, conf Config) (SelfTestStatus, error) {
+	status := SelfTestStatus{Device: devName}
+	out, err := runSmartctl(conf.SmartctlPath, devName, "-c", "-l", "selftest")
+	if err != nil {
+		return status, err
+	}
+
+	if data := out.AtaSmartData; data != nil {
+		current := data.SelfTest.Status
+		// Status values 0xF0 to 0xFF mean a test is running, the low nibble counts down by 10%
+		status.InProgress = current.Value>>4 == 0xF
+		status.Status = current.String
+		if status.InProgress {
+			status.RemainingPercent = current.RemainingPercent
+		}
+	}
+	if testLog := out.AtaSmartSelfTestLog; testLog != nil && len(testLog.Standard.Table) > 0 {
+		entry := testLog.Standard.Table[0]
+		status.Last = &SelfTestEntry{Type: entry.Type.String, Result: entry.Status.String, Passed: entry.Status.Value>>4 == 0, PowerOnHours: entry.LifetimeHours, Lba: entry.Lba}
+	}
+
+	if testLog := out.NvmeSelfTestLog; testLog != nil {
+		status.InProgress = testLog.CurrentOperation.Value != 0
+		status.Status = testLog.CurrentOperation.String
+		if status.InProgress {
+			remaining := 100 - testLog.CurrentCompletionPercent
+			status.RemainingPercent = &remaining
+		}
+		if len(testLog.Table) > 0 {
+			entry := testLog.Table[0]
+			status.Last = &SelfTestEntry{Type: entry.Code.String, Result: entry.Result.String, Passed: entry.Result.Value == 0, PowerOnHours: entry.PowerOnHours}
+		}
+	}
+
+	if out.AtaSmartData == nil && out.NvmeSelfTestLog == nil {
+		return status, fmt.Errorf("%s does not report self-tests", devName)
+	}
+	return status, nil
+}
+
+// configuredDevice looks up a device of the config by path.
+func (d *daemon) configuredDevice(device string) (PartitionConfig, bool) {
+	i := slices.IndexFunc(d.conf.Partitions, func(p PartitionConfig) bool { return deviceKey(p.Path) == deviceKey(device) })
+	if i < 0 {
+		return PartitionConfig{}, false
+	}
+	return d.conf.Partitions[i], true
+}
+
+// handleSelfTest starts a self-test on POST, with type short (the default), long or offline, and
+// reports its progress and the last result on GET. Both need the device parameter.
+func (d *daemon) handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	device, ok := d.configuredDevice(r.URL.Query().Get("device"))
+	if !ok {
+		http.Error(w, "device must be a configured device", http.StatusNotFound)
+		return
+	}
+
+	code := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		testType := r.URL.Query().Get("type")
+		if testType == "" {
+			testType = SelfTestShort
+		} else if testType != SelfTestShort && testType != SelfTestLong && testType != SelfTestOffline {
+			http.Error(w, "type must be short, long or offline", http.StatusBadRequest)
+			return
+		}
+		if d.rejectPaused(w) {
+			return
+		}
+		if err := startSelfTest(device.Path, testType, d.conf); err != nil {
+			d.recordError(err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		code = http.StatusAccepted
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := readSelfTestStatus(device.Path, d.conf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// offlineCollectionLoop starts offline data collection on the ATA drives of the last collection
+// every interval. NVMe drives have no equivalent and are skipped.
+func (d *daemon) offlineCollectionLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		if d.skipPaused("offline data collection") {
+			continue
+		}
+		d.mu.Lock()
+		ata := make(map[string]bool)
+		for _, record := range d.lastRecords {
+			ata[record.PartitionName] = record.SmartSupported && record.NvmeHealth == nil
+		}
+		d.mu.Unlock()
+
+		started := make(map[string]bool)
+		for _, t := range discoverTargets(d.conf, time.Now()) {
+			if !ata[t.line.PartitionName] || started[t.smartPath] {
+				continue
+			}
+			started[t.smartPath] = true
+			d.collectMu.Lock()
+			err := startSelfTest(t.smartPath, SelfTestOffline, d.conf)
+			d.collectMu.Unlock()
+			if err != nil {
+				d.recordError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type SensuConfig struct {
+	// AgentUrl is the local Sensu agent API, defaults to http://127.0.0.1:3031. Ignored if
+	// BackendUrl is set.
+	AgentUrl string `json:"agent_url,omitempty"`
+	// BackendUrl, ApiKey and Namespace send events straight to the backend API instead
+	BackendUrl string `json:"backend_url,omitempty"`
+	ApiKey     string `json:"api_key,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	// Entity is the entity events belong to when using the backend API, defaults to the host name
+	Entity string `json:"entity,omitempty"`
+	// Handlers are set on every check
+	Handlers []string `json:"handlers,omitempty"`
+}
+
+type sensuMetadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type sensuEntity struct {
+	EntityClass string        `json:"entity_class"`
+	Metadata    sensuMetadata `json:"metadata"`
+}
+
+type sensuEvent struct {
+	Entity *sensuEntity `json:"entity,omitempty"`
+	Check  struct {
+		Metadata sensuMetadata `json:"metadata"`
+		Status   int           `json:"status"`
+		Output   string        `json:"output"`
+		Executed int64         `json:"executed"`
+		Handlers []string      `json:"handlers,omitempty"`
+		// OutputMetricFormat lets Sensu metric handlers parse the attributes from the output
+		OutputMetricFormat string `json:"output_metric_format,omitempty"`
+	} `json:"check"`
+}
+
+// saveToSensu sends a record as a check event to the Sensu agent or backend.
+func saveToSensu(record PartitionLine, conf SensuConfig) error {
+	var event sensuEvent
+	status, output := checkResult(record)
+	event.Check.Metadata.Name = checkName(record.PartitionName)
+	event.Check.Status = status
+	event.Check.Executed = record.Ts.Unix()
+	event.Check.Handlers = conf.Handlers
+
+	// Attributes follow the check output as nagios_perfdata
+	var perfData []string
+	for _, attr := range record.Attributes {
+		perfData = append(perfData, fmt.Sprintf("%d_%s=%d", attr.Id, attr.Name, attr.ValueDecoded))
+	}
+	if len(perfData) > 0 {
+		output += " | " + strings.Join(perfData, " ")
+		event.Check.OutputMetricFormat = "nagios_perfdata"
+	}
+	event.Check.Output = output
+
+	url := conf.AgentUrl
+	if url == "" {
+		url = "http://127.0.0.1:3031"
+	}
+	url = strings.TrimSuffix(url, "/") + "/events"
+	if conf.BackendUrl != "" {
+		namespace := conf.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		entity := conf.Entity
+		if entity == "" {
+			entity, _ = os.Hostname()
+		}
+		event.Check.Metadata.Namespace = namespace
+		event.Entity = &sensuEntity{EntityClass: "proxy", Metadata: sensuMetadata{Name: entity, Namespace: namespace}}
+		url = fmt.Sprintf("%s/api/core/v2/namespaces/%s/events", strings.TrimSuffix(conf.BackendUrl, "/"), namespace)
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("sensu request error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if conf.ApiKey != "" {
+		req.Header.Set("Authorization", "Key "+conf.ApiKey)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sensu write error for %s: %w", record.PartitionName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sensu write error for %s: %s %s", record.PartitionName, resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ServeConfig struct {
+	// Listen is the address of the HTTP server, defaults to :9633
+	Listen string `json:"listen,omitempty"`
+	// IntervalSeconds between collections, defaults to 300. The first collection runs at startup.
+	IntervalSeconds int `json:"interval_seconds,omitempty"`
+	// Align schedules collections on wall-clock multiples of the interval
+	Align bool `json:"align,omitempty"`
+	// Stagger spreads the devices evenly over the interval, each collected on its own at a fixed
+	// offset, instead of reading all of them at once
+	Stagger bool `json:"stagger,omitempty"`
+	// DeviceIntervals collect groups of devices on their own intervals, the first matching group
+	// applies. Stagger and Align are ignored with them.
+	DeviceIntervals []DeviceInterval `json:"device_intervals,omitempty"`
+	// HotplugSeconds between rescans for attached and detached devices, off if zero. Attached
+	// devices are collected right away instead of at the next collection.
+	HotplugSeconds int `json:"hotplug_seconds,omitempty"`
+	// OfflineCollectionHours between runs of ATA offline data collection on the collected drives, off
+	// if zero. Some drives only update attributes such as Offline_Uncorrectable when it runs.
+	OfflineCollectionHours int `json:"offline_collection_hours,omitempty"`
+	// Udev collects devices as soon as the kernel reports them attached, on Linux
+	Udev *UdevConfig `json:"udev,omitempty"`
+	// DisableDashboard turns off the web dashboard at / and its /api endpoints
+	DisableDashboard bool `json:"disable_dashboard,omitempty"`
+	// DisableMetrics turns off the Prometheus endpoint at /metrics
+	DisableMetrics bool `json:"disable_metrics,omitempty"`
+	// Debug exposes /debug/pprof and /debug/state, do not enable it on untrusted networks
+	Debug bool `json:"debug,omitempty"`
+	// Tokens restrict the HTTP API when set: read_only tokens may read devices, history and
+	// self-test results, operator tokens may also trigger collections and self-tests and use the
+	// debug endpoints. The dashboard page itself is public and takes a token as #token=<token>.
+	Tokens []ApiToken `json:"tokens,omitempty"`
+	// RequestsPerMinute limits the requests of each token, and of each remote address for requests
+	// without a known token, unlimited if zero. Short bursts of up to a minute's worth are allowed.
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	// AccessLog writes a JSON line per request to stderr
+	AccessLog bool `json:"access_log,omitempty"`
+	// User and Group to switch to after opening the listening socket and the configured devices, to
+	// start as root without collecting as root. Group defaults to the user's primary group. Devices
+	// are held open, so smartctl and anything else that reopens devices fails after the switch.
+	// Not supported on Windows.
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+	// Sandbox restricts serve to the files and ports the config needs, on Linux
+	Sandbox *SandboxConfig `json:"sandbox,omitempty"`
+}
+
+// maxDaemonErrors is how many recent errors /debug/state keeps.
+const maxDaemonErrors = 20
+
+type daemonError struct {
+	Ts    time.Time `json:"ts"`
+	Error string    `json:"error"`
+}
+
+// daemon collects on an interval and keeps the state the HTTP endpoints report on.
+type daemon struct {
+	conf    Config
+	writer  *outputSet
+	started time.Time
+	// collectMu serializes scheduled and on-demand collections, which share the writer
+	collectMu sync.Mutex
+	// silencesMu serializes changes to the silences file, collections only read it
+	silencesMu sync.Mutex
+
+	mu           sync.Mutex
+	runs         int
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastRecords  []PartitionLine
+	lastErrors   []daemonError
+	queueDepth   int
+	// lastSinks is the delivery summary of each output in the last collection
+	lastSinks []SinkStatus
+	// readiness is the outcome of probing the outputs at startup
+	readiness []OutputReadiness
+	// collectSeconds per device and temperatures of all devices, for /metrics
+	collectSeconds map[string]*promHistogram
+	temperatures   *promHistogram
+	// pausedAt is when collection was paused, zero while collecting, and pauseUntil when it resumes
+	// on its own, zero to wait for a resume
+	pausedAt    time.Time
+	pauseUntil  time.Time
+	pauseReason string
+	// skipped counts the collections skipped while paused
+	skipped int
+}
+
+func newDaemon(conf Config) *daemon {
+	return &daemon{conf: conf, writer: newOutputSet(conf), started: time.Now()}
+}
+
+func (d *daemon) recordError(err error) {
+	log.Println(err)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastErrors = append(d.lastErrors, daemonError{Ts: time.Now(), Error: err.Error()})
+	if len(d.lastErrors) > maxDaemonErrors {
+		d.lastErrors = d.lastErrors[len(d.lastErrors)-maxDaemonErrors:]
+	}
+}
+
+func (d *daemon) setQueueDepth(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queueDepth = n
+}
+
+// collect runs one collection and writes its records to the configured output.
+func (d *daemon) collect() {
+	d.collectDevices(d.conf.Partitions)
+}
+
+// collectStaggered collects the configured devices one at a time, spread evenly over interval.
+func (d *daemon) collectStaggered(interval time.Duration) {
+	step := interval / time.Duration(max(len(d.conf.Partitions), 1))
+	start := time.Now()
+	for i, partition := range d.conf.Partitions {
+		time.Sleep(time.Until(start.Add(time.Duration(i) * step)))
+		d.collectDevices([]PartitionConfig{partition})
+	}
+}
+
+// collectDevices collects some of the configured devices and writes their records to the
+// configured output. The records replace those of the same devices in the daemon state.
+func (d *daemon) collectDevices(partitions []PartitionConfig) []PartitionLine {
+	return d.collectForEvent(partitions, nil)
+}
+
+// collectForEvent collects like collectDevices, the run of the records names the event that
+// triggered it if any. It collects nothing while collection is paused.
+func (d *daemon) collectForEvent(partitions []PartitionConfig, event *externalEvent) []PartitionLine {
+	d.collectMu.Lock()
+	defer d.collectMu.Unlock()
+	// Checked after waiting for a running collection, which a pause does not interrupt
+	if d.skipPaused(fmt.Sprintf("collection of %d devices", len(partitions))) {
+		return nil
+	}
+
+	conf := d.conf
+	conf.Partitions = partitions
+	start := time.Now()
+	records := collectAll(conf)
+	// All records of a run share its RunInfo
+	if event != nil && len(records) > 0 && records[0].Run != nil {
+		records[0].Run.Event, records[0].Run.EventDetail = event.Name, event.Detail
+	}
+	for _, record := range records {
+		if err := d.writer.Write(record); err != nil {
+			d.recordError(err)
+		}
+		d.setQueueDepth(d.writer.pending())
+	}
+	if err := d.writer.Flush(); err != nil {
+		d.recordError(err)
+	}
+	syncLocalStore(conf)
+	detectReplacements(records, conf)
+
+	d.mu.Lock()
+	prev, first := d.lastRecords, d.runs == 0
+	d.mu.Unlock()
+	// Without a previous collection there is no baseline, alerts would fire again on every restart
+	if d.conf.Grafana != nil && !first {
+		for _, t := range alertTransitions(prev, records) {
+			if err := annotateGrafana(t, *d.conf.Grafana); err != nil {
+				d.recordError(err)
+			}
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queueDepth = d.writer.pending()
+	d.lastSinks = d.writer.status()
+	for _, status := range d.lastSinks {
+		log.Printf("output %s\n", status)
+	}
+	writeRunSummary(d.conf.RunSummaryPath, summarizeRun(records, d.lastSinks, start))
+	d.runs++
+	d.lastRun = start
+	d.lastDuration = time.Since(start)
+	d.lastRecords = mergeRecords(d.lastRecords, records)
+	d.observeRecords(records)
+	return records
+}
+
+// mergeRecords replaces the records in prev of the devices in cur and adds new devices, keeping
+// the partition name order of collectAll.
+func mergeRecords(prev, cur []PartitionLine) []PartitionLine {
+	if len(prev) == 0 {
+		return cur
+	}
+	collected := make(map[string]bool, len(cur))
+	for _, record := range cur {
+		collected[record.PartitionName] = true
+	}
+	merged := slices.Clone(cur)
+	for _, record := range prev {
+		if !collected[record.PartitionName] {
+			merged = append(merged, record)
+		}
+	}
+	slices.SortStableFunc(merged, func(a, b PartitionLine) int { return strings.Compare(a.PartitionName, b.PartitionName) })
+	return merged
+}
+
+// handleCollect runs an immediate collection of all devices, or of the configured device given as
+// device, and returns its records.
+func (d *daemon) handleCollect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	partitions := d.conf.Partitions
+	if device := r.URL.Query().Get("device"); device != "" {
+		partition, ok := d.configuredDevice(device)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s is not a configured device", device), http.StatusNotFound)
+			return
+		}
+		partitions = []PartitionConfig{partition}
+	}
+	if d.rejectPaused(w) {
+		return
+	}
+
+	records := d.collectDevices(partitions)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(records)
+}
+
+// handleState reports runtime and collection state for diagnosing a misbehaving daemon.
+func (d *daemon) handleState(w http.ResponseWriter, _ *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	d.mu.Lock()
+	state := map[string]any{
+		"uptime_seconds":        time.Since(d.started).Seconds(),
+		"goroutines":            runtime.NumGoroutine(),
+		"heap_alloc_bytes":      mem.HeapAlloc,
+		"heap_objects":          mem.HeapObjects,
+		"num_gc":                mem.NumGC,
+		"runs":                  d.runs,
+		"last_run":              d.lastRun,
+		"last_duration_seconds": d.lastDuration.Seconds(),
+		"last_records":          len(d.lastRecords),
+		"queue_depth":           d.queueDepth,
+		"last_errors":           d.lastErrors,
+		"outputs":               d.lastSinks,
+		"readiness":             d.readiness,
+		"pause":                 d.pauseStatus(time.Now()),
+	}
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(state)
+}
+
+func (d *daemon) handler(conf ServeConfig, auth apiAuth) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/collect", auth.byMethod(d.handleCollect))
+	mux.HandleFunc("/selftest", auth.byMethod(d.handleSelfTest))
+	mux.HandleFunc("/silences", auth.byMethod(d.handleSilences))
+	mux.HandleFunc("/acknowledgements", auth.byMethod(d.handleAcknowledgements))
+	mux.HandleFunc("/events", auth.byMethod(d.handleEvent))
+	mux.HandleFunc("/pause", auth.byMethod(d.handlePause))
+	// Public like the dashboard page, so service monitors need no token
+	mux.HandleFunc("/health", d.handleHealth)
+	if !conf.DisableDashboard {
+		d.handleDashboard(mux, auth)
+	}
+	if !conf.DisableMetrics {
+		mux.HandleFunc("/metrics", auth.require(RoleReadOnly, d.handleMetrics))
+	}
+	if conf.Debug {
+		mux.HandleFunc("/debug/pprof/", auth.require(RoleOperator, pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", auth.require(RoleOperator, pprof.Cmdline))
+		mux.HandleFunc("/debug/pprof/profile", auth.require(RoleOperator, pprof.Profile))
+		mux.HandleFunc("/debug/pprof/symbol", auth.require(RoleOperator, pprof.Symbol))
+		mux.HandleFunc("/debug/pprof/trace", auth.require(RoleOperator, pprof.Trace))
+		mux.HandleFunc("/debug/state", auth.require(RoleOperator, d.handleState))
+	}
+	return mux
+}
+
+// runServe implements `gosmart serve`, which collects on an interval and serves HTTP endpoints
+// until it is stopped.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+	serveConf := ServeConfig{}
+	if conf.Serve != nil {
+		serveConf = *conf.Serve
+	}
+	if serveConf.Listen == "" {
+		serveConf.Listen = ":9633"
+	}
+	if serveConf.IntervalSeconds <= 0 {
+		serveConf.IntervalSeconds = 300
+	}
+
+	auth, err := newApiAuth(serveConf.Tokens)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// The sandboxed process inherits the priority, and may not write to cgroups
+	if os.Getenv(sandboxedEnv) == "" {
+		applyPriority(conf.Priority)
+	}
+
+	if serveConf.Sandbox != nil && os.Getenv(sandboxedEnv) == "" {
+		if *confFiPath == "-" {
+			log.Fatalln("the sandbox needs a config file, the config cannot be read from stdin again")
+		}
+		confPath := *confFiPath
+		if confPath == "" {
+			// loadConfig found the config in the standard locations, the sandboxed serve searches
+			// them again and must be able to read what it finds
+			confPath, _ = findConfig()
+		}
+		if !isRemoteConfig(confPath) {
+			confPath, _ = filepath.Abs(confPath)
+		}
+		err := enterSandbox(buildSandboxRules(conf, serveConf, confPath))
+		if serveConf.Sandbox.Required {
+			log.Fatalln(err)
+		}
+		log.Printf("running without sandbox: %s\n", err)
+	} else if serveConf.Sandbox != nil {
+		log.Println("Running in sandbox")
+	}
+
+	listener, err := net.Listen("tcp", serveConf.Listen)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if serveConf.User != "" {
+		held := holdDevices(conf)
+		if err := dropPrivileges(serveConf.User, serveConf.Group); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Running as %s with %d devices held open\n", serveConf.User, held)
+	}
+
+	d := newDaemon(conf)
+	d.readiness = checkOutputsReady(conf)
+	go func() {
+		log.Printf("Listening on %s\n", serveConf.Listen)
+		if err := http.Serve(listener, limitAndLog(d.handler(serveConf, auth), serveConf, auth)); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}()
+
+	if serveConf.HotplugSeconds > 0 {
+		go d.watchHotplug(time.Duration(serveConf.HotplugSeconds) * time.Second)
+	}
+	if serveConf.Udev != nil {
+		go d.watchUdev(*serveConf.Udev)
+	}
+	if serveConf.OfflineCollectionHours > 0 {
+		go d.offlineCollectionLoop(time.Duration(serveConf.OfflineCollectionHours) * time.Hour)
+	}
+
+	interval := time.Duration(serveConf.IntervalSeconds) * time.Second
+	if len(serveConf.DeviceIntervals) > 0 {
+		d.scheduleLoop(interval, serveConf.DeviceIntervals)
+		return
+	}
+	collect := d.collect
+	if serveConf.Stagger {
+		collect = func() { d.collectStaggered(interval) }
+	}
+	collectLoop(interval, serveConf.Align, collect)
+}
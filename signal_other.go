@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// collectSignals trigger a collection in execd mode.
+var collectSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// collectSignals trigger a collection in execd mode. Windows has no user signals, Telegraf has to
+// use signal = "STDIN".
+var collectSignals []os.Signal
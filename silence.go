@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Silence mutes the alerts of a device, or of the whole host, for a maintenance window such as a
+// planned resilver or drive replacement. Silenced records are still collected and written, but
+// count as info for min_severity and do not fire Grafana annotations.
+type Silence struct {
+	Id string `json:"id,omitempty"`
+	// Device is the silenced device path, the whole host if empty
+	Device string `json:"device,omitempty"`
+	// Start defaults to always having started
+	Start   time.Time `json:"start,omitempty"`
+	End     time.Time `json:"end"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+func (s Silence) matches(device string, ts time.Time) bool {
+	return (s.Device == "" || s.Device == device) && !ts.Before(s.Start) && ts.Before(s.End)
+}
+
+// loadSilences reads the silences created through the serve API from path, a missing file means
+// there are none.
+func loadSilences(path string) ([]Silence, error) {
+	var silences []Silence
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return silences, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return silences, json.Unmarshal(b, &silences)
+}
+
+func saveSilences(path string, silences []Silence) error {
+	b, err := json.MarshalIndent(silences, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// activeSilences returns the configured silences and those of the silences file that have not
+// ended at now.
+func activeSilences(conf Config, now time.Time) []Silence {
+	silences := slices.Clone(conf.Silences)
+	if conf.SilencesPath != "" {
+		stored, err := loadSilences(conf.SilencesPath)
+		if err != nil {
+			log.Printf("Could not read silences file %s: %s\n", conf.SilencesPath, err)
+		}
+		silences = append(silences, stored...)
+	}
+	return slices.DeleteFunc(silences, func(s Silence) bool { return !now.Before(s.End) })
+}
+
+// applySilences sets the end of the longest silence covering each record.
+func applySilences(records []PartitionLine, conf Config) []PartitionLine {
+	silences := activeSilences(conf, time.Now())
+	for i := range records {
+		record := &records[i]
+		for _, s := range silences {
+			if s.matches(record.PartitionName, record.Ts) && (record.SilencedUntil == nil || s.End.After(*record.SilencedUntil)) {
+				end := s.End
+				record.SilencedUntil = &end
+			}
+		}
+	}
+	return records
+}
+
+// handleSilences lists the silences that have not ended, creates a silence from the device,
+// duration and comment parameters, or deletes the silence given by id. Only silences of the
+// silences file can be deleted, configured silences stay until the config changes.
+func (d *daemon) handleSilences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && d.conf.SilencesPath == "" {
+		http.Error(w, "silences_path is not configured", http.StatusConflict)
+		return
+	}
+
+	d.silencesMu.Lock()
+	defer d.silencesMu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		query := r.URL.Query()
+		device := query.Get("device")
+		if device != "" {
+			if _, ok := d.configuredDevice(device); !ok {
+				http.Error(w, "device must be a configured device", http.StatusNotFound)
+				return
+			}
+		}
+		duration, err := time.ParseDuration(query.Get("duration"))
+		if err != nil || duration <= 0 {
+			http.Error(w, "duration must be a positive duration like 90m or 6h", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		silence := Silence{Id: newRunId(), Device: device, Start: now, End: now.Add(duration), Comment: query.Get("comment")}
+		if err := d.updateSilences(func(silences []Silence) []Silence { return append(silences, silence) }); err != nil {
+			d.recordError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Silenced %s until %s: %s\n", silenceTarget(silence), silence.End.Format(time.RFC3339), silence.Comment)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(silence)
+		return
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		found := false
+		err := d.updateSilences(func(silences []Silence) []Silence {
+			return slices.DeleteFunc(silences, func(s Silence) bool {
+				if s.Id == id {
+					found = true
+				}
+				return s.Id == id
+			})
+		})
+		if err != nil {
+			d.recordError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if !found {
+			http.Error(w, "no silence with this id in the silences file", http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	silences := activeSilences(d.conf, time.Now())
+	if silences == nil {
+		silences = []Silence{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(silences)
+}
+
+// updateSilences rewrites the silences file with the result of update, dropping silences that
+// have ended.
+func (d *daemon) updateSilences(update func([]Silence) []Silence) error {
+	silences, err := loadSilences(d.conf.SilencesPath)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	silences = slices.DeleteFunc(update(silences), func(s Silence) bool { return !now.Before(s.End) })
+	return saveSilences(d.conf.SilencesPath, silences)
+}
+
+func silenceTarget(s Silence) string {
+	if s.Device == "" {
+		return "all devices"
+	}
+	return s.Device
+}
+
+// writeFileAtomic replaces path with b through a temporary file in the same directory, so a crash
+// never leaves a partial file.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultSinkTimeout bounds each delivery to an output if its SinkLimit.TimeoutSeconds is zero.
+const defaultSinkTimeout = 60 * time.Second
+
+// SinkLimit throttles and batches the writes to one output.
+type SinkLimit struct {
+	// MaxPerSecond caps the records written per second, unlimited if zero
+	MaxPerSecond float64 `json:"max_per_second,omitempty"`
+	// BatchSize records are collected before they are written together, 1 if zero. Postgres
+	// inserts a batch in one statement, DuckDB in one transaction, BigQuery in one insertAll
+	// request, VictoriaMetrics in one import request and plugins in one request, other outputs
+	// still send each record on its own.
+	BatchSize int `json:"batch_size,omitempty"`
+	// FlushIntervalSeconds writes an incomplete batch once its oldest record waited this long
+	FlushIntervalSeconds int `json:"flush_interval_seconds,omitempty"`
+	// TimeoutSeconds is how long other outputs and the next collection wait for a delivery to this
+	// output, including the wait for the rate limit, 60 if zero and unbounded if negative. A
+	// delivery running longer goes on in the background, and records for the output are dropped
+	// as failed until it is done.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+func (l SinkLimit) timeout() time.Duration {
+	if l.TimeoutSeconds == 0 {
+		return defaultSinkTimeout
+	}
+	return time.Duration(l.TimeoutSeconds) * time.Second
+}
+
+// recordWriter writes records to an output within its SinkLimit. mu is held for every Write and
+// Flush, including the delivery itself.
+type recordWriter struct {
+	mu         sync.Mutex
+	outputType string
+	conf       Config
+	limit      SinkLimit
+	// minSeverity drops records of lower severity, none if empty
+	minSeverity string
+	// attributes narrows the attributes of every record written
+	attributes AttributeFilter
+	pending    []PartitionLine
+	oldest     time.Time
+	// next is the earliest time the rate limit allows the next batch to be written
+	next time.Time
+	// onFlush is called with every batch the output accepted
+	onFlush func([]PartitionLine) error
+	// status counts the records written since the last reset
+	status SinkStatus
+}
+
+// SinkStatus is the delivery summary of one output.
+type SinkStatus struct {
+	Output    string `json:"output"`
+	Delivered int    `json:"delivered"`
+	Failed    int    `json:"failed"`
+	// Filtered records were below the output's minimum severity
+	Filtered  int    `json:"filtered,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	// LastErrorClass is timeout if the last error was a database operation running out of time
+	LastErrorClass string `json:"last_error_class,omitempty"`
+}
+
+// SinkErrorTimeout is the SinkStatus.LastErrorClass of database timeouts.
+const SinkErrorTimeout = "timeout"
+
+func (s SinkStatus) String() string {
+	msg := fmt.Sprintf("%s: %d delivered, %d failed", s.Output, s.Delivered, s.Failed)
+	if s.Filtered > 0 {
+		msg += fmt.Sprintf(", %d below minimum severity", s.Filtered)
+	}
+	if s.LastError != "" {
+		msg += ", last error: " + s.LastError
+	}
+	if s.LastErrorClass != "" {
+		msg += " (" + s.LastErrorClass + ")"
+	}
+	return msg
+}
+
+func newRecordWriter(outputType string, conf Config) *recordWriter {
+	minSeverity := conf.MinSeverity[outputType]
+	if _, ok := severityRanks[minSeverity]; !ok && minSeverity != "" {
+		log.Printf("unknown minimum severity %q for the %s output, sending all records\n", minSeverity, outputType)
+		minSeverity = ""
+	}
+	return &recordWriter{outputType: outputType, conf: conf, limit: conf.SinkLimits[outputType], minSeverity: minSeverity, attributes: conf.OutputAttributes[outputType], status: SinkStatus{Output: outputType}}
+}
+
+// Write queues a record and writes the batch if it is full or has waited long enough.
+func (w *recordWriter) Write(record PartitionLine) error {
+	if w.minSeverity != "" && severityRanks[recordSeverity(record)] < severityRanks[w.minSeverity] {
+		w.status.Filtered++
+		return nil
+	}
+	if len(w.pending) == 0 {
+		w.oldest = time.Now()
+	}
+	w.pending = append(w.pending, filterAttributes(record, w.attributes))
+
+	full := len(w.pending) >= max(w.limit.BatchSize, 1)
+	stale := w.limit.FlushIntervalSeconds > 0 && time.Since(w.oldest) >= time.Duration(w.limit.FlushIntervalSeconds)*time.Second
+	if full || stale {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes all queued records, waiting for the rate limit first. Records are dropped from the
+// queue even if the output rejected them.
+func (w *recordWriter) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	batch := w.pending
+	w.pending = nil
+
+	if w.limit.MaxPerSecond > 0 {
+		time.Sleep(time.Until(w.next))
+		w.next = time.Now().Add(time.Duration(float64(len(batch)) / w.limit.MaxPerSecond * float64(time.Second)))
+	}
+
+	if err := safeWriteBatch(batch, w.outputType, w.conf); err != nil {
+		w.status.Failed += len(batch)
+		w.status.LastError = err.Error()
+		w.status.LastErrorClass = ""
+		if isTimeout(err) {
+			w.status.LastErrorClass = SinkErrorTimeout
+		}
+		return err
+	}
+	w.status.Delivered += len(batch)
+	if w.onFlush != nil {
+		return w.onFlush(batch)
+	}
+	return nil
+}
+
+// safeWriteBatch is writeBatch turning panics into errors, so a broken output cannot take the
+// others down with it.
+func safeWriteBatch(records []PartitionLine, outputType string, conf Config) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s output panicked: %v", outputType, r)
+		}
+	}()
+	return writeBatch(records, outputType, conf)
+}
+
+// writeBatch writes records to the output, in one transaction for Postgres, DuckDB and the local
+// store, in one request for BigQuery, VictoriaMetrics and plugins, in one append for the archive
+// and one at a time otherwise.
+func writeBatch(records []PartitionLine, outputType string, conf Config) error {
+	if _, ok := pluginName(outputType); ok {
+		return runPlugin(PluginWrite, records, outputType, conf)
+	}
+	if outputType == OutputBigQuery && conf.BigQuery != nil {
+		return saveToBigQuery(records, *conf.BigQuery)
+	}
+	if outputType == OutputVictoria && conf.VictoriaMetrics != nil {
+		return saveBatchToVictoriaMetrics(records, *conf.VictoriaMetrics, conf.DropMetricLabels)
+	}
+	if outputType == OutputArchive && conf.Archive != nil {
+		return saveToArchive(records, *conf.Archive, conf.TimestampFormat)
+	}
+	if (outputType == OutputPostgres && conf.Db != nil) || (outputType == OutputLocal && conf.Local != nil) || (outputType == OutputDuckdb && conf.Duckdb != nil) {
+		var supported []PartitionLine
+		for _, record := range records {
+			if savesRecord(record, conf) {
+				supported = append(supported, record)
+			} else {
+				fmt.Printf("Not saving %s without SMART data: %s\n", record.PartitionName, record.SkipReason)
+			}
+		}
+		if len(supported) == 0 {
+			return nil
+		}
+		if outputType == OutputLocal {
+			return saveToLocal(supported, *conf.Local)
+		}
+		if outputType == OutputDuckdb {
+			return saveToDuckdb(supported, *conf.Duckdb)
+		}
+		return saveBatchToPostgresDB(supported, *conf.Db)
+	}
+
+	var errs []error
+	for _, record := range records {
+		if err := writeRecord(record, outputType, conf); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// savesRecord reports whether the database outputs save record, those without SMART data only
+// with IncludeUnsupported.
+func savesRecord(record PartitionLine, conf Config) bool {
+	return record.SmartSupported || conf.IncludeUnsupported
+}
+
+// outputTypes are the outputs records are written to, OutputTypes if set and OutputType otherwise.
+func outputTypes(conf Config) []string {
+	if len(conf.OutputTypes) > 0 {
+		return conf.OutputTypes
+	}
+	return []string{conf.OutputType}
+}
+
+// outputSet writes records to every configured output. Each output has its own writer, batches
+// and limits, and is written to concurrently with the others, so an output failing or slow does
+// not keep records from the others.
+type outputSet struct {
+	writers []*recordWriter
+	mu      sync.Mutex
+	// dropped counts the records of each writer dropped while it was still busy, guarded by mu
+	dropped map[*recordWriter]int
+}
+
+func newOutputSet(conf Config) *outputSet {
+	set := &outputSet{dropped: make(map[*recordWriter]int)}
+	for _, outputType := range outputTypes(conf) {
+		set.writers = append(set.writers, newRecordWriter(outputType, conf))
+	}
+	return set
+}
+
+// Write queues the record on every output, returning the errors of those that failed.
+func (s *outputSet) Write(record PartitionLine) error {
+	return s.each(1, func(w *recordWriter) error { return w.Write(record) })
+}
+
+// Flush writes the queued records of every output, returning the errors of those that failed.
+func (s *outputSet) Flush() error {
+	return s.each(0, (*recordWriter).Flush)
+}
+
+// each runs op on every writer at once and waits for all of them, for each at most the timeout of
+// its output. A writer still busy from an earlier op that timed out is skipped, the records it was
+// given counted as dropped.
+func (s *outputSet) each(records int, op func(w *recordWriter) error) error {
+	errs := make([]error, len(s.writers))
+	var wg sync.WaitGroup
+	for i, w := range s.writers {
+		if !w.mu.TryLock() {
+			s.mu.Lock()
+			s.dropped[w] += records
+			s.mu.Unlock()
+			errs[i] = fmt.Errorf("%s output: still busy with an earlier delivery", w.outputType)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, w *recordWriter) {
+			defer wg.Done()
+			// Buffered so a delivery that timed out can still finish and release the writer
+			done := make(chan error, 1)
+			go func() {
+				defer w.mu.Unlock()
+				done <- op(w)
+			}()
+			timeout := w.limit.timeout()
+			if timeout < 0 {
+				errs[i] = <-done
+			} else {
+				timer := time.NewTimer(timeout)
+				defer timer.Stop()
+				select {
+				case errs[i] = <-done:
+				case <-timer.C:
+					errs[i] = fmt.Errorf("no answer within %s, the delivery goes on in the background", timeout)
+				}
+			}
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s output: %w", w.outputType, errs[i])
+			}
+		}(i, w)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// pending is the number of records queued over all outputs, not counting busy ones.
+func (s *outputSet) pending() int {
+	n := 0
+	for _, w := range s.writers {
+		if w.mu.TryLock() {
+			n += len(w.pending)
+			w.mu.Unlock()
+		}
+	}
+	return n
+}
+
+// status returns the delivery summary of every output since the last call, and starts over. An
+// output still busy with a delivery that timed out only reports the records dropped meanwhile,
+// the rest is reported once it is done.
+func (s *outputSet) status() []SinkStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var statuses []SinkStatus
+	for _, w := range s.writers {
+		if !w.mu.TryLock() {
+			statuses = append(statuses, SinkStatus{Output: w.outputType, Failed: s.dropped[w], LastError: "still busy with an earlier delivery", LastErrorClass: SinkErrorTimeout})
+			s.dropped[w] = 0
+			continue
+		}
+		status := w.status
+		if s.dropped[w] > 0 {
+			status.Failed += s.dropped[w]
+			status.LastError, status.LastErrorClass = "still busy with an earlier delivery", SinkErrorTimeout
+			s.dropped[w] = 0
+		}
+		w.status = SinkStatus{Output: w.outputType}
+		w.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
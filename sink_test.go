@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sinkRecords are a healthy record and one with pending sectors, which is critical.
+func sinkRecords() []PartitionLine {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	healthy := PartitionLine{PartitionName: "/dev/sda", Ts: ts, SmartSupported: true}
+	failing := PartitionLine{PartitionName: "/dev/sdb", Ts: ts, SmartSupported: true, Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 197, ValueRaw: 2}, ValueDecoded: 2}}}
+	return []PartitionLine{healthy, failing}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestOutputSetDelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		// archiveBroken puts the archive under a regular file, so it cannot be written
+		archiveBroken bool
+		minSeverity   map[string]string
+		batchSize     int
+		wantErr       bool
+		// want are the statuses of the file and archive outputs
+		want      []SinkStatus
+		fileLines int
+	}{{
+		name:      "all delivered",
+		want:      []SinkStatus{{Output: OutputFile, Delivered: 2}, {Output: OutputArchive, Delivered: 2}},
+		fileLines: 2,
+	}, {
+		name:          "one output failing",
+		archiveBroken: true,
+		wantErr:       true,
+		want:          []SinkStatus{{Output: OutputFile, Delivered: 2}, {Output: OutputArchive, Failed: 2}},
+		fileLines:     2,
+	}, {
+		name:        "minimum severity",
+		minSeverity: map[string]string{OutputFile: SeverityCritical},
+		want:        []SinkStatus{{Output: OutputFile, Delivered: 1, Filtered: 1}, {Output: OutputArchive, Delivered: 2}},
+		fileLines:   1,
+	}, {
+		name:      "batched",
+		batchSize: 2,
+		want:      []SinkStatus{{Output: OutputFile, Delivered: 2}, {Output: OutputArchive, Delivered: 2}},
+		fileLines: 2,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archiveDir := filepath.Join(dir, "archive")
+			if tt.archiveBroken {
+				if err := os.WriteFile(filepath.Join(dir, "not-a-dir"), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				archiveDir = filepath.Join(dir, "not-a-dir", "archive")
+			}
+			conf := Config{
+				OutputTypes: []string{OutputFile, OutputArchive},
+				File:        &FileConfig{Path: filepath.Join(dir, "records.jsonl")},
+				Archive:     &ArchiveConfig{Dir: archiveDir},
+				MinSeverity: tt.minSeverity,
+				SinkLimits:  map[string]SinkLimit{OutputFile: {BatchSize: tt.batchSize}, OutputArchive: {BatchSize: tt.batchSize}},
+			}
+			set := newOutputSet(conf)
+			var errs []error
+			for _, record := range sinkRecords() {
+				errs = append(errs, set.Write(record))
+			}
+			errs = append(errs, set.Flush())
+			failed := false
+			for _, err := range errs {
+				failed = failed || err != nil
+			}
+			if failed != tt.wantErr {
+				t.Errorf("errors %v, want failure %v", errs, tt.wantErr)
+			}
+			if set.pending() != 0 {
+				t.Errorf("%d records still pending after flush", set.pending())
+			}
+
+			statuses := set.status()
+			if len(statuses) != len(tt.want) {
+				t.Fatalf("statuses %+v, want %+v", statuses, tt.want)
+			}
+			for i, status := range statuses {
+				if (status.LastError != "") != (status.Failed > 0) {
+					t.Errorf("%s status %+v: last error does not match failures", status.Output, status)
+				}
+				status.LastError = ""
+				if status != tt.want[i] {
+					t.Errorf("status %+v, want %+v", status, tt.want[i])
+				}
+			}
+			if lines := countLines(t, conf.File.Path); lines != tt.fileLines {
+				t.Errorf("file output has %d lines, want %d", lines, tt.fileLines)
+			}
+
+			// status starts over
+			for _, status := range set.status() {
+				if status != (SinkStatus{Output: status.Output}) {
+					t.Errorf("status not reset: %+v", status)
+				}
+			}
+		})
+	}
+}
+
+// A batch is only written once it is full, or flushed.
+func TestRecordWriterBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	conf := Config{OutputType: OutputFile, File: &FileConfig{Path: path}, SinkLimits: map[string]SinkLimit{OutputFile: {BatchSize: 3}}}
+	set := newOutputSet(conf)
+	for _, record := range sinkRecords() {
+		if err := set.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if set.pending() != 2 || countLines(t, path) != 0 {
+		t.Fatalf("%d pending and %d written, want 2 and 0", set.pending(), countLines(t, path))
+	}
+	if err := set.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if set.pending() != 0 || countLines(t, path) != 2 {
+		t.Errorf("%d pending and %d written after flush, want 0 and 2", set.pending(), countLines(t, path))
+	}
+}
+
+func TestRecordWriterRateLimit(t *testing.T) {
+	conf := Config{OutputType: OutputFile, File: &FileConfig{Path: filepath.Join(t.TempDir(), "records.jsonl")}, SinkLimits: map[string]SinkLimit{OutputFile: {MaxPerSecond: 10}}}
+	w := newRecordWriter(OutputFile, conf)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := w.Write(sinkRecords()[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first record goes out right away, the others 100ms apart
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("3 records at 10 per second took %s", elapsed)
+	}
+}
+
+func TestSinkLimitTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		seconds int
+		want    time.Duration
+	}{{0, defaultSinkTimeout}, {5, 5 * time.Second}, {-1, -time.Second}} {
+		if got := (SinkLimit{TimeoutSeconds: tt.seconds}).timeout(); got != tt.want {
+			t.Errorf("timeout of %d seconds is %s, want %s", tt.seconds, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A file output writing to a FIFO without a reader blocks until the test opens it, so a delivery
+// outlasts its timeout for as long as the test wants.
+func TestOutputSetTimeout(t *testing.T) {
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "records.fifo")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Skipf("no FIFO: %s", err)
+	}
+	conf := Config{
+		OutputTypes: []string{OutputFile, OutputArchive},
+		File:        &FileConfig{Path: fifo},
+		Archive:     &ArchiveConfig{Dir: filepath.Join(dir, "archive")},
+		SinkLimits:  map[string]SinkLimit{OutputFile: {TimeoutSeconds: 1}},
+	}
+	set := newOutputSet(conf)
+	records := sinkRecords()
+
+	start := time.Now()
+	err := set.Write(records[0])
+	if err == nil || !strings.Contains(err.Error(), "no answer within 1s") {
+		t.Fatalf("blocked output returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("write waited %s for an output with a timeout of 1s", elapsed)
+	}
+
+	// The file output is still busy, its records are dropped while the archive goes on
+	err = set.Write(records[1])
+	if err == nil || !strings.Contains(err.Error(), "still busy") {
+		t.Errorf("busy output returned %v", err)
+	}
+	statuses := set.status()
+	if want := (SinkStatus{Output: OutputFile, Failed: 1, LastError: "still busy with an earlier delivery", LastErrorClass: SinkErrorTimeout}); statuses[0] != want {
+		t.Errorf("busy output status %+v, want %+v", statuses[0], want)
+	}
+	if want := (SinkStatus{Output: OutputArchive, Delivered: 2}); statuses[1] != want {
+		t.Errorf("archive status %+v, want %+v", statuses[1], want)
+	}
+
+	// Reading the FIFO lets the first delivery finish, which is reported by the next status
+	r, err := os.Open(fifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for set.pending() != 0 || !set.writers[0].mu.TryLock() {
+		if time.Now().After(deadline) {
+			t.Fatal("the file output did not finish its delivery")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	set.writers[0].mu.Unlock()
+	statuses = set.status()
+	if want := (SinkStatus{Output: OutputFile, Delivered: 1}); statuses[0] != want {
+		t.Errorf("finished output status %+v, want %+v", statuses[0], want)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// smartctlOutput is the subset of `smartctl -j` output used to build PartitionLine records and
+// report self-tests.
+type smartctlOutput struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String   string `json:"string"`
+			Severity string `json:"severity"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	Device struct {
+		Name     string `json:"name"`
+		Protocol string `json:"protocol"`
+	} `json:"device"`
+	// LocalTime is when smartctl ran, used when importing archived outputs
+	LocalTime struct {
+		TimeT int64 `json:"time_t"`
+	} `json:"local_time"`
+	SmartSupport *struct {
+		Available bool `json:"available"`
+		Enabled   bool `json:"enabled"`
+	} `json:"smart_support"`
+	ModelName       string `json:"model_name"`
+	SerialNumber    string `json:"serial_number"`
+	FirmwareVersion string `json:"firmware_version"`
+	// RotationRate is 0 for solid state devices and absent if the drive does not report it
+	RotationRate       *int `json:"rotation_rate"`
+	AtaSmartAttributes *struct {
+		Table []smartctlAtaAttr `json:"table"`
+	} `json:"ata_smart_attributes"`
+	AtaSctErc *struct {
+		Read  smartctlErcTimer `json:"read"`
+		Write smartctlErcTimer `json:"write"`
+	} `json:"ata_sct_erc"`
+	AtaApm *struct {
+		Enabled bool `json:"enabled"`
+		Level   int  `json:"level"`
+	} `json:"ata_apm"`
+	AtaAam *struct {
+		Enabled bool `json:"enabled"`
+		Level   int  `json:"level"`
+	} `json:"ata_aam"`
+	WriteCache *struct {
+		Enabled bool `json:"enabled"`
+	} `json:"write_cache"`
+	ReadLookahead *struct {
+		Enabled bool `json:"enabled"`
+	} `json:"read_lookahead"`
+	AtaDeviceStatistics *struct {
+		Pages []struct {
+			Number int `json:"number"`
+			Table  []struct {
+				Offset int    `json:"offset"`
+				Name   string `json:"name"`
+				Value  *int64 `json:"value"`
+				Flags  struct {
+					Valid bool `json:"valid"`
+				} `json:"flags"`
+			} `json:"table"`
+		} `json:"pages"`
+	} `json:"ata_device_statistics"`
+	AtaSmartErrorLog *struct {
+		Extended *smartctlErrorLog `json:"extended"`
+		Summary  *smartctlErrorLog `json:"summary"`
+	} `json:"ata_smart_error_log"`
+	NvmeSmartHealthInformationLog *struct {
+		CriticalWarning  uint8  `json:"critical_warning"`
+		Temperature      int    `json:"temperature"`
+		AvailableSpare   uint8  `json:"available_spare"`
+		SpareThreshold   uint8  `json:"available_spare_threshold"`
+		Sensors          []int  `json:"temperature_sensors"`
+		PercentageUsed   uint8  `json:"percentage_used"`
+		DataUnitsRead    uint64 `json:"data_units_read"`
+		DataUnitsWritten uint64 `json:"data_units_written"`
+		PowerCycles      uint64 `json:"power_cycles"`
+		PowerOnHours     uint64 `json:"power_on_hours"`
+		UnsafeShutdowns  uint64 `json:"unsafe_shutdowns"`
+		MediaErrors      uint64 `json:"media_errors"`
+		NumErrLogEntries uint64 `json:"num_err_log_entries"`
+	} `json:"nvme_smart_health_information_log"`
+	AtaSmartData *struct {
+		SelfTest struct {
+			Status struct {
+				Value            int    `json:"value"`
+				String           string `json:"string"`
+				RemainingPercent *int   `json:"remaining_percent"`
+			} `json:"status"`
+		} `json:"self_test"`
+		OfflineDataCollection *struct {
+			Status smartctlValue `json:"status"`
+		} `json:"offline_data_collection"`
+		Capabilities *struct {
+			ExecOfflineImmediateSupported bool `json:"exec_offline_immediate_supported"`
+			OfflineSurfaceScanSupported   bool `json:"offline_surface_scan_supported"`
+			SelfTestsSupported            bool `json:"self_tests_supported"`
+			ConveyanceSelfTestSupported   bool `json:"conveyance_self_test_supported"`
+			SelectiveSelfTestSupported    bool `json:"selective_self_test_supported"`
+			AttributeAutosaveEnabled      bool `json:"attribute_autosave_enabled"`
+			ErrorLoggingSupported         bool `json:"error_logging_supported"`
+			GpLoggingSupported            bool `json:"gp_logging_supported"`
+		} `json:"capabilities"`
+	} `json:"ata_smart_data"`
+	NvmeOptionalAdminCommands *struct {
+		SelfTest bool `json:"self_test"`
+	} `json:"nvme_optional_admin_commands"`
+	AtaSmartSelfTestLog *struct {
+		Standard struct {
+			Table []struct {
+				Type          smartctlValue `json:"type"`
+				Status        smartctlValue `json:"status"`
+				LifetimeHours int           `json:"lifetime_hours"`
+				// Lba of the first failure, only present for tests that failed reading
+				Lba *uint64 `json:"lba"`
+			} `json:"table"`
+		} `json:"standard"`
+	} `json:"ata_smart_self_test_log"`
+	NvmeSelfTestLog *struct {
+		CurrentOperation         smartctlValue `json:"current_self_test_operation"`
+		CurrentCompletionPercent int           `json:"current_self_test_completion_percent"`
+		Table                    []struct {
+			Code         smartctlValue `json:"self_test_code"`
+			Result       smartctlValue `json:"self_test_result"`
+			PowerOnHours int           `json:"power_on_hours"`
+		} `json:"table"`
+	} `json:"nvme_self_test_log"`
+}
+
+// smartctlValue is smartctl's representation of an enumerated value with its description.
+type smartctlValue struct {
+	Value  int    `json:"value"`
+	String string `json:"string"`
+}
+
+type smartctlAtaAttr struct {
+	Id    uint8  `json:"id"`
+	Name  string `json:"name"`
+	Value uint8  `json:"value"`
+	Worst uint8  `json:"worst"`
+	Flags struct {
+		Value uint16 `json:"value"`
+	} `json:"flags"`
+	Raw struct {
+		Value uint64 `json:"value"`
+	} `json:"raw"`
+}
+
+type smartctlErcTimer struct {
+	Enabled     bool `json:"enabled"`
+	Deciseconds int  `json:"deciseconds"`
+}
+
+// runSmartctl runs `smartctl -j <args> devName` and parses its JSON output.
+func runSmartctl(smartctlPath string, devName string, args ...string) (*smartctlOutput, error) {
+	cmdArgs := append(append([]string{"-j"}, args...), devName)
+	out, err := exec.Command(smartctlPath, cmdArgs...).Output()
+
+	// smartctl uses non-zero exit codes to report drive health as well as failures, so only give up
+	// here if it did not produce any output at all
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+
+	var result smartctlOutput
+	if jsonErr := json.Unmarshal(out, &result); jsonErr != nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not parse smartctl output: %w", jsonErr)
+	}
+
+	// Bit 0: command line did not parse, bit 1: device open failed
+	if result.Smartctl.ExitStatus&0x3 != 0 {
+		var msgs []string
+		for _, m := range result.Smartctl.Messages {
+			msgs = append(msgs, m.String)
+		}
+		return nil, fmt.Errorf("smartctl exit status %d: %s", result.Smartctl.ExitStatus, strings.Join(msgs, "; "))
+	}
+	return &result, nil
+}
+
+// ataAttrs converts smartctl's ATA attribute table into the attribute model of the smartgo backend.
+func (o *smartctlOutput) ataAttrs() map[uint8]AtaSmartAttr {
+	attrs := make(map[uint8]AtaSmartAttr)
+	if o.AtaSmartAttributes == nil {
+		return attrs
+	}
+	for _, a := range o.AtaSmartAttributes.Table {
+		attrs[a.Id] = AtaSmartAttr{
+			Id:       a.Id,
+			Flags:    a.Flags.Value,
+			Current:  a.Value,
+			Worst:    a.Worst,
+			Name:     a.Name,
+			ValueRaw: a.Raw.Value,
+		}
+	}
+	return attrs
+}
+
+// collectSmartctlDevice is the smartctl backend equivalent of collectDevice.
+func collectSmartctlDevice(devName string, line PartitionLine, conf Config) (PartitionLine, bool) {
+	out, err := runSmartctl(conf.SmartctlPath, devName, "-a")
+	if err != nil {
+		line.fail(ErrorClassRead, err, "Could not read SMART data for %s with smartctl", devName)
+		return line, false
+	}
+
+	line.Model = out.ModelName
+	line.Serial = out.SerialNumber
+	line.Firmware = out.FirmwareVersion
+	if support := out.SmartSupport; support != nil && support.Available && !support.Enabled {
+		if !enableDisabledSmart(devName, &line, conf) {
+			return line, true
+		}
+		if out, err = runSmartctl(conf.SmartctlPath, devName, "-a"); err != nil {
+			line.fail(ErrorClassRead, err, "Could not read SMART data for %s with smartctl", devName)
+			return line, false
+		}
+	}
+	if nvme := out.NvmeSmartHealthInformationLog; nvme != nil {
+		line.Attributes = make([]Attr, 0)
+		// smartctl already converts the temperature to Celsius
+		line.NvmeHealth = &NvmeHealth{
+			CriticalWarning:         nvme.CriticalWarning,
+			TemperatureC:            nvme.Temperature,
+			AvailableSpare:          nvme.AvailableSpare,
+			AvailableSpareThreshold: nvme.SpareThreshold,
+			TemperatureSensorsC:     nvme.Sensors,
+			PercentageUsed:          nvme.PercentageUsed,
+			DataUnitsRead:           nvme.DataUnitsRead,
+			DataUnitsWritten:        nvme.DataUnitsWritten,
+			PowerCycles:             nvme.PowerCycles,
+			PowerOnHours:            nvme.PowerOnHours,
+			UnsafeShutdowns:         nvme.UnsafeShutdowns,
+			MediaErrors:             nvme.MediaErrors,
+			ErrorLogEntries:         nvme.NumErrLogEntries,
+		}
+		line.MediaType = MediaSsd
+		line.SmartSupported = true
+		return line, true
+	}
+
+	// Only ATA and NVMe devices are supported, matching the smart.go backend
+	if out.AtaSmartAttributes == nil {
+		return line, false
+	}
+
+	if out.RotationRate != nil {
+		line.MediaType = MediaHdd
+		if *out.RotationRate == 0 {
+			line.MediaType = MediaSsd
+		}
+	}
+	line = buildAttributes(line, out.ataAttrs(), conf)
+	line.SmartSupported = true
+	return line, true
+}
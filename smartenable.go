@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// smartDisabledReason is the skip reason of drives that support SMART with it turned off.
+const smartDisabledReason = "SMART is supported but disabled, set enable_smart or run `gosmart smart enable`"
+
+// enableSmart issues SMART ENABLE OPERATIONS and ATTRIBUTE AUTOSAVE to devName through smartctl.
+// Both persist across power cycles.
+func enableSmart(devName string, smartctlPath string) error {
+	out, err := runSmartctl(smartctlPath, devName, "-s", "on", "-S", "on")
+	if err != nil {
+		return err
+	}
+	// Bit 1: the device could not be opened, bit 2: a command to the drive failed
+	if out.Smartctl.ExitStatus&0x6 != 0 {
+		return fmt.Errorf("%s did not accept SMART ENABLE OPERATIONS or ATTRIBUTE AUTOSAVE", devName)
+	}
+	return nil
+}
+
+// enableDisabledSmart enables SMART on a drive found with it disabled if the config allows it,
+// and otherwise says why the line has no SMART data. It returns false if the line should not be
+// read further.
+func enableDisabledSmart(devName string, line *PartitionLine, conf Config) bool {
+	if !conf.EnableSmart {
+		fmt.Printf("%s: %s\n", devName, smartDisabledReason)
+		line.SkipReason = smartDisabledReason
+		return false
+	}
+	if err := enableSmart(devName, conf.SmartctlPath); err != nil {
+		line.fail(ErrorClassRead, err, "Could not enable SMART on %s", devName)
+		return false
+	}
+	log.Printf("%s: SMART was disabled, enabled it along with attribute autosave\n", devName)
+	return true
+}
+
+// runSmartCommand implements `gosmart smart enable`, which enables SMART and attribute autosave on
+// the given devices or the disks of the configured partitions.
+func runSmartCommand(args []string) {
+	if len(args) == 0 || args[0] != "enable" {
+		fmt.Fprintf(os.Stderr, "Usage: %s smart enable [-f conf.json] [device...]\n", os.Args[0])
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("smart enable", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage+", for the devices and smartctl path")
+	_ = fs.Parse(args[1:])
+
+	devices := fs.Args()
+	smartctlPath := "smartctl"
+	if len(devices) == 0 {
+		conf, err := loadConfig(*confFiPath)
+		if err != nil {
+			panic(fmt.Sprintf("Could not read config: %s\n", err))
+		}
+		conf = applyDefaults(conf)
+		smartctlPath = conf.SmartctlPath
+		seen := make(map[string]bool)
+		for _, t := range discoverTargets(conf, time.Now()) {
+			if !seen[t.smartPath] {
+				seen[t.smartPath] = true
+				devices = append(devices, t.smartPath)
+			}
+		}
+	}
+
+	failed := 0
+	for _, devName := range devices {
+		if err := enableSmart(devName, smartctlPath); err != nil {
+			fmt.Printf("%s: %s\n", devName, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: SMART and attribute autosave enabled\n", devName)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
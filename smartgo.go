@@ -0,0 +1,171 @@
+//go:build !darwin
+
+package main
+
+import (
+	"github.com/anatol/smart.go"
+	"log"
+	"time"
+)
+
+// init registers the smartgo backend. smart.go does not build on macOS, so everything reading
+// devices through it is kept in this file and macOS reads SMART data with smartctl only.
+func init() {
+	deviceBackends[BackendSmartGo] = smartGoBackend{}
+}
+
+type smartGoBackend struct{}
+
+func (smartGoBackend) ReadDevice(devName string, line PartitionLine, conf Config) (PartitionLine, bool) {
+	return readSmartGoDevice(devName, line, conf)
+}
+
+// readSmartGoDevice reads devName with smart.go, the smartgo backend.
+func readSmartGoDevice(devName string, line PartitionLine, conf Config) (PartitionLine, bool) {
+	fabric := line.Transport != "" && line.Transport != NvmeTransportPcie
+	dev, release, err := openDevice(devName)
+	if err != nil && fabric {
+		line.fail(ErrorClassOpen, err, "could not open NVMe-oF namespace %s over %s, the target may not pass through admin commands", devName, line.Transport)
+		return line, false
+	} else if err != nil {
+		// some devices (like dmcrypt) do not support SMART interface
+		line.fail(ErrorClassOpen, err, "could not open disk %s, check sudo/administrator?", devName)
+		return line, false
+	}
+	defer release()
+
+	switch sm := dev.(type) {
+	case *smart.SataDevice:
+		identity, identifyErr := sm.Identify()
+		if identifyErr == nil {
+			line.Model = identity.ModelNumber()
+			line.Serial = identity.SerialNumber()
+			line.Firmware = identity.FirmwareRevision()
+			line.MediaType = ataMediaType(identity.RotationRate)
+			if ataSmartDisabled(identity) && !enableDisabledSmart(devName, &line, conf) {
+				return line, true
+			}
+			if conf.CollectAtaSecurity {
+				line = collectAtaSecurity(devName, line)
+			}
+		}
+
+		data, err := sm.ReadSMARTData()
+		if err != nil {
+			line.fail(ErrorClassRead, err, "Could not read Sata Disk SMART data for %s", devName)
+			return line, false
+		}
+
+		line = buildAttributes(line, smartGoAttrs(data.Attrs), conf)
+		line.SmartSupported = true
+		return line, true
+	case *smart.ScsiDevice:
+		_, _ = sm.Capacity()
+	case *smart.NVMeDevice:
+		healthLog, err := sm.ReadSMART()
+		if err != nil {
+			line.fail(ErrorClassRead, err, "Could not read NVMe SMART log for %s", devName)
+			return line, false
+		}
+
+		line.Attributes = make([]Attr, 0)
+		line.NvmeHealth = nvmeHealthFromLog(healthLog)
+		line.MediaType = MediaSsd
+
+		if controller, _, err := sm.Identify(); err == nil {
+			line.Model = controller.ModelNumber()
+			line.Serial = controller.SerialNumber()
+			line.Firmware = controller.FirmwareRev()
+			if conf.CollectNvmeExtendedLogs {
+				line = collectNvmeExtendedLogs(devName, controller.VendorID, line)
+			}
+		}
+		if conf.CollectNvmeSanitize {
+			line = collectNvmeSanitizeStatus(devName, line)
+		}
+		line.SmartSupported = true
+		return line, true
+	}
+	return line, false
+}
+
+// smartGoAttrs converts smart.go's attribute table, whose attributes have the same fields.
+func smartGoAttrs(attrs map[uint8]smart.AtaSmartAttr) map[uint8]AtaSmartAttr {
+	converted := make(map[uint8]AtaSmartAttr, len(attrs))
+	for id, attr := range attrs {
+		converted[id] = AtaSmartAttr(attr)
+	}
+	return converted
+}
+
+func nvmeHealthFromLog(log *smart.NvmeSMARTLog) *NvmeHealth {
+	// NVMe reports temperatures in Kelvin, unimplemented sensors read 0
+	var sensors []int
+	for _, sensor := range log.TempSensor {
+		if sensor != 0 {
+			sensors = append(sensors, int(sensor)-273)
+		}
+	}
+
+	return &NvmeHealth{
+		CriticalWarning:         log.CritWarning,
+		TemperatureC:            int(log.Temperature) - 273,
+		AvailableSpare:          log.AvailSpare,
+		AvailableSpareThreshold: log.SpareThresh,
+		TemperatureSensorsC:     sensors,
+		PercentageUsed:          log.PercentUsed,
+		DataUnitsRead:           log.DataUnitsRead.Val[0],
+		DataUnitsWritten:        log.DataUnitsWritten.Val[0],
+		PowerCycles:             log.PowerCycles.Val[0],
+		PowerOnHours:            log.PowerOnHours.Val[0],
+		UnsafeShutdowns:         log.UnsafeShutdowns.Val[0],
+		MediaErrors:             log.MediaErrors.Val[0],
+		ErrorLogEntries:         log.NumErrLogEntries.Val[0],
+	}
+}
+
+// ataSmartDisabled reports whether an ATA drive supports SMART (word 82 bit 0) but has it
+// disabled (word 85 bit 0).
+func ataSmartDisabled(identity *smart.AtaIdentifyDevice) bool {
+	return identity.CommandsSupported1&1 != 0 && identity.CommandsEnabled1&1 == 0
+}
+
+// heldDevices are opened before serve drops privileges and kept open for the lifetime of the
+// process, as they cannot be opened again afterwards. They are only written before collecting.
+var heldDevices = map[string]smart.Device{}
+
+// holdDevices opens the smartgo devices of the configured partitions and keeps them open, and
+// returns how many are held. Devices that cannot be opened are left to fail at collection as before.
+func holdDevices(conf Config) int {
+	for _, t := range discoverTargets(conf, time.Now()) {
+		if t.device.Backend == BackendSmartctl {
+			log.Printf("%s uses the smartctl backend, which cannot open it after dropping privileges\n", t.smartPath)
+			continue
+		}
+		if t.device.Backend != BackendSmartGo {
+			continue
+		}
+		if _, ok := heldDevices[t.smartPath]; ok {
+			continue
+		}
+		dev, err := smart.Open(t.smartPath)
+		if err != nil {
+			continue
+		}
+		heldDevices[t.smartPath] = dev
+	}
+	return len(heldDevices)
+}
+
+// openDevice opens devName, or returns it if it is held open. release closes devices that are
+// not held.
+func openDevice(devName string) (dev smart.Device, release func(), err error) {
+	if dev, ok := heldDevices[devName]; ok {
+		return dev, func() {}, nil
+	}
+	dev, err = smart.Open(devName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dev, func() { _ = dev.Close() }, nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// holdDevices has nothing to hold on macOS, where smart.go is not built. smartctl opens the
+// devices itself, which fails after dropping privileges.
+func holdDevices(conf Config) int {
+	for _, t := range discoverTargets(conf, time.Now()) {
+		log.Printf("%s uses the smartctl backend, which cannot open it after dropping privileges\n", t.smartPath)
+	}
+	return 0
+}
@@ -0,0 +1,45 @@
+//go:build !darwin
+
+package main
+
+import (
+	"github.com/anatol/smart.go"
+	"testing"
+)
+
+// The attribute type is converted from smart.go's and its temperature parser ported, both must
+// keep matching smart.go.
+func TestParseTemperatureMatchesSmartGo(t *testing.T) {
+	if ataAttrTypeTempMinMax != smart.AtaDeviceAttributeTypeTempMinMax || ataAttrTypeTemp10X != smart.AtaDeviceAttributeTypeTemp10X {
+		t.Fatalf("raw format numbers differ from smart.go")
+	}
+	raws := []uint64{
+		0,
+		0x0000_0000_0028,      // 00 00 00 00 xx TT
+		0x0000_3214_0028,      // 00 00 HL LH xx TT
+		0x0000_0032_1428,      // 00 00 00 HL LH TT
+		0x0032_0014_0028,      // xx HL xx LH xx TT
+		0x0123_3214_0028,      // CC CC HL LH xx TT
+		0x0000_0000_00f6,      // negative
+		0x0000_fff6_0028,      // implausible range
+		0x00ff_ffff_ffff_ffff, // all set
+		0x0000_0000_01c2,      // 45.0 as Temp10X
+	}
+	// Pseudo-random raw values, with the upper bytes cleared in turn to reach the layouts
+	x := uint64(1)
+	for i := 0; i < 20000; i++ {
+		x = x*6364136223846793005 + 1442695040888963407
+		raws = append(raws, (x>>16)&(0xffff_ffff_ffff>>(8*(i%5))))
+	}
+	for _, typ := range []int{ataAttrTypeTempMinMax, ataAttrTypeTemp10X, 3} {
+		for _, raw := range raws {
+			theirs := smart.AtaSmartAttr{Id: 194, Type: typ, ValueRaw: raw}
+			wantCurrent, wantLow, wantHigh, _, wantErr := theirs.ParseAsTemperature()
+			current, low, high, err := AtaSmartAttr(theirs).parseTemperature()
+			if (err != nil) != (wantErr != nil) || current != wantCurrent || low != wantLow || high != wantHigh {
+				t.Errorf("type %d raw %#x: got %d %d %d %v, smart.go %d %d %d %v",
+					typ, raw, current, low, high, err, wantCurrent, wantLow, wantHigh, wantErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The default base OID is net-snmp's playpen (NET-SNMP-MIB::netSnmpPlaypen), which is meant for
+// local extensions. GOSMART-MIB.txt describes the tree below it.
+const defaultSnmpBaseOid = ".1.3.6.1.4.1.8072.9999.9999.1"
+
+// Device status values of gosmartDeviceStatus
+const (
+	snmpStatusOk      = 1
+	snmpStatusWarning = 2
+	snmpStatusNoData  = 3
+	snmpStatusError   = 4
+)
+
+type snmpOid []int
+
+func parseSnmpOid(s string) (snmpOid, error) {
+	s = strings.Trim(strings.TrimSpace(s), ".")
+	if s == "" {
+		return snmpOid{}, nil
+	}
+	parts := strings.Split(s, ".")
+	oid := make(snmpOid, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+func (o snmpOid) String() string {
+	var b strings.Builder
+	for _, n := range o {
+		b.WriteByte('.')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+func (o snmpOid) append(n ...int) snmpOid {
+	return append(slices.Clone(o), n...)
+}
+
+// snmpVar is a variable in pass_persist form: the net-snmp type name and its value.
+type snmpVar struct {
+	Oid   snmpOid
+	Type  string
+	Value string
+}
+
+// snmpStatus maps a record to gosmartDeviceStatus.
+func snmpStatus(line PartitionLine) int {
+	switch status, _ := deviceStatus(line); status {
+	case StatusOk:
+		return snmpStatusOk
+	case StatusWarning:
+		return snmpStatusWarning
+	case StatusError:
+		return snmpStatusError
+	}
+	return snmpStatusNoData
+}
+
+// deviceTemperature returns the current drive temperature in degrees Celsius if it is known.
+func deviceTemperature(line PartitionLine) (int, bool) {
+	if line.NvmeHealth != nil {
+		return line.NvmeHealth.TemperatureC, true
+	}
+	for _, attr := range line.Attributes {
+		if attr.Temperature != nil {
+			return attr.Temperature.Current, true
+		}
+	}
+	return 0, false
+}
+
+// buildSnmpTree lays the records out as GOSMART-MIB, sorted by OID:
+//
+//	base.1.1.<column>.<device>           gosmartDeviceTable
+//	base.2.1.<column>.<device>.<attr id> gosmartAttributeTable
+func buildSnmpTree(base snmpOid, records []PartitionLine) []snmpVar {
+	var vars []snmpVar
+	add := func(oid snmpOid, typ, value string) {
+		vars = append(vars, snmpVar{Oid: oid, Type: typ, Value: value})
+	}
+
+	device := base.append(1, 1)
+	for i, line := range records {
+		idx := i + 1
+		temperature, _ := deviceTemperature(line)
+		add(device.append(1, idx), "integer", strconv.Itoa(idx))
+		add(device.append(2, idx), "string", line.PartitionName)
+		add(device.append(3, idx), "string", line.Model)
+		add(device.append(4, idx), "string", line.Firmware)
+		add(device.append(5, idx), "integer", strconv.Itoa(snmpStatus(line)))
+		add(device.append(6, idx), "integer", strconv.Itoa(temperature))
+	}
+
+	attribute := base.append(2, 1)
+	for i, line := range records {
+		for _, attr := range line.Attributes {
+			idx := []int{i + 1, int(attr.Id)}
+			add(attribute.append(append([]int{1}, idx...)...), "integer", strconv.Itoa(int(attr.Id)))
+			add(attribute.append(append([]int{2}, idx...)...), "string", attr.Name)
+			add(attribute.append(append([]int{3}, idx...)...), "integer", strconv.Itoa(int(attr.Current)))
+			add(attribute.append(append([]int{4}, idx...)...), "integer", strconv.Itoa(int(attr.Worst)))
+			add(attribute.append(append([]int{5}, idx...)...), "counter64", strconv.FormatUint(attr.ValueDecoded, 10))
+		}
+	}
+
+	slices.SortFunc(vars, func(a, b snmpVar) int { return slices.Compare(a.Oid, b.Oid) })
+	return vars
+}
+
+// snmpGet returns the variable at oid, or with next the first variable after it.
+func snmpGet(vars []snmpVar, oid snmpOid, next bool) (snmpVar, bool) {
+	i, found := slices.BinarySearchFunc(vars, oid, func(v snmpVar, o snmpOid) int { return slices.Compare(v.Oid, o) })
+	if next && found {
+		i++
+	} else if !next && !found {
+		return snmpVar{}, false
+	}
+	if i < len(vars) {
+		return vars[i], true
+	}
+	return snmpVar{}, false
+}
+
+// snmpAgent answers net-snmp pass_persist requests from the latest collected readings, collecting
+// again once they are older than refresh.
+type snmpAgent struct {
+	conf      Config
+	base      snmpOid
+	refresh   time.Duration
+	mu        sync.Mutex
+	vars      []snmpVar
+	collected time.Time
+}
+
+func (a *snmpAgent) current() []snmpVar {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.vars == nil || time.Since(a.collected) > a.refresh {
+		a.vars = buildSnmpTree(a.base, collectAll(a.conf))
+		a.collected = time.Now()
+	}
+	return a.vars
+}
+
+// serve speaks the pass_persist protocol, see snmpd.conf(5).
+func (a *snmpAgent) serve(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	w := bufio.NewWriter(out)
+	readLine := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		return strings.TrimSpace(scanner.Text()), true
+	}
+
+	for {
+		cmd, ok := readLine()
+		if !ok {
+			return scanner.Err()
+		}
+		switch strings.ToLower(cmd) {
+		case "":
+			continue
+		case "ping":
+			fmt.Fprintln(w, "PONG")
+		case "get", "getnext":
+			arg, ok := readLine()
+			if !ok {
+				return scanner.Err()
+			}
+			oid, err := parseSnmpOid(arg)
+			v, found := snmpVar{}, false
+			if err == nil {
+				v, found = snmpGet(a.current(), oid, cmd == "getnext")
+			}
+			if found {
+				fmt.Fprintf(w, "%s\n%s\n%s\n", v.Oid, v.Type, strings.ReplaceAll(v.Value, "\n", " "))
+			} else {
+				fmt.Fprintln(w, "NONE")
+			}
+		case "set":
+			// set is followed by the OID and the typed value
+			readLine()
+			readLine()
+			fmt.Fprintln(w, "not-writable")
+		default:
+			fmt.Fprintln(w, "NONE")
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// runSnmp implements `gosmart snmp`, a net-snmp pass_persist extension. Add to snmpd.conf:
+//
+//	pass_persist .1.3.6.1.4.1.8072.9999.9999.1 /usr/local/bin/gosmart snmp -f /etc/gosmart.json
+func runSnmp(args []string) {
+	fs := flag.NewFlagSet("snmp", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	baseOid := fs.String("base-oid", defaultSnmpBaseOid, "OID the pass_persist entry is registered at")
+	refresh := fs.Duration("refresh", time.Minute, "Collect again when readings are older than this")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read config: %s\n", err)
+		os.Exit(1)
+	}
+	base, err := parseSnmpOid(*baseOid)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// stdout belongs to snmpd, collection errors go to stderr instead
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	agent := &snmpAgent{conf: applyDefaults(conf), base: base, refresh: *refresh}
+	if err := agent.serve(os.Stdin, out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// AttributeSummary is the change of one attribute over a summary period.
+type AttributeSummary struct {
+	Id       uint8  `json:"id"`
+	Name     string `json:"name"`
+	Min      uint64 `json:"min"`
+	Max      uint64 `json:"max"`
+	First    uint64 `json:"first"`
+	Last     uint64 `json:"last"`
+	Readings int    `json:"readings"`
+}
+
+// Delta is the change between the first and last reading of the period.
+func (s AttributeSummary) Delta() int64 {
+	return int64(s.Last) - int64(s.First)
+}
+
+// PartitionSummary is the per attribute digest of one partition over a summary period.
+type PartitionSummary struct {
+	PartitionName string             `json:"partition_name"`
+	Attributes    []AttributeSummary `json:"attributes"`
+}
+
+// summarizeHistory folds stored readings into per partition, per attribute min/max/delta.
+// Rows must be ordered oldest first.
+func summarizeHistory(rows []historyRow) ([]PartitionSummary, error) {
+	byPartition := make(map[string]map[uint8]*AttributeSummary)
+	for _, row := range rows {
+		attrs, err := row.attributes()
+		if err != nil {
+			return nil, fmt.Errorf("%s at %s: %w", row.PartitionName, row.Ts, err)
+		}
+		summaries, ok := byPartition[row.PartitionName]
+		if !ok {
+			summaries = make(map[uint8]*AttributeSummary)
+			byPartition[row.PartitionName] = summaries
+		}
+		for _, attr := range attrs {
+			v := attr.value()
+			s, ok := summaries[attr.Id]
+			if !ok {
+				summaries[attr.Id] = &AttributeSummary{Id: attr.Id, Name: attr.Name, Min: v, Max: v, First: v, Last: v, Readings: 1}
+				continue
+			}
+			s.Min = min(s.Min, v)
+			s.Max = max(s.Max, v)
+			s.Last = v
+			s.Readings++
+		}
+	}
+
+	result := make([]PartitionSummary, 0, len(byPartition))
+	for name, summaries := range byPartition {
+		p := PartitionSummary{PartitionName: name}
+		for _, s := range summaries {
+			p.Attributes = append(p.Attributes, *s)
+		}
+		sort.Slice(p.Attributes, func(i, j int) bool { return p.Attributes[i].Id < p.Attributes[j].Id })
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].PartitionName < result[j].PartitionName })
+	return result, nil
+}
+
+func writeSummary(w io.Writer, summaries []PartitionSummary, since, until time.Time) {
+	fmt.Fprintf(w, "SMART summary %s - %s\n", since.Format(time.DateTime), until.Format(time.DateTime))
+	if len(summaries) == 0 {
+		fmt.Fprintln(w, "No readings in this period")
+	}
+	for _, p := range summaries {
+		fmt.Fprintf(w, "\n%s\n", p.PartitionName)
+		for _, s := range p.Attributes {
+			fmt.Fprintf(w, "\t%d %s min %d max %d delta %+d (%d readings)\n", s.Id, s.Name, s.Min, s.Max, s.Delta(), s.Readings)
+		}
+	}
+}
+
+// summaryPeriod maps the --period flag to a duration. Anything other than daily and weekly is
+// parsed as a Go duration.
+func summaryPeriod(period string) (time.Duration, error) {
+	switch period {
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(period)
+}
+
+// runSummary implements `gosmart summary`, which digests the readings stored in the local store or
+// database over the last period. Run it from cron and mail the output to get one digest instead of
+// raw rows.
+func runSummary(args []string) {
+	fs := flag.NewFlagSet("summary", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	period := fs.String("period", "daily", "Summary period: daily, weekly or a duration such as 12h")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	if conf.Local == nil && conf.Db == nil {
+		fmt.Fprintln(os.Stderr, "summary needs a local store or database to read history from")
+		os.Exit(1)
+	}
+	d, err := summaryPeriod(*period)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid period %q: %s\n", *period, err)
+		os.Exit(1)
+	}
+
+	source, err := openHistory(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open history: %s\n", err)
+		os.Exit(1)
+	}
+	defer source.Close()
+
+	until := time.Now()
+	since := until.Add(-d)
+	rows, err := source.historySince(since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read history: %s\n", err)
+		os.Exit(1)
+	}
+	summaries, err := summarizeHistory(rows)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not summarize history: %s\n", err)
+		os.Exit(1)
+	}
+	writeSummary(os.Stdout, summaries, since, until)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MediaSsd = "ssd"
+	MediaHdd = "hdd"
+)
+
+// ataMediaType interprets the nominal media rotation rate of ATA IDENTIFY word 217: 1 means a
+// solid state device, 0x401 and up is the RPM of a spinning disk, anything else is not reported.
+func ataMediaType(rotationRate uint16) string {
+	switch {
+	case rotationRate == 1:
+		return MediaSsd
+	case rotationRate >= 0x401 && rotationRate != 0xffff:
+		return MediaHdd
+	}
+	return ""
+}
+
+// Threshold warns when a collected attribute crosses a limit.
+type Threshold struct {
+	Attribute uint8 `json:"attribute"`
+	// MaxValue is the highest acceptable decoded raw value, e.g. 0 for pending sectors
+	MaxValue *uint64 `json:"max_value,omitempty"`
+	// MinCurrent is the lowest acceptable normalized value, e.g. for SSD wear levelling counts
+	MinCurrent *uint8 `json:"min_current,omitempty"`
+	// ClearValue and ClearCurrent add hysteresis: once MaxValue or MinCurrent is crossed, the
+	// warning stays until the value is back at or below ClearValue, or the normalized value at or
+	// above ClearCurrent, e.g. warn above 45°C and clear at 40°C. Without them the warning clears
+	// as soon as the limit is no longer crossed. They need the earlier collections, which only
+	// serve, --interval and --execd keep, single runs reject them.
+	ClearValue   *uint64 `json:"clear_value,omitempty"`
+	ClearCurrent *uint8  `json:"clear_current,omitempty"`
+	// ForRuns and ForMinutes only warn once the limit has been crossed in this many consecutive
+	// collections, and for this long since the first of them, so a transient blip such as a
+	// pending sector that is remapped right away does not alert. Like clear levels they need the
+	// earlier collections, single runs reject them.
+	ForRuns    int `json:"for_runs,omitempty"`
+	ForMinutes int `json:"for_minutes,omitempty"`
+}
+
+// ThresholdTemplate applies its thresholds to every drive matching all of its non-empty conditions,
+// e.g. stricter pending sector limits for one SMR model family or wear limits for all SSDs.
+// Thresholds only see the attributes that are collected.
+type ThresholdTemplate struct {
+	Name        string      `json:"name"`
+	ModelPrefix string      `json:"model_prefix,omitempty"`
+	MediaType   string      `json:"media_type,omitempty"`
+	Thresholds  []Threshold `json:"thresholds"`
+}
+
+func (t ThresholdTemplate) matches(line PartitionLine) bool {
+	if t.ModelPrefix != "" && !strings.HasPrefix(line.Model, t.ModelPrefix) {
+		return false
+	}
+	return t.MediaType == "" || t.MediaType == line.MediaType
+}
+
+// thresholdState is the state of a threshold on one device between collections.
+type thresholdState struct {
+	// breachSince and breachRuns count the consecutive collections the limit was crossed in
+	breachSince time.Time
+	breachRuns  int
+	triggered   bool
+}
+
+// thresholdStates are keyed by thresholdKey. They are kept in memory, so single runs, which start
+// without them, reject the thresholds that need them, see statefulThresholds, and other commands
+// collecting once warn about them.
+var (
+	thresholdStates   = map[string]thresholdState{}
+	thresholdStatesMu sync.Mutex
+)
+
+// statefulThresholds lists the thresholds of templates that need the state of earlier
+// collections, those with a clear level or needing a sustained breach.
+func statefulThresholds(templates []ThresholdTemplate) []string {
+	var names []string
+	for _, template := range templates {
+		for _, threshold := range template.Thresholds {
+			if threshold.ClearValue != nil || threshold.ClearCurrent != nil || threshold.ForRuns > 1 || threshold.ForMinutes > 0 {
+				names = append(names, fmt.Sprintf("%s attribute %d", template.Name, threshold.Attribute))
+			}
+		}
+	}
+	return names
+}
+
+// warnStatefulThresholds warns that command collects once, without the state of earlier
+// collections, so the thresholds listed by statefulThresholds do not behave as in serve.
+func warnStatefulThresholds(conf Config, command string) {
+	if stateful := statefulThresholds(conf.ThresholdTemplates); len(stateful) > 0 {
+		log.Printf("Thresholds %s need earlier collections, which %s does not keep: their clear levels are ignored and for_runs or for_minutes never trigger\n", strings.Join(stateful, ", "), command)
+	}
+}
+
+func thresholdKey(device, template string, attribute uint8, limit string) string {
+	return fmt.Sprintf("%s/%s/%d/%s", device, template, attribute, limit)
+}
+
+// evaluateThreshold updates the state of the threshold keyed by key with a reading at ts, given
+// whether its limit is crossed and whether its clear level is reached. The threshold is triggered
+// once the limit has been crossed for long enough, and without a clear level only while the
+// breach lasts.
+func evaluateThreshold(key string, threshold Threshold, ts time.Time, crossed, hasClear, cleared bool) thresholdState {
+	thresholdStatesMu.Lock()
+	defer thresholdStatesMu.Unlock()
+	state := thresholdStates[key]
+	if !crossed {
+		state.breachRuns, state.breachSince = 0, time.Time{}
+	} else if state.breachRuns++; state.breachRuns == 1 {
+		state.breachSince = ts
+	}
+	sustained := crossed && state.breachRuns >= threshold.ForRuns && ts.Sub(state.breachSince) >= time.Duration(threshold.ForMinutes)*time.Minute
+	state.triggered = sustained || (hasClear && state.triggered && !cleared)
+	if state == (thresholdState{}) {
+		delete(thresholdStates, key)
+	} else {
+		thresholdStates[key] = state
+	}
+	return state
+}
+
+// breachDuration describes how long a breach has lasted, for thresholds that need a sustained
+// one. A threshold held by its clear level may be crossed again for less.
+func breachDuration(threshold Threshold, state thresholdState, ts time.Time) string {
+	if threshold.ForRuns <= 1 && threshold.ForMinutes <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" for %d runs over %s", state.breachRuns, ts.Sub(state.breachSince).Round(time.Second))
+}
+
+// applyThresholdTemplates adds a warning for every threshold of a matching template that is
+// crossed for long enough, or was and has not reached its clear level yet.
+func applyThresholdTemplates(line PartitionLine, templates []ThresholdTemplate) PartitionLine {
+	for _, template := range templates {
+		if !template.matches(line) {
+			continue
+		}
+		for _, threshold := range template.Thresholds {
+			for _, attr := range line.Attributes {
+				if attr.Id != threshold.Attribute {
+					continue
+				}
+				if limit := threshold.MaxValue; limit != nil {
+					crossed := attr.ValueDecoded > *limit
+					clearAt := threshold.ClearValue
+					key := thresholdKey(line.PartitionName, template.Name, attr.Id, "max")
+					state := evaluateThreshold(key, threshold, line.Ts, crossed, clearAt != nil, clearAt != nil && attr.ValueDecoded <= *clearAt)
+					if state.triggered {
+						if crossed {
+							line.warn("%s: %d %s is %d, above %d%s", template.Name, attr.Id, attr.Name, attr.ValueDecoded, *limit, breachDuration(threshold, state, line.Ts))
+						} else {
+							line.warn("%s: %d %s is %d, not yet back to %d after going above %d", template.Name, attr.Id, attr.Name, attr.ValueDecoded, *clearAt, *limit)
+						}
+					}
+				}
+				if limit := threshold.MinCurrent; limit != nil {
+					crossed := attr.Current < *limit
+					clearAt := threshold.ClearCurrent
+					key := thresholdKey(line.PartitionName, template.Name, attr.Id, "min")
+					state := evaluateThreshold(key, threshold, line.Ts, crossed, clearAt != nil, clearAt != nil && attr.Current >= *clearAt)
+					if state.triggered {
+						if crossed {
+							line.warn("%s: %d %s normalized value is %d, below %d%s", template.Name, attr.Id, attr.Name, attr.Current, *limit, breachDuration(threshold, state, line.Ts))
+						} else {
+							line.warn("%s: %d %s normalized value is %d, not yet back to %d after going below %d", template.Name, attr.Id, attr.Name, attr.Current, *clearAt, *limit)
+						}
+					}
+				}
+			}
+		}
+	}
+	return line
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestAtaMediaType(t *testing.T) {
+	for rate, want := range map[uint16]string{0: "", 1: MediaSsd, 2: "", 0x400: "", 0x401: MediaHdd, 7200: MediaHdd, 0xffff: ""} {
+		if got := ataMediaType(rate); got != want {
+			t.Errorf("rotation rate %#x is %q, want %q", rate, got, want)
+		}
+	}
+}
+
+func TestThresholdTemplateMatches(t *testing.T) {
+	line := PartitionLine{Model: "ST8000DM004-2CX188", MediaType: MediaHdd}
+	for _, tt := range []struct {
+		template ThresholdTemplate
+		want     bool
+	}{
+		{ThresholdTemplate{}, true},
+		{ThresholdTemplate{ModelPrefix: "ST8000DM004"}, true},
+		{ThresholdTemplate{ModelPrefix: "WDC"}, false},
+		{ThresholdTemplate{MediaType: MediaHdd}, true},
+		{ThresholdTemplate{MediaType: MediaSsd}, false},
+		{ThresholdTemplate{ModelPrefix: "ST8000DM004", MediaType: MediaSsd}, false},
+	} {
+		if got := tt.template.matches(line); got != tt.want {
+			t.Errorf("%+v matches %v, want %v", tt.template, got, tt.want)
+		}
+	}
+}
+
+// thresholdCase is a threshold and the warnings of consecutive collections of one attribute.
+type thresholdCase struct {
+	name      string
+	threshold Threshold
+	// readings are the raw value and the normalized value of the attribute in consecutive
+	// collections five minutes apart
+	readings [][2]uint64
+	// want is the start of the warning of each collection, empty for none
+	want []string
+}
+
+func checkThresholdCases(t *testing.T, tests []thresholdCase) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// States are kept by device, so every case has its own
+			device := "/dev/" + tt.name
+			templates := []ThresholdTemplate{{Name: "t", Thresholds: []Threshold{tt.threshold}}}
+			for i, reading := range tt.readings {
+				line := PartitionLine{PartitionName: device, Ts: start.Add(time.Duration(i) * 5 * time.Minute), Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 194, Name: "Temperature", Current: uint8(reading[1])}, ValueDecoded: reading[0]}}}
+				line = applyThresholdTemplates(line, templates)
+				switch {
+				case tt.want[i] == "" && len(line.Warnings) > 0:
+					t.Errorf("collection %d warned %q", i, line.Warnings)
+				case tt.want[i] != "" && (len(line.Warnings) != 1 || !strings.HasPrefix(line.Warnings[0], tt.want[i])):
+					t.Errorf("collection %d warned %q, want %q", i, line.Warnings, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyThresholdTemplates(t *testing.T) {
+	checkThresholdCases(t, []thresholdCase{{
+		name:      "max value",
+		threshold: Threshold{Attribute: 194, MaxValue: ptr[uint64](45)},
+		readings:  [][2]uint64{{40, 100}, {46, 100}, {44, 100}},
+		want:      []string{"", "t: 194 Temperature is 46, above 45", ""},
+	}, {
+		name:      "min current",
+		threshold: Threshold{Attribute: 194, MinCurrent: ptr[uint8](10)},
+		readings:  [][2]uint64{{0, 15}, {0, 5}, {0, 15}},
+		want:      []string{"", "t: 194 Temperature normalized value is 5, below 10", ""},
+	}})
+}
+
+// A threshold with a clear level keeps warning until the value is back past it.
+func TestThresholdClearLevels(t *testing.T) {
+	checkThresholdCases(t, []thresholdCase{{
+		name:      "max value with clear level",
+		threshold: Threshold{Attribute: 194, MaxValue: ptr[uint64](45), ClearValue: ptr[uint64](40)},
+		readings:  [][2]uint64{{46, 100}, {43, 100}, {46, 100}, {40, 100}, {43, 100}},
+		want:      []string{"t: 194 Temperature is 46, above 45", "t: 194 Temperature is 43, not yet back to 40 after going above 45", "t: 194 Temperature is 46, above 45", "", ""},
+	}, {
+		name:      "min current with clear level",
+		threshold: Threshold{Attribute: 194, MinCurrent: ptr[uint8](10), ClearCurrent: ptr[uint8](20)},
+		readings:  [][2]uint64{{0, 15}, {0, 5}, {0, 15}, {0, 25}},
+		want:      []string{"", "t: 194 Temperature normalized value is 5, below 10", "t: 194 Temperature normalized value is 15, not yet back to 20 after going below 10", ""},
+	}})
+}
+
+// A threshold with for_runs or for_minutes only warns once the breach lasted that long.
+func TestThresholdSustainedBreach(t *testing.T) {
+	checkThresholdCases(t, []thresholdCase{{
+		name:      "for runs",
+		threshold: Threshold{Attribute: 194, MaxValue: ptr[uint64](0), ForRuns: 3},
+		readings:  [][2]uint64{{1, 100}, {1, 100}, {1, 100}, {0, 100}, {1, 100}, {1, 100}},
+		want:      []string{"", "", "t: 194 Temperature is 1, above 0 for 3 runs over 10m0s", "", "", ""},
+	}, {
+		name:      "for minutes",
+		threshold: Threshold{Attribute: 194, MaxValue: ptr[uint64](0), ForMinutes: 12},
+		readings:  [][2]uint64{{1, 100}, {1, 100}, {1, 100}, {1, 100}},
+		want:      []string{"", "", "", "t: 194 Temperature is 1, above 0 for 4 runs over 15m0s"},
+	}, {
+		name:      "for runs with clear level",
+		threshold: Threshold{Attribute: 194, MaxValue: ptr[uint64](45), ClearValue: ptr[uint64](40), ForRuns: 2},
+		readings:  [][2]uint64{{46, 100}, {46, 100}, {43, 100}, {46, 100}, {39, 100}},
+		want:      []string{"", "t: 194 Temperature is 46, above 45 for 2 runs over 5m0s", "t: 194 Temperature is 43, not yet back to 40", "t: 194 Temperature is 46, above 45 for 1 runs over 0s", ""},
+	}})
+}
+
+func TestStatefulThresholds(t *testing.T) {
+	templates := []ThresholdTemplate{
+		{Name: "temperature", Thresholds: []Threshold{{Attribute: 194, MaxValue: ptr[uint64](45), ClearValue: ptr[uint64](40)}}},
+		{Name: "wear", Thresholds: []Threshold{{Attribute: 177, MinCurrent: ptr[uint8](10)}, {Attribute: 231, MinCurrent: ptr[uint8](10), ClearCurrent: ptr[uint8](20)}}},
+		{Name: "pending", Thresholds: []Threshold{{Attribute: 197, MaxValue: ptr[uint64](0)}, {Attribute: 5, MaxValue: ptr[uint64](0), ForRuns: 1}, {Attribute: 198, MaxValue: ptr[uint64](0), ForRuns: 3}}},
+		{Name: "reallocated", Thresholds: []Threshold{{Attribute: 5, MaxValue: ptr[uint64](10), ForMinutes: 60}}},
+	}
+	want := []string{"temperature attribute 194", "wear attribute 231", "pending attribute 198", "reallocated attribute 5"}
+	if got := statefulThresholds(templates); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// captureLog returns what is logged until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestWarnStatefulThresholds(t *testing.T) {
+	logged := captureLog(t)
+	conf := Config{ThresholdTemplates: []ThresholdTemplate{{Name: "temperature", Thresholds: []Threshold{{Attribute: 194, MaxValue: ptr[uint64](45)}}}}}
+	warnStatefulThresholds(conf, "inventory")
+	if logged.Len() > 0 {
+		t.Errorf("warned about thresholds without state: %s", logged)
+	}
+	conf.ThresholdTemplates[0].Thresholds[0].ClearValue = ptr[uint64](40)
+	warnStatefulThresholds(conf, "inventory")
+	if !strings.Contains(logged.String(), "temperature attribute 194") || !strings.Contains(logged.String(), "inventory does not keep") {
+		t.Errorf("clear level not warned about: %q", logged)
+	}
+}
+
+// A report collects once, so a sustained breach is never reached and the report says so.
+func TestReportWarnsSustainedBreach(t *testing.T) {
+	dir := t.TempDir()
+	conf := Config{
+		Discovery:          DiscoveryDirect,
+		Partitions:         []PartitionConfig{{Path: "/dev/fa", Backend: BackendFake}},
+		Attributes:         []uint8{197},
+		FakeDevices:        map[string]FakeDevice{"/dev/fa": {Model: "OLD HDD", Serial: "A", MediaType: MediaHdd, Attributes: []FakeAttribute{{Id: 197, Current: 100, Worst: 100, Raw: 2}}}},
+		ThresholdTemplates: []ThresholdTemplate{{Name: "pending", Thresholds: []Threshold{{Attribute: 197, MaxValue: ptr[uint64](0), ForRuns: 2}}}},
+	}
+	b, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confPath := filepath.Join(dir, "conf.json")
+	if err := os.WriteFile(confPath, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := captureLog(t)
+	out := filepath.Join(dir, "report.md")
+	runReport([]string{"-f", confPath, "-format", ReportMarkdown, "-o", out})
+	if !strings.Contains(logged.String(), "pending attribute 197") || !strings.Contains(logged.String(), "report does not keep") {
+		t.Errorf("sustained breach not warned about: %q", logged)
+	}
+	if report, err := os.ReadFile(out); err != nil || !strings.Contains(string(report), "/dev/fa") {
+		t.Errorf("report of /dev/fa not written: %v", err)
+	}
+}
+
+// A device that is not crossing any limit keeps no state.
+func TestThresholdStateForgotten(t *testing.T) {
+	key := thresholdKey("/dev/forgotten", "t", 5, "max")
+	threshold := Threshold{ForRuns: 2}
+	now := time.Now()
+	evaluateThreshold(key, threshold, now, true, false, false)
+	evaluateThreshold(key, threshold, now.Add(time.Minute), false, false, false)
+	thresholdStatesMu.Lock()
+	defer thresholdStatesMu.Unlock()
+	if state, ok := thresholdStates[key]; ok {
+		t.Errorf("state %+v kept after the breach ended", state)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	TimestampLocal = "local"
+	TimestampUtc   = "utc"
+	TimestampUnix  = "unix"
+)
+
+// formatTimestamp renders a record timestamp as configured: RFC3339 in local time (the default),
+// RFC3339 in UTC, or Unix epoch seconds.
+func formatTimestamp(ts time.Time, format string) (any, error) {
+	switch format {
+	case "", TimestampLocal:
+		return ts.Local().Format(time.RFC3339Nano), nil
+	case TimestampUtc:
+		return ts.UTC().Format(time.RFC3339Nano), nil
+	case TimestampUnix:
+		return ts.Unix(), nil
+	}
+	return nil, fmt.Errorf("unknown timestamp format %q", format)
+}
+
+// timestampedLine replaces the ts of a record with its rendered form, the outer field shadows the
+// embedded one when marshalling.
+type timestampedLine struct {
+	PartitionLine
+	Ts any `json:"ts"`
+}
+
+// renderRecord prepares a record for marshalling with the configured timestamp format and the
+// current schema version.
+func renderRecord(record PartitionLine, format string) (any, error) {
+	ts, err := formatTimestamp(record.Ts, format)
+	if err != nil {
+		return nil, err
+	}
+	record.SchemaVersion = recordSchemaVersion
+	return timestampedLine{PartitionLine: record, Ts: ts}, nil
+}
+
+// marshalRecord encodes a record as JSON with the configured timestamp format and indentation.
+func marshalRecord(record PartitionLine, conf Config) ([]byte, error) {
+	v, err := renderRecord(record, conf.TimestampFormat)
+	if err != nil {
+		return nil, err
+	}
+	if conf.JsonIndent != "" {
+		return json.MarshalIndent(v, "", conf.JsonIndent)
+	}
+	return json.Marshal(v)
+}
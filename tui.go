@@ -0,0 +1,345 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// tuiKey is a key press the dashboard reacts to.
+type tuiKey int
+
+const (
+	keyNone tuiKey = iota
+	keyUp
+	keyDown
+	keyEnter
+	keyBack
+	keyRefresh
+	keyQuit
+)
+
+// parseTuiKey decodes a read from the raw terminal, arrow keys arrive as escape sequences.
+func parseTuiKey(b []byte) tuiKey {
+	switch s := string(b); s {
+	case "\x1b[A", "\x1bOA", "k":
+		return keyUp
+	case "\x1b[B", "\x1bOB", "j":
+		return keyDown
+	case "\r", "\n", "l", "\x1b[C":
+		return keyEnter
+	case "\x1b", "\x7f", "h", "\x1b[D":
+		return keyBack
+	case "r":
+		return keyRefresh
+	case "q", "\x03":
+		return keyQuit
+	}
+	return keyNone
+}
+
+// tuiState is what the dashboard shows: the device list, or the details of the selected device
+// when detail is set.
+type tuiState struct {
+	records    []PartitionLine
+	histories  map[string]map[uint8][]float64
+	collected  time.Time
+	collecting bool
+	cursor     int
+	detail     bool
+	scroll     int
+	format     numberFormat
+}
+
+var tuiStatusColors = map[string]string{StatusOk: "32", StatusWarning: "31", StatusError: "35", StatusNoData: "2"}
+
+// tuiKeyAttributes summarizes the failure predicting values of a device for the list view.
+func tuiKeyAttributes(line PartitionLine, f numberFormat) string {
+	if h := line.NvmeHealth; h != nil {
+		return fmt.Sprintf("spare %d%%, used %d%%, media errors %s", h.AvailableSpare, h.PercentageUsed, f.uint(h.MediaErrors))
+	}
+	var parts []string
+	for _, attr := range line.Attributes {
+		for _, id := range failureAttributes {
+			if attr.Id == id {
+				parts = append(parts, fmt.Sprintf("%d=%s", attr.Id, f.uint(attr.ValueDecoded)))
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *tuiState) listLines() []string {
+	lines := []string{fmt.Sprintf("%-18s %-24s %-8s %5s  %s", "DEVICE", "MODEL", "STATUS", "TEMP", "KEY ATTRIBUTES")}
+	for i, line := range s.records {
+		status, _ := deviceStatus(line)
+		temperature := "-"
+		if t, ok := deviceTemperature(line); ok {
+			temperature = s.format.temperature(t) + s.format.temperatureUnit()
+		}
+		row := fmt.Sprintf("%-18s %-24s \x1b[%sm%-8s\x1b[0m %5s  %s", line.PartitionName, line.Model, tuiStatusColors[status], status, temperature, tuiKeyAttributes(line, s.format))
+		if i == s.cursor {
+			row = "\x1b[7m" + strings.ReplaceAll(row, "\x1b[0m", "\x1b[0;7m") + "\x1b[0m"
+		}
+		lines = append(lines, row)
+	}
+	if len(s.records) == 0 && !s.collecting {
+		lines = append(lines, "No devices found")
+	}
+	return lines
+}
+
+func (s *tuiState) detailLines() []string {
+	line, f := s.records[s.cursor], s.format
+	status, reasons := deviceStatus(line)
+	lines := []string{
+		line.PartitionName,
+		fmt.Sprintf("Model: %s, firmware %s, %s", line.Model, line.Firmware, line.MediaType),
+		fmt.Sprintf("Status: \x1b[%sm%s\x1b[0m", tuiStatusColors[status], status),
+	}
+	for _, reason := range reasons {
+		lines = append(lines, "  "+reason)
+	}
+	for _, advisory := range line.Advisories {
+		lines = append(lines, "Advisory: "+advisory)
+	}
+	for _, prediction := range line.Predictions {
+		lines = append(lines, fmt.Sprintf("Prediction: %s", prediction))
+	}
+	if line.Backblaze != nil {
+		lines = append(lines, fmt.Sprintf("Backblaze: %s", line.Backblaze))
+	}
+	if h := line.NvmeHealth; h != nil {
+		lines = append(lines,
+			fmt.Sprintf("NVMe %s: temperature %s %s %s", line.Transport, f.temperature(h.TemperatureC), f.temperatureUnit(), f.temperatures(h.TemperatureSensorsC)),
+			fmt.Sprintf("  spare %d%% (threshold %d%%), used %d%%", h.AvailableSpare, h.AvailableSpareThreshold, h.PercentageUsed),
+			fmt.Sprintf("  media errors %s, critical warning %#x", f.uint(h.MediaErrors), h.CriticalWarning))
+	}
+	if line.PowerSettings != nil {
+		lines = append(lines, fmt.Sprintf("Power: %s", line.PowerSettings))
+	}
+	if line.AtaSecurity != nil {
+		lines = append(lines, fmt.Sprintf("Security: %s", line.AtaSecurity))
+	}
+	if line.Capabilities != nil {
+		lines = append(lines, fmt.Sprintf("Capabilities: %s", line.Capabilities))
+	}
+	for _, stat := range line.DeviceStatistics {
+		lines = append(lines, fmt.Sprintf("%s: %s", stat.Name, f.int(stat.Value)))
+	}
+	if errorLog := line.AtaErrorLog; errorLog != nil {
+		lines = append(lines, fmt.Sprintf("Error log: %s errors", f.int(int64(errorLog.Count))))
+		for _, entry := range errorLog.Entries {
+			lines = append(lines, "  "+entry.String())
+		}
+	}
+	if len(line.FailedLbas) > 0 {
+		lines = append(lines, fmt.Sprintf("Failed LBAs: %s", formatLbas(line.FailedLbas)))
+	}
+	if line.Filesystem != nil {
+		lines = append(lines, "Filesystem: "+line.Filesystem.format(f))
+	}
+	if line.DiskStats != nil {
+		lines = append(lines, "I/O: "+line.DiskStats.format(f))
+	}
+	if len(line.Attributes) > 0 {
+		lines = append(lines, "", fmt.Sprintf("%3s %-28s %7s %5s %20s %20s %-8s %s", "ID", "NAME", "CURRENT", "WORST", "RAW", "DECODED", "UNIT", "TREND"))
+	}
+	history := s.histories[line.PartitionName]
+	for _, attr := range line.Attributes {
+		lines = append(lines, fmt.Sprintf("%3d %-28s %7d %5d %20s %20s %-8s %s", attr.Id, attr.Name, attr.Current, attr.Worst, f.uint(attr.ValueRaw), f.uint(attr.ValueDecoded), attr.Unit, attributeTrend(history, attr)))
+	}
+	for _, attr := range line.NvmeVendorSmart {
+		lines = append(lines, fmt.Sprintf("    %-28s %7d %5s %20s", attr.Name, attr.Normalized, "", f.uint(attr.Raw)))
+	}
+	return lines
+}
+
+// draw renders the current view to fit a width x height terminal.
+func (s *tuiState) draw(out io.Writer, width, height int) {
+	header := fmt.Sprintf("gosmart: %d devices", len(s.records))
+	if !s.collected.IsZero() {
+		header += ", collected " + s.collected.Format("15:04:05")
+	}
+	if s.collecting {
+		header += ", collecting..."
+	}
+	footer := "up/down select  enter details  r refresh  q quit"
+	body := s.listLines()
+	if s.detail {
+		footer = "up/down scroll  esc back  r refresh  q quit"
+		body = s.detailLines()
+	}
+
+	rows := max(height-3, 1)
+	if !s.detail {
+		// keep the selected row, which follows the column header, on screen
+		if row := s.cursor + 1; s.cursor == 0 {
+			s.scroll = 0
+		} else if row < s.scroll {
+			s.scroll = row
+		} else if row >= s.scroll+rows {
+			s.scroll = row - rows + 1
+		}
+	}
+	s.scroll = max(min(s.scroll, len(body)-rows), 0)
+	body = body[s.scroll:min(s.scroll+rows, len(body))]
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	for _, l := range append([]string{"\x1b[1m" + header + "\x1b[0m", ""}, body...) {
+		b.WriteString(tuiTruncate(l, width) + "\r\n")
+	}
+	fmt.Fprintf(&b, "\x1b[%d;1H\x1b[2m%s\x1b[0m", height, tuiTruncate(footer, width))
+	_, _ = io.WriteString(out, b.String())
+}
+
+// tuiTruncate cuts a line to width visible characters, leaving escape sequences intact.
+func tuiTruncate(s string, width int) string {
+	var b strings.Builder
+	visible := 0
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case r == '\x1b':
+			inEscape = true
+		case inEscape:
+			inEscape = r < '@' || r > '~' || r == '['
+		default:
+			if visible >= width {
+				continue
+			}
+			visible++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// runTui shows a live dashboard of the configured devices, collected every interval.
+func runTui(args []string) {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	confFiPath := fs.String("f", "", configFlagUsage)
+	interval := fs.Duration("interval", 30*time.Second, "Collection interval")
+	_ = fs.Parse(args)
+
+	conf, err := loadConfig(*confFiPath)
+	if err != nil {
+		panic(fmt.Sprintf("Could not read config: %s\n", err))
+	}
+	conf = applyDefaults(conf)
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		fmt.Fprintln(os.Stderr, "tui needs an interactive terminal")
+		os.Exit(2)
+	}
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// The screen is ours, collection messages would scroll it; errors show up in the records
+	screen := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	log.SetOutput(io.Discard)
+	_, _ = io.WriteString(screen, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		_, _ = io.WriteString(screen, "\x1b[?25h\x1b[?1049l")
+		_ = term.Restore(fd, oldState)
+		os.Stdout = screen
+	}()
+
+	keys := make(chan tuiKey)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- parseTuiKey(buf[:n])
+		}
+	}()
+
+	type tuiResult struct {
+		records   []PartitionLine
+		histories map[string]map[uint8][]float64
+	}
+	results := make(chan tuiResult, 1)
+	state := &tuiState{format: newNumberFormat(conf.Display)}
+	collect := func() {
+		if state.collecting {
+			return
+		}
+		state.collecting = true
+		// Threshold state is kept across refreshes, as in serve, so all thresholds apply
+		go func() {
+			records := collectAll(conf)
+			results <- tuiResult{records, loadHistories(conf, records, sparklineReadings(conf))}
+		}()
+	}
+	collect()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		width, height, err := term.GetSize(int(screen.Fd()))
+		if err != nil || width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+		state.draw(screen, width, height)
+
+		select {
+		case result := <-results:
+			records := result.records
+			state.records = records
+			state.histories = result.histories
+			state.collected = time.Now()
+			state.collecting = false
+			state.cursor = min(state.cursor, max(len(records)-1, 0))
+			if len(records) == 0 {
+				state.detail = false
+			}
+		case <-ticker.C:
+			collect()
+		case key, ok := <-keys:
+			if !ok || key == keyQuit {
+				return
+			}
+			switch key {
+			case keyUp:
+				if state.detail {
+					state.scroll--
+				} else {
+					state.cursor = max(state.cursor-1, 0)
+				}
+			case keyDown:
+				if state.detail {
+					state.scroll++
+				} else {
+					state.cursor = min(state.cursor+1, max(len(state.records)-1, 0))
+				}
+			case keyEnter:
+				if !state.detail && len(state.records) > 0 {
+					state.detail = true
+					state.scroll = 0
+				}
+			case keyBack:
+				if state.detail {
+					state.detail = false
+					state.scroll = 0
+				}
+			case keyRefresh:
+				collect()
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// UdevConfig collects block devices as soon as the kernel reports them attached, on Linux.
+type UdevConfig struct {
+	// Allow lists glob patterns of device paths, e.g. /dev/sd?, that are collected when attached
+	// even though they are not configured. Configured devices are always collected when attached.
+	Allow []string `json:"allow,omitempty"`
+	// UsbOnly ignores devices that are not attached over USB
+	UsbOnly bool `json:"usb_only,omitempty"`
+}
+
+// udevDevice returns the device of a block device uevent if it is configured or allowed.
+func (d *daemon) udevDevice(conf UdevConfig, props map[string]string) (PartitionConfig, bool) {
+	if props["SUBSYSTEM"] != "block" || props["DEVNAME"] == "" {
+		return PartitionConfig{}, false
+	}
+	if conf.UsbOnly && !strings.Contains(props["DEVPATH"], "/usb") {
+		return PartitionConfig{}, false
+	}
+	devName := "/dev/" + props["DEVNAME"]
+	if device, ok := d.configuredDevice(devName); ok {
+		return device, true
+	}
+	for _, pattern := range conf.Allow {
+		if ok, _ := path.Match(pattern, devName); ok {
+			return PartitionConfig{Path: devName}, true
+		}
+	}
+	return PartitionConfig{}, false
+}
+
+// watchUdev collects configured and allowed block devices when they are attached, and drops
+// their records when they are detached. Both are logged and annotated in Grafana if configured.
+func (d *daemon) watchUdev(conf UdevConfig) {
+	err := listenUevents(func(props map[string]string) {
+		action := props["ACTION"]
+		if action != "add" && action != "remove" {
+			return
+		}
+		device, ok := d.udevDevice(conf, props)
+		if !ok {
+			return
+		}
+
+		e := deviceEvent{Device: device.Path, Attached: action == "add", Ts: time.Now()}
+		log.Println(e.text())
+		if d.conf.Grafana != nil {
+			if err := annotateDeviceEvent(e, *d.conf.Grafana); err != nil {
+				d.recordError(err)
+			}
+		}
+		if e.Attached {
+			go d.collectDevices([]PartitionConfig{device})
+		} else {
+			d.dropDevice(device.Path)
+		}
+	})
+	d.recordError(err)
+}
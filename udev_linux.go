@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+)
+
+// listenUevents calls handle with the properties of every kernel uevent, such as ACTION,
+// SUBSYSTEM and DEVNAME, until reading from the netlink socket fails.
+func listenUevents(handle func(map[string]string)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return fmt.Errorf("uevent socket error: %w", err)
+	}
+	defer syscall.Close(fd)
+	// Group 1 receives the events of the kernel, group 2 those udev re-broadcasts after processing
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		return fmt.Errorf("uevent socket error: %w", err)
+	}
+
+	buf := make([]byte, 16*1024)
+	for {
+		n, from, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EINTR || err == syscall.ENOBUFS {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("uevent read error: %w", err)
+		}
+		// Only the kernel sends with port ID 0
+		if sender, ok := from.(*syscall.SockaddrNetlink); !ok || sender.Pid != 0 {
+			continue
+		}
+
+		// "action@devpath\0KEY=value\0..."
+		props := make(map[string]string)
+		for _, field := range bytes.Split(buf[:n], []byte{0})[1:] {
+			if k, v, ok := bytes.Cut(field, []byte("=")); ok {
+				props[string(k)] = string(v)
+			}
+		}
+		handle(props)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func listenUevents(handle func(map[string]string)) error {
+	return errors.New("udev events are only available on Linux")
+}
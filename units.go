@@ -0,0 +1,95 @@
+package main
+
+const (
+	UnitCount   = "count"
+	UnitSectors = "sectors"
+	UnitHours   = "hours"
+	UnitCelsius = "celsius"
+)
+
+// attributeUnits are the units of the decoded raw values of well known attributes. Vendor specific
+// rates such as 1 Raw_Read_Error_Rate have no meaningful unit and are left out.
+var attributeUnits = map[uint8]string{
+	4:   UnitCount,
+	5:   UnitSectors,
+	9:   UnitHours,
+	10:  UnitCount,
+	12:  UnitCount,
+	171: UnitCount,
+	172: UnitCount,
+	181: UnitCount,
+	182: UnitCount,
+	183: UnitCount,
+	184: UnitCount,
+	187: UnitCount,
+	188: UnitCount,
+	190: UnitCelsius,
+	192: UnitCount,
+	193: UnitCount,
+	194: UnitCelsius,
+	196: UnitCount,
+	197: UnitSectors,
+	198: UnitSectors,
+	199: UnitCount,
+	241: UnitSectors,
+	242: UnitSectors,
+}
+
+// normalizeUnits sets the unit of every attribute with a known one, overrides taking precedence.
+// Power-on hours keep only their low 32 bits, the upper bytes hold minutes or milliseconds on
+// drives that count them.
+func normalizeUnits(attrs []Attr, overrides map[uint8]string) []Attr {
+	for i, attr := range attrs {
+		unit, ok := overrides[attr.Id]
+		if !ok {
+			unit = attributeUnits[attr.Id]
+		}
+		attrs[i].Unit = unit
+
+		if attr.Id == 9 && unit == UnitHours {
+			attrs[i].ValueDecoded &= 0xffffffff
+		}
+	}
+	return attrs
+}
+
+// attributeCounters are the attributes whose decoded raw values only grow over the life of the
+// drive. Metric outputs report them as counters, so rates can be taken, the others as gauges.
+// Pending and offline uncorrectable sectors fall when the sectors are remapped or rewritten.
+var attributeCounters = map[uint8]bool{
+	4:   true,
+	5:   true,
+	9:   true,
+	10:  true,
+	12:  true,
+	171: true,
+	172: true,
+	181: true,
+	182: true,
+	183: true,
+	184: true,
+	187: true,
+	188: true,
+	192: true,
+	193: true,
+	196: true,
+	199: true,
+	241: true,
+	242: true,
+}
+
+// classifyMetricTypes sets whether every attribute is a counter or a gauge, overrides taking
+// precedence.
+func classifyMetricTypes(attrs []Attr, overrides map[uint8]string) []Attr {
+	for i, attr := range attrs {
+		metricType, ok := overrides[attr.Id]
+		if !ok {
+			metricType = MetricGauge
+			if attributeCounters[attr.Id] {
+				metricType = MetricCounter
+			}
+		}
+		attrs[i].metricType = metricType
+	}
+	return attrs
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type VictoriaMetricsConfig struct {
+	// Url is the base URL of a single-node VictoriaMetrics, e.g. http://localhost:8428
+	Url      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// ExtraLabels are added to every sample, e.g. the host name
+	ExtraLabels map[string]string `json:"extra_labels,omitempty"`
+	// Compression is empty, gzip or zstd, which shrinks the import requests of remote sites several
+	// times. zstd needs a VictoriaMetrics that accepts it on imports. Batch records with
+	// sink_limits to send fewer, larger requests.
+	Compression string `json:"compression,omitempty"`
+}
+
+// vmImportLine is one line of the VictoriaMetrics JSON line import format.
+type vmImportLine struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []float64         `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// saveToVictoriaMetrics posts a record's samples to the /api/v1/import endpoint.
+func saveToVictoriaMetrics(record PartitionLine, conf VictoriaMetricsConfig, dropLabels []string) error {
+	return saveBatchToVictoriaMetrics([]PartitionLine{record}, conf, dropLabels)
+}
+
+// saveBatchToVictoriaMetrics posts the samples of records in one request.
+func saveBatchToVictoriaMetrics(records []PartitionLine, conf VictoriaMetricsConfig, dropLabels []string) error {
+	var body bytes.Buffer
+	w, err := compressWriter(&body, conf.Compression)
+	if err != nil {
+		return fmt.Errorf("victoriametrics compression error: %w", err)
+	}
+	enc := json.NewEncoder(w)
+	for _, record := range records {
+		for _, sample := range recordMetrics(record, dropLabels) {
+			metric := map[string]string{"__name__": sample.Name}
+			for k, v := range conf.ExtraLabels {
+				metric[k] = v
+			}
+			for k, v := range sample.Labels {
+				metric[k] = v
+			}
+			if err := enc.Encode(vmImportLine{Metric: metric, Values: []float64{sample.Value}, Timestamps: []int64{record.Ts.UnixMilli()}}); err != nil {
+				_ = w.Close()
+				return fmt.Errorf("json output error for %s: %w", record.PartitionName, err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("victoriametrics compression error: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, conf.Url+"/api/v1/import", &body)
+	if err != nil {
+		return fmt.Errorf("victoriametrics request error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if conf.Compression != "" {
+		req.Header.Set("Content-Encoding", conf.Compression)
+	}
+	if conf.Username != "" {
+		req.SetBasicAuth(conf.Username, conf.Password)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("victoriametrics write error for %d records: %w", len(records), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("victoriametrics write error for %d records: %s %s", len(records), resp.Status, msg)
+	}
+	return nil
+}
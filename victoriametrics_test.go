@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSaveBatchToVictoriaMetricsCompression(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []PartitionLine{
+		{Ts: ts, PartitionName: "/dev/sda", SmartSupported: true, Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 9, ValueRaw: 100}, ValueDecoded: 100}}},
+		{Ts: ts, PartitionName: "/dev/sdb", SmartSupported: true, Attributes: []Attr{{AtaSmartAttr: AtaSmartAttr{Id: 9, ValueRaw: 200}, ValueDecoded: 200}}},
+	}
+	for _, compression := range []string{"", CompressionGzip, CompressionZstd} {
+		t.Run("compression="+compression, func(t *testing.T) {
+			var encoding string
+			var lines []vmImportLine
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoding = r.Header.Get("Content-Encoding")
+				var body io.Reader = r.Body
+				switch encoding {
+				case CompressionGzip:
+					gz, err := gzip.NewReader(r.Body)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					body = gz
+				case CompressionZstd:
+					zr, err := zstd.NewReader(r.Body)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					defer zr.Close()
+					body = zr
+				}
+				scanner := bufio.NewScanner(body)
+				for scanner.Scan() {
+					var line vmImportLine
+					if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					lines = append(lines, line)
+				}
+				if err := scanner.Err(); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				}
+			}))
+			defer server.Close()
+
+			conf := VictoriaMetricsConfig{Url: server.URL, Compression: compression, ExtraLabels: map[string]string{"site": "boat"}}
+			if err := saveBatchToVictoriaMetrics(records, conf, nil); err != nil {
+				t.Fatal(err)
+			}
+			if encoding != compression {
+				t.Errorf("Content-Encoding %q, want %q", encoding, compression)
+			}
+			devices := map[string]bool{}
+			for _, line := range lines {
+				if line.Metric["site"] != "boat" || len(line.Timestamps) != 1 || line.Timestamps[0] != ts.UnixMilli() {
+					t.Errorf("sample %+v", line)
+				}
+				devices[line.Metric["device"]] = true
+			}
+			if len(lines) == 0 || len(devices) != 2 {
+				t.Errorf("got %d samples of devices %v, want both records in one request", len(lines), devices)
+			}
+		})
+	}
+
+	if err := saveBatchToVictoriaMetrics(records, VictoriaMetricsConfig{Url: "http://127.0.0.1:1", Compression: "brotli"}, nil); err == nil {
+		t.Error("sent with an unknown compression")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// virtualDeviceNames maps kernel name patterns of virtual and network block devices, which do not
+// implement SMART, to the kind of device reported in skip records.
+var virtualDeviceNames = []struct {
+	pattern *regexp.Regexp
+	kind    string
+}{
+	{regexp.MustCompile(`^loop\d+`), "loop"},
+	{regexp.MustCompile(`^zram\d+`), "zram"},
+	{regexp.MustCompile(`^rbd\d+`), "rbd"},
+	{regexp.MustCompile(`^nbd\d+`), "nbd"},
+	{regexp.MustCompile(`^vd[a-z]+`), "virtio"},
+	{regexp.MustCompile(`^xvd[a-z]+`), "xen"},
+}
+
+// sysfsRoot is where sysfs is mounted. Tests point it at a tree laid out like it.
+var sysfsRoot = "/sys"
+
+// sysfsBlockDir resolves the sysfs directory of the disk holding devName, following partitions up
+// to their parent disk. It returns an empty string when sysfs is unavailable.
+func sysfsBlockDir(devName string) string {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "class", "block", filepath.Base(devName)))
+	if err != nil {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partition")); err == nil {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// emulatedDiskModels maps the vendor and model of disks emulated by hypervisors to the hypervisor.
+// Disks passed through to a VM, over an HBA or as raw SCSI devices, report the vendor and model
+// of the physical drive instead.
+var emulatedDiskModels = []struct {
+	pattern *regexp.Regexp
+	kind    string
+}{
+	{regexp.MustCompile(`QEMU HARDDISK`), "qemu"},
+	{regexp.MustCompile(`^VMware.* Virtual disk`), "vmware"},
+	{regexp.MustCompile(`^Msft Virtual Disk`), "hyperv"},
+	{regexp.MustCompile(`VBOX HARDDISK`), "virtualbox"},
+	{regexp.MustCompile(`^Google PersistentDisk`), "gce"},
+}
+
+// virtualDeviceKind returns the kind of virtual or network block device devName is, or an empty
+// string for devices that may support SMART. SCSI disks of a VM are only virtual if the hypervisor
+// emulates them, passed through disks are read.
+func virtualDeviceKind(devName string) string {
+	name := filepath.Base(devName)
+	for _, v := range virtualDeviceNames {
+		if v.pattern.MatchString(name) {
+			return v.kind
+		}
+	}
+
+	dir := sysfsBlockDir(devName)
+	if dir == "" {
+		return ""
+	}
+	vendorModel := strings.Join(strings.Fields(readSysfsString(filepath.Join(dir, "device", "vendor"))+" "+readSysfsString(filepath.Join(dir, "device", "model"))), " ")
+	for _, m := range emulatedDiskModels {
+		if m.pattern.MatchString(vendorModel) {
+			return m.kind
+		}
+	}
+	_, err := os.Stat(filepath.Join(dir, "device", "scsi_device"))
+	scsi := err == nil
+	switch {
+	case strings.Contains(dir, "/session"):
+		return "iscsi"
+	case strings.Contains(dir, "/virtio") && !scsi:
+		return "virtio"
+	}
+	return ""
+}
+
+func readSysfsString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSysfs builds a sysfs tree and points sysfsRoot at it for the test. files are written with
+// their contents and links point at other paths of the tree, paths are relative to its root and
+// directories are created as needed.
+func fakeSysfs(t *testing.T, files map[string]string, links map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, target := range links {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(root, target), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(root, target), full); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := sysfsRoot
+	sysfsRoot = root
+	t.Cleanup(func() { sysfsRoot = previous })
+	return root
+}
+
+func TestSysfsBlockDir(t *testing.T) {
+	const disk = "devices/pci0000:00/0000:00:17.0/ata3/host2/target2:0:0/2:0:0:0/block/sdb"
+	root := fakeSysfs(t, map[string]string{
+		disk + "/dev":            "8:16",
+		disk + "/sdb1/partition": "1",
+	}, map[string]string{
+		"class/block/sdb":  disk,
+		"class/block/sdb1": disk + "/sdb1",
+	})
+	for _, devName := range []string{"/dev/sdb", "/dev/sdb1", "sdb1"} {
+		if dir := sysfsBlockDir(devName); dir != filepath.Join(root, disk) {
+			t.Errorf("%s resolved to %s", devName, strings.TrimPrefix(dir, root))
+		}
+	}
+	if dir := sysfsBlockDir("/dev/sdz"); dir != "" {
+		t.Errorf("unknown disk resolved to %s", dir)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// watchHighlight marks values that changed since the previous refresh.
+const watchHighlight = "\x1b[1;33m"
+
+// highlightChanges compares a table line with the line at the same position of the previous
+// refresh and highlights the words that differ, or the whole line if it is new or its layout
+// changed. Nothing is highlighted for devices that were not in the previous refresh.
+func highlightChanges(line string, prev []string, i int) string {
+	if prev == nil {
+		return line
+	} else if i >= len(prev) {
+		return watchHighlight + line + "\x1b[0m"
+	} else if line == prev[i] {
+		return line
+	}
+	words, prevWords := strings.Fields(line), strings.Fields(prev[i])
+	if len(words) != len(prevWords) {
+		return watchHighlight + line + "\x1b[0m"
+	}
+	for j := range words {
+		if words[j] != prevWords[j] {
+			words[j] = watchHighlight + words[j] + "\x1b[0m"
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// runWatch clears the terminal and prints the table output every interval, like watch(1), with
+// the values that changed since the previous refresh highlighted.
+func runWatch(conf Config, interval time.Duration) {
+	conf = applyDefaults(conf)
+	var prev map[string][]string
+	collectLoop(interval, false, func() {
+		records := collectAll(conf)
+		histories := loadHistories(conf, records, sparklineReadings(conf))
+		next := make(map[string][]string, len(records))
+
+		var b strings.Builder
+		b.WriteString("\x1b[H\x1b[2J")
+		fmt.Fprintf(&b, "Every %s: %d devices, collected %s\n\n", interval, len(records), time.Now().Format(time.TimeOnly))
+		for _, record := range records {
+			lines := tableLines(record, histories[record.PartitionName], newNumberFormat(conf.Display))
+			fmt.Fprintf(&b, "\x1b[1m%s\x1b[0m\n", record.PartitionName)
+			for i, line := range lines {
+				b.WriteString(highlightChanges(line, prev[record.PartitionName], i) + "\n")
+			}
+			b.WriteString("\n")
+			next[record.PartitionName] = lines
+		}
+		prev = next
+		_, _ = os.Stdout.WriteString(b.String())
+	})
+}
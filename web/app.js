@@ -0,0 +1,109 @@
+// gosmart dashboard: polls /api/devices and draws attribute trends from /api/history.
+"use strict";
+
+const refreshSeconds = 60;
+let selected = null;
+
+// An API token may be passed as #token=<token>, it is kept for the session and removed from the URL.
+const tokenMatch = location.hash.match(/token=([^&]+)/);
+if (tokenMatch) {
+  sessionStorage.setItem("gosmart-token", decodeURIComponent(tokenMatch[1]));
+  history.replaceState(null, "", location.pathname + location.search);
+}
+
+function api(path) {
+  const token = sessionStorage.getItem("gosmart-token");
+  return fetch(path, token ? {headers: {"Authorization": "Bearer " + token}} : {});
+}
+
+function statusClass(status) {
+  return {"ok": "ok", "warning": "warning", "error": "error"}[status] || "nodata";
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text === undefined || text === null ? "" : text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function temperature(device) {
+  if (device.nvme_health) {
+    return device.nvme_health.temperature_c + " C";
+  }
+  const attr = (device.attributes || []).find(a => a.Temperature);
+  return attr ? attr.Temperature.Current + " C" : "";
+}
+
+// sparkline draws values as an SVG polyline scaled between their minimum and maximum.
+function sparkline(values) {
+  if (!values || values.length < 2) {
+    return "";
+  }
+  const width = 120, height = 24;
+  const min = Math.min(...values), max = Math.max(...values);
+  const points = values.map((v, i) => {
+    const y = max > min ? height - 2 - (v - min) / (max - min) * (height - 4) : height / 2;
+    return (i / (values.length - 1) * width).toFixed(1) + "," + y.toFixed(1);
+  });
+  return `<svg width="${width}" height="${height}"><polyline fill="none" stroke="#36c" stroke-width="1.5" points="${points.join(" ")}"/></svg>`;
+}
+
+async function showDetail(device) {
+  selected = device.partition_name;
+  document.querySelectorAll("#devices tbody tr").forEach(tr => tr.classList.toggle("selected", tr.dataset.device === selected));
+  document.getElementById("detail").hidden = false;
+  document.getElementById("detail-title").textContent = device.partition_name;
+  const info = [device.model, device.firmware && "firmware " + device.firmware, device.media_type].filter(Boolean);
+  document.getElementById("detail-info").textContent = info.concat(device.warnings || [], device.advisories || []).join(" · ");
+
+  let trends = null;
+  const resp = await api("api/history?device=" + encodeURIComponent(device.partition_name));
+  if (resp.ok) {
+    trends = await resp.json();
+  }
+  document.getElementById("history-note").hidden = trends !== null;
+
+  const tbody = document.querySelector("#attributes tbody");
+  tbody.replaceChildren();
+  for (const attr of device.attributes || []) {
+    const row = tbody.insertRow();
+    [attr.Id, attr.Name, attr.Current, attr.Worst, attr.ValueRaw, attr.ValueDecoded, attr.Unit].forEach(v => cell(row, v));
+    const values = trends && trends[attr.Id] ? trends[attr.Id].concat([attr.ValueDecoded]) : null;
+    row.insertCell().innerHTML = sparkline(values);
+  }
+}
+
+async function refresh() {
+  const resp = await api("api/devices");
+  if (!resp.ok) {
+    document.getElementById("meta").textContent = "Could not load devices: " + resp.status;
+    return;
+  }
+  const state = await resp.json();
+  document.getElementById("meta").textContent = state.last_run
+    ? `${state.devices.length} devices, collected ${new Date(state.last_run).toLocaleString()}`
+    : "Waiting for the first collection";
+
+  const tbody = document.querySelector("#devices tbody");
+  tbody.replaceChildren();
+  for (const device of state.devices) {
+    const row = tbody.insertRow();
+    row.dataset.device = device.partition_name;
+    cell(row, device.partition_name);
+    cell(row, device.model);
+    cell(row, device.mount_path);
+    cell(row, temperature(device));
+    cell(row, device.status, statusClass(device.status));
+    cell(row, (device.reasons || []).join("; "));
+    row.addEventListener("click", () => showDetail(device));
+    if (device.partition_name === selected) {
+      showDetail(device);
+    }
+  }
+}
+
+refresh();
+setInterval(refresh, refreshSeconds * 1000);
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ZfsMember is a disk's place in a ZFS pool, with the error counts ZFS keeps for it and the scrub
+// or resilver of the pool. Checksum errors on a member with clean SMART data point at cabling or
+// the controller rather than the disk.
+type ZfsMember struct {
+	Pool      string `json:"pool"`
+	PoolState string `json:"pool_state"`
+	// Vdev is the device of the member as zpool status lists it, often a partition of the disk
+	Vdev           string   `json:"vdev"`
+	State          string   `json:"state"`
+	ReadErrors     uint64   `json:"read_errors"`
+	WriteErrors    uint64   `json:"write_errors"`
+	ChecksumErrors uint64   `json:"checksum_errors"`
+	Scan           *ZfsScan `json:"scan,omitempty"`
+}
+
+func (m ZfsMember) String() string {
+	s := fmt.Sprintf("%s %s %s, errors read %d write %d checksum %d", m.Pool, m.Vdev, m.State, m.ReadErrors, m.WriteErrors, m.ChecksumErrors)
+	if m.Scan != nil {
+		s += "; " + m.Scan.String()
+	}
+	return s
+}
+
+// ZfsScan is the last or current scrub or resilver of a pool.
+type ZfsScan struct {
+	// Function is scrub or resilver, State in_progress, finished or canceled
+	Function string `json:"function"`
+	State    string `json:"state"`
+	// PercentDone is set while the scan is in progress
+	PercentDone *float64 `json:"percent_done,omitempty"`
+	// Errors is the number of errors the finished scan found
+	Errors *uint64 `json:"errors,omitempty"`
+}
+
+func (s ZfsScan) String() string {
+	switch {
+	case s.PercentDone != nil:
+		return fmt.Sprintf("%s %.1f%% done", s.Function, *s.PercentDone)
+	case s.Errors != nil:
+		return fmt.Sprintf("%s %s with %d errors", s.Function, s.State, *s.Errors)
+	}
+	return fmt.Sprintf("%s %s", s.Function, s.State)
+}
+
+const (
+	ZfsScanInProgress = "in_progress"
+	ZfsScanFinished   = "finished"
+	ZfsScanCanceled   = "canceled"
+)
+
+var (
+	zfsScanFinished = regexp.MustCompile(`^(scrub repaired|resilvered) .* with (\d+) errors`)
+	zfsScanPercent  = regexp.MustCompile(`([\d.]+)% done`)
+)
+
+// parseZpoolStatus reads the members of all pools from zpool status -P -L, which lists leaf vdevs
+// by their resolved device paths.
+func parseZpoolStatus(out string) []ZfsMember {
+	var members []ZfsMember
+	var pool, poolState string
+	var scan *ZfsScan
+	inConfig := false
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		key, value, _ := strings.Cut(trimmed, ": ")
+		switch {
+		case key == "pool":
+			pool, poolState, scan, inConfig = value, "", nil, false
+		case key == "state" && !inConfig:
+			poolState = value
+		case key == "scan":
+			scan = parseZfsScan(value)
+		case trimmed == "config:":
+			inConfig = true
+		case strings.HasPrefix(trimmed, "errors:"):
+			inConfig = false
+		case scan != nil && scan.State == ZfsScanInProgress && !inConfig:
+			// The progress of a running scan is on the lines after scan:
+			if m := zfsScanPercent.FindStringSubmatch(trimmed); m != nil {
+				if percent, err := strconv.ParseFloat(m[1], 64); err == nil {
+					scan.PercentDone = &percent
+				}
+			}
+		case inConfig:
+			fields := strings.Fields(trimmed)
+			if len(fields) < 5 || !strings.HasPrefix(fields[0], "/dev/") {
+				continue
+			}
+			members = append(members, ZfsMember{
+				Pool:           pool,
+				PoolState:      poolState,
+				Vdev:           fields[0],
+				State:          fields[1],
+				ReadErrors:     parseZfsCount(fields[2]),
+				WriteErrors:    parseZfsCount(fields[3]),
+				ChecksumErrors: parseZfsCount(fields[4]),
+				Scan:           scan,
+			})
+		}
+	}
+	return members
+}
+
+func parseZfsScan(value string) *ZfsScan {
+	scan := &ZfsScan{Function: "scrub"}
+	if strings.HasPrefix(value, "resilver") {
+		scan.Function = "resilver"
+	}
+	switch {
+	case strings.Contains(value, "in progress"):
+		scan.State = ZfsScanInProgress
+	case strings.Contains(value, "canceled"):
+		scan.State = ZfsScanCanceled
+	default:
+		m := zfsScanFinished.FindStringSubmatch(value)
+		if m == nil {
+			// none requested
+			return nil
+		}
+		scan.State = ZfsScanFinished
+		errors, _ := strconv.ParseUint(m[2], 10, 64)
+		scan.Errors = &errors
+	}
+	return scan
+}
+
+// parseZfsCount reads an error count, which zpool abbreviates from a thousand on, e.g. 1.2K.
+func parseZfsCount(s string) uint64 {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1e3
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1e6
+	}
+	n, _ := strconv.ParseFloat(strings.TrimRight(s, "KM"), 64)
+	// 2.01 * 1e3 is just below 2010
+	return uint64(math.Round(n * multiplier))
+}
+
+// applyZfsStatus adds the pools each record's disk is a member of, and warns about members that
+// are not online or have errors.
+func applyZfsStatus(records []PartitionLine) []PartitionLine {
+	out, err := exec.Command("zpool", "status", "-P", "-L").Output()
+	if err != nil {
+		log.Printf("Could not read zpool status: %s\n", err)
+		return records
+	}
+	members := parseZpoolStatus(string(out))
+	for i := range records {
+		record := &records[i]
+		disks := deviceDisks(record.PartitionName)
+		for _, member := range members {
+			if !sameDisk(member.Vdev, record.PartitionName, disks) {
+				continue
+			}
+			record.Zfs = append(record.Zfs, member)
+			if member.State != "ONLINE" {
+				record.warn("ZFS pool %s: %s is %s", member.Pool, member.Vdev, member.State)
+			}
+			if errors := member.ReadErrors + member.WriteErrors + member.ChecksumErrors; errors > 0 {
+				record.warn("ZFS pool %s: %d read, %d write and %d checksum errors on %s", member.Pool, member.ReadErrors, member.WriteErrors, member.ChecksumErrors, member.Vdev)
+			}
+		}
+	}
+	return records
+}
+
+// sameDisk reports whether a vdev is on the device, by its disks where they can be resolved.
+func sameDisk(vdev, device string, disks []string) bool {
+	if vdev == device {
+		return true
+	}
+	for _, disk := range deviceDisks(vdev) {
+		for _, d := range disks {
+			if disk == d {
+				return true
+			}
+		}
+	}
+	return false
+}
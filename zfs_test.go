@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// zpoolStatus is `zpool status -P -L` of three pools: tank after a clean scrub, backup resilvering
+// onto a replacement disk, and cold with a canceled scrub and a spare.
+const zpoolStatus = `  pool: backup
+ state: DEGRADED
+status: One or more devices is currently being resilvered.  The pool will
+	continue to function, possibly in a degraded state.
+action: Wait for the resilver to complete.
+  scan: resilver in progress since Thu Oct 15 09:12:01 2026
+	1.23T scanned at 812M/s, 402G issued at 265M/s, 3.61T total
+	134G resilvered, 10.87% done, 03:31:30 to go
+config:
+
+	NAME                STATE     READ WRITE CKSUM
+	backup              DEGRADED     0     0     0
+	  raidz1-0          DEGRADED     0     0     0
+	    /dev/sdc1       ONLINE       0     0     0
+	    replacing-1     DEGRADED     0     0     0
+	      /dev/sdd1     FAULTED     12  1.2K     0  too many errors
+	      /dev/sde1     ONLINE       0     0     0  (resilvering)
+	    /dev/sdf1       ONLINE       0     0  2.5M
+
+errors: No known data errors
+
+  pool: cold
+ state: ONLINE
+  scan: scrub canceled on Mon Oct 12 10:00:00 2026
+config:
+
+	NAME              STATE     READ WRITE CKSUM
+	cold              ONLINE       0     0     0
+	  /dev/nvme0n1p2  ONLINE       0     0     0
+	spares
+	  /dev/sdg1       AVAIL
+
+errors: No known data errors
+
+  pool: tank
+ state: ONLINE
+  scan: scrub repaired 0B in 02:14:33 with 3 errors on Sun Oct 11 02:38:34 2026
+config:
+
+	NAME           STATE     READ WRITE CKSUM
+	tank           ONLINE       0     0     0
+	  mirror-0     ONLINE       0     0     0
+	    /dev/sda1  ONLINE       0     0     0
+	    /dev/sdb1  ONLINE       0     0     0
+
+errors: No known data errors
+`
+
+func TestParseZpoolStatus(t *testing.T) {
+	resilver := &ZfsScan{Function: "resilver", State: ZfsScanInProgress, PercentDone: ptr(10.87)}
+	canceled := &ZfsScan{Function: "scrub", State: ZfsScanCanceled}
+	scrub := &ZfsScan{Function: "scrub", State: ZfsScanFinished, Errors: ptr[uint64](3)}
+	want := []ZfsMember{
+		{Pool: "backup", PoolState: "DEGRADED", Vdev: "/dev/sdc1", State: "ONLINE", Scan: resilver},
+		{Pool: "backup", PoolState: "DEGRADED", Vdev: "/dev/sdd1", State: "FAULTED", ReadErrors: 12, WriteErrors: 1200, Scan: resilver},
+		{Pool: "backup", PoolState: "DEGRADED", Vdev: "/dev/sde1", State: "ONLINE", Scan: resilver},
+		{Pool: "backup", PoolState: "DEGRADED", Vdev: "/dev/sdf1", State: "ONLINE", ChecksumErrors: 2500000, Scan: resilver},
+		{Pool: "cold", PoolState: "ONLINE", Vdev: "/dev/nvme0n1p2", State: "ONLINE", Scan: canceled},
+		{Pool: "tank", PoolState: "ONLINE", Vdev: "/dev/sda1", State: "ONLINE", Scan: scrub},
+		{Pool: "tank", PoolState: "ONLINE", Vdev: "/dev/sdb1", State: "ONLINE", Scan: scrub},
+	}
+	got := parseZpoolStatus(zpoolStatus)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d members, want %d", len(got), len(want))
+		for i := range got {
+			if i >= len(want) || !reflect.DeepEqual(got[i], want[i]) {
+				t.Errorf("member %d is %s", i, got[i])
+			}
+		}
+	}
+	if got := parseZpoolStatus("no pools available\n"); got != nil {
+		t.Errorf("members without pools: %v", got)
+	}
+}
+
+func TestParseZfsScan(t *testing.T) {
+	tests := []struct {
+		value string
+		want  *ZfsScan
+	}{
+		{"none requested", nil},
+		{"scrub in progress since Thu Oct 15 09:12:01 2026", &ZfsScan{Function: "scrub", State: ZfsScanInProgress}},
+		{"resilvered 1.21T in 05:01:02 with 0 errors on Fri Oct  9 14:13:03 2026", &ZfsScan{Function: "resilver", State: ZfsScanFinished, Errors: ptr[uint64](0)}},
+		{"resilver canceled on Fri Oct  9 10:00:00 2026", &ZfsScan{Function: "resilver", State: ZfsScanCanceled}},
+	}
+	for _, tt := range tests {
+		if got := parseZfsScan(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q parsed as %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseZfsCount(t *testing.T) {
+	for s, want := range map[string]uint64{"0": 0, "17": 17, "1.2K": 1200, "1.15K": 1150, "2.01K": 2010, "2.01M": 2010000, "999": 999, "2.5M": 2500000, "x": 0} {
+		if got := parseZfsCount(s); got != want {
+			t.Errorf("%s parsed as %d, want %d", s, got, want)
+		}
+	}
+}